@@ -7,9 +7,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	apiutil "github.com/affix-io/affix/api/util"
 	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/config"
 	"github.com/affix-io/affix/lib"
 	qhttp "github.com/affix-io/affix/lib/http"
 	"github.com/affix-io/affix/lib/websocket"
@@ -29,6 +35,9 @@ const (
 	DefaultTemplateHash = "/ipfs/QmeqeRTf2Cvkqdx4xUdWi1nJB2TgCyxmemsL3H4f1eTBaw"
 	// TemplateUpdateAddress is the URI for the template update
 	TemplateUpdateAddress = "/ipns/defaulttmpl.affix.io"
+	// DefaultShutdownTimeout is the default time a server waits for in-flight
+	// work to finish when shutting down
+	DefaultShutdownTimeout = time.Second * 30
 )
 
 func init() {
@@ -41,15 +50,226 @@ type Server struct {
 	*lib.Instance
 	Mux       *mux.Router
 	websocket websocket.Handler
+	opts      Options
+	uploads   *UploadStore
+	metrics   *requestMetrics
+	limiter   *rateLimiter
+	// accessLogLock serializes writes to the access log
+	accessLogLock *sync.Mutex
+	// stop is closed by Shutdown
+	stop     chan struct{}
+	stopOnce *sync.Once
+}
+
+// Options configures behaviour of a Server that isn't covered by the API
+// section of the instance config
+type Options struct {
+	// UploadDir is the local directory resumable uploads are staged in.
+	// Defaults to the "uploads" directory of the repo
+	UploadDir string
+	// UploadTTL is how long a resumable upload is kept after its last write
+	UploadTTL time.Duration
+	// MaxUploadSize caps the size of a resumable upload in bytes
+	MaxUploadSize int64
+	// AuthPolicy sets the role required to call each endpoint
+	AuthPolicy AuthPolicy
+	// RateLimits caps request rates & concurrency per endpoint
+	RateLimits RateLimits
+	// AccessLog receives a JSON line for each logged request. Access logging is
+	// off unless a writer is set with OptAccessLog
+	AccessLog io.Writer
+	// TLS serves the API over HTTPS when set. Defaults to TLSConfig
+	TLS *TLSOptions
+	// TLSConfig is the TLS section of a config file, validated & read when
+	// the server starts. It's ignored when TLS is set
+	TLSConfig *config.APITLS
+	// UnixPeers maps the user IDs of local processes calling the API over a
+	// unix socket without a token to the profile they authenticate as. The
+	// user running the node is always the node owner. Mapping other users opens
+	// the socket file to all local users, unmapped users must send a token
+	UnixPeers map[int]ClientCertProfile
+	// EventQueueSize caps the number of events waiting to be sent to each
+	// websocket connection or event stream
+	EventQueueSize int
+	// EventWriteTimeout bounds writing a single message to a websocket
+	EventWriteTimeout time.Duration
+	// SlowConsumers decides what happens to event clients that fall far enough
+	// behind to fill their queue
+	SlowConsumers websocket.SlowConsumerPolicy
+	// OAuthClients are the third-party clients allowed to ask users for
+	// authorization at AEAuthorize
+	OAuthClients []*token.Client
+	// ShutdownTimeout is how long the server waits for in-flight requests,
+	// websocket connections & background deploys to finish when shutting down
+	ShutdownTimeout time.Duration
+}
+
+// Option is a function that adjusts server options
+type Option func(o *Options)
+
+// DefaultOptions returns the default server configuration
+func DefaultOptions() *Options {
+	return &Options{
+		UploadTTL:     DefaultUploadTTL,
+		MaxUploadSize: DefaultMaxUploadSize,
+		AuthPolicy:    DefaultAuthPolicy(),
+		RateLimits:    DefaultRateLimits(),
+
+		EventQueueSize:    websocket.DefaultQueueSize,
+		EventWriteTimeout: websocket.DefaultWriteTimeout,
+		SlowConsumers:     websocket.DropEvents,
+
+		ShutdownTimeout: DefaultShutdownTimeout,
+	}
+}
+
+// OptUploads configures staging of resumable uploads. Zero values keep the
+// defaults
+func OptUploads(dir string, ttl time.Duration, maxSize int64) Option {
+	return func(o *Options) {
+		if dir != "" {
+			o.UploadDir = dir
+		}
+		if ttl > 0 {
+			o.UploadTTL = ttl
+		}
+		if maxSize > 0 {
+			o.MaxUploadSize = maxSize
+		}
+	}
+}
+
+// OptAuthPolicy replaces the default authorization policy
+func OptAuthPolicy(p AuthPolicy) Option {
+	return func(o *Options) {
+		o.AuthPolicy = p
+	}
+}
+
+// OptRateLimits replaces the default rate limits
+func OptRateLimits(l RateLimits) Option {
+	return func(o *Options) {
+		o.RateLimits = l
+	}
+}
+
+// OptAccessLog sets the destination of access logs, nil disables access
+// logging
+func OptAccessLog(w io.Writer) Option {
+	return func(o *Options) {
+		o.AccessLog = w
+	}
+}
+
+// OptShutdownTimeout sets how long a server drains in-flight work when shutting
+// down before forcibly closing connections
+func OptShutdownTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.ShutdownTimeout = d
+	}
+}
+
+// OptTLS serves the API over HTTPS with a certificate & key loaded from disk
+func OptTLS(certFile, keyFile string) Option {
+	return func(o *Options) {
+		if o.TLS == nil {
+			o.TLS = &TLSOptions{}
+		}
+		o.TLS.CertFile = certFile
+		o.TLS.KeyFile = keyFile
+	}
+}
+
+// OptTLSConfig serves the API over HTTPS as configured by the TLS section of a
+// config file. The config is validated when the server starts. OptTLS &
+// OptClientCerts take precedence
+func OptTLSConfig(c *config.APITLS) Option {
+	return func(o *Options) {
+		o.TLSConfig = c
+	}
+}
+
+// OptClientCerts verifies client certificates signed by the authorities in
+// caFile, mapping certificate common names to profiles. require rejects
+// clients that don't present a certificate. Client certificates are only
+// checked when serving over HTTPS, see OptTLS
+func OptClientCerts(caFile string, require bool, profiles map[string]ClientCertProfile) Option {
+	return func(o *Options) {
+		if o.TLS == nil {
+			o.TLS = &TLSOptions{}
+		}
+		o.TLS.ClientCAFile = caFile
+		o.TLS.RequireClientCert = require
+		o.TLS.ClientProfiles = profiles
+	}
+}
+
+// OptUnixPeers maps the user IDs of processes calling the API over a unix
+// socket to profiles. Peers are only authenticated on platforms that support
+// reading unix socket peer credentials, elsewhere requests without a token
+// respond 403. Mapping users other than the one running the node opens the
+// socket file to all local users, with UnixPeersSocketMode, on platforms that
+// support peer credentials. Requests from unmapped users without a token
+// respond 403
+func OptUnixPeers(peers map[int]ClientCertProfile) Option {
+	return func(o *Options) {
+		o.UnixPeers = peers
+	}
+}
+
+// OptEventDelivery configures delivery of events to websocket connections &
+// event streams. Zero values for queueSize & writeTimeout keep the defaults
+func OptEventDelivery(queueSize int, writeTimeout time.Duration, slowConsumers websocket.SlowConsumerPolicy) Option {
+	return func(o *Options) {
+		if queueSize > 0 {
+			o.EventQueueSize = queueSize
+		}
+		if writeTimeout > 0 {
+			o.EventWriteTimeout = writeTimeout
+		}
+		o.SlowConsumers = slowConsumers
+	}
+}
+
+// OptOAuthClients registers third-party clients that can ask users to
+// authorize them with the authorization code flow
+func OptOAuthClients(clients ...*token.Client) Option {
+	return func(o *Options) {
+		o.OAuthClients = append(o.OAuthClients, clients...)
+	}
 }
 
 // New creates a new affix server from a p2p node & configuration
-func New(inst *lib.Instance) Server {
+func New(inst *lib.Instance, opts ...Option) Server {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.UploadDir == "" {
+		o.UploadDir = filepath.Join(inst.RepoPath(), "uploads")
+	}
 	return Server{
 		Instance: inst,
+		opts:     *o,
+		uploads:  NewUploadStore(o.UploadDir, o.UploadTTL, o.MaxUploadSize),
+		metrics:  newRequestMetrics(),
+		limiter:  newRateLimiter(o.RateLimits, inst.KeyStore()),
+
+		accessLogLock: &sync.Mutex{},
+		stop:          make(chan struct{}),
+		stopOnce:      &sync.Once{},
 	}
 }
 
+// Shutdown gracefully stops Serve. In-flight requests, websocket connections
+// and background deploys are drained while the application context is still
+// live, Serve returns once they're done. Cancel the application context after
+// Serve returns. Cancelling the context passed to Serve also shuts down, but
+// deploys are cancelled with it instead of drained
+func (s Server) Shutdown() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
 // Serve starts the server. It will block while the server is running
 func (s Server) Serve(ctx context.Context) (err error) {
 	node := s.Node()
@@ -57,11 +277,35 @@ func (s Server) Serve(ctx context.Context) (err error) {
 
 	node.LocalStreams.Print(fmt.Sprintf("affix version v%s\nconnecting...\n", APIVersion))
 
-	ws, err := websocket.NewHandler(ctx, s.Instance.Bus(), s.Instance.KeyStore())
+	ws, err := websocket.NewHandler(ctx, s.Instance.Bus(), s.Instance.KeyStore(),
+		websocket.OptQueueSize(s.opts.EventQueueSize),
+		websocket.OptWriteTimeout(s.opts.EventWriteTimeout),
+		websocket.OptSlowConsumerPolicy(s.opts.SlowConsumers),
+		websocket.OptCallFunc(newBatchHandler(s, s.limiter).websocketCall),
+	)
 	if err != nil {
 		return err
 	}
 	s.websocket = ws
+
+	// options take precedence over the config, so callers can serve HTTPS
+	// without writing certificate paths to the config file
+	if s.opts.TLS == nil {
+		if s.opts.TLS, err = TLSOptionsFromConfig(s.opts.TLSConfig); err != nil {
+			return err
+		}
+	}
+	if len(s.opts.OAuthClients) > 0 {
+		authorizer, ok := s.TokenProvider().(token.Authorizer)
+		if !ok {
+			return fmt.Errorf("token provider doesn't support authorization codes")
+		}
+		for _, c := range s.opts.OAuthClients {
+			if err := authorizer.RegisterClient(c); err != nil {
+				return err
+			}
+		}
+	}
 	s.Mux = NewServerRoutes(s)
 
 	p2pConnected := true
@@ -75,6 +319,14 @@ func (s Server) Serve(ctx context.Context) (err error) {
 	server := &http.Server{
 		Handler: s.Mux,
 	}
+	// event streams never finish on their own, end them so shutdown can drain
+	// requests
+	server.RegisterOnShutdown(ws.EndStreams)
+	if s.opts.TLS != nil {
+		if server.TLSConfig, err = s.opts.TLS.Config(); err != nil {
+			return err
+		}
+	}
 
 	// TODO(ramfox): check config to see if automation is active
 	automationRunning := true
@@ -103,14 +355,86 @@ func (s Server) Serve(ctx context.Context) (err error) {
 
 	node.LocalStreams.Print(info)
 
+	if s.uploads != nil {
+		go s.uploads.ReapEvery(s.uploads.ttl/4, ctx.Done())
+	}
+
+	shutdownErr := make(chan error, 1)
 	go func() {
-		<-ctx.Done()
+		select {
+		case <-s.stop:
+		case <-ctx.Done():
+		}
 		log.Info("shutting down")
-		server.Close()
+		shutdownErr <- s.shutdown(server)
 	}()
 
-	// http.ListenAndServe will not return unless there's an error
-	return StartServer(cfg.API, server)
+	// startServer will not return unless there's an error, returning
+	// http.ErrServerClosed as soon as shutdown begins
+	if err = startServer(cfg.API, server, s.unixSocketMode()); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if shutdownErr := <-shutdownErr; shutdownErr != nil {
+		return shutdownErr
+	}
+	return err
+}
+
+// shutdown gracefully stops a running server. New connections are refused
+// while in-flight requests finish, websocket clients are sent a close frame,
+// and background deploys are given time to finish. Connections still open
+// when the shutdown timeout expires are closed
+func (s Server) shutdown(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.ShutdownTimeout)
+	defer cancel()
+
+	var errs []string
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("draining requests: %s", err))
+		server.Close()
+	}
+	if s.websocket != nil {
+		if err := s.websocket.Shutdown(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if s.Instance != nil {
+		if err := s.Instance.WaitForDeploys(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		log.Errorw("shutting down", "errors", errs)
+		return fmt.Errorf("shutting down: %s", strings.Join(errs, ", "))
+	}
+	log.Info("shutdown complete")
+	return nil
+}
+
+// ownerID returns the encoded profile identifier of the node owner
+func (s Server) ownerID() string {
+	if pro := s.Repo().Profiles().Owner(context.Background()); pro != nil {
+		return pro.ID.Encode()
+	}
+	return ""
+}
+
+// unixSocketMode is the permission mode of the API socket file. Unix peers
+// with user IDs other than the node's can't connect at UnixSocketMode. The
+// socket is only opened to other users on platforms that can tell who they are
+func (s Server) unixSocketMode() os.FileMode {
+	for uid := range s.opts.UnixPeers {
+		if uid == os.Getuid() {
+			continue
+		}
+		if !peerCredentials {
+			log.Warnf("unix socket peer credentials aren't supported on this platform, only the node user can connect to the API socket")
+			return UnixSocketMode
+		}
+		return UnixPeersSocketMode
+	}
+	return UnixSocketMode
 }
 
 // HandleIPFSPath responds to IPFS Hash requests with raw data
@@ -126,7 +450,19 @@ func (s *Server) HandleIPFSPath(w http.ResponseWriter, r *http.Request) {
 
 // helper function
 func readOnlyResponse(w http.ResponseWriter, endpoint string) {
-	apiutil.WriteErrResponse(w, http.StatusForbidden, fmt.Errorf("affix server is in read-only mode, access to '%s' endpoint is forbidden", endpoint))
+	apiutil.WriteErrResponse(w, http.StatusForbidden, readOnlyError(endpoint))
+}
+
+// readOnlyError is the error for accessing a mutating endpoint in read-only
+// mode
+func readOnlyError(endpoint string) error {
+	return fmt.Errorf("affix server is in read-only mode, access to '%s' endpoint is forbidden", endpoint)
+}
+
+// EventsHandler streams events to an authenticated client as server-sent
+// events
+func (s *Server) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	s.websocket.ServeEvents(w, r)
 }
 
 // HomeHandler responds with a health check on the empty path, 404 for
@@ -217,31 +553,56 @@ func NewServerRoutes(s Server) *mux.Router {
 	cfg := s.GetConfig()
 
 	m := s.Instance.GiveAPIServer(s.Middleware, []string{})
+	m.Use(requestIDMiddleware)
+	m.Use(s.accessLogMiddleware)
+	s.recordUnmatched(m)
 	m.Use(corsMiddleware(cfg.API.AllowedOrigins))
 	m.Use(muxVarsToQueryParamMiddleware)
 	m.Use(refStringMiddleware)
 	m.Use(token.OAuthTokenMiddleware)
+	if s.opts.TLS != nil && len(s.opts.TLS.ClientProfiles) > 0 {
+		m.Use(clientCertMiddleware(s.opts.TLS.ClientProfiles))
+	}
+	m.Use(unixPeerMiddleware(s.opts.UnixPeers, s.ownerID()))
+	m.Use(authorizationMiddleware(s.opts.AuthPolicy, s.KeyStore(), s.ownerID()))
+	// the limiter is shared with calls made over websocket connections
+	limiter := s.limiter
+	m.Use(limiter.middleware)
+	if cfg.API.ReadOnly {
+		log.Info("running in read-only mode")
+		m.Use(readOnlyMiddleware(readOnlyEndpoints))
+	}
 
 	var routeParams refRouteParams
 
 	// misc endpoints
 	m.Handle(AEHome.String(), s.NoLogMiddleware(s.HomeHandler))
 	m.Handle(AEHealth.String(), s.NoLogMiddleware(HealthCheckHandler))
+	m.Handle(AEMetrics.String(), s.NoLogMiddleware(s.MetricsHandler)).Methods(http.MethodGet)
+	m.Handle(AEOpenAPI.String(), s.NoLogMiddleware(OpenAPIHandler(s.Instance))).Methods(http.MethodGet, http.MethodHead)
+	m.Handle(AEEvents.String(), s.NoLogMiddleware(s.EventsHandler)).Methods(http.MethodGet)
 	m.Handle(AEIPFS.String(), s.Middleware(s.HandleIPFSPath))
+	m.Handle(qhttp.AEBatch.String(), s.Middleware(newBatchHandler(s, limiter).ServeHTTP)).Methods(http.MethodPost, http.MethodOptions)
 	if cfg.API.Webui {
 		m.Handle(AEWebUI.String(), s.Middleware(WebuiHandler))
 	}
 
 	// auth endpoints
 	m.Handle(AEToken.String(), s.Middleware(TokenHandler(s.Instance))).Methods(http.MethodPost, http.MethodOptions)
+	m.Handle(AERevoke.String(), s.Middleware(RevokeHandler(s.Instance))).Methods(http.MethodPost, http.MethodOptions)
+	m.Handle(AEAuthorize.String(), s.Middleware(AuthorizeHandler(s.Instance))).Methods(http.MethodGet, http.MethodPost)
 
 	// non POST/json dataset endpoints
 	m.Handle(AEGetCSVFullRef.String(), s.Middleware(GetBodyCSVHandler(s.Instance))).Methods(http.MethodGet)
 	m.Handle(AEGetCSVShortRef.String(), s.Middleware(GetBodyCSVHandler(s.Instance))).Methods(http.MethodGet)
 	routeParams = newrefRouteParams(qhttp.AEGet, false, true, http.MethodGet)
 	handleRefRoute(m, routeParams, s.Middleware(GetHandler(s.Instance, qhttp.AEGet.String())))
+	routeParams = newrefRouteParams(AEBody, false, false, http.MethodGet, http.MethodHead)
+	handleRefRoute(m, routeParams, s.Middleware(GetBodyStreamHandler(s.Instance)))
 	m.Handle(AEUnpack.String(), s.Middleware(UnpackHandler(AEUnpack.NoTrailingSlash())))
 	m.Handle(AESaveByUpload.String(), s.Middleware(SaveByUploadHandler(s.Instance, AESaveByUpload.NoTrailingSlash())))
+	m.Handle(AEUpload.String(), s.Middleware(CreateUploadHandler(s.uploads))).Methods(http.MethodPost, http.MethodOptions)
+	m.Handle(AEUploadID.String(), s.Middleware(UploadHandler(s.Instance, s.uploads))).Methods(http.MethodHead, http.MethodPatch, http.MethodPost, http.MethodDelete, http.MethodOptions)
 
 	// sync/protocol endpoints
 	if cfg.RemoteServer != nil && cfg.RemoteServer.Enabled {
@@ -8,6 +8,7 @@ import (
 	"io"
 	"io/ioutil"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -80,6 +81,85 @@ func TestConnectNoP2P(t *testing.T) {
 	}
 }
 
+func TestServeShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, teardown := newTestNode(t)
+	defer teardown()
+
+	inst := newTestInstanceWithProfileFromNode(ctx, node)
+	cfg := inst.GetConfig()
+	cfg.P2P.Enabled = false
+	if err := inst.ChangeConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(inst)
+	time.AfterFunc(time.Millisecond*15, s.Shutdown)
+	if err := s.Serve(ctx); !errors.Is(err, http.ErrServerClosed) {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("expected Shutdown to leave the application context running")
+	}
+}
+
+func TestServerShutdownDrainsRequests(t *testing.T) {
+	s := Server{opts: Options{ShutdownTimeout: time.Second * 5}}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	url := "http://" + ln.Addr().String()
+
+	resCode := make(chan int)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			t.Errorf("in-flight request: %s", err)
+			resCode <- 0
+			return
+		}
+		res.Body.Close()
+		resCode <- res.StatusCode
+	}()
+	<-started
+
+	shutdownErr := make(chan error)
+	go func() { shutdownErr <- s.shutdown(server) }()
+
+	// wait for the listener to close before finishing the in-flight request
+	for i := 0; ; i++ {
+		if _, err := http.Get(url); err != nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("expected new requests to be refused while shutting down")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	close(release)
+
+	if code := <-resCode; code != http.StatusOK {
+		t.Errorf("expected in-flight request to finish with status %d, got %d", http.StatusOK, code)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("unexpected shutdown error: %s", err)
+	}
+}
+
 func newTestRepo(t *testing.T) (r repo.Repo, teardown func()) {
 	var err error
 	if err = confirmaffixNotRunning(); err != nil {
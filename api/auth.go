@@ -1,11 +1,21 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/key"
 	"github.com/affix-io/affix/auth/token"
 	"github.com/affix-io/affix/lib"
 	qhttp "github.com/affix-io/affix/lib/http"
@@ -14,6 +24,10 @@ import (
 const (
 	// AEToken is the token provider endpoint
 	AEToken qhttp.APIEndpoint = "/oauth/token"
+	// AERevoke revokes refresh tokens
+	AERevoke qhttp.APIEndpoint = "/oauth/revoke"
+	// AEAuthorize asks users to authorize third-party clients
+	AEAuthorize qhttp.APIEndpoint = "/oauth/authorize"
 )
 
 // TokenHandler is a handler to authenticate and generate access & refresh tokens
@@ -35,6 +49,301 @@ func TokenHandler(inst *lib.Instance) http.HandlerFunc {
 	}
 }
 
+// RevokeHandler revokes the refresh token in the "token" form value. Following
+// RFC 7009, unknown & already revoked tokens respond with success
+func RevokeHandler(inst *lib.Instance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refreshToken := r.FormValue("token")
+		if refreshToken == "" {
+			util.WriteErrResponse(w, http.StatusBadRequest, token.ErrInvalidRequest)
+			return
+		}
+		revoker, ok := inst.TokenProvider().(token.Revoker)
+		if !ok {
+			util.WriteErrResponse(w, http.StatusNotImplemented, fmt.Errorf("token provider doesn't support revoking tokens"))
+			return
+		}
+		if err := revoker.RevokeRefreshToken(r.Context(), refreshToken); err != nil {
+			log.Debugf("revokeHandler failed to revoke token: %q", err.Error())
+			util.WriteErrResponse(w, http.StatusInternalServerError, token.ErrServerError)
+			return
+		}
+		util.WriteResponse(w, map[string]string{})
+	}
+}
+
+// AuthorizeHandler runs the OAuth 2.0 authorization code flow for registered
+// clients. GET requests render a consent page, which posts the user's decision
+// back. Approving redirects to the client with a code it exchanges at AEToken
+// along with its PKCE code verifier. Callers verified by
+// authorizationMiddleware approve on behalf of their own profile. Browsers
+// redirected here by a client carry no credentials, and are asked to log in
+// with an access token first, see loginSessions
+func AuthorizeHandler(inst *lib.Instance) http.HandlerFunc {
+	sessions := newLoginSessions()
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorizer, ok := inst.TokenProvider().(token.Authorizer)
+		if !ok {
+			util.WriteErrResponse(w, http.StatusNotImplemented, fmt.Errorf("token provider doesn't support authorization codes"))
+			return
+		}
+		req := parseAuthorizeRequest(r)
+		state := r.FormValue("state")
+		client, err := authorizer.AuthorizeClient(r.Context(), req)
+		if errors.Is(err, token.ErrInvalidClient) {
+			// never redirect to a URI the client hasn't registered
+			util.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		} else if err != nil {
+			redirectAuthorization(w, r, req.RedirectURI, state, "error", authorizeErrorCode(err))
+			return
+		}
+
+		if r.Method == http.MethodPost && crossOrigin(r) {
+			// session cookies, client certificates & unix socket peers are sent
+			// with any request, other sites must not be able to post a login or
+			// an approval
+			util.WriteErrResponse(w, http.StatusForbidden, fmt.Errorf("cross-origin authorization requests are not allowed"))
+			return
+		}
+
+		if r.Method == http.MethodPost && r.FormValue("login_token") != "" {
+			profileID, err := loginProfileID(r.Context(), inst.KeyStore(), r.FormValue("login_token"))
+			if err != nil {
+				log.Debugf("authorizeHandler failed to log in: %q", err.Error())
+				renderLogin(w, http.StatusUnauthorized, client, req, state, "The access token is invalid or expired.")
+				return
+			}
+			if err := sessions.start(w, r, profileID); err != nil {
+				log.Debugf("authorizeHandler failed to start session: %q", err.Error())
+				util.WriteErrResponse(w, http.StatusInternalServerError, token.ErrServerError)
+				return
+			}
+			req.ProfileID = profileID
+			renderConsent(w, client, req, state)
+			return
+		}
+
+		if caller, ok := callerFromCtx(r.Context()); ok {
+			req.ProfileID = caller.ProfileID
+		} else if profileID, ok := sessions.profileID(r); ok {
+			req.ProfileID = profileID
+		} else {
+			renderLogin(w, http.StatusOK, client, req, state, "")
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			renderConsent(w, client, req, state)
+			return
+		}
+		if r.FormValue("decision") != "approve" {
+			redirectAuthorization(w, r, req.RedirectURI, state, "error", "access_denied")
+			return
+		}
+		code, err := authorizer.Authorize(r.Context(), req)
+		if err != nil {
+			log.Debugf("authorizeHandler failed to authorize client: %q", err.Error())
+			redirectAuthorization(w, r, req.RedirectURI, state, "error", authorizeErrorCode(err))
+			return
+		}
+		redirectAuthorization(w, r, req.RedirectURI, state, "code", code)
+	}
+}
+
+// loginSessionCookie is the cookie that carries a browser's login session
+const loginSessionCookie = "affix_authorize_session"
+
+// loginSessionTTL is how long a browser login can approve authorization
+// requests
+const loginSessionTTL = time.Minute * 15
+
+// loginSessions are short-lived browser logins to the authorize endpoint. A
+// user logs in by pasting an access token, such as one created with
+// AccessMethods.CreateAuthToken, and gets a session cookie scoped to
+// AEAuthorize. The token isn't kept in the browser
+type loginSessions struct {
+	lk       sync.Mutex
+	sessions map[string]loginSession
+}
+
+type loginSession struct {
+	profileID string
+	expires   time.Time
+}
+
+func newLoginSessions() *loginSessions {
+	return &loginSessions{sessions: map[string]loginSession{}}
+}
+
+// start creates a session for a profile, setting the session cookie on w
+func (ls *loginSessions) start(w http.ResponseWriter, r *http.Request, profileID string) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	id := base64.RawURLEncoding.EncodeToString(buf)
+	now := time.Now()
+
+	ls.lk.Lock()
+	for sid, s := range ls.sessions {
+		if now.After(s.expires) {
+			delete(ls.sessions, sid)
+		}
+	}
+	ls.sessions[id] = loginSession{profileID: profileID, expires: now.Add(loginSessionTTL)}
+	ls.lk.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginSessionCookie,
+		Value:    id,
+		Path:     AEAuthorize.String(),
+		MaxAge:   int(loginSessionTTL.Seconds()),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		// clients send users here with a cross-site redirect, lax cookies are
+		// sent with that navigation but not with cross-site posts
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// profileID returns the profile logged in by the session cookie of a request
+func (ls *loginSessions) profileID(r *http.Request) (string, bool) {
+	c, err := r.Cookie(loginSessionCookie)
+	if err != nil {
+		return "", false
+	}
+	ls.lk.Lock()
+	defer ls.lk.Unlock()
+	s, ok := ls.sessions[c.Value]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(s.expires) {
+		delete(ls.sessions, c.Value)
+		return "", false
+	}
+	return s.profileID, true
+}
+
+// loginProfileID verifies an access token pasted into the login page,
+// returning the profile it was issued to
+func loginProfileID(ctx context.Context, keystore key.Store, accessToken string) (string, error) {
+	tok, err := token.ParseAuthToken(ctx, strings.TrimSpace(accessToken), keystore)
+	if err != nil {
+		return "", err
+	}
+	claims, ok := tok.Claims.(*token.Claims)
+	if !ok || !tok.Valid || claims.StandardClaims == nil || claims.Subject == "" {
+		return "", token.ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// parseAuthorizeRequest extracts the token.AuthorizeRequest from the query or
+// form of an incoming http request
+func parseAuthorizeRequest(r *http.Request) *token.AuthorizeRequest {
+	return &token.AuthorizeRequest{
+		ResponseType:        token.ResponseType(r.FormValue("response_type")),
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+	}
+}
+
+// crossOrigin reports whether a browser sent a request from another origin
+func crossOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// renderConsent writes the consent page for an authorization request
+func renderConsent(w http.ResponseWriter, client *token.Client, req *token.AuthorizeRequest, state string) {
+	data := authorizePageData(client, req, state)
+	data["profileID"] = req.ProfileID
+	renderAuthorizePage(w, http.StatusOK, "consent", data)
+}
+
+// renderLogin writes the login page shown to browsers that make an
+// authorization request without credentials
+func renderLogin(w http.ResponseWriter, status int, client *token.Client, req *token.AuthorizeRequest, state, message string) {
+	data := authorizePageData(client, req, state)
+	data["error"] = message
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="affix"`)
+	}
+	renderAuthorizePage(w, status, "login", data)
+}
+
+// authorizePageData is the template data shared by the login & consent pages
+func authorizePageData(client *token.Client, req *token.AuthorizeRequest, state string) map[string]interface{} {
+	name := client.Name
+	if name == "" {
+		name = client.ID
+	}
+	redirectHost := req.RedirectURI
+	if u, err := url.Parse(req.RedirectURI); err == nil {
+		redirectHost = u.Host
+	}
+	return map[string]interface{}{
+		"client":       name,
+		"redirectHost": redirectHost,
+		"action":       AEAuthorize.String(),
+		"params": map[string]string{
+			"response_type":         req.ResponseType.String(),
+			"client_id":             req.ClientID,
+			"redirect_uri":          req.RedirectURI,
+			"state":                 state,
+			"code_challenge":        req.CodeChallenge,
+			"code_challenge_method": req.CodeChallengeMethod,
+		},
+	}
+}
+
+func renderAuthorizePage(w http.ResponseWriter, status int, tmpl string, data map[string]interface{}) {
+	// pages that can't be framed can't trick users into clicking approve
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplateData(w, status, tmpl, data)
+}
+
+// redirectAuthorization sends the result of an authorization request to the
+// client's redirect URI as a query param, following RFC 6749 section 4.1.2
+func redirectAuthorization(w http.ResponseWriter, r *http.Request, redirectURI, state, key, value string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		util.WriteErrResponse(w, http.StatusBadRequest, token.ErrInvalidClient)
+		return
+	}
+	q := u.Query()
+	q.Set(key, value)
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// authorizeErrorCode maps an authorization error to an RFC 6749 error code
+func authorizeErrorCode(err error) string {
+	switch {
+	case errors.Is(err, token.ErrUnsupportedResponseType):
+		return "unsupported_response_type"
+	case errors.Is(err, token.ErrInvalidRequest):
+		return "invalid_request"
+	case errors.Is(err, token.ErrInvalidCredentials), errors.Is(err, token.ErrNotFound):
+		return "access_denied"
+	}
+	return "server_error"
+}
+
 // parseTokenRequest extracts the token.Request from the incoming http request
 func parseTokenRequest(r *http.Request) (*token.Request, error) {
 	tr := &token.Request{}
@@ -68,5 +377,11 @@ func parseTokenRequest(r *http.Request) (*token.Request, error) {
 	if tr.RefreshToken == "" {
 		tr.RefreshToken = r.FormValue("refresh_token")
 	}
+	if tr.ClientID == "" {
+		tr.ClientID = r.FormValue("client_id")
+	}
+	if tr.CodeVerifier == "" {
+		tr.CodeVerifier = r.FormValue("code_verifier")
+	}
 	return tr, nil
 }
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/lib"
+)
+
+func TestAuthorizeBrowserLogin(t *testing.T) {
+	run := NewAPITestRunner(t)
+	defer run.Delete()
+
+	authorizer, ok := run.Inst.TokenProvider().(token.Authorizer)
+	if !ok {
+		t.Fatal("expected the token provider to issue authorization codes")
+	}
+	client := &token.Client{ID: "notebook", Name: "Notebook", RedirectURIs: []string{"http://127.0.0.1/callback"}}
+	if err := authorizer.RegisterClient(client); err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := run.Inst.Access().CreateAuthToken(run.Ctx, &lib.CreateAuthTokenParams{GranteeUsername: run.Owner().Peername})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(NewServerRoutes(New(run.Inst)))
+	defer ts.Close()
+
+	// browser is the user agent a notebook redirects to the node, it has no
+	// bearer token, client certificate or unix socket
+	newBrowser := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	read := func(res *http.Response) string {
+		t.Helper()
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	verifier := strings.Repeat("verifier", 6)
+	sum := sha256.Sum256([]byte(verifier))
+	redirectURI := "http://127.0.0.1:52100/callback"
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ID},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {token.PKCEMethodS256},
+	}
+	authorizeURL := ts.URL + AEAuthorize.String()
+	post := func(browser *http.Client, extra url.Values) *http.Response {
+		t.Helper()
+		form := url.Values{}
+		for k, v := range params {
+			form[k] = v
+		}
+		for k, v := range extra {
+			form[k] = v
+		}
+		res, err := browser.PostForm(authorizeURL, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	browser := newBrowser()
+	res, err := browser.Get(authorizeURL + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := read(res); res.StatusCode != http.StatusOK || !strings.Contains(body, `name="login_token"`) {
+		t.Fatalf("expected a redirect without credentials to show the login page, got %d: %s", res.StatusCode, body)
+	}
+
+	// approving without logging in shows the login page again
+	res = post(browser, url.Values{"decision": {"approve"}})
+	if body := read(res); res.StatusCode != http.StatusOK || !strings.Contains(body, `name="login_token"`) {
+		t.Fatalf("expected approving without a login to show the login page, got %d: %s", res.StatusCode, body)
+	}
+
+	res = post(browser, url.Values{"login_token": {"not.a.token"}})
+	if body := read(res); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an invalid login token to respond %d, got %d: %s", http.StatusUnauthorized, res.StatusCode, body)
+	}
+
+	res = post(browser, url.Values{"login_token": {accessToken}})
+	if body := read(res); res.StatusCode != http.StatusOK || !strings.Contains(body, `value="approve"`) {
+		t.Fatalf("expected logging in to show the consent page, got %d: %s", res.StatusCode, body)
+	}
+
+	// another site can't post an approval with the session cookie
+	form := url.Values{"decision": {"approve"}}
+	for k, v := range params {
+		form[k] = v
+	}
+	req, err := http.NewRequest(http.MethodPost, authorizeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.com")
+	res, err = browser.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := read(res); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a cross-origin approval to respond %d, got %d: %s", http.StatusForbidden, res.StatusCode, body)
+	}
+
+	res = post(browser, url.Values{"decision": {"approve"}})
+	read(res)
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected approving to redirect to the client, got %d", res.StatusCode)
+	}
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Host != "127.0.0.1:52100" || loc.Query().Get("state") != "xyz" || loc.Query().Get("code") == "" {
+		t.Fatalf("expected a redirect to the client with a code & state, got %q", loc)
+	}
+
+	res, err = http.PostForm(ts.URL+AEToken.String(), url.Values{
+		"grant_type":    {string(token.AuthorizationCode)},
+		"code":          {loc.Query().Get("code")},
+		"client_id":     {client.ID},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenRes := struct {
+		Data token.Response
+	}{}
+	if err := json.Unmarshal([]byte(read(res)), &tokenRes); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || tokenRes.Data.AccessToken == "" {
+		t.Fatalf("expected exchanging the code to issue tokens, got %d: %#v", res.StatusCode, tokenRes)
+	}
+	if _, err := token.ParseAuthToken(run.Ctx, tokenRes.Data.AccessToken, run.Inst.KeyStore()); err != nil {
+		t.Errorf("expected a valid access token, got: %s", err)
+	}
+
+	// sessions belong to the browser that logged in
+	res = post(newBrowser(), url.Values{"decision": {"approve"}})
+	if body := read(res); res.StatusCode != http.StatusOK || !strings.Contains(body, `name="login_token"`) {
+		t.Errorf("expected another browser to be asked to log in, got %d: %s", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/key"
+	"github.com/affix-io/affix/auth/token"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/gorilla/mux"
+)
+
+// Role is a level of access to the API. Roles are ordered, each role is
+// granted all permissions of the roles below it
+type Role int
+
+const (
+	// RoleAnonymous requires no authentication
+	RoleAnonymous Role = iota
+	// RoleReader can read data from the node
+	RoleReader
+	// RoleWriter can read from and write to the node
+	RoleWriter
+	// RoleAdmin has full access to the node
+	RoleAdmin
+)
+
+// String implements the fmt.Stringer interface
+func (r Role) String() string {
+	switch r {
+	case RoleAnonymous:
+		return "anonymous"
+	case RoleReader:
+		return "reader"
+	case RoleWriter:
+		return "writer"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// ParseRole converts a role name to a Role
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(s) {
+	case "anonymous":
+		return RoleAnonymous, nil
+	case "reader":
+		return RoleReader, nil
+	case "writer":
+		return RoleWriter, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return RoleAnonymous, fmt.Errorf("unknown role %q", s)
+}
+
+// AuthPolicy maps API endpoints to the minimum role required to call them.
+// Routes nested below an endpoint, like the dataset reference routes added by
+// handleRefRoute, share the endpoint's policy. Endpoints absent from the
+// policy require RoleReader, public endpoints must be listed as RoleAnonymous
+type AuthPolicy map[qhttp.APIEndpoint]Role
+
+// DefaultAuthPolicy is the policy enforced when a server isn't configured with
+// a policy of its own. Reading requires a reader, every endpoint that modifies
+// repo state, as listed for read-only mode, requires write access. Public
+// endpoints either serve no repo data, or authenticate requests themselves:
+// batch calls are checked against the policy one by one, event streams &
+// websocket connections on the home endpoint subscribe with a token, and sync
+// endpoints are left to the access checks of the remote they belong to
+func DefaultAuthPolicy() AuthPolicy {
+	p := AuthPolicy{
+		AEHome:        RoleAnonymous,
+		AEHealth:      RoleAnonymous,
+		AEOpenAPI:     RoleAnonymous,
+		AEWebUI:       RoleAnonymous,
+		AEEvents:      RoleAnonymous,
+		AEToken:       RoleAnonymous,
+		AERevoke:      RoleAnonymous,
+		AEAuthorize:   RoleAnonymous,
+		qhttp.AEBatch: RoleAnonymous,
+
+		qhttp.AERemoteDSync:   RoleAnonymous,
+		qhttp.AERemoteLogSync: RoleAnonymous,
+		qhttp.AERemoteRefs:    RoleAnonymous,
+
+		AEUnpack:  RoleWriter,
+		AEMetrics: RoleAdmin,
+	}
+	for ep, servesReads := range readOnlyEndpoints {
+		if !servesReads {
+			p[ep] = RoleWriter
+		}
+	}
+	return p
+}
+
+// RequiredRole returns the role needed to access a path template. Templates
+// are matched against the longest endpoint they're equal to or nested below,
+// templates matching no endpoint require RoleReader
+func (p AuthPolicy) RequiredRole(pathTemplate string) Role {
+	endpoints := make([]qhttp.APIEndpoint, 0, len(p))
+	for ep := range p {
+		endpoints = append(endpoints, ep)
+	}
+	if ep, ok := matchEndpoint(pathTemplate, endpoints); ok {
+		return p[ep]
+	}
+	return RoleReader
+}
+
+type callerCtxKey struct{}
+
+// verifiedCaller is the identity of a request verified by
+// authorizationMiddleware
+type verifiedCaller struct {
+	ProfileID string
+	Role      Role
+}
+
+// callerFromCtx returns the verified identity of the caller making a request.
+// Requests without credentials, or with credentials that can't be verified
+// have no identity
+func callerFromCtx(ctx context.Context) (verifiedCaller, bool) {
+	c, ok := ctx.Value(callerCtxKey{}).(verifiedCaller)
+	return c, ok
+}
+
+// authorizationMiddleware enforces an AuthPolicy on all routes of a router.
+// Requests to a protected endpoint must carry a JWT verifiable with the
+// keystore, which is mapped to a role by roleFromClaims, or a client
+// certificate or unix socket peer mapped to a profile by clientCertMiddleware
+// or unixPeerMiddleware. Missing or invalid tokens respond 401, tokens with too
+// little access respond 403. The verified caller of every request is added to
+// the request context, see callerFromCtx
+func authorizationMiddleware(policy AuthPolicy, keystore key.Store, ownerID string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, callerErr := verifyCaller(r.Context(), keystore, ownerID)
+			if callerErr == nil && c.ProfileID != "" {
+				r = r.WithContext(context.WithValue(r.Context(), callerCtxKey{}, c))
+				if rec := accessRecordFromCtx(r.Context()); rec != nil {
+					rec.ProfileID = c.ProfileID
+				}
+			}
+
+			route := mux.CurrentRoute(r)
+			if route == nil || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tmpl, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			required := policy.RequiredRole(tmpl)
+			if required == RoleAnonymous {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			role, err := c.Role, callerErr
+			if err != nil {
+				log.Debugw("authorization parse token", "err", err)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="affix", error="invalid_token"`)
+				util.WriteErrResponse(w, http.StatusUnauthorized, token.ErrInvalidToken)
+				return
+			}
+			if role < required {
+				err := roleError(r.Context(), required, role, r.URL.Path)
+				if err.Code == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="affix"`)
+				}
+				util.WriteErrResponse(w, err.Code, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// callerRole determines the role of the caller making a request from the
+// token or profile in the request context. Callers without credentials are
+// anonymous, tokens that can't be verified with keystore are an error
+func callerRole(ctx context.Context, keystore key.Store, ownerID string) (Role, error) {
+	c, err := verifyCaller(ctx, keystore, ownerID)
+	return c.Role, err
+}
+
+// verifyCaller determines the profile & role of the caller making a request
+// from the token or profile in the request context
+func verifyCaller(ctx context.Context, keystore key.Store, ownerID string) (verifiedCaller, error) {
+	tokenStr := token.FromCtx(ctx)
+	if cert, ok := clientCertFromCtx(ctx); ok && tokenStr == "" {
+		return verifiedCaller{ProfileID: cert.ProfileID, Role: roleFromClientCert(cert, ownerID)}, nil
+	}
+	if tokenStr == "" {
+		return verifiedCaller{Role: RoleAnonymous}, nil
+	}
+	tok, err := token.ParseAuthToken(ctx, tokenStr, keystore)
+	if err != nil {
+		return verifiedCaller{Role: RoleAnonymous}, err
+	}
+	claims, ok := tok.Claims.(*token.Claims)
+	if !ok || !tok.Valid || claims.StandardClaims == nil {
+		return verifiedCaller{Role: RoleAnonymous}, token.ErrInvalidToken
+	}
+	return verifiedCaller{ProfileID: claims.Subject, Role: roleFromClaims(claims, ownerID)}, nil
+}
+
+// roleError describes a caller lacking the role required to access path.
+// Callers without credentials get a 401, callers with too little access a 403
+func roleError(ctx context.Context, required, have Role, path string) *util.APIError {
+	if _, hasCert := clientCertFromCtx(ctx); !hasCert && token.FromCtx(ctx) == "" {
+		return util.NewAPIError(http.StatusUnauthorized, "authorization required")
+	}
+	return util.NewAPIError(http.StatusForbidden, fmt.Sprintf("%s role required to access %s, have %s", required, path, have))
+}
+
+// roleFromClaims determines the role of a verified token. The node owner is
+// always an admin. Other tokens get the role named in the role claim,
+// defaulting to writer for node clients and reader for users
+func roleFromClaims(claims *token.Claims, ownerID string) Role {
+	if claims.StandardClaims != nil && ownerID != "" && claims.Subject == ownerID {
+		return RoleAdmin
+	}
+	if claims.Role != "" {
+		if role, err := ParseRole(claims.Role); err == nil {
+			return role
+		}
+		log.Debugw("unknown role claim", "role", claims.Role)
+		return RoleAnonymous
+	}
+	if claims.ClientType == token.NodeClient {
+		return RoleWriter
+	}
+	return RoleReader
+}
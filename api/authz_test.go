@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/affix-io/affix/auth/key"
+	testkeys "github.com/affix-io/affix/auth/key/test"
+	"github.com/affix-io/affix/auth/token"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/affix-io/affix/profile"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+)
+
+func TestAuthPolicyRequiredRole(t *testing.T) {
+	p := AuthPolicy{
+		"/":               RoleAdmin,
+		AEHealth:          RoleAnonymous,
+		AEIPFS:            RoleReader,
+		AEUpload:          RoleWriter,
+		"/ds/upload/{id}": RoleAdmin,
+	}
+
+	cases := []struct {
+		tmpl   string
+		expect Role
+	}{
+		{"/", RoleAdmin},
+		{"/health", RoleAnonymous},
+		{"/health/deep", RoleAnonymous},
+		{"/qfs/ipfs/{path:.*}", RoleReader},
+		{"/ds/upload", RoleWriter},
+		{"/ds/upload/{id}", RoleAdmin},
+		{"/ds/uploads", RoleReader},
+		{"/list", RoleReader},
+	}
+
+	for i, c := range cases {
+		if got := p.RequiredRole(c.tmpl); got != c.expect {
+			t.Errorf("case %d %q: expected role %s, got %s", i, c.tmpl, c.expect, got)
+		}
+	}
+}
+
+func TestRoleFromClaims(t *testing.T) {
+	owner := "QmOwner"
+	cases := []struct {
+		claims *token.Claims
+		expect Role
+	}{
+		{&token.Claims{StandardClaims: &jwt.StandardClaims{Subject: owner}}, RoleAdmin},
+		{&token.Claims{StandardClaims: &jwt.StandardClaims{Subject: owner}, Role: "reader"}, RoleAdmin},
+		{&token.Claims{StandardClaims: &jwt.StandardClaims{Subject: "QmOther"}, ClientType: token.UserClient}, RoleReader},
+		{&token.Claims{StandardClaims: &jwt.StandardClaims{Subject: "QmOther"}, ClientType: token.NodeClient}, RoleWriter},
+		{&token.Claims{StandardClaims: &jwt.StandardClaims{Subject: "QmOther"}, Role: "admin"}, RoleAdmin},
+		{&token.Claims{StandardClaims: &jwt.StandardClaims{Subject: "QmOther"}, Role: "superuser"}, RoleAnonymous},
+	}
+
+	for i, c := range cases {
+		if got := roleFromClaims(c.claims, owner); got != c.expect {
+			t.Errorf("case %d: expected role %s, got %s", i, c.expect, got)
+		}
+	}
+}
+
+func TestAuthorizationMiddleware(t *testing.T) {
+	ctx := context.Background()
+	kd := testkeys.GetKeyData(0)
+	ks, err := key.NewMemStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.AddPubKey(ctx, kd.KeyID, kd.PrivKey.GetPublic()); err != nil {
+		t.Fatal(err)
+	}
+	src, err := token.NewPrivKeySource(kd.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustToken := func(role string) string {
+		s, err := src.CreateTokenWithClaims(&token.Claims{
+			StandardClaims: &jwt.StandardClaims{Issuer: kd.KeyID.String(), Subject: "QmOther"},
+			ClientType:     token.UserClient,
+			Role:           role,
+		}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	m := mux.NewRouter()
+	m.Use(token.OAuthTokenMiddleware)
+	m.Use(authorizationMiddleware(DefaultAuthPolicy(), ks, "QmOwner"))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	m.HandleFunc(AEHealth.String(), ok)
+	m.HandleFunc(AEOpenAPI.String(), ok)
+	m.HandleFunc(qhttp.AEList.String(), ok)
+	m.HandleFunc(AEUnpack.String(), ok)
+	m.HandleFunc(AEIPFS.String(), ok)
+	m.HandleFunc(AEMetrics.String(), ok)
+	m.HandleFunc(qhttp.AESave.String(), ok)
+	m.HandleFunc(qhttp.AEDeploy.String(), ok)
+	m.HandleFunc(qhttp.AESetProfile.String(), ok)
+	m.HandleFunc(qhttp.AERemoteDSync.String(), ok)
+
+	// refresh tokens are signed by the same key as access tokens, but only
+	// grant new tokens
+	refreshToken := newTestRefreshToken(ctx, t, ks, kd)
+
+	cases := []struct {
+		path   string
+		token  string
+		expect int
+	}{
+		{"/health", "", http.StatusOK},
+		{"/openapi.json", "", http.StatusOK},
+		{"/list", "", http.StatusUnauthorized},
+		{"/list", "not.a.token", http.StatusUnauthorized},
+		{"/list", mustToken(""), http.StatusOK},
+		{"/ds/unpack", "", http.StatusUnauthorized},
+		{"/ds/unpack", "not.a.token", http.StatusUnauthorized},
+		{"/ds/unpack", mustToken(""), http.StatusForbidden},
+		{"/ds/unpack", mustToken("writer"), http.StatusOK},
+		{"/qfs/ipfs/QmFoo", mustToken(""), http.StatusOK},
+		{"/qfs/ipfs/QmFoo", "", http.StatusUnauthorized},
+		{"/ds/save", "", http.StatusUnauthorized},
+		{"/ds/save", mustToken(""), http.StatusForbidden},
+		{"/ds/save", mustToken("writer"), http.StatusOK},
+		{"/auto/deploy", mustToken("reader"), http.StatusForbidden},
+		{"/profile/set", "", http.StatusUnauthorized},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/metrics", mustToken("writer"), http.StatusForbidden},
+		{"/metrics", mustToken("admin"), http.StatusOK},
+		{"/metrics", refreshToken, http.StatusUnauthorized},
+		{"/ds/save", refreshToken, http.StatusUnauthorized},
+		{"/remote/dsync", "", http.StatusOK},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, req)
+		if w.Code != c.expect {
+			t.Errorf("case %d %s: expected status %d, got %d: %s", i, c.path, c.expect, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAuthorizationMiddlewareCaller(t *testing.T) {
+	ctx := context.Background()
+	kd := testkeys.GetKeyData(0)
+	ks, err := key.NewMemStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.AddPubKey(ctx, kd.KeyID, kd.PrivKey.GetPublic()); err != nil {
+		t.Fatal(err)
+	}
+	// tokens signed by keys missing from the keystore can't be verified
+	forger := testkeys.GetKeyData(1)
+	valid, err := token.NewPrivKeyAuthToken(kd.PrivKey, "QmOther", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := token.NewPrivKeyAuthToken(forger.PrivKey, "QmOwner", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got verifiedCaller
+	m := mux.NewRouter()
+	m.Use(token.OAuthTokenMiddleware)
+	m.Use(authorizationMiddleware(DefaultAuthPolicy(), ks, "QmOwner"))
+	m.HandleFunc(AEHealth.String(), func(w http.ResponseWriter, r *http.Request) {
+		got, _ = callerFromCtx(r.Context())
+	})
+
+	cases := []struct {
+		token  string
+		expect verifiedCaller
+	}{
+		{"", verifiedCaller{}},
+		{forged, verifiedCaller{}},
+		{valid, verifiedCaller{ProfileID: "QmOther", Role: RoleReader}},
+	}
+	for i, c := range cases {
+		got = verifiedCaller{}
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		m.ServeHTTP(httptest.NewRecorder(), req)
+		if got != c.expect {
+			t.Errorf("case %d: expected caller %#v, got %#v", i, c.expect, got)
+		}
+	}
+}
+
+// newTestRefreshToken logs in to a token provider as the profile of kd,
+// returning the refresh token it issues
+func newTestRefreshToken(ctx context.Context, t *testing.T, ks key.Store, kd *testkeys.KeyData) string {
+	t.Helper()
+	pro := &profile.Profile{
+		ID:       profile.IDFromPeerID(kd.PeerID),
+		Peername: "owner",
+		PrivKey:  kd.PrivKey,
+		PubKey:   kd.PrivKey.GetPublic(),
+	}
+	ps, err := profile.NewLocalStore(ctx, filepath.Join(t.TempDir(), "profiles.json"), pro, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := token.NewProvider(ps, ks, token.OptRepoPath(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := p.Token(ctx, &token.Request{GrantType: token.PasswordCredentials, Username: pro.Peername})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.RefreshToken
+}
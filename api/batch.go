@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/key"
+	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/lib"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/affix-io/affix/lib/websocket"
+)
+
+const (
+	// MaxBatchCalls caps the number of calls in a batch request
+	MaxBatchCalls = 100
+	// MaxBatchParallelism caps the number of calls in a batch request that run
+	// at the same time
+	MaxBatchParallelism = 8
+)
+
+// batchCall is a call in a batch request body, see qhttp.BatchCall
+type batchCall struct {
+	Method string          `json:"method"`
+	Source string          `json:"source"`
+	Params json.RawMessage `json:"params"`
+}
+
+// batchHandler calls many lib methods in a single request. Each call is
+// checked against the auth policy, read-only mode & rate limits of the
+// method's endpoint, as if it were requested on its own. It also runs methods
+// called over websocket connections, with the same checks
+type batchHandler struct {
+	methods  map[string]lib.AttributeSet
+	newParam func(method string) interface{}
+	dispatch func(ctx context.Context, source, method string, p interface{}) (interface{}, error)
+
+	policy   AuthPolicy
+	keystore key.Store
+	ownerID  string
+	readOnly []qhttp.APIEndpoint
+	limiter  *rateLimiter
+}
+
+func newBatchHandler(s Server, limiter *rateLimiter) *batchHandler {
+	h := &batchHandler{
+		methods:  map[string]lib.AttributeSet{},
+		newParam: s.Instance.NewInputParam,
+		dispatch: func(ctx context.Context, source, method string, p interface{}) (interface{}, error) {
+			res, _, err := s.Instance.WithSource(source).Dispatch(ctx, method, p)
+			return res, err
+		},
+		policy:   s.opts.AuthPolicy,
+		keystore: s.KeyStore(),
+		ownerID:  s.ownerID(),
+		limiter:  limiter,
+	}
+	for _, ms := range s.Instance.AllMethods() {
+		for name, attrs := range ms.Attributes() {
+			if attrs.Endpoint != qhttp.DenyHTTP {
+				h.methods[ms.Name()+"."+name] = attrs
+			}
+		}
+	}
+	if s.GetConfig().API.ReadOnly {
+		for ep := range readOnlyEndpoints {
+			h.readOnly = append(h.readOnly, ep)
+		}
+	}
+	return h
+}
+
+// ServeHTTP responds with the envelope of each call in the order of the
+// request. The optional "parallel" query parameter sets how many calls run at
+// once, defaulting to one at a time
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	calls := []batchCall{}
+	if err := json.NewDecoder(r.Body).Decode(&calls); err != nil {
+		util.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("unable to decode batch calls from request body: %w", err))
+		return
+	}
+	if len(calls) > MaxBatchCalls {
+		util.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("batch has %d calls, the maximum is %d", len(calls), MaxBatchCalls))
+		return
+	}
+
+	parallel := 1
+	if v := r.URL.Query().Get("parallel"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			util.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("parallel must be a positive integer"))
+			return
+		}
+		parallel = n
+	}
+	if parallel > MaxBatchParallelism {
+		parallel = MaxBatchParallelism
+	}
+
+	role, err := callerRole(r.Context(), h.keystore, h.ownerID)
+	if err != nil {
+		log.Debugw("batch parse token", "err", err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="affix", error="invalid_token"`)
+		util.WriteErrResponse(w, http.StatusUnauthorized, token.ErrInvalidToken)
+		return
+	}
+	caller := ""
+	if h.limiter != nil {
+		caller = h.limiter.caller(r.Context(), r.RemoteAddr)
+	}
+
+	results := make([]util.Response, len(calls))
+	sem := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	for i, c := range calls {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, c batchCall) {
+			defer func() {
+				// net/http only recovers panics in the handler's own goroutine,
+				// a panicking method must not take down the node
+				if rec := recover(); rec != nil {
+					log.Errorw("batch call panicked", "method", c.Method, "panic", rec, "stack", string(debug.Stack()))
+					results[i] = batchError(http.StatusInternalServerError, fmt.Errorf("internal error calling %s", c.Method))
+				}
+				<-sem
+				wg.Done()
+			}()
+			results[i] = h.call(r.Context(), role, caller, r.Header.Get(qhttp.SourceResolver), c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	util.WriteResponse(w, results)
+}
+
+// websocketCall runs a lib method called over a websocket connection, checked
+// as if it were requested with the connection's token
+func (h *batchHandler) websocketCall(ctx context.Context, call websocket.Call) util.Response {
+	role, err := callerRole(ctx, h.keystore, h.ownerID)
+	if err != nil {
+		log.Debugw("websocket call parse token", "err", err)
+		return batchError(http.StatusUnauthorized, token.ErrInvalidToken)
+	}
+	caller := ""
+	if h.limiter != nil {
+		caller = h.limiter.caller(ctx, call.RemoteAddr)
+	}
+	return h.call(ctx, role, caller, "", batchCall{Method: call.Method, Source: call.Source, Params: call.Params})
+}
+
+// call runs a single call of a batch, returning its response envelope. Calls
+// without a source use defaultSource
+func (h *batchHandler) call(ctx context.Context, role Role, caller, defaultSource string, c batchCall) util.Response {
+	attrs, ok := h.methods[c.Method]
+	if !ok {
+		return batchError(http.StatusNotFound, fmt.Errorf("method %q not found", c.Method))
+	}
+	ep := attrs.Endpoint
+
+	if required := h.policy.RequiredRole(ep.String()); role < required {
+		err := roleError(ctx, required, role, ep.String())
+		return batchError(err.Code, err)
+	}
+	if _, ok := matchEndpoint(ep.String(), h.readOnly); ok {
+		return batchError(http.StatusForbidden, readOnlyError(ep.String()))
+	}
+	if h.limiter != nil {
+		if lep, ok := matchEndpoint(ep.String(), h.limiter.endpoints); ok {
+			limit := h.limiter.limits[lep]
+			if !h.limiter.acquire(lep, limit) {
+				return batchError(http.StatusTooManyRequests, fmt.Errorf("too many concurrent requests to %s", lep))
+			}
+			defer h.limiter.release(lep)
+			if wait, ok := h.limiter.allow(lep, caller, limit); !ok {
+				return batchError(http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded for %s, retry in %s", lep, wait))
+			}
+		}
+	}
+
+	p := h.newParam(c.Method)
+	if p == nil {
+		return batchError(http.StatusBadRequest, fmt.Errorf("no params for method %s", c.Method))
+	}
+	if len(c.Params) > 0 {
+		if err := json.Unmarshal(c.Params, p); err != nil {
+			return batchError(http.StatusBadRequest, fmt.Errorf("unable to decode params: %w", err))
+		}
+	}
+	if setter, ok := p.(lib.NZDefaultSetter); ok {
+		setter.SetNonZeroDefaults()
+	}
+
+	source := c.Source
+	if source == "" {
+		source = defaultSource
+	}
+	res, err := h.dispatch(ctx, source, c.Method, p)
+	if err != nil {
+		log.Debugw("batch dispatch", "method", c.Method, "err", err)
+		return batchError(util.ErrorStatus(err), err)
+	}
+	return util.Response{Meta: &util.Meta{Code: http.StatusOK}, Data: res}
+}
+
+// batchError creates the envelope of a failed batch call
+func batchError(code int, err error) util.Response {
+	return util.Response{Meta: &util.Meta{Code: code, Error: err.Error()}}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/lib"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/affix-io/affix/lib/websocket"
+	"github.com/google/go-cmp/cmp"
+)
+
+type batchTestParams struct {
+	Name string `json:"name"`
+}
+
+func newTestBatchHandler(dispatch func(ctx context.Context, source, method string, p interface{}) (interface{}, error)) *batchHandler {
+	return &batchHandler{
+		methods: map[string]lib.AttributeSet{
+			"test.echo":   {Endpoint: "/test/echo"},
+			"test.write":  {Endpoint: AEUnpack},
+			"test.limits": {Endpoint: qhttp.AEApply},
+		},
+		newParam: func(string) interface{} { return &batchTestParams{} },
+		dispatch: dispatch,
+		policy:   AuthPolicy{"/test/echo": RoleAnonymous, qhttp.AEApply: RoleAnonymous, AEUnpack: RoleWriter},
+		ownerID:  "owner_id",
+		limiter:  newRateLimiter(RateLimits{qhttp.AEApply: {Rate: 1, Burst: 1}}, nil),
+	}
+}
+
+func serveBatch(ctx context.Context, t *testing.T, h *batchHandler, query, body string) []util.Response {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, qhttp.AEBatch.String()+query, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r.WithContext(ctx))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	res := struct{ Data []util.Response }{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Data
+}
+
+func TestBatchHandler(t *testing.T) {
+	h := newTestBatchHandler(func(ctx context.Context, source, method string, p interface{}) (interface{}, error) {
+		name := p.(*batchTestParams).Name
+		if name == "missing" {
+			return nil, util.NewAPIError(http.StatusNotFound, "not found")
+		}
+		return fmt.Sprintf("%s:%s", source, name), nil
+	})
+	now := time.Now()
+	h.limiter.now = func() time.Time { return now }
+
+	body := `[
+		{"method":"test.echo","params":{"name":"a"}},
+		{"method":"test.echo","source":"network","params":{"name":"b"}},
+		{"method":"test.echo","params":{"name":"missing"}},
+		{"method":"test.unknown"},
+		{"method":"test.echo","params":"not an object"},
+		{"method":"test.write","params":{"name":"c"}},
+		{"method":"test.limits","params":{"name":"d"}},
+		{"method":"test.limits","params":{"name":"e"}}
+	]`
+	got := serveBatch(context.Background(), t, h, "", body)
+
+	expect := []util.Response{
+		{Meta: &util.Meta{Code: 200}, Data: ":a"},
+		{Meta: &util.Meta{Code: 200}, Data: "network:b"},
+		{Meta: &util.Meta{Code: 404, Error: "not found"}},
+		{Meta: &util.Meta{Code: 404, Error: `method "test.unknown" not found`}},
+		{Meta: &util.Meta{Code: 400, Error: "unable to decode params: json: cannot unmarshal string into Go value of type api.batchTestParams"}},
+		{Meta: &util.Meta{Code: 401, Error: "authorization required"}},
+		{Meta: &util.Meta{Code: 200}, Data: ":d"},
+		{Meta: &util.Meta{Code: 429, Error: "rate limit exceeded for /auto/apply, retry in 1s"}},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+
+	owner := context.WithValue(context.Background(), clientCertCtxKey{}, ClientCertProfile{ProfileID: "owner_id"})
+	reader := context.WithValue(context.Background(), clientCertCtxKey{}, ClientCertProfile{ProfileID: "reader_id"})
+	body = `[{"method":"test.write","params":{"name":"c"}}]`
+	if got := serveBatch(owner, t, h, "", body); got[0].Meta.Code != http.StatusOK {
+		t.Errorf("expected the owner to call a protected method, got: %v", got[0].Meta)
+	}
+	if got := serveBatch(reader, t, h, "", body); got[0].Meta.Code != http.StatusForbidden {
+		t.Errorf("expected a reader calling a protected method to be forbidden, got: %v", got[0].Meta)
+	}
+
+	h.readOnly = []qhttp.APIEndpoint{AEUnpack}
+	if got := serveBatch(owner, t, h, "", body); got[0].Meta.Code != http.StatusForbidden {
+		t.Errorf("expected calling a mutating method in read-only mode to be forbidden, got: %v", got[0].Meta)
+	}
+}
+
+func TestBatchHandlerRecoversPanics(t *testing.T) {
+	h := newTestBatchHandler(func(ctx context.Context, source, method string, p interface{}) (interface{}, error) {
+		if p.(*batchTestParams).Name == "panic" {
+			panic("method panicked")
+		}
+		return "ok", nil
+	})
+	body := `[
+		{"method":"test.echo","params":{"name":"panic"}},
+		{"method":"test.echo","params":{"name":"a"}}
+	]`
+	got := serveBatch(context.Background(), t, h, "?parallel=2", body)
+	expect := []util.Response{
+		{Meta: &util.Meta{Code: 500, Error: "internal error calling test.echo"}},
+		{Meta: &util.Meta{Code: 200}, Data: "ok"},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBatchHandlerParallelism(t *testing.T) {
+	lock := sync.Mutex{}
+	running, peak := 0, 0
+	h := newTestBatchHandler(func(ctx context.Context, source, method string, p interface{}) (interface{}, error) {
+		lock.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return p.(*batchTestParams).Name, nil
+	})
+
+	calls := make([]string, 12)
+	for i := range calls {
+		calls[i] = fmt.Sprintf(`{"method":"test.echo","params":{"name":"%d"}}`, i)
+	}
+	body := "[" + strings.Join(calls, ",") + "]"
+
+	cases := []struct {
+		query string
+		peak  int
+	}{
+		{"", 1},
+		{"?parallel=3", 3},
+		{"?parallel=100", MaxBatchParallelism},
+	}
+	for _, c := range cases {
+		peak = 0
+		got := serveBatch(context.Background(), t, h, c.query, body)
+		for i, res := range got {
+			if res.Data != fmt.Sprintf("%d", i) {
+				t.Errorf("%q: expected results in call order, got %v at %d", c.query, res.Data, i)
+			}
+		}
+		if peak > c.peak {
+			t.Errorf("%q: expected at most %d calls at once, got %d", c.query, c.peak, peak)
+		}
+	}
+
+	for _, query := range []string{"?parallel=0", "?parallel=many"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, qhttp.AEBatch.String()+query, strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestBatchHandlerWebsocketCall(t *testing.T) {
+	h := newTestBatchHandler(func(ctx context.Context, source, method string, p interface{}) (interface{}, error) {
+		return fmt.Sprintf("%s:%s", source, p.(*batchTestParams).Name), nil
+	})
+	now := time.Now()
+	h.limiter.now = func() time.Time { return now }
+
+	owner := context.WithValue(context.Background(), clientCertCtxKey{}, ClientCertProfile{ProfileID: "owner_id"})
+	badToken := token.AddToContext(context.Background(), "not a token")
+	cases := []struct {
+		ctx    context.Context
+		call   websocket.Call
+		expect util.Response
+	}{
+		{context.Background(), websocket.Call{Method: "test.echo", Source: "network", Params: json.RawMessage(`{"name":"a"}`)}, util.Response{Meta: &util.Meta{Code: 200}, Data: "network:a"}},
+		{context.Background(), websocket.Call{Method: "test.write", Params: json.RawMessage(`{"name":"b"}`)}, util.Response{Meta: &util.Meta{Code: 401, Error: "authorization required"}}},
+		{owner, websocket.Call{Method: "test.write", Params: json.RawMessage(`{"name":"c"}`)}, util.Response{Meta: &util.Meta{Code: 200}, Data: ":c"}},
+		{badToken, websocket.Call{Method: "test.echo"}, util.Response{Meta: &util.Meta{Code: 401, Error: token.ErrInvalidToken.Error()}}},
+		{context.Background(), websocket.Call{Method: "test.limits", RemoteAddr: "10.0.0.1:1234"}, util.Response{Meta: &util.Meta{Code: 200}, Data: ":"}},
+		{context.Background(), websocket.Call{Method: "test.limits", RemoteAddr: "10.0.0.1:5678"}, util.Response{Meta: &util.Meta{Code: 429, Error: "rate limit exceeded for /auto/apply, retry in 1s"}}},
+		{context.Background(), websocket.Call{Method: "test.limits", RemoteAddr: "10.0.0.2:1234"}, util.Response{Meta: &util.Meta{Code: 200}, Data: ":"}},
+	}
+	for i, c := range cases {
+		got := h.websocketCall(c.ctx, c.call)
+		if diff := cmp.Diff(c.expect, got); diff != "" {
+			t.Errorf("case %d: result mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/base"
+	"github.com/affix-io/affix/base/bodyquery"
+	"github.com/affix-io/affix/base/columnar"
+	"github.com/affix-io/affix/base/dsfs"
+	"github.com/affix-io/affix/lib"
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/qfs"
+)
+
+const (
+	// rangeUnitBytes is the standard HTTP byte range unit
+	rangeUnitBytes = "bytes"
+	// rangeUnitRows is a custom range unit that selects top-level body entries
+	rangeUnitRows = "rows"
+)
+
+var (
+	// errRangeNotSatisfiable indicates a requested range falls outside of the
+	// body being requested
+	errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+	// streamableBodyFormats lists the formats GetBodyStreamHandler can write
+	streamableBodyFormats = []string{"csv", "json", "ndjson", "parquet", "arrow"}
+	// streamOnlyBodyFormats are body formats GetHandler delegates to
+	// GetBodyStreamHandler
+	streamOnlyBodyFormats = []string{"ndjson", "parquet", "arrow"}
+)
+
+// GetBodyStreamHandler streams a dataset body to the client without holding
+// the body in memory. Output format is set with either the "format" param or
+// the Accept header, defaulting to the format the body is stored in.
+// Responses carry an ETag derived from the dataset path and params, and honor
+// If-None-Match, If-Range and Range headers. Range accepts "bytes" when
+// serving the body in its stored format, and "rows" in any format. Tabular
+// bodies can also be streamed as parquet files & arrow IPC streams, and
+// narrowed with columns, where & sort params. Queried bodies ignore Range
+// Examples:
+// curl http://localhost:2503/ds/body/b5/world_bank_population?format=ndjson
+// curl -H "Accept: application/vnd.apache.parquet" http://localhost:2503/ds/body/b5/world_bank_population
+// curl -H "Range: rows=100-199" http://localhost:2503/ds/body/b5/world_bank_population
+// curl -H "Range: bytes=1048576-" http://localhost:2503/ds/body/b5/world_bank_population/at/ipfs/QmFoo
+// curl "http://localhost:2503/ds/body/b5/world_bank_population?columns=country,pop&where=pop>1000000"
+func GetBodyStreamHandler(inst *lib.Instance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			util.NotFoundHandler(w, r)
+			return
+		}
+
+		p := &lib.GetParams{}
+		if err := parseGetParamsFromRequest(r, p); err != nil {
+			util.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		}
+		q, err := parseBodyQueryFromRequest(r)
+		if err != nil {
+			util.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		}
+		if p.Selector != "" && p.Selector != "body" {
+			util.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("can only stream the body component, got selector %q", p.Selector))
+			return
+		}
+
+		ctx := r.Context()
+		ref, _, err := inst.ParseAndResolveRef(ctx, p.Ref, "local")
+		if err != nil {
+			util.RespondWithError(w, err)
+			return
+		}
+
+		fs := inst.Repo().Filesystem()
+		ds, err := dsfs.LoadDataset(ctx, fs, ref.Path)
+		if err != nil {
+			util.RespondWithError(w, err)
+			return
+		}
+		if ds.Structure == nil || ds.BodyPath == "" {
+			util.WriteErrResponse(w, http.StatusNotFound, fmt.Errorf("dataset has no body"))
+			return
+		}
+
+		format, err := bodyStreamFormat(r, ds.Structure)
+		if err != nil {
+			util.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		}
+		colFormat, colErr := columnar.ParseFormat(format)
+		if colErr == nil {
+			// check the schema before responding, column types can't be
+			// determined once the response has started
+			if _, err := columnar.Columns(ds.Structure); err != nil {
+				util.WriteErrResponse(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		if q != nil {
+			if _, err := q.Structure(ds.Structure); err != nil {
+				util.WriteErrResponse(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		// raw responses skip entry conversion & copy stored bytes directly
+		raw := format == ds.Structure.Format && ds.Structure.Compression == "" && q == nil
+
+		etag := bodyETag(ds.Path, format, p, q)
+		w.Header().Set("ETag", etag)
+		if raw {
+			w.Header().Set("Accept-Ranges", fmt.Sprintf("%s, %s", rangeUnitBytes, rangeUnitRows))
+		} else {
+			w.Header().Set("Accept-Ranges", rangeUnitRows)
+		}
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		rng, err := parseRangeHeader(r.Header.Get("Range"))
+		if err != nil {
+			util.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		}
+		if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+			// representation has changed since the client started downloading,
+			// fall back to sending the full body
+			rng = nil
+		}
+		if rng != nil && rng.Unit == rangeUnitBytes && (!raw || !p.All) {
+			// byte offsets of converted or paged output aren't stable enough to
+			// resume from. RFC 7233 permits ignoring the range in that case
+			rng = nil
+		}
+		if q != nil {
+			// the number of rows a query selects isn't known until the body is
+			// read, so row ranges can't be resolved
+			rng = nil
+		}
+
+		f, err := dsfs.LoadBody(ctx, fs, ds)
+		if err != nil {
+			util.RespondWithError(w, err)
+			return
+		}
+		defer f.Close()
+		ds.SetBodyFile(f)
+
+		w.Header().Set("Content-Type", extensionToMimeType("."+format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=body.%s", format))
+
+		if raw && p.All && (rng == nil || rng.Unit == rangeUnitBytes) {
+			writeRawBodyResponse(w, r, f, rawBodyLength(ds.Structure, f), rng)
+			publishDownloadEvent(ctx, inst, p.Ref)
+			return
+		}
+
+		limit, offset, all := p.Limit, p.Offset, p.All
+		if rng != nil {
+			first, last, err := rng.Resolve(int64(ds.Structure.Entries))
+			if err != nil {
+				w.Header().Set("Content-Range", fmt.Sprintf("%s */%d", rangeUnitRows, ds.Structure.Entries))
+				util.WriteErrResponse(w, http.StatusRequestedRangeNotSatisfiable, err)
+				return
+			}
+			offset, limit, all = int(first), int(last-first+1), false
+			w.Header().Set("Content-Range", fmt.Sprintf("%s %d-%d/%d", rangeUnitRows, first, last, ds.Structure.Entries))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		if colErr == nil {
+			err = base.WriteColumnarBody(w, ds, q, colFormat, limit, offset, all)
+		} else {
+			var df dataset.DataFormat
+			if df, err = dataset.ParseDataFormatString(format); err != nil {
+				util.WriteErrResponse(w, http.StatusBadRequest, err)
+				return
+			}
+			err = base.WriteBody(w, ds, q, df, nil, limit, offset, all)
+		}
+		if err != nil {
+			// headers have already been sent, so we can't respond with an error.
+			// aborting the handler drops the connection without terminating the
+			// chunked response, signaling to the client the body is incomplete
+			log.Errorf("streaming body %q: %s", ds.Path, err)
+			panic(http.ErrAbortHandler)
+		}
+		publishDownloadEvent(ctx, inst, p.Ref)
+	}
+}
+
+// rawBodyLength returns the size of a stored body file in bytes, falling back
+// to the size of the file when the structure doesn't record a length. Returns
+// -1 if neither is known
+func rawBodyLength(st *dataset.Structure, f qfs.File) int64 {
+	if st.Length > 0 {
+		return int64(st.Length)
+	}
+	if sf, ok := f.(qfs.SizeFile); ok {
+		return sf.Size()
+	}
+	return -1
+}
+
+// writeRawBodyResponse copies a stored body file to the response, serving a
+// byte range of the file if one is provided. Ranges are ignored when the
+// length of the file isn't known
+func writeRawBodyResponse(w http.ResponseWriter, r *http.Request, f io.Reader, length int64, rng *httpRange) {
+	if length < 0 {
+		rng = nil
+	}
+	first, size := int64(0), length
+	if rng != nil {
+		var (
+			last int64
+			err  error
+		)
+		if first, last, err = rng.Resolve(length); err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("%s */%d", rangeUnitBytes, length))
+			util.WriteErrResponse(w, http.StatusRequestedRangeNotSatisfiable, err)
+			return
+		}
+		size = last - first + 1
+		w.Header().Set("Content-Range", fmt.Sprintf("%s %d-%d/%d", rangeUnitBytes, first, last, length))
+	}
+	if size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if rng != nil {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if first > 0 {
+		if _, err := io.CopyN(ioutil.Discard, f, first); err != nil {
+			log.Errorf("seeking body to byte %d: %s", first, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if size > 0 {
+		f = io.LimitReader(f, size)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		log.Debugf("copying raw body: %s", err)
+	}
+}
+
+// bodyStreamFormat determines the output format for a streamed body request
+func bodyStreamFormat(r *http.Request, st *dataset.Structure) (string, error) {
+	format := r.FormValue("format")
+	if format == "" {
+		for _, f := range streamableBodyFormats {
+			if arrayContains(r.Header["Accept"], extensionToMimeType("."+f)) {
+				format = f
+				break
+			}
+		}
+	}
+	if format == "" {
+		format = st.Format
+	}
+	for _, f := range streamableBodyFormats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("cannot stream body as %q, format must be one of: %s", format, strings.Join(streamableBodyFormats, ", "))
+}
+
+// acceptsStreamOnlyFormat reports whether a request asks for a body format
+// that's only served by GetBodyStreamHandler
+func acceptsStreamOnlyFormat(r *http.Request) bool {
+	format := r.FormValue("format")
+	for _, f := range streamOnlyBodyFormats {
+		if format == f || (format == "" && arrayContains(r.Header["Accept"], extensionToMimeType("."+f))) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyETag returns a strong entity tag for a body in a given format, narrowed
+// by an optional page & query. dataset paths are content-addressed, so the
+// path and params identify a response body exactly
+func bodyETag(dsPath, format string, p *lib.GetParams, q *bodyquery.Query) string {
+	var params []string
+	if !p.All {
+		params = append(params, fmt.Sprintf("limit=%d&offset=%d", p.Limit, p.Offset))
+	}
+	if q != nil {
+		params = append(params, q.String())
+	}
+	if len(params) > 0 {
+		return fmt.Sprintf(`"%s/body.%s?%s"`, dsPath, format, strings.Join(params, "&"))
+	}
+	return fmt.Sprintf(`"%s/body.%s"`, dsPath, format)
+}
+
+// etagMatches reports whether an If-None-Match header value lists etag
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// httpRange is a single range parsed from an HTTP Range header. First and Last
+// are inclusive. A Last value of -1 denotes an open-ended range, a First value
+// of -1 denotes a suffix range of the final Last units
+type httpRange struct {
+	Unit  string
+	First int64
+	Last  int64
+}
+
+// parseRangeHeader parses a Range header value with a single range in either
+// bytes or rows units. It returns nil for an empty header, and for headers
+// requesting multiple ranges, which the server is free to ignore
+func parseRangeHeader(header string) (*httpRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	eq := strings.Index(header, "=")
+	if eq < 0 {
+		return nil, fmt.Errorf("invalid range %q", header)
+	}
+	unit, spec := strings.TrimSpace(header[:eq]), strings.TrimSpace(header[eq+1:])
+	if unit != rangeUnitBytes && unit != rangeUnitRows {
+		return nil, fmt.Errorf("unsupported range unit %q", unit)
+	}
+	if strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return nil, fmt.Errorf("invalid range %q", header)
+	}
+	firstStr, lastStr := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+	rng := &httpRange{Unit: unit, First: -1, Last: -1}
+
+	if firstStr == "" {
+		// suffix range, eg: "bytes=-500"
+		n, err := strconv.ParseInt(lastStr, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid range %q", header)
+		}
+		rng.Last = n
+		return rng, nil
+	}
+
+	first, err := strconv.ParseInt(firstStr, 10, 64)
+	if err != nil || first < 0 {
+		return nil, fmt.Errorf("invalid range %q", header)
+	}
+	rng.First = first
+	if lastStr != "" {
+		last, err := strconv.ParseInt(lastStr, 10, 64)
+		if err != nil || last < first {
+			return nil, fmt.Errorf("invalid range %q", header)
+		}
+		rng.Last = last
+	}
+	return rng, nil
+}
+
+// Resolve converts a range to absolute, inclusive first & last positions
+// within a total number of units
+func (rng *httpRange) Resolve(total int64) (first, last int64, err error) {
+	if rng.First < 0 {
+		first = total - rng.Last
+		if first < 0 {
+			first = 0
+		}
+		if total == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		return first, total - 1, nil
+	}
+
+	if rng.First >= total {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	last = rng.Last
+	if last < 0 || last >= total {
+		last = total - 1
+	}
+	return rng.First, last, nil
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/base"
+	"github.com/affix-io/affix/base/archive"
+	"github.com/affix-io/affix/base/bodyquery"
+	"github.com/affix-io/affix/base/dsfs"
+	"github.com/affix-io/affix/dsref"
+	"github.com/affix-io/affix/lib"
+	"github.com/affix-io/dataset"
+)
+
+// loadQueryDataset resolves a reference to a local dataset, checks a body
+// query against its schema & opens the body file for reading. Callers must
+// close the body file
+func loadQueryDataset(ctx context.Context, inst *lib.Instance, refStr string, q *bodyquery.Query) (dsref.Ref, *dataset.Dataset, error) {
+	ref, _, err := inst.ParseAndResolveRef(ctx, refStr, "local")
+	if err != nil {
+		return ref, nil, err
+	}
+
+	fs := inst.Repo().Filesystem()
+	ds, err := dsfs.LoadDataset(ctx, fs, ref.Path)
+	if err != nil {
+		return ref, nil, err
+	}
+	if ds.Structure == nil || ds.BodyPath == "" {
+		return ref, nil, util.NewAPIError(http.StatusNotFound, "dataset has no body")
+	}
+	if _, err := q.Structure(ds.Structure); err != nil {
+		return ref, nil, util.NewAPIError(http.StatusBadRequest, err.Error())
+	}
+
+	f, err := dsfs.LoadBody(ctx, fs, ds)
+	if err != nil {
+		return ref, nil, err
+	}
+	ds.SetBodyFile(f)
+	return ref, ds, nil
+}
+
+// getBodyWithQuery selects rows of a dataset body, returning them as a
+// go-native structure
+func getBodyWithQuery(ctx context.Context, inst *lib.Instance, p *lib.GetParams, q *bodyquery.Query) (interface{}, error) {
+	_, ds, err := loadQueryDataset(ctx, inst, p.Ref, q)
+	if err != nil {
+		return nil, err
+	}
+	defer ds.BodyFile().Close()
+	return base.GetBodyWithQuery(ds, q, p.Limit, p.Offset, p.All)
+}
+
+// getCSVWithQuery selects rows of a dataset body, encoding them as CSV
+func getCSVWithQuery(ctx context.Context, inst *lib.Instance, p *lib.GetParams, q *bodyquery.Query) ([]byte, error) {
+	_, ds, err := loadQueryDataset(ctx, inst, p.Ref, q)
+	if err != nil {
+		return nil, err
+	}
+	defer ds.BodyFile().Close()
+	return base.ReadBodyBytesWithQuery(ds, q, dataset.CSVDataFormat, nil, p.Limit, p.Offset, p.All)
+}
+
+// getZipWithQuery creates a zip archive of a dataset with a body narrowed to
+// the rows & columns a query selects. The archived structure describes the
+// selected body
+func getZipWithQuery(ctx context.Context, inst *lib.Instance, p *lib.GetParams, q *bodyquery.Query) (*lib.GetZipResults, error) {
+	ref, ds, err := loadQueryDataset(ctx, inst, p.Ref, q)
+	if err != nil {
+		return nil, err
+	}
+	defer ds.BodyFile().Close()
+
+	body, err := base.GetBodyWithQuery(ds, q, p.Limit, p.Offset, p.All)
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := body.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected query results to be an array, got %T", body)
+	}
+	st, err := q.Structure(ds.Structure)
+	if err != nil {
+		return nil, err
+	}
+	st.Entries = len(rows)
+	ds.Structure = st
+	ds.Body = rows
+
+	buf := &bytes.Buffer{}
+	if err := archive.WriteZip(ctx, inst.Repo().Filesystem(), ds, "json", ref.InitID, ref, buf); err != nil {
+		return nil, err
+	}
+	filename, err := archive.GenerateFilename(ds, "zip")
+	if err != nil {
+		return nil, err
+	}
+	return &lib.GetZipResults{Bytes: buf.Bytes(), GeneratedName: filename}, nil
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/qfs"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+)
+
+func TestGetBodyStreamHandler(t *testing.T) {
+	run := NewAPITestRunner(t)
+	defer run.Delete()
+
+	ds := dataset.Dataset{
+		Name: "test_ds",
+		Meta: &dataset.Meta{
+			Title: "title one",
+		},
+	}
+	run.SaveDataset(&ds, "testdata/cities/data.csv")
+
+	h := GetBodyStreamHandler(run.Inst)
+	muxVars := map[string]string{"username": "peer", "name": "test_ds"}
+
+	// full body in stored format
+	res := bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds", muxVars, nil)
+	assertStatusCode(t, "full body", res.StatusCode, http.StatusOK)
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Errorf("expected full body response to set an ETag")
+	}
+	if got := res.Header.Get("Accept-Ranges"); got != "bytes, rows" {
+		t.Errorf("Accept-Ranges mismatch. want %q, got %q", "bytes, rows", got)
+	}
+	if got := readBody(t, res); !strings.HasPrefix(got, "city,pop,avg_age,in_usa\ntoronto") {
+		t.Errorf("expected full body to begin with the stored csv header, got: %q", got)
+	}
+
+	// conditional request with a matching etag
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds", muxVars, map[string]string{"If-None-Match": etag})
+	assertStatusCode(t, "if-none-match", res.StatusCode, http.StatusNotModified)
+
+	// byte range of the stored file
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds", muxVars, map[string]string{"Range": "bytes=0-3"})
+	assertStatusCode(t, "byte range", res.StatusCode, http.StatusPartialContent)
+	if diff := cmp.Diff("city", readBody(t, res)); diff != "" {
+		t.Errorf("byte range mismatch (-want +got):\n%s", diff)
+	}
+
+	// stale If-Range falls back to the full body
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds", muxVars, map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`})
+	assertStatusCode(t, "stale if-range", res.StatusCode, http.StatusOK)
+
+	// row range converted to ndjson
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds?format=ndjson", muxVars, map[string]string{"Range": "rows=1-2"})
+	assertStatusCode(t, "row range", res.StatusCode, http.StatusPartialContent)
+	if got := res.Header.Get("Content-Range"); got != "rows 1-2/5" {
+		t.Errorf("Content-Range mismatch. want %q, got %q", "rows 1-2/5", got)
+	}
+	expect := "[\"new york\",8500000,44.4,true]\n[\"chicago\",300000,44.4,true]\n"
+	if diff := cmp.Diff(expect, readBody(t, res)); diff != "" {
+		t.Errorf("row range mismatch (-want +got):\n%s", diff)
+	}
+
+	// format negotiated with the Accept header
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds", muxVars, map[string]string{"Accept": "application/json", "Range": "rows=-1"})
+	assertStatusCode(t, "accept json", res.StatusCode, http.StatusPartialContent)
+	expect = `[["raleigh",250000,50.65,true]]`
+	if diff := cmp.Diff(expect, readBody(t, res)); diff != "" {
+		t.Errorf("accept json mismatch (-want +got):\n%s", diff)
+	}
+
+	// row range out of bounds
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds", muxVars, map[string]string{"Range": "rows=10-"})
+	assertStatusCode(t, "unsatisfiable range", res.StatusCode, http.StatusRequestedRangeNotSatisfiable)
+
+	// columnar formats
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds", muxVars, map[string]string{"Accept": "application/vnd.apache.parquet"})
+	assertStatusCode(t, "accept parquet", res.StatusCode, http.StatusOK)
+	if got := readBody(t, res); !strings.HasPrefix(got, "PAR1") || !strings.HasSuffix(got, "PAR1") {
+		t.Errorf("expected a parquet file, got: %q", got)
+	}
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds?format=arrow", muxVars, nil)
+	assertStatusCode(t, "arrow format", res.StatusCode, http.StatusOK)
+	if got := res.Header.Get("Content-Type"); got != "application/vnd.apache.arrow.stream" {
+		t.Errorf("Content-Type mismatch. want %q, got %q", "application/vnd.apache.arrow.stream", got)
+	}
+
+	// queried body, ignoring the row range
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds?format=ndjson&columns=city&where=pop%3E=300000&sort=-pop", muxVars, map[string]string{"Range": "rows=0-0"})
+	assertStatusCode(t, "queried body", res.StatusCode, http.StatusOK)
+	if got := res.Header.Get("ETag"); got == etag || !strings.Contains(got, "where=") {
+		t.Errorf("expected queried body to have a distinct ETag, got: %q", got)
+	}
+	expect = "[\"toronto\"]\n[\"new york\"]\n[\"chicago\"]\n"
+	if diff := cmp.Diff(expect, readBody(t, res)); diff != "" {
+		t.Errorf("queried body mismatch (-want +got):\n%s", diff)
+	}
+	// paged bodies have distinct ETags
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds?limit=2&offset=1", muxVars, nil)
+	assertStatusCode(t, "paged body", res.StatusCode, http.StatusOK)
+	pagedETag := res.Header.Get("ETag")
+	if pagedETag == etag || !strings.Contains(pagedETag, "limit=2&offset=1") {
+		t.Errorf("expected paged body to have a distinct ETag, got: %q", pagedETag)
+	}
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds?limit=2&offset=3", muxVars, map[string]string{"If-None-Match": pagedETag})
+	assertStatusCode(t, "another page", res.StatusCode, http.StatusOK)
+
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds?columns=country", muxVars, nil)
+	assertStatusCode(t, "unknown query column", res.StatusCode, http.StatusBadRequest)
+
+	// unsupported format
+	res = bodyStreamCall(t, h, http.MethodGet, "/ds/body/peer/test_ds?format=xlsx", muxVars, nil)
+	assertStatusCode(t, "unsupported format", res.StatusCode, http.StatusBadRequest)
+
+	// incorrect http method
+	res = bodyStreamCall(t, h, http.MethodPost, "/ds/body/peer/test_ds", muxVars, nil)
+	assertStatusCode(t, "incorrect http method", res.StatusCode, http.StatusNotFound)
+}
+
+func TestWriteRawBodyResponse(t *testing.T) {
+	data := "city,pop\ntoronto,40000000\n"
+	f := sizeFile{File: qfs.NewMemfileBytes("body.csv", []byte(data)), size: int64(len(data))}
+	length := rawBodyLength(&dataset.Structure{}, f)
+	if length != int64(len(data)) {
+		t.Fatalf("expected a structure without a length to fall back to the file size %d, got %d", len(data), length)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	writeRawBodyResponse(w, r, f, length, &httpRange{Unit: rangeUnitBytes, First: 0, Last: 3})
+	assertStatusCode(t, "byte range", w.Code, http.StatusPartialContent)
+	if diff := cmp.Diff("city", w.Body.String()); diff != "" {
+		t.Errorf("byte range mismatch (-want +got):\n%s", diff)
+	}
+
+	// unknown lengths serve the full body
+	w = httptest.NewRecorder()
+	writeRawBodyResponse(w, r, strings.NewReader(data), -1, &httpRange{Unit: rangeUnitBytes, First: 0, Last: 3})
+	assertStatusCode(t, "unknown length", w.Code, http.StatusOK)
+	if diff := cmp.Diff(data, w.Body.String()); diff != "" {
+		t.Errorf("full body mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// sizeFile is a file that reports its size
+type sizeFile struct {
+	qfs.File
+	size int64
+}
+
+func (f sizeFile) Size() int64 { return f.size }
+
+func TestParseRangeHeader(t *testing.T) {
+	cases := []struct {
+		header string
+		expect *httpRange
+		err    string
+	}{
+		{"", nil, ""},
+		{"bytes=0-499", &httpRange{Unit: "bytes", First: 0, Last: 499}, ""},
+		{"bytes=500-", &httpRange{Unit: "bytes", First: 500, Last: -1}, ""},
+		{"bytes=-500", &httpRange{Unit: "bytes", First: -1, Last: 500}, ""},
+		{"rows=10-19", &httpRange{Unit: "rows", First: 10, Last: 19}, ""},
+		{"rows=0-1, 5-6", nil, ""},
+		{"pages=0-1", nil, `unsupported range unit "pages"`},
+		{"bytes", nil, `invalid range "bytes"`},
+		{"bytes=5", nil, `invalid range "bytes=5"`},
+		{"bytes=5-2", nil, `invalid range "bytes=5-2"`},
+		{"bytes=-0", nil, `invalid range "bytes=-0"`},
+		{"rows=a-b", nil, `invalid range "rows=a-b"`},
+	}
+
+	for i, c := range cases {
+		got, err := parseRangeHeader(c.header)
+		if (c.err != "" && err == nil) || (err != nil && c.err != err.Error()) {
+			t.Errorf("case %d, error mismatch: expected '%s' but got '%s'", i, c.err, err)
+			continue
+		}
+		if diff := cmp.Diff(c.expect, got); diff != "" {
+			t.Errorf("case %d: output mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+func TestHTTPRangeResolve(t *testing.T) {
+	cases := []struct {
+		rng         httpRange
+		total       int64
+		first, last int64
+		err         error
+	}{
+		{httpRange{First: 0, Last: 9}, 100, 0, 9, nil},
+		{httpRange{First: 90, Last: -1}, 100, 90, 99, nil},
+		{httpRange{First: 90, Last: 200}, 100, 90, 99, nil},
+		{httpRange{First: -1, Last: 10}, 100, 90, 99, nil},
+		{httpRange{First: -1, Last: 200}, 100, 0, 99, nil},
+		{httpRange{First: 100, Last: -1}, 100, 0, 0, errRangeNotSatisfiable},
+		{httpRange{First: -1, Last: 1}, 0, 0, 0, errRangeNotSatisfiable},
+	}
+
+	for i, c := range cases {
+		first, last, err := c.rng.Resolve(c.total)
+		if err != c.err {
+			t.Errorf("case %d, error mismatch: expected '%v' but got '%v'", i, c.err, err)
+			continue
+		}
+		if first != c.first || last != c.last {
+			t.Errorf("case %d: expected range %d-%d, got %d-%d", i, c.first, c.last, first, last)
+		}
+	}
+}
+
+func bodyStreamCall(t *testing.T, h http.HandlerFunc, method, url string, muxVars, headers map[string]string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, url, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req = mux.SetURLVars(req, muxVars)
+	setRefStringFromMuxVars(req)
+	if err := setMuxVarsToQueryParams(req); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w.Result()
+}
+
+func readBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
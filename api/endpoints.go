@@ -15,6 +15,12 @@ const (
 	AEIPFS qhttp.APIEndpoint = "/qfs/ipfs/{path:.*}"
 	// AEWebUI serves the remote WebUI
 	AEWebUI qhttp.APIEndpoint = "/webui"
+	// AEMetrics serves request metrics in the Prometheus text exposition format
+	AEMetrics qhttp.APIEndpoint = "/metrics"
+	// AEOpenAPI serves the OpenAPI 3 document describing the API
+	AEOpenAPI qhttp.APIEndpoint = "/openapi.json"
+	// AEEvents streams events to an authenticated client as server-sent events
+	AEEvents qhttp.APIEndpoint = "/events"
 
 	// dataset endpoints
 
@@ -22,8 +28,52 @@ const (
 	AEGetCSVFullRef qhttp.APIEndpoint = "/ds/get/{username}/{name}/at/{fs}/{hash}/body.csv"
 	// AEGetCSVShortRef is the route used to get a body as a csv
 	AEGetCSVShortRef qhttp.APIEndpoint = "/ds/get/{username}/{name}/body.csv"
+	// AEBody streams a dataset body, supporting range requests & conditional
+	// GETs
+	AEBody qhttp.APIEndpoint = "/ds/body"
 	// AEUnpack unpacks a zip file and sends it back
 	AEUnpack qhttp.APIEndpoint = "/ds/unpack"
 	// AESaveByUpload is the route used to save a dataset using a multipart form file in the request
 	AESaveByUpload qhttp.APIEndpoint = "/ds/save/upload"
+	// AEUpload begins a resumable body upload
+	AEUpload qhttp.APIEndpoint = "/ds/upload"
+	// AEUploadID reports on, appends to, finalizes or cancels a resumable upload
+	AEUploadID qhttp.APIEndpoint = "/ds/upload/{id}"
 )
+
+// readOnlyEndpoints lists the endpoints that modify repo state, which are
+// disabled when the API is configured to be read-only. Endpoints mapped to true
+// also serve reads over GET, and remain accessible for GET & HEAD requests
+var readOnlyEndpoints = map[qhttp.APIEndpoint]bool{
+	// dataset endpoints
+	qhttp.AESave:   false,
+	qhttp.AERemove: false,
+	qhttp.AERename: false,
+	qhttp.AEPull:   false,
+	qhttp.AEPush:   false,
+	AESaveByUpload: false,
+	AEUpload:       false,
+	AEUploadID:     false,
+
+	// automation endpoints
+	qhttp.AEDeploy:         false,
+	qhttp.AERun:            false,
+	qhttp.AECancel:         false,
+	qhttp.AERemoveWorkflow: false,
+
+	// profile endpoints
+	qhttp.AESetProfile:      false,
+	qhttp.AESetProfilePhoto: false,
+	qhttp.AESetPosterPhoto:  false,
+
+	// remote & registry endpoints
+	qhttp.AERemoteRemove:   false,
+	qhttp.AERegistryNew:    false,
+	qhttp.AERegistryProve:  false,
+	qhttp.AERegistryFollow: false,
+
+	// sync endpoints
+	qhttp.AERemoteDSync:   true,
+	qhttp.AERemoteLogSync: true,
+	qhttp.AERemoteRefs:    true,
+}
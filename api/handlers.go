@@ -11,6 +11,7 @@ import (
 	"github.com/affix-io/affix/base/archive"
 	"github.com/affix-io/affix/event"
 	"github.com/affix-io/affix/lib"
+	"github.com/affix-io/affix/requestid"
 	"github.com/affix-io/dataset"
 )
 
@@ -35,13 +36,24 @@ func GetBodyCSVHandler(inst *lib.Instance) http.HandlerFunc {
 			return
 		}
 
+		q, err := parseBodyQueryFromRequest(r)
+		if err != nil {
+			util.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
 		p.Selector = "body"
 		if err := validateCSVRequest(r, p); err != nil {
 			util.WriteErrResponse(w, http.StatusBadRequest, err)
 			return
 		}
 
-		outBytes, err := inst.Dataset().GetCSV(r.Context(), p)
+		var outBytes []byte
+		if q != nil {
+			outBytes, err = getCSVWithQuery(r.Context(), inst, p, q)
+		} else {
+			outBytes, err = inst.Dataset().GetCSV(r.Context(), p)
+		}
 		if err != nil {
 			util.RespondWithError(w, err)
 			return
@@ -64,6 +76,12 @@ func GetHandler(inst *lib.Instance, routePrefix string) http.HandlerFunc {
 			return
 		}
 
+		q, err := parseBodyQueryFromRequest(r)
+		if err != nil {
+			util.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
 		format := r.FormValue("format")
 
 		switch {
@@ -75,7 +93,12 @@ func GetHandler(inst *lib.Instance, routePrefix string) http.HandlerFunc {
 				util.WriteErrResponse(w, http.StatusBadRequest, err)
 				return
 			}
-			outBytes, err := inst.Dataset().GetCSV(r.Context(), p)
+			var outBytes []byte
+			if q != nil {
+				outBytes, err = getCSVWithQuery(r.Context(), inst, p, q)
+			} else {
+				outBytes, err = inst.Dataset().GetCSV(r.Context(), p)
+			}
 			if err != nil {
 				util.RespondWithError(w, err)
 				return
@@ -93,7 +116,12 @@ func GetHandler(inst *lib.Instance, routePrefix string) http.HandlerFunc {
 				util.WriteErrResponse(w, http.StatusBadRequest, err)
 				return
 			}
-			zipResults, err := inst.Dataset().GetZip(r.Context(), p)
+			var zipResults *lib.GetZipResults
+			if q != nil {
+				zipResults, err = getZipWithQuery(r.Context(), inst, p, q)
+			} else {
+				zipResults, err = inst.Dataset().GetZip(r.Context(), p)
+			}
 			if err != nil {
 				util.RespondWithError(w, err)
 				return
@@ -102,6 +130,27 @@ func GetHandler(inst *lib.Instance, routePrefix string) http.HandlerFunc {
 			writeFileResponse(w, zipResults.Bytes, zipResults.GeneratedName, "zip")
 			return
 
+		case p.Selector == "body" && acceptsStreamOnlyFormat(r):
+			// Examples:
+			// curl -H "Accept: application/vnd.apache.arrow.stream" http://localhost:2503/ds/get/b5/world_bank_population/body
+			// curl http://localhost:2503/ds/get/b5/world_bank_population/body?format=parquet
+			GetBodyStreamHandler(inst)(w, r)
+			return
+
+		case q != nil:
+			// Examples:
+			// curl "http://localhost:2503/ds/get/b5/world_bank_population/body?columns=country,pop&where=pop>1000000&sort=-pop"
+			if p.Selector != "body" {
+				util.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("columns, where & sort params require the body selector or zip format"))
+				return
+			}
+			body, err := getBodyWithQuery(r.Context(), inst, p, q)
+			if err != nil {
+				util.RespondWithError(w, err)
+				return
+			}
+			util.WriteResponse(w, body)
+
 		default:
 			res, err := inst.Dataset().Get(r.Context(), p)
 			if err != nil {
@@ -215,6 +264,13 @@ func extensionToMimeType(ext string) string {
 		return "text/csv"
 	case ".json":
 		return "application/json"
+	case ".ndjson":
+		return "application/x-ndjson"
+	case ".parquet":
+		return "application/vnd.apache.parquet"
+	case ".arrow":
+		// bodies are always served in the arrow IPC streaming format
+		return "application/vnd.apache.arrow.stream"
 	case ".yaml":
 		return "application/x-yaml"
 	case ".xlsx":
@@ -253,5 +309,5 @@ func publishDownloadEvent(ctx context.Context, inst *lib.Instance, refStr string
 		log.Debugw("api.GetBodyCSVHandler - unable to resolve ref %q", err)
 		return
 	}
-	inst.Bus().Publish(ctx, event.ETDatasetDownload, ref.InitID)
+	inst.Bus().PublishID(ctx, event.ETDatasetDownload, requestid.FromCtx(ctx), ref.InitID)
 }
@@ -18,6 +18,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/affix-io/affix/base/archive"
 	"github.com/affix-io/affix/lib"
 	"github.com/affix-io/dataset"
 	"github.com/affix-io/dataset/dstest"
@@ -104,6 +105,12 @@ func TestDatasetGet(t *testing.T) {
 	actualStatusCode, _ = APICall("/get/peer/test_ds/body?format=csv", GetHandler(run.Inst, ""), map[string]string{"username": "peer", "name": "test_ds", "selector": "body"})
 	assertStatusCode(t, "get csv file using format", actualStatusCode, 200)
 
+	// Can get columnar body files using format
+	for _, format := range []string{"ndjson", "parquet", "arrow"} {
+		actualStatusCode, _ = APICall("/get/peer/test_ds/body?format="+format, GetHandler(run.Inst, ""), map[string]string{"username": "peer", "name": "test_ds", "selector": "body"})
+		assertStatusCode(t, "get body file using format "+format, actualStatusCode, 200)
+	}
+
 	// Can get zip file
 	actualStatusCode, _ = APICall("/get/peer/test_ds?format=zip", GetHandler(run.Inst, ""), map[string]string{"username": "peer", "name": "test_ds"})
 	assertStatusCode(t, "get zip file", actualStatusCode, 200)
@@ -133,6 +140,125 @@ func TestDatasetGet(t *testing.T) {
 	assertStatusCode(t, "invalid dsref", actualStatusCode, 400)
 }
 
+func TestDatasetGetBodyQuery(t *testing.T) {
+	run := NewAPITestRunner(t)
+	defer run.Delete()
+
+	ds := dataset.Dataset{
+		Name: "test_ds",
+		Meta: &dataset.Meta{
+			Title: "title one",
+		},
+	}
+	run.SaveDataset(&ds, "testdata/cities/data.csv")
+
+	bodyVars := map[string]string{"username": "peer", "name": "test_ds", "selector": "body"}
+	query := "columns=city,pop&where=in_usa%20=%20true%20AND%20pop%20%3C%201000000&sort=-pop"
+
+	// json body
+	actualStatusCode, actualBody := APICall("/get/peer/test_ds/body?"+query, GetHandler(run.Inst, ""), bodyVars)
+	assertStatusCode(t, "query json body", actualStatusCode, 200)
+	res := struct {
+		Data []interface{}
+	}{}
+	if err := json.Unmarshal([]byte(actualBody), &res); err != nil {
+		t.Fatal(err)
+	}
+	expect := []interface{}{
+		[]interface{}{"chicago", float64(300000)},
+		[]interface{}{"raleigh", float64(250000)},
+		[]interface{}{"chatham", float64(35000)},
+	}
+	if diff := cmp.Diff(expect, res.Data); diff != "" {
+		t.Errorf("query json body mismatch (-want +got):\n%s", diff)
+	}
+
+	// csv body, paging through query results
+	actualStatusCode, actualBody = APICall("/get/peer/test_ds/body?format=csv&limit=2&offset=1&"+query, GetHandler(run.Inst, ""), bodyVars)
+	assertStatusCode(t, "query csv body", actualStatusCode, 200)
+	if diff := cmp.Diff("city,pop\nraleigh,250000\nchatham,35000\n", actualBody); diff != "" {
+		t.Errorf("query csv body mismatch (-want +got):\n%s", diff)
+	}
+
+	// zip archive with a narrowed body
+	actualStatusCode, actualBody = APICall("/get/peer/test_ds?format=zip&"+query, GetHandler(run.Inst, ""), map[string]string{"username": "peer", "name": "test_ds"})
+	assertStatusCode(t, "query zip", actualStatusCode, 200)
+	contents, err := archive.UnzipGetContents([]byte(actualBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("city,pop\nchicago,300000\nraleigh,250000\nchatham,35000\n", contents["body.csv"]); diff != "" {
+		t.Errorf("query zip body mismatch (-want +got):\n%s", diff)
+	}
+
+	// Error 400 for an unknown column
+	actualStatusCode, _ = APICall("/get/peer/test_ds/body?sort=country", GetHandler(run.Inst, ""), bodyVars)
+	assertStatusCode(t, "unknown query column", actualStatusCode, 400)
+
+	// Error 400 for an invalid expression
+	actualStatusCode, _ = APICall("/get/peer/test_ds/body?where=pop%20%3E", GetHandler(run.Inst, ""), bodyVars)
+	assertStatusCode(t, "invalid where expression", actualStatusCode, 400)
+
+	// Error 400 when querying a component other than the body
+	actualStatusCode, _ = APICall("/get/peer/test_ds/meta?columns=city", GetHandler(run.Inst, ""), map[string]string{"username": "peer", "name": "test_ds", "selector": "meta"})
+	assertStatusCode(t, "query non-body component", actualStatusCode, 400)
+}
+
+func TestSQLQuery(t *testing.T) {
+	run := NewAPITestRunner(t)
+	defer run.Delete()
+
+	ds := dataset.Dataset{Name: "test_ds"}
+	run.SaveDataset(&ds, "testdata/cities/data.csv")
+
+	ts := run.MustTestServer(t)
+	defer ts.Close()
+
+	query := func(body string) (int, string) {
+		t.Helper()
+		res, err := http.Post(ts.URL+"/ds/query", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(data)
+	}
+
+	status, body := query(`{"query":"SELECT city, pop FROM peer/test_ds WHERE in_usa = true ORDER BY pop DESC","limit":2}`)
+	assertStatusCode(t, "sql query", status, 200)
+	res := struct {
+		Data struct {
+			Columns []string
+			Rows    [][]interface{}
+		}
+	}{}
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"city", "pop"}, res.Data.Columns); diff != "" {
+		t.Errorf("sql query columns mismatch (-want +got):\n%s", diff)
+	}
+	expect := [][]interface{}{
+		{"new york", float64(8500000)},
+		{"chicago", float64(300000)},
+	}
+	if diff := cmp.Diff(expect, res.Data.Rows); diff != "" {
+		t.Errorf("sql query rows mismatch (-want +got):\n%s", diff)
+	}
+
+	// Error 400 for an invalid query
+	status, _ = query(`{"query":"SELECT FROM"}`)
+	assertStatusCode(t, "invalid sql query", status, 400)
+
+	// Error 404 for a dataset that doesn't exist
+	status, _ = query(`{"query":"SELECT city FROM peer/not_a_dataset"}`)
+	assertStatusCode(t, "unknown sql dataset", status, 404)
+}
+
 func TestUnpackHandler(t *testing.T) {
 	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
@@ -320,6 +446,9 @@ func TestExtensionToMimeType(t *testing.T) {
 		{".csv", "text/csv"},
 		{".json", "application/json"},
 		{".yaml", "application/x-yaml"},
+		{".ndjson", "application/x-ndjson"},
+		{".parquet", "application/vnd.apache.parquet"},
+		{".arrow", "application/vnd.apache.arrow.stream"},
 		{".xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
 		{".zip", "application/zip"},
 		{".txt", "text/plain"},
@@ -64,7 +64,7 @@ func TestHTTPClient(t *testing.T) {
 	inst := lib.NewInstanceFromConfigAndNode(ctx, cfg, node)
 	s := New(inst)
 
-	server := httptest.NewServer(NewServerRoutes(s))
+	server := httptest.NewServer(withTestToken(t, inst, NewServerRoutes(s)))
 	sURL, err := url.Parse(server.URL)
 	if err != nil {
 		t.Fatal(err.Error())
@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package api
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listenUnixPrivate listens on a socket file with the given mode. The socket
+// is bound inside a new directory only the user running the node can enter,
+// given its mode, then moved to path, so no other user can connect before the
+// mode is set. The process umask is left alone
+func listenUnixPrivate(path string, mode os.FileMode) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".affix-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, filepath.Base(path))
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixFileListener{Listener: listener, path: path}, nil
+}
+
+// unixFileListener is a unix socket listener bound at a path other than the
+// one the socket file was moved to. It reports & removes the moved file
+type unixFileListener struct {
+	net.Listener
+	path string
+}
+
+// Addr returns the path of the socket file
+func (l *unixFileListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close stops listening and removes the socket file
+func (l *unixFileListener) Close() error {
+	err := l.Listener.Close()
+	if rmErr := os.Remove(l.path); err == nil && !os.IsNotExist(rmErr) {
+		err = rmErr
+	}
+	return err
+}
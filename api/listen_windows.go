@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnixPrivate listens on a socket file with the given mode. Windows
+// has no unix file modes, socket access is controlled by the ACL of the
+// directory the socket is in
+func listenUnixPrivate(path string, mode os.FileMode) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
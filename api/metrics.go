@@ -0,0 +1,325 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/lib/websocket"
+	"github.com/affix-io/affix/requestid"
+	"github.com/gorilla/mux"
+)
+
+// latencyBuckets are the upper bounds of request duration histogram buckets,
+// in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestMetrics collects per-endpoint request counts & latencies
+type requestMetrics struct {
+	lock      sync.Mutex
+	counts    map[requestCountKey]uint64
+	latencies map[requestLatencyKey]*histogram
+}
+
+type requestCountKey struct {
+	endpoint, method string
+	code             int
+}
+
+type requestLatencyKey struct {
+	endpoint, method string
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{
+		counts:    map[requestCountKey]uint64{},
+		latencies: map[requestLatencyKey]*histogram{},
+	}
+}
+
+// observe records a completed request. Methods other than the standard HTTP
+// methods are recorded as "other", so clients can't add label values
+func (m *requestMetrics) observe(endpoint, method string, code int, d time.Duration) {
+	method = metricsMethod(method)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.counts[requestCountKey{endpoint, method, code}]++
+
+	lk := requestLatencyKey{endpoint, method}
+	h, ok := m.latencies[lk]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.latencies[lk] = h
+	}
+	secs := d.Seconds()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (m *requestMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	buf := &strings.Builder{}
+
+	countKeys := make([]requestCountKey, 0, len(m.counts))
+	for k := range m.counts {
+		countKeys = append(countKeys, k)
+	}
+	sort.Slice(countKeys, func(i, j int) bool {
+		a, b := countKeys[i], countKeys[j]
+		if a.endpoint != b.endpoint {
+			return a.endpoint < b.endpoint
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	buf.WriteString("# HELP affix_http_requests_total Total number of HTTP requests by endpoint, method and status code.\n")
+	buf.WriteString("# TYPE affix_http_requests_total counter\n")
+	for _, k := range countKeys {
+		fmt.Fprintf(buf, "affix_http_requests_total{endpoint=%s,method=%s,code=\"%d\"} %d\n", promLabel(k.endpoint), promLabel(k.method), k.code, m.counts[k])
+	}
+
+	latencyKeys := make([]requestLatencyKey, 0, len(m.latencies))
+	for k := range m.latencies {
+		latencyKeys = append(latencyKeys, k)
+	}
+	sort.Slice(latencyKeys, func(i, j int) bool {
+		a, b := latencyKeys[i], latencyKeys[j]
+		if a.endpoint != b.endpoint {
+			return a.endpoint < b.endpoint
+		}
+		return a.method < b.method
+	})
+	buf.WriteString("# HELP affix_http_request_duration_seconds HTTP request latency by endpoint and method.\n")
+	buf.WriteString("# TYPE affix_http_request_duration_seconds histogram\n")
+	for _, k := range latencyKeys {
+		h := m.latencies[k]
+		labels := fmt.Sprintf("endpoint=%s,method=%s", promLabel(k.endpoint), promLabel(k.method))
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(buf, "affix_http_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, le, h.buckets[i])
+		}
+		fmt.Fprintf(buf, "affix_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(buf, "affix_http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(buf, "affix_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	n, err := io.WriteString(w, buf.String())
+	return int64(n), err
+}
+
+// promLabel quotes & escapes a Prometheus label value
+func promLabel(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, "\n", `\n`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return `"` + v + `"`
+}
+
+// writeConnMetrics writes the outbound queue state of websocket connections &
+// event streams in the Prometheus text exposition format. Metrics are labelled
+// by transport only, connection IDs & profiles would give each connection its
+// own series
+func writeConnMetrics(w io.Writer, stats []websocket.QueueStats) (int64, error) {
+	buf := &strings.Builder{}
+	buf.WriteString("# HELP affix_event_connections Number of open websocket connections or event streams.\n")
+	buf.WriteString("# TYPE affix_event_connections gauge\n")
+	for _, st := range stats {
+		fmt.Fprintf(buf, "affix_event_connections{transport=%s} %d\n", promLabel(st.Transport), st.Conns)
+	}
+	buf.WriteString("# HELP affix_event_queue_depth Number of events waiting to be sent to websocket connections or event streams.\n")
+	buf.WriteString("# TYPE affix_event_queue_depth gauge\n")
+	for _, st := range stats {
+		fmt.Fprintf(buf, "affix_event_queue_depth{transport=%s} %d\n", promLabel(st.Transport), st.QueueDepth)
+	}
+	buf.WriteString("# HELP affix_event_dropped_total Events dropped because a websocket connection or event stream fell behind.\n")
+	buf.WriteString("# TYPE affix_event_dropped_total counter\n")
+	for _, st := range stats {
+		fmt.Fprintf(buf, "affix_event_dropped_total{transport=%s} %d\n", promLabel(st.Transport), st.Dropped)
+	}
+
+	n, err := io.WriteString(w, buf.String())
+	return int64(n), err
+}
+
+// metricsMethod returns the method label of a request
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// MetricsHandler serves request metrics in the Prometheus text format
+func (s Server) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	s.metrics.WriteTo(w)
+	if s.websocket != nil {
+		writeConnMetrics(w, s.websocket.Stats())
+	}
+}
+
+// accessRecord describes a single request for access logging. Handlers
+// further down the middleware chain annotate the record through the request
+// context
+type accessRecord struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"requestID,omitempty"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Endpoint   string  `json:"endpoint"`
+	Status     int     `json:"status"`
+	DurationMS float64 `json:"durationMs"`
+	Bytes      int64   `json:"bytes"`
+	ProfileID  string  `json:"profileID,omitempty"`
+	RemoteAddr string  `json:"remoteAddr,omitempty"`
+
+	noLog bool
+}
+
+type accessRecordCtxKey struct{}
+
+func accessRecordFromCtx(ctx context.Context) *accessRecord {
+	rec, _ := ctx.Value(accessRecordCtxKey{}).(*accessRecord)
+	return rec
+}
+
+// accessLogMiddleware measures every request, adding it to request metrics,
+// and writing a JSON access log line for routes that don't opt out of logging
+// with NoLogMiddleware. it should wrap all other router middleware except
+// requestIDMiddleware, so requests rejected by other middleware are still
+// recorded. Router middleware doesn't run for requests that match no route,
+// use recordUnmatched to record those too
+func (s Server) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecord{
+			RequestID:  requestid.FromCtx(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Endpoint:   "unmatched",
+			RemoteAddr: r.RemoteAddr,
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				rec.Endpoint = tmpl
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		// record from a deferred call so aborted handlers are still counted
+		defer s.recordAccess(rec, sw, start)
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessRecordCtxKey{}, rec)))
+	})
+}
+
+// recordUnmatched answers requests matching no route, or none of a route's
+// methods, with JSON 404 & 405 responses that are logged & counted like
+// matched requests
+func (s Server) recordUnmatched(m *mux.Router) {
+	m.NotFoundHandler = requestIDMiddleware(s.accessLogMiddleware(http.HandlerFunc(util.NotFoundHandler)))
+	m.MethodNotAllowedHandler = requestIDMiddleware(s.accessLogMiddleware(http.HandlerFunc(util.MethodNotAllowedHandler)))
+}
+
+func (s Server) recordAccess(rec *accessRecord, sw *statusWriter, start time.Time) {
+	duration := time.Since(start)
+	rec.Status = sw.Status()
+	s.metrics.observe(rec.Endpoint, rec.Method, rec.Status, duration)
+
+	if rec.noLog || s.opts.AccessLog == nil {
+		return
+	}
+	rec.Time = start.UTC().Format(time.RFC3339Nano)
+	rec.DurationMS = float64(duration.Microseconds()) / 1000
+	rec.Bytes = sw.bytes
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Debugw("encoding access log", "err", err)
+		return
+	}
+	s.accessLogLock.Lock()
+	s.opts.AccessLog.Write(append(data, '\n'))
+	s.accessLogLock.Unlock()
+}
+
+// statusWriter wraps an http.ResponseWriter, recording the status code and
+// number of bytes written
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Status returns the response status code
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// WriteHeader implements the http.ResponseWriter interface
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the http.ResponseWriter interface
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements the http.Flusher interface, required by streaming
+// responses
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements the http.Hijacker interface, required by websocket
+// connections
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
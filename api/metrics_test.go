@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/affix-io/affix/lib/websocket"
+	"github.com/gorilla/mux"
+)
+
+func TestRequestMetricsWriteTo(t *testing.T) {
+	m := newRequestMetrics()
+	m.observe("/ds/get", "POST", 200, time.Millisecond*20)
+	m.observe("/ds/get", "POST", 200, time.Millisecond*200)
+	m.observe("/ds/get", "POST", 404, time.Millisecond)
+	m.observe(`/weird"path`, "GET", 200, time.Second*20)
+	m.observe("/ds/get", "PROPFIND", 404, time.Millisecond)
+	m.observe("/ds/get", "get", 404, time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	expectLines := []string{
+		`# TYPE affix_http_requests_total counter`,
+		`affix_http_requests_total{endpoint="/ds/get",method="POST",code="200"} 2`,
+		`affix_http_requests_total{endpoint="/ds/get",method="POST",code="404"} 1`,
+		`affix_http_requests_total{endpoint="/weird\"path",method="GET",code="200"} 1`,
+		`affix_http_requests_total{endpoint="/ds/get",method="other",code="404"} 2`,
+		`# TYPE affix_http_request_duration_seconds histogram`,
+		`affix_http_request_duration_seconds_bucket{endpoint="/ds/get",method="POST",le="0.005"} 1`,
+		`affix_http_request_duration_seconds_bucket{endpoint="/ds/get",method="POST",le="0.025"} 2`,
+		`affix_http_request_duration_seconds_bucket{endpoint="/ds/get",method="POST",le="0.25"} 3`,
+		`affix_http_request_duration_seconds_bucket{endpoint="/ds/get",method="POST",le="+Inf"} 3`,
+		`affix_http_request_duration_seconds_count{endpoint="/ds/get",method="POST"} 3`,
+		`affix_http_request_duration_seconds_bucket{endpoint="/weird\"path",method="GET",le="10"} 0`,
+		`affix_http_request_duration_seconds_bucket{endpoint="/weird\"path",method="GET",le="+Inf"} 1`,
+	}
+	for _, line := range expectLines {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expected metrics output to contain line:\n%s\ngot:\n%s", line, got)
+		}
+	}
+}
+
+func TestWriteConnMetrics(t *testing.T) {
+	stats := []websocket.QueueStats{
+		{Transport: websocket.TransportWebsocket, Conns: 1, QueueDepth: 3},
+		{Transport: websocket.TransportSSE, Conns: 2, QueueDepth: 256, Dropped: 12},
+	}
+	buf := &bytes.Buffer{}
+	if _, err := writeConnMetrics(buf, stats); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	expectLines := []string{
+		`# TYPE affix_event_connections gauge`,
+		`affix_event_connections{transport="websocket"} 1`,
+		`affix_event_connections{transport="sse"} 2`,
+		`# TYPE affix_event_queue_depth gauge`,
+		`affix_event_queue_depth{transport="websocket"} 3`,
+		`affix_event_queue_depth{transport="sse"} 256`,
+		`# TYPE affix_event_dropped_total counter`,
+		`affix_event_dropped_total{transport="websocket"} 0`,
+		`affix_event_dropped_total{transport="sse"} 12`,
+	}
+	for _, line := range expectLines {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("expected metrics output to contain line:\n%s\ngot:\n%s", line, got)
+		}
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	logs := &bytes.Buffer{}
+	s := Server{
+		opts:          Options{AccessLog: logs},
+		metrics:       newRequestMetrics(),
+		accessLogLock: &sync.Mutex{},
+	}
+
+	m := mux.NewRouter()
+	m.Use(requestIDMiddleware)
+	m.Use(s.accessLogMiddleware)
+	s.recordUnmatched(m)
+	m.Handle(AEHealth.String(), s.NoLogMiddleware(HealthCheckHandler))
+	m.Handle("/ds/get/{username}/{name}", s.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	m.Handle(AEMetrics.String(), s.NoLogMiddleware(s.MetricsHandler)).Methods(http.MethodGet)
+
+	for _, path := range []string{"/health", "/health", "/ds/get/peer/cities"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		m.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected exactly one access log line, got %d:\n%s", len(lines), logs.String())
+	}
+	rec := accessRecord{}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.RequestID != "req-1" || rec.Endpoint != "/ds/get/{username}/{name}" || rec.Path != "/ds/get/peer/cities" || rec.Status != http.StatusTeapot || rec.Bytes != 15 {
+		t.Errorf("unexpected access log record: %s", lines[0])
+	}
+
+	// requests matching no route skip router middleware, but are still
+	// recorded
+	logs.Reset()
+	unmatched := []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/unknown/path", http.StatusNotFound},
+		{http.MethodPost, "/metrics", http.StatusMethodNotAllowed},
+	}
+	for _, c := range unmatched {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.status {
+			t.Errorf("%s %s: expected status %d, got %d", c.method, c.path, c.status, w.Code)
+		}
+		if w.Header().Get("X-Request-ID") == "" {
+			t.Errorf("%s %s: expected a request ID", c.method, c.path)
+		}
+	}
+	lines = strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != len(unmatched) {
+		t.Fatalf("expected an access log line for each unmatched request, got %d:\n%s", len(lines), logs.String())
+	}
+	for i, line := range lines {
+		rec := accessRecord{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if c := unmatched[i]; rec.Endpoint != "unmatched" || rec.Path != c.path || rec.Status != c.status {
+			t.Errorf("unexpected access log record: %s", line)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, expect := range []string{
+		`affix_http_requests_total{endpoint="/health",method="GET",code="200"} 2`,
+		`affix_http_requests_total{endpoint="unmatched",method="GET",code="404"} 1`,
+		`affix_http_requests_total{endpoint="unmatched",method="POST",code="405"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), expect) {
+			t.Errorf("expected metrics to contain %q. got:\n%s", expect, w.Body.String())
+		}
+	}
+}
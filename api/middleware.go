@@ -3,10 +3,12 @@ package api
 import (
 	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/affix-io/affix/api/util"
 	"github.com/affix-io/affix/dsref"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/affix-io/affix/requestid"
 	"github.com/gorilla/mux"
 )
 
@@ -22,14 +24,39 @@ func (s Server) NoLogMiddleware(handler http.HandlerFunc) http.HandlerFunc {
 
 func (s Server) mwFunc(handler http.HandlerFunc, shouldLog bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if shouldLog {
-			log.Infof("%s %s %s", r.Method, r.URL.Path, time.Now())
+		r = withRequestID(w, r)
+		// annotate the record written by accessLogMiddleware. the caller's
+		// profile is set by authorizationMiddleware
+		if rec := accessRecordFromCtx(r.Context()); rec != nil {
+			rec.noLog = !shouldLog
 		}
 
 		handler.ServeHTTP(w, r)
 	}
 }
 
+// requestIDMiddleware ensures every request carries a request ID
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withRequestID(w, r))
+	})
+}
+
+// withRequestID adds a request ID to the request context & response headers.
+// IDs are accepted from the request header if present & valid, otherwise one
+// is generated. requests that already have an ID in context are left as-is
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if requestid.FromCtx(r.Context()) != "" {
+		return r
+	}
+	id := r.Header.Get(requestid.Header)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+	w.Header().Set(requestid.Header, id)
+	return r.WithContext(requestid.AddToContext(r.Context(), id))
+}
+
 // corsMiddleware adds Cross-Origin Resource Sharing headers for any request
 // who's origin matches one of allowedOrigins
 func corsMiddleware(allowedOrigins []string) mux.MiddlewareFunc {
@@ -39,8 +66,9 @@ func corsMiddleware(allowedOrigins []string) mux.MiddlewareFunc {
 			for _, o := range allowedOrigins {
 				if origin == o {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
-					w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, DELETE, OPTIONS")
-					w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+					w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, PATCH, POST, DELETE, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,Upload-Length,Upload-Offset,X-Request-ID,Last-Event-ID")
+					w.Header().Set("Access-Control-Expose-Headers", "Location,Upload-Length,Upload-Offset,Upload-Expires,X-Request-ID")
 					w.Header().Set("Access-Control-Allow-Credentials", "true")
 				}
 			}
@@ -113,3 +141,49 @@ func muxVarsPath(mvars map[string]string) string {
 	}
 	return ""
 }
+
+// readOnlyMiddleware rejects requests to mutating endpoints with a 403 naming
+// the endpoint. Endpoints mapped to true in mutating still serve GET & HEAD
+// requests, which lets read-only remotes keep answering sync reads
+func readOnlyMiddleware(mutating map[qhttp.APIEndpoint]bool) mux.MiddlewareFunc {
+	endpoints := make([]qhttp.APIEndpoint, 0, len(mutating))
+	for ep := range mutating {
+		endpoints = append(endpoints, ep)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tmpl, err := route.GetPathTemplate()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ep, ok := matchEndpoint(tmpl, endpoints)
+			if !ok || (mutating[ep] && (r.Method == http.MethodGet || r.Method == http.MethodHead)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			readOnlyResponse(w, ep.String())
+		})
+	}
+}
+
+// matchEndpoint finds the endpoint a mux path template belongs to, which is
+// the longest endpoint the template is equal to or nested below
+func matchEndpoint(tmpl string, endpoints []qhttp.APIEndpoint) (match qhttp.APIEndpoint, ok bool) {
+	for _, ep := range endpoints {
+		s := ep.String()
+		if tmpl != s && (s == "/" || !strings.HasPrefix(tmpl, strings.TrimSuffix(s, "/")+"/")) {
+			continue
+		}
+		if !ok || len(s) > len(match) {
+			match, ok = ep, true
+		}
+	}
+	return match, ok
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/affix-io/affix/requestid"
+	"github.com/gorilla/mux"
+)
+
+func TestReadOnlyMiddleware(t *testing.T) {
+	m := mux.NewRouter()
+	m.Use(readOnlyMiddleware(readOnlyEndpoints))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	m.HandleFunc(AEHealth.String(), ok)
+	m.HandleFunc(qhttp.AEGet.String(), ok)
+	m.HandleFunc(qhttp.AESave.String(), ok)
+	m.HandleFunc(AESaveByUpload.String(), ok)
+	m.HandleFunc(AEUploadID.String(), ok)
+	m.HandleFunc(qhttp.AERemoteDSync.String(), ok)
+
+	cases := []struct {
+		method, path string
+		expect       int
+	}{
+		{http.MethodGet, "/health", http.StatusOK},
+		{http.MethodPost, "/ds/get", http.StatusOK},
+		{http.MethodPost, "/ds/save", http.StatusForbidden},
+		{http.MethodPost, "/ds/save/upload", http.StatusForbidden},
+		{http.MethodPatch, "/ds/upload/abc", http.StatusForbidden},
+		{http.MethodOptions, "/ds/save", http.StatusOK},
+		{http.MethodGet, "/remote/dsync", http.StatusOK},
+		{http.MethodPut, "/remote/dsync", http.StatusForbidden},
+	}
+
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.expect {
+			t.Errorf("case %d %s %s: expected status %d, got %d", i, c.method, c.path, c.expect, w.Code)
+		}
+		if w.Code == http.StatusForbidden && !strings.Contains(w.Body.String(), "read-only mode") {
+			t.Errorf("case %d %s %s: expected read-only error message, got: %s", i, c.method, c.path, w.Body.String())
+		}
+	}
+}
+
+func TestMatchEndpoint(t *testing.T) {
+	endpoints := []qhttp.APIEndpoint{"/", "/ds/save", "/ds/save/upload", "/ds/get"}
+	cases := []struct {
+		tmpl   string
+		expect qhttp.APIEndpoint
+		ok     bool
+	}{
+		{"/", "/", true},
+		{"/health", "", false},
+		{"/ds/save", "/ds/save", true},
+		{"/ds/save/upload", "/ds/save/upload", true},
+		{"/ds/get/{username}/{name}", "/ds/get", true},
+		{"/ds/getter", "", false},
+	}
+
+	for i, c := range cases {
+		got, ok := matchEndpoint(c.tmpl, endpoints)
+		if got != c.expect || ok != c.ok {
+			t.Errorf("case %d %q: expected (%q, %t), got (%q, %t)", i, c.tmpl, c.expect, c.ok, got, ok)
+		}
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var gotID string
+	h := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = requestid.FromCtx(r.Context())
+	}))
+
+	cases := []struct {
+		header   string
+		generate bool
+	}{
+		{"", true},
+		{"client-provided-id", false},
+		{"invalid id", true},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.header != "" {
+			req.Header.Set(requestid.Header, c.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		resID := w.Header().Get(requestid.Header)
+		if resID != gotID {
+			t.Errorf("case %d: response header %q doesn't match context request ID %q", i, resID, gotID)
+		}
+		if c.generate && (gotID == c.header || !requestid.Valid(gotID)) {
+			t.Errorf("case %d: expected a generated request ID, got %q", i, gotID)
+		}
+		if !c.generate && gotID != c.header {
+			t.Errorf("case %d: expected request ID %q, got %q", i, c.header, gotID)
+		}
+	}
+}
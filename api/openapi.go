@@ -0,0 +1,427 @@
+package api
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/lib"
+	qhttp "github.com/affix-io/affix/lib/http"
+)
+
+// apiOperation describes an endpoint the api package serves directly, rather
+// than through a lib method
+type apiOperation struct {
+	endpoint qhttp.APIEndpoint
+	method   string
+	id       string
+	// request is the content type of the request body, if any
+	request string
+	// response is the content type of a successful response. empty responses
+	// are wrapped in the standard JSON envelope
+	response string
+}
+
+// apiOperations lists endpoints registered in NewServerRoutes
+var apiOperations = []apiOperation{
+	{endpoint: AEHome, method: http.MethodGet, id: "api.home"},
+	{endpoint: AEHealth, method: http.MethodGet, id: "api.health"},
+	{endpoint: AEMetrics, method: http.MethodGet, id: "api.metrics", response: "text/plain"},
+	{endpoint: AEOpenAPI, method: http.MethodGet, id: "api.openapi", response: "application/json"},
+	{endpoint: AEEvents, method: http.MethodGet, id: "api.events", response: "text/event-stream"},
+	{endpoint: AEIPFS, method: http.MethodGet, id: "api.ipfs", response: "application/octet-stream"},
+	{endpoint: AEWebUI, method: http.MethodGet, id: "api.webui", response: "text/html"},
+	{endpoint: qhttp.AEBatch, method: http.MethodPost, id: "api.batch", request: "application/json"},
+	{endpoint: AEToken, method: http.MethodPost, id: "api.token", request: "application/x-www-form-urlencoded"},
+	{endpoint: AERevoke, method: http.MethodPost, id: "api.revoke", request: "application/x-www-form-urlencoded"},
+	{endpoint: AEAuthorize, method: http.MethodGet, id: "api.authorize", response: "text/html"},
+	{endpoint: AEAuthorize, method: http.MethodPost, id: "api.authorize_decision", request: "application/x-www-form-urlencoded", response: "text/html"},
+	{endpoint: AEGetCSVShortRef, method: http.MethodGet, id: "api.get_csv", response: "text/csv"},
+	{endpoint: AEGetCSVFullRef, method: http.MethodGet, id: "api.get_csv_ref", response: "text/csv"},
+	{endpoint: qhttp.AEGet.WithSuffix("{username}/{name}"), method: http.MethodGet, id: "api.get"},
+	{endpoint: qhttp.AEGet.WithSuffix("{username}/{name}/{selector}"), method: http.MethodGet, id: "api.get_selector"},
+	{endpoint: AEBody.WithSuffix("{username}/{name}"), method: http.MethodGet, id: "api.body", response: "application/octet-stream"},
+	{endpoint: AEUnpack, method: http.MethodPost, id: "api.unpack", request: "application/zip"},
+	{endpoint: AESaveByUpload, method: http.MethodPost, id: "api.save_upload", request: "multipart/form-data"},
+	{endpoint: AEUpload, method: http.MethodPost, id: "api.upload_create"},
+	{endpoint: AEUploadID, method: http.MethodHead, id: "api.upload_offset"},
+	{endpoint: AEUploadID, method: http.MethodPatch, id: "api.upload_append", request: "application/offset+octet-stream"},
+	{endpoint: AEUploadID, method: http.MethodPost, id: "api.upload_finalize", request: "multipart/form-data"},
+	{endpoint: AEUploadID, method: http.MethodDelete, id: "api.upload_cancel"},
+}
+
+// OpenAPISpec generates an OpenAPI 3 document describing the HTTP API. lib
+// methods are described by reflecting over each method group's attributes &
+// the Go types of method params & results, using json field tags for
+// property names. The document is a tree of maps, slices & strings that
+// marshals to JSON or YAML
+func OpenAPISpec(methods []lib.MethodSet) map[string]interface{} {
+	g := &specGenerator{
+		schemas: map[string]interface{}{},
+		names:   map[reflect.Type]string{},
+	}
+	// describe the response envelope first so it claims its schema names
+	g.schema(reflect.TypeOf(util.Response{}))
+
+	paths := map[string]interface{}{}
+	tags := []interface{}{}
+	for _, ms := range methods {
+		if g.addMethodSet(paths, ms) {
+			tags = append(tags, map[string]interface{}{"name": ms.Name()})
+		}
+	}
+	tags = append(tags, map[string]interface{}{"name": "api"})
+	for _, op := range apiOperations {
+		g.addAPIOperation(paths, op)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       "affix API",
+			"description": "affix API used to communicate with a affix node.",
+			"version":     "n/a",
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+		},
+	}
+}
+
+// OpenAPIHandler serves the OpenAPI 3 document for the API as JSON
+func OpenAPIHandler(inst *lib.Instance) http.HandlerFunc {
+	var (
+		once sync.Once
+		data []byte
+		err  error
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			util.NotFoundHandler(w, r)
+			return
+		}
+		once.Do(func() {
+			data, err = json.Marshal(OpenAPISpec(inst.AllMethods()))
+		})
+		if err != nil {
+			util.WriteErrResponse(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// specGenerator accumulates component schemas for Go types
+type specGenerator struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+}
+
+var (
+	contextType       = reflect.TypeOf((*context.Context)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// addMethodSet adds an operation for each method of a group that is served
+// over HTTP, reporting whether any were added
+func (g *specGenerator) addMethodSet(paths map[string]interface{}, ms lib.MethodSet) bool {
+	msType := reflect.TypeOf(ms)
+	methods := map[string]reflect.Method{}
+	for i := 0; i < msType.NumMethod(); i++ {
+		m := msType.Method(i)
+		methods[strings.ToLower(m.Name)] = m
+	}
+
+	attrs := ms.Attributes()
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	added := false
+	for _, name := range names {
+		attr := attrs[name]
+		m, ok := methods[name]
+		if attr.Endpoint == qhttp.DenyHTTP || !ok {
+			continue
+		}
+		ft := m.Type
+		op := map[string]interface{}{
+			"operationId": ms.Name() + "." + m.Name,
+			"tags":        []interface{}{ms.Name()},
+		}
+		// method types include the receiver: (recv, ctx, params)
+		if ft.NumIn() == 3 && ft.In(1).Implements(contextType) {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schema(ft.In(2))},
+				},
+			}
+		}
+		var data map[string]interface{}
+		if ft.NumOut() > 1 {
+			data = g.schema(ft.Out(0))
+		}
+		// methods that return a cursor are paginated
+		op["responses"] = envelopeResponses(data, ft.NumOut() == 3)
+
+		verb := strings.ToLower(attr.HTTPVerb)
+		if verb == "" {
+			verb = "post"
+		}
+		addOperation(paths, attr.Endpoint.String(), verb, op)
+		added = true
+	}
+	return added
+}
+
+// pathParam matches gorilla/mux path variables, which may include a pattern
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+func (g *specGenerator) addAPIOperation(paths map[string]interface{}, o apiOperation) {
+	endpoint := pathParam.ReplaceAllString(o.endpoint.String(), "{$1}")
+	op := map[string]interface{}{
+		"operationId": o.id,
+		"tags":        []interface{}{"api"},
+	}
+
+	params := []interface{}{}
+	for _, m := range pathParam.FindAllStringSubmatch(o.endpoint.String(), -1) {
+		params = append(params, map[string]interface{}{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if o.request != "" {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				o.request: map[string]interface{}{
+					"schema": map[string]interface{}{"type": "string", "format": "binary"},
+				},
+			},
+		}
+	}
+
+	if o.response == "" {
+		op["responses"] = envelopeResponses(map[string]interface{}{}, false)
+	} else {
+		responses := envelopeResponses(nil, false)
+		responses["200"] = map[string]interface{}{
+			"description": "OK",
+			"content": map[string]interface{}{
+				o.response: map[string]interface{}{
+					"schema": map[string]interface{}{"type": "string", "format": "binary"},
+				},
+			},
+		}
+		op["responses"] = responses
+	}
+	addOperation(paths, endpoint, strings.ToLower(o.method), op)
+}
+
+func addOperation(paths map[string]interface{}, endpoint, verb string, op map[string]interface{}) {
+	item, ok := paths[endpoint].(map[string]interface{})
+	if !ok {
+		item = map[string]interface{}{}
+		paths[endpoint] = item
+	}
+	item[verb] = op
+}
+
+// envelopeResponses describes responses wrapped in a util.Response envelope.
+// a nil data schema describes a response with no data
+func envelopeResponses(data map[string]interface{}, paged bool) map[string]interface{} {
+	envelope := func(props map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"allOf": []interface{}{
+						schemaRef("Response"),
+						map[string]interface{}{"properties": props},
+					},
+				},
+			},
+		}
+	}
+
+	ok := map[string]interface{}{}
+	if data != nil {
+		ok["data"] = data
+	}
+	if paged {
+		ok["pagination"] = schemaRef("Page")
+	}
+	errResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     envelope(map[string]interface{}{"meta": schemaRef("Meta")}),
+		}
+	}
+	return map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "OK",
+			"content":     envelope(ok),
+		},
+		"400":     errResponse("Bad request"),
+		"500":     errResponse("Server error"),
+		"default": errResponse("Error"),
+	}
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schema describes a Go type the way encoding/json encodes it. Named structs
+// are added to component schemas & referenced
+func (g *specGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t.Kind() != reflect.Struct {
+		ptr := reflect.PtrTo(t)
+		if t.Implements(jsonMarshalerType) || ptr.Implements(jsonMarshalerType) {
+			// custom encodings can't be described by reflection
+			return map[string]interface{}{}
+		}
+		if t.Implements(textMarshalerType) || ptr.Implements(textMarshalerType) {
+			return map[string]interface{}{"type": "string"}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return schemaRef(g.define(t))
+	}
+	// interfaces, functions & channels
+	return map[string]interface{}{}
+}
+
+// define adds a named struct to component schemas, returning its schema
+// name. Types that share a name with an already defined type are qualified by
+// package name
+func (g *specGenerator) define(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := g.schemas[name]; taken {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	g.names[t] = name
+	// claim the name before describing fields, which may refer back to t
+	g.schemas[name] = map[string]interface{}{}
+	g.schemas[name] = g.structSchema(t)
+	return name
+}
+
+func (g *specGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	g.addFields(props, t, false)
+	s := map[string]interface{}{"type": "object"}
+	if len(props) > 0 {
+		s["properties"] = props
+	}
+	return s
+}
+
+// addFields adds properties for the exported fields of a struct, following
+// encoding/json rules for tags & embedded structs. Fields of embedded structs
+// don't replace fields of the outer struct
+func (g *specGenerator) addFields(props map[string]interface{}, t reflect.Type, embedded bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.Index(tag, ","); j >= 0 {
+			name, opts = tag[:j], tag[j+1:]
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(props, ft, true)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, exists := props[name]; exists && embedded {
+			continue
+		}
+
+		s := g.schema(f.Type)
+		if hasTagOption(opts, "string") {
+			switch s["type"] {
+			case "boolean", "integer", "number":
+				s = map[string]interface{}{"type": "string"}
+			}
+		}
+		props[name] = s
+	}
+}
+
+func hasTagOption(opts, opt string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
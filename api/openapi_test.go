@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type specTestParams struct {
+	specTestEmbedded
+	Ref     string            `json:"ref"`
+	Limit   int               `json:"limit,omitempty"`
+	Force   bool              `json:"force,string"`
+	Tags    []string          `json:"tags"`
+	Meta    map[string]string `json:"meta"`
+	Body    []byte            `json:"body"`
+	When    time.Time         `json:"when"`
+	Child   *specTestChild    `json:"child"`
+	Untyped interface{}       `json:"untyped"`
+	Skipped string            `json:"-"`
+	NoTag   float64
+	private string
+}
+
+type specTestEmbedded struct {
+	Ref    string `json:"ref"`
+	Offset int    `json:"offset"`
+}
+
+type specTestChild struct {
+	Name   string           `json:"name"`
+	Parent *specTestChild   `json:"parent"`
+	Kids   []*specTestChild `json:"kids"`
+}
+
+func TestOpenAPISchema(t *testing.T) {
+	g := &specGenerator{
+		schemas: map[string]interface{}{},
+		names:   map[reflect.Type]string{},
+	}
+	got := g.schema(reflect.TypeOf(&specTestParams{}))
+	if diff := cmp.Diff(schemaRef("specTestParams"), got); diff != "" {
+		t.Errorf("schema mismatch (-want +got):\n%s", diff)
+	}
+
+	expect := map[string]interface{}{
+		"specTestParams": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"ref":     map[string]interface{}{"type": "string"},
+				"offset":  map[string]interface{}{"type": "integer"},
+				"limit":   map[string]interface{}{"type": "integer"},
+				"force":   map[string]interface{}{"type": "string"},
+				"tags":    map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+				"meta":    map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+				"body":    map[string]interface{}{"type": "string", "format": "byte"},
+				"when":    map[string]interface{}{"type": "string", "format": "date-time"},
+				"child":   schemaRef("specTestChild"),
+				"untyped": map[string]interface{}{},
+				"NoTag":   map[string]interface{}{"type": "number"},
+			},
+		},
+		"specTestChild": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name":   map[string]interface{}{"type": "string"},
+				"parent": schemaRef("specTestChild"),
+				"kids":   map[string]interface{}{"type": "array", "items": schemaRef("specTestChild")},
+			},
+		},
+	}
+	if diff := cmp.Diff(expect, g.schemas); diff != "" {
+		t.Errorf("component schemas mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	run := NewAPITestRunner(t)
+	defer run.Delete()
+
+	h := OpenAPIHandler(run.Inst)
+	status, body := APICall("/openapi.json", h, nil)
+	assertStatusCode(t, "openapi spec", status, http.StatusOK)
+
+	spec := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(body), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec["openapi"] != "3.0.0" {
+		t.Errorf("expected an OpenAPI 3.0.0 document, got version: %v", spec["openapi"])
+	}
+	paths, _ := spec["paths"].(map[string]interface{})
+	for _, ms := range run.Inst.AllMethods() {
+		for _, attr := range ms.Attributes() {
+			if attr.Endpoint == "" {
+				continue
+			}
+			if _, ok := paths[attr.Endpoint.String()]; !ok {
+				t.Errorf("expected spec to describe %s endpoint %q", ms.Name(), attr.Endpoint)
+			}
+		}
+	}
+	if _, ok := paths["/ds/upload/{id}"].(map[string]interface{})["patch"]; !ok {
+		t.Errorf("expected spec to describe upload PATCH requests")
+	}
+
+	status, _ = APICallWithParams(http.MethodPost, "/openapi.json", nil, h, nil)
+	assertStatusCode(t, "post openapi spec", status, http.StatusNotFound)
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/base/bodyquery"
 	"github.com/affix-io/affix/base/fill"
 	"github.com/affix-io/affix/dsref"
 	"github.com/affix-io/affix/lib"
@@ -60,6 +61,16 @@ func parseGetParamsFromRequest(r *http.Request, p *lib.GetParams) error {
 	return nil
 }
 
+// parseBodyQueryFromRequest parses body projection, filter & sort params.
+// It returns a nil query if the request has none of them
+// Examples:
+// ?columns=city,pop
+// ?where=pop > 100000 AND in_usa = true
+// ?sort=-pop,city
+func parseBodyQueryFromRequest(r *http.Request) (*bodyquery.Query, error) {
+	return bodyquery.Parse(r.FormValue("columns"), r.FormValue("where"), r.FormValue("sort"))
+}
+
 // parseSaveParamsFromRequest parses the form from the request
 // it ignores `bodyFile`, `filePaths`, and `secrets`
 // `dataset`, if it exists, is expected to be a json string in
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials is true on platforms where peerUID can read unix socket
+// peer credentials
+const peerCredentials = true
+
+// peerUID returns the user ID of the process on the other end of a unix socket
+func peerUID(c *net.UnixConn) (int, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return int(cred.Uid), nil
+}
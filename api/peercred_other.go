@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package api
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerCredentials is false on platforms where peerUID always fails
+const peerCredentials = false
+
+// peerUID returns the user ID of the process on the other end of a unix
+// socket. Peer credentials are only read on linux, callers on other platforms
+// must authenticate with a token
+func peerUID(c *net.UnixConn) (int, error) {
+	return 0, fmt.Errorf("unix socket peer credentials aren't supported on this platform")
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/key"
+	"github.com/affix-io/affix/auth/token"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/gorilla/mux"
+)
+
+// AEGetZip is a pseudo-endpoint for configuring limits on zip exports, which
+// are served by the get endpoint when requesting the "zip" format
+const AEGetZip qhttp.APIEndpoint = "/ds/get?format=zip"
+
+// bucketSweepInterval is how often idle token buckets are cleaned up
+const bucketSweepInterval = time.Minute
+
+// RateLimit configures request limits for an endpoint. Rate limits apply to
+// each caller separately, concurrency limits are shared by all callers
+type RateLimit struct {
+	// Rate is the number of requests per second a caller is allowed to make
+	// on average. zero disables rate limiting
+	Rate float64
+	// Burst is the largest number of requests a caller can make at once,
+	// defaults to the ceiling of Rate
+	Burst int
+	// MaxConcurrent caps the number of requests to the endpoint that run at
+	// the same time. zero disables concurrency limits
+	MaxConcurrent int
+}
+
+// burst returns the token bucket capacity of a limit
+func (l RateLimit) burst() float64 {
+	if l.Burst < 1 {
+		return math.Ceil(l.Rate)
+	}
+	return float64(l.Burst)
+}
+
+// RateLimits maps endpoints to limits. Like AuthPolicy, routes nested below
+// an endpoint share the endpoint's limits
+type RateLimits map[qhttp.APIEndpoint]RateLimit
+
+// DefaultRateLimits are the limits applied when a server isn't configured
+// with limits of its own
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		qhttp.AEGet:    {Rate: 20, Burst: 40},
+		AEBody:         {Rate: 10, Burst: 20, MaxConcurrent: 8},
+		AEGetZip:       {Rate: 1, Burst: 5, MaxConcurrent: 2},
+		qhttp.AESave:   {Rate: 2, Burst: 10, MaxConcurrent: 4},
+		AESaveByUpload: {Rate: 2, Burst: 10, MaxConcurrent: 4},
+		qhttp.AEApply:  {Rate: 1, Burst: 5, MaxConcurrent: 2},
+	}
+}
+
+// rateLimiter enforces RateLimits with a token bucket per endpoint & caller
+// and a semaphore per endpoint
+type rateLimiter struct {
+	limits    RateLimits
+	endpoints []qhttp.APIEndpoint
+	keystore  key.Store
+	now       func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[bucketKey]*tokenBucket
+	running   map[qhttp.APIEndpoint]int
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	endpoint qhttp.APIEndpoint
+	caller   string
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limits RateLimits, keystore key.Store) *rateLimiter {
+	endpoints := make([]qhttp.APIEndpoint, 0, len(limits))
+	for ep := range limits {
+		endpoints = append(endpoints, ep)
+	}
+	return &rateLimiter{
+		limits:    limits,
+		endpoints: endpoints,
+		keystore:  keystore,
+		now:       time.Now,
+		buckets:   map[bucketKey]*tokenBucket{},
+		running:   map[qhttp.APIEndpoint]int{},
+	}
+}
+
+// middleware rejects requests that exceed their endpoint's limits with a 429
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ep, ok := rl.endpoint(r)
+		if !ok || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := rl.limits[ep]
+
+		// take a concurrency slot first, requests turned away for concurrency
+		// don't spend a token
+		if !rl.acquire(ep, limit) {
+			writeTooManyRequests(w, time.Second, fmt.Errorf("too many concurrent requests to %s", ep))
+			return
+		}
+		defer rl.release(ep)
+		if wait, ok := rl.allow(ep, rl.caller(r.Context(), r.RemoteAddr), limit); !ok {
+			writeTooManyRequests(w, wait, fmt.Errorf("rate limit exceeded for %s, retry in %s", ep, wait))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// endpoint finds the limited endpoint a request belongs to
+func (rl *rateLimiter) endpoint(r *http.Request) (qhttp.APIEndpoint, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "", false
+	}
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		return "", false
+	}
+	if _, zipLimited := rl.limits[AEGetZip]; zipLimited && isZipExport(r) && isEndpointRoute(tmpl, qhttp.AEGet) {
+		return AEGetZip, true
+	}
+	return matchEndpoint(tmpl, rl.endpoints)
+}
+
+// caller identifies who is making a request: the profile ID of a verifiable
+// bearer token or client certificate, or the client IP address for anonymous
+// requests
+func (rl *rateLimiter) caller(ctx context.Context, remoteAddr string) string {
+	if cert, ok := clientCertFromCtx(ctx); ok && token.FromCtx(ctx) == "" {
+		return "profile:" + cert.ProfileID
+	}
+	if tokenStr := token.FromCtx(ctx); tokenStr != "" && rl.keystore != nil {
+		if tok, err := token.ParseAuthToken(ctx, tokenStr, rl.keystore); err == nil {
+			if claims, ok := tok.Claims.(*token.Claims); ok && claims.StandardClaims != nil && claims.Subject != "" {
+				return "profile:" + claims.Subject
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return "ip:" + host
+}
+
+// allow takes a token from a caller's bucket, returning false and the time
+// until a token is available if the bucket is empty
+func (rl *rateLimiter) allow(ep qhttp.APIEndpoint, caller string, limit RateLimit) (time.Duration, bool) {
+	if limit.Rate <= 0 {
+		return 0, true
+	}
+	burst := limit.burst()
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	k := bucketKey{endpoint: ep, caller: caller}
+	b, ok := rl.buckets[k]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		rl.buckets[k] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+		return wait, false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// sweep periodically drops buckets that have been idle long enough to refill,
+// which are equivalent to a new bucket. callers must hold the lock
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < bucketSweepInterval {
+		return
+	}
+	rl.lastSweep = now
+	for k, b := range rl.buckets {
+		limit := rl.limits[k.endpoint]
+		if b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= limit.burst() {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+func (rl *rateLimiter) acquire(ep qhttp.APIEndpoint, limit RateLimit) bool {
+	if limit.MaxConcurrent <= 0 {
+		return true
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if rl.running[ep] >= limit.MaxConcurrent {
+		return false
+	}
+	rl.running[ep]++
+	return true
+}
+
+func (rl *rateLimiter) release(ep qhttp.APIEndpoint) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if rl.running[ep] > 0 {
+		rl.running[ep]--
+	}
+}
+
+// isZipExport reports whether a request asks for a dataset zip archive
+func isZipExport(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "zip" || arrayContains(r.Header["Accept"], "application/zip")
+}
+
+// isEndpointRoute reports whether a path template is an endpoint's route
+func isEndpointRoute(tmpl string, ep qhttp.APIEndpoint) bool {
+	_, ok := matchEndpoint(tmpl, []qhttp.APIEndpoint{ep})
+	return ok
+}
+
+// writeTooManyRequests responds with a 429 status, setting the Retry-After
+// header to the number of seconds the client should wait
+func writeTooManyRequests(w http.ResponseWriter, wait time.Duration, err error) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	util.WriteErrResponse(w, http.StatusTooManyRequests, err)
+}
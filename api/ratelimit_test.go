@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/affix-io/affix/api/util"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/gorilla/mux"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(RateLimits{}, nil)
+	rl.now = func() time.Time { return now }
+	limit := RateLimit{Rate: 2, Burst: 3}
+
+	// a full bucket allows a burst
+	for i := 0; i < 3; i++ {
+		if _, ok := rl.allow(qhttp.AEGet, "ip:a", limit); !ok {
+			t.Fatalf("request %d: expected burst request to be allowed", i)
+		}
+	}
+	wait, ok := rl.allow(qhttp.AEGet, "ip:a", limit)
+	if ok {
+		t.Fatalf("expected request over burst to be limited")
+	}
+	if wait != time.Millisecond*500 {
+		t.Errorf("wait mismatch. expected: %s, got: %s", time.Millisecond*500, wait)
+	}
+
+	// callers & endpoints have separate buckets
+	if _, ok := rl.allow(qhttp.AEGet, "ip:b", limit); !ok {
+		t.Errorf("expected a different caller to be allowed")
+	}
+	if _, ok := rl.allow(qhttp.AESave, "ip:a", limit); !ok {
+		t.Errorf("expected a different endpoint to be allowed")
+	}
+
+	// buckets refill at the configured rate
+	now = now.Add(time.Millisecond * 500)
+	if _, ok := rl.allow(qhttp.AEGet, "ip:a", limit); !ok {
+		t.Errorf("expected request after refill to be allowed")
+	}
+	if _, ok := rl.allow(qhttp.AEGet, "ip:a", limit); ok {
+		t.Errorf("expected refill to add a single token")
+	}
+
+	// idle buckets are swept once refilled
+	now = now.Add(time.Hour)
+	rl.allow(qhttp.AESave, "ip:c", limit)
+	if len(rl.buckets) != 1 {
+		t.Errorf("expected idle buckets to be swept, have %d buckets", len(rl.buckets))
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	rl := newRateLimiter(RateLimits{
+		qhttp.AEGet:  {Rate: 1, Burst: 1},
+		AEGetZip:     {Rate: 0.001, Burst: 2, MaxConcurrent: 1},
+		qhttp.AESave: {MaxConcurrent: 1},
+	}, nil)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	m := mux.NewRouter()
+	m.Use(rl.middleware)
+	m.HandleFunc("/ds/get/{username}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if isZipExport(r) {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	m.HandleFunc(qhttp.AESave.String(), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := call("/ds/get/peer/cities"); w.Code != http.StatusOK {
+		t.Errorf("expected first request to succeed, got status %d", w.Code)
+	}
+	w := call("/ds/get/peer/cities")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected rate limited status code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Retry-After mismatch. expected: %q, got: %q", "1", w.Header().Get("Retry-After"))
+	}
+	res := util.Response{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Meta == nil || res.Meta.Code != http.StatusTooManyRequests {
+		t.Errorf("expected error response envelope with a 429 code, got: %s", w.Body.String())
+	}
+
+	// zip exports have their own limits, capped at one concurrent export
+	done := make(chan int)
+	go func() { done <- call("/ds/get/peer/cities?format=zip").Code }()
+	<-started
+	if w := call("/ds/get/peer/cities?format=zip"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected concurrent zip export to be limited, got status %d", w.Code)
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("expected first zip export to succeed, got status %d", code)
+	}
+	// the limited export didn't spend a token
+	if w := call("/ds/get/peer/cities?format=zip"); w.Code != http.StatusOK {
+		t.Errorf("expected zip export after a concurrency limited one to succeed, got status %d", w.Code)
+	}
+	if w := call("/ds/get/peer/cities?format=zip"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected zip export to be rate limited once the bucket is empty, got status %d", w.Code)
+	}
+
+	// released concurrency slots can be reused
+	for i := 0; i < 2; i++ {
+		if w := call(qhttp.AESave.String()); w.Code != http.StatusOK {
+			t.Errorf("save request %d: expected status 200, got %d", i, w.Code)
+		}
+	}
+}
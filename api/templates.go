@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"html/template"
 	"net/http"
 )
@@ -14,6 +15,8 @@ const DefaultWebappPort = 2505
 
 func init() {
 	templates = template.Must(template.New("webapp").Parse(webapptmpl))
+	template.Must(templates.New("consent").Parse(consenttmpl))
+	template.Must(templates.New("login").Parse(logintmpl))
 }
 
 // templateRenderer returns a func "renderTemplate" that renders a template, using the values of a Config
@@ -26,6 +29,18 @@ func renderTemplate(w http.ResponseWriter, tmpl string) {
 	}
 }
 
+// renderTemplateData renders a template with data, responding with status
+func renderTemplateData(w http.ResponseWriter, status int, tmpl string, data map[string]interface{}) {
+	buf := &bytes.Buffer{}
+	if err := templates.ExecuteTemplate(buf, tmpl, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
 const webapptmpl = `
 <!DOCTYPE html>
 <html>
@@ -37,3 +52,47 @@ const webapptmpl = `
   <script type="text/javascript" src="/webapp/main.js"></script>
 </body>
 </html>`
+
+// consenttmpl asks a user to authorize a third-party client. The params of the
+// authorization request are carried through the form as hidden fields
+const consenttmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+  <title>Authorize {{.client}} | affix</title>
+  <meta charset="utf-8">
+</head>
+<body>
+  <h1>Authorize {{.client}}</h1>
+  <p>{{.client}} wants to access affix as profile {{.profileID}}. Approving sends you back to {{.redirectHost}}.</p>
+  <form method="POST" action="{{.action}}">
+    {{range $name, $value := .params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+    {{end}}
+    <button type="submit" name="decision" value="approve">Authorize</button>
+    <button type="submit" name="decision" value="deny">Deny</button>
+  </form>
+</body>
+</html>`
+
+// logintmpl asks a user redirected by a third-party client to log in before
+// the consent page. The params of the authorization request are carried
+// through the form as hidden fields
+const logintmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+  <title>Log in to authorize {{.client}} | affix</title>
+  <meta charset="utf-8">
+</head>
+<body>
+  <h1>Log in to authorize {{.client}}</h1>
+  <p>{{.client}} wants to access affix. Paste an access token for your profile, created with <code>affix access token</code>, to continue. You'll be sent back to {{.redirectHost}} after you decide.</p>
+  {{if .error}}<p role="alert">{{.error}}</p>{{end}}
+  <form method="POST" action="{{.action}}">
+    {{range $name, $value := .params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+    {{end}}
+    <label>Access token <input type="password" name="login_token" autocomplete="off" required></label>
+    <button type="submit">Log in</button>
+  </form>
+</body>
+</html>`
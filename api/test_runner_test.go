@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
@@ -118,7 +119,26 @@ func (r *APITestRunner) SaveDataset(ds *dataset.Dataset, bodyFilename string) {
 	}
 }
 
+// MustTestServer serves the API, authorizing requests without a token as the
+// node owner
 func (r *APITestRunner) MustTestServer(t *testing.T) *httptest.Server {
 	s := New(r.Inst)
-	return httptest.NewServer(NewServerRoutes(s))
+	return httptest.NewServer(withTestToken(t, r.Inst, NewServerRoutes(s)))
+}
+
+// withTestToken adds a token for the owner of inst to requests that don't
+// carry one, for tests that exercise endpoints rather than authorization
+func withTestToken(t *testing.T, inst *lib.Instance, h http.Handler) http.Handler {
+	ctx := context.Background()
+	owner := inst.Repo().Profiles().Owner(ctx)
+	tokenStr, err := inst.Access().CreateAuthToken(ctx, &lib.CreateAuthTokenParams{GranteeUsername: owner.Peername})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+tokenStr)
+		}
+		h.ServeHTTP(w, r)
+	})
 }
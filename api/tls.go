@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/affix-io/affix/config"
+)
+
+// certCheckInterval is how often certificate files are checked for changes
+const certCheckInterval = time.Second * 10
+
+// TLSOptions configures serving the API over HTTPS
+type TLSOptions struct {
+	// CertFile & KeyFile are paths to a PEM encoded certificate & private key.
+	// Both files are watched for changes, replacing the served certificate
+	// without restarting the server
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a path to PEM encoded certificate authorities that sign
+	// client certificates. When set, clients that present a certificate must
+	// present one signed by these authorities
+	ClientCAFile string
+	// RequireClientCert rejects connections that don't present a valid client
+	// certificate. requires ClientCAFile
+	RequireClientCert bool
+	// ClientProfiles maps the subject common name of verified client
+	// certificates to the profile they authenticate as
+	ClientProfiles map[string]ClientCertProfile
+}
+
+// ClientCertProfile is the identity a client certificate is mapped to
+type ClientCertProfile struct {
+	// ProfileID is the encoded profile identifier of the client
+	ProfileID string
+	// Role is the level of access granted to the client. The node owner is
+	// always an admin, RoleAnonymous defaults to RoleReader
+	Role Role
+}
+
+// TLSOptionsFromConfig reads TLS options from the API config, returning nil
+// when the API isn't configured to serve HTTPS
+func TLSOptionsFromConfig(c *config.APITLS) (*TLSOptions, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c == nil || (c.CertFile == "" && c.KeyFile == "") {
+		return nil, nil
+	}
+	o := &TLSOptions{
+		CertFile:          c.CertFile,
+		KeyFile:           c.KeyFile,
+		ClientCAFile:      c.ClientCAFile,
+		RequireClientCert: c.RequireClientCert,
+	}
+	if len(c.ClientProfiles) > 0 {
+		o.ClientProfiles = make(map[string]ClientCertProfile, len(c.ClientProfiles))
+		for cn, p := range c.ClientProfiles {
+			role := RoleAnonymous
+			if p.Role != "" {
+				var err error
+				if role, err = ParseRole(p.Role); err != nil {
+					return nil, fmt.Errorf("client certificate %q: %w", cn, err)
+				}
+			}
+			o.ClientProfiles[cn] = ClientCertProfile{ProfileID: p.ProfileID, Role: role}
+		}
+	}
+	return o, nil
+}
+
+// Config builds a tls.Config from options, loading certificates from disk
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, fmt.Errorf("TLS requires both a certificate and key file")
+	}
+	reloader, err := newCertReloader(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if o.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(o.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("client CA file %q contains no certificates", o.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if o.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if o.RequireClientCert {
+		return nil, fmt.Errorf("requiring client certificates requires a client CA file")
+	}
+
+	return cfg, nil
+}
+
+// certReloader serves a certificate loaded from disk, reloading it when the
+// certificate or key files change
+type certReloader struct {
+	certFile, keyFile string
+	now               func() time.Time
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		now:      time.Now,
+	}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	r.checked = r.now()
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, satisfying the
+// tls.Config.GetCertificate field. Files are checked for changes at most once
+// per certCheckInterval. Failing to reload keeps the previous certificate
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if now := r.now(); now.Sub(r.checked) >= certCheckInterval {
+		r.checked = now
+		if modTime, err := r.filesModTime(); err != nil {
+			log.Errorw("checking TLS certificate files", "err", err)
+		} else if !modTime.Equal(r.modTime) {
+			if err := r.load(modTime); err != nil {
+				log.Errorw("reloading TLS certificate", "err", err)
+			} else {
+				log.Infow("reloaded TLS certificate", "certFile", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// load reads the certificate & key pair. callers must hold the lock, or have
+// exclusive access to the reloader
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// filesModTime returns the latest modification time of the certificate & key
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+type clientCertCtxKey struct{}
+
+// clientCertFromCtx returns the profile a request's client certificate or unix
+// socket peer is mapped to, if any
+func clientCertFromCtx(ctx context.Context) (ClientCertProfile, bool) {
+	p, ok := ctx.Value(clientCertCtxKey{}).(ClientCertProfile)
+	return p, ok
+}
+
+// clientCertMiddleware maps verified client certificates to profiles, adding
+// the profile to the request context. Certificates are verified during the
+// TLS handshake, requests without a verified certificate pass through
+// unchanged
+func clientCertMiddleware(profiles map[string]ClientCertProfile) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+			p, ok := profiles[name]
+			if !ok {
+				log.Debugw("unmapped client certificate", "commonName", name)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if rec := accessRecordFromCtx(r.Context()); rec != nil {
+				rec.ProfileID = p.ProfileID
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientCertCtxKey{}, p)))
+		})
+	}
+}
+
+// roleFromClientCert determines the role of a client certificate profile
+func roleFromClientCert(p ClientCertProfile, ownerID string) Role {
+	if ownerID != "" && p.ProfileID == ownerID {
+		return RoleAdmin
+	}
+	if p.Role == RoleAnonymous {
+		return RoleReader
+	}
+	return p.Role
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/affix-io/affix/config"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "affix_test_cert_reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestKeyPair(t, newTestCert(t, "first", nil), certFile, keyFile)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	commonName := func() string {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	if got := commonName(); got != "first" {
+		t.Errorf("expected initial certificate %q, got %q", "first", got)
+	}
+
+	writeTestKeyPair(t, newTestCert(t, "second", nil), certFile, keyFile)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if got := commonName(); got != "first" {
+		t.Errorf("expected certificate to be cached until the next check, got %q", got)
+	}
+	now = now.Add(certCheckInterval)
+	if got := commonName(); got != "second" {
+		t.Errorf("expected reloaded certificate %q, got %q", "second", got)
+	}
+
+	// broken files keep the last good certificate
+	ioutil.WriteFile(keyFile, []byte("not a key"), 0600)
+	os.Chtimes(keyFile, future.Add(time.Minute), future.Add(time.Minute))
+	now = now.Add(certCheckInterval)
+	if got := commonName(); got != "second" {
+		t.Errorf("expected failed reload to keep certificate %q, got %q", "second", got)
+	}
+}
+
+func TestClientCertAuthorization(t *testing.T) {
+	dir, err := ioutil.TempDir("", "affix_test_client_certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "test ca", nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	writeTestKeyPair(t, ca, caFile, filepath.Join(dir, "ca_key.pem"))
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestKeyPair(t, newTestCert(t, "127.0.0.1", ca), certFile, keyFile)
+
+	opts := &TLSOptions{
+		CertFile:          certFile,
+		KeyFile:           keyFile,
+		ClientCAFile:      caFile,
+		RequireClientCert: true,
+		ClientProfiles: map[string]ClientCertProfile{
+			"owner":  {ProfileID: "owner_id"},
+			"reader": {ProfileID: "reader_id"},
+		},
+	}
+	cfg, err := opts.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := mux.NewRouter()
+	m.Use(clientCertMiddleware(opts.ClientProfiles))
+	m.Use(authorizationMiddleware(AuthPolicy{AEHealth: RoleAnonymous, AEUnpack: RoleWriter}, nil, "owner_id"))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	m.HandleFunc(AEHealth.String(), ok)
+	m.HandleFunc(AEUnpack.String(), ok)
+
+	// serve like StartServer does, httptest servers replace the certificate
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: m, TLSConfig: cfg}
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+	url := "https://" + ln.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.leaf)
+	client := func(clientCert *testCert) *http.Client {
+		tlsCfg := &tls.Config{RootCAs: roots}
+		if clientCert != nil {
+			tlsCfg.Certificates = []tls.Certificate{clientCert.tlsCert()}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+	}
+
+	cases := []struct {
+		description string
+		cert        *testCert
+		path        string
+		expect      int
+	}{
+		{"owner certificate", newTestCert(t, "owner", ca), "/ds/unpack", http.StatusOK},
+		{"reader certificate", newTestCert(t, "reader", ca), "/ds/unpack", http.StatusForbidden},
+		{"reader certificate, open endpoint", newTestCert(t, "reader", ca), "/health", http.StatusOK},
+		{"unmapped certificate", newTestCert(t, "stranger", ca), "/ds/unpack", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		res, err := client(c.cert).Get(url + c.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.description, err)
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode != c.expect {
+			t.Errorf("%s: expected status %d, got %d", c.description, c.expect, res.StatusCode)
+		}
+	}
+
+	// certificates must be signed by the client CA
+	selfSigned := newTestCert(t, "owner", nil)
+	if res, err := client(selfSigned).Get(url + "/health"); err == nil {
+		res.Body.Close()
+		t.Errorf("expected self-signed client certificate to be rejected")
+	}
+	// client certificates are required
+	if res, err := client(nil).Get(url + "/health"); err == nil {
+		res.Body.Close()
+		t.Errorf("expected connection without a client certificate to be rejected")
+	}
+}
+
+func TestTLSOptionsConfig(t *testing.T) {
+	if _, err := (&TLSOptions{CertFile: "cert.pem"}).Config(); err == nil {
+		t.Errorf("expected missing key file to error")
+	}
+	if _, err := (&TLSOptions{CertFile: "missing.pem", KeyFile: "missing.pem"}).Config(); err == nil {
+		t.Errorf("expected missing certificate files to error")
+	}
+}
+
+func TestTLSOptionsFromConfig(t *testing.T) {
+	for i, c := range []*config.APITLS{nil, {}} {
+		if o, err := TLSOptionsFromConfig(c); o != nil || err != nil {
+			t.Errorf("case %d: expected no options & no error, got %v, %v", i, o, err)
+		}
+	}
+
+	got, err := TLSOptionsFromConfig(&config.APITLS{
+		CertFile:          "cert.pem",
+		KeyFile:           "key.pem",
+		ClientCAFile:      "ca.pem",
+		RequireClientCert: true,
+		ClientProfiles: map[string]config.APIClientProfile{
+			"notebook": {ProfileID: "QmNotebook"},
+			"pipeline": {ProfileID: "QmPipeline", Role: "writer"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &TLSOptions{
+		CertFile:          "cert.pem",
+		KeyFile:           "key.pem",
+		ClientCAFile:      "ca.pem",
+		RequireClientCert: true,
+		ClientProfiles: map[string]ClientCertProfile{
+			"notebook": {ProfileID: "QmNotebook", Role: RoleAnonymous},
+			"pipeline": {ProfileID: "QmPipeline", Role: RoleWriter},
+		},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("options mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = TLSOptionsFromConfig(&config.APITLS{
+		CertFile:       "cert.pem",
+		KeyFile:        "key.pem",
+		ClientProfiles: map[string]config.APIClientProfile{"notebook": {Role: "owner"}},
+	})
+	if err == nil {
+		t.Errorf("expected unknown role to error")
+	}
+
+	if _, err = TLSOptionsFromConfig(&config.APITLS{ClientCAFile: "ca.pem"}); err == nil {
+		t.Errorf("expected a client CA without a certificate to error")
+	}
+}
+
+type testCert struct {
+	leaf *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// newTestCert creates a certificate for commonName signed by parent. A nil
+// parent creates a self-signed certificate authority
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.leaf, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{leaf: leaf, der: der, key: key}
+}
+
+func writeTestKeyPair(t *testing.T, c *testCert, certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
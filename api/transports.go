@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/affix-io/affix/config"
@@ -11,8 +12,26 @@ import (
 	manet "github.com/multiformats/go-multiaddr/net"
 )
 
-// StartServer interprets info from config to start an API server
+// UnixSocketMode is the permission mode of API socket files, which only
+// allows the user running the node to connect
+const UnixSocketMode os.FileMode = 0600
+
+// UnixPeersSocketMode is the permission mode of API socket files when unix
+// peers map other users to profiles, which couldn't connect to a socket at
+// UnixSocketMode. Any local user can connect, requests from users without a
+// mapping or a token are rejected
+const UnixPeersSocketMode os.FileMode = 0666
+
+// StartServer interprets info from config to start an API server. Servers
+// with a TLSConfig serve HTTPS using the config's certificates. /unix
+// addresses listen on a socket file with UnixSocketMode, adding the user ID of
+// connecting processes to request contexts
 func StartServer(c *config.API, s *http.Server) error {
+	return startServer(c, s, UnixSocketMode)
+}
+
+// startServer is StartServer, listening on socket files with the given mode
+func startServer(c *config.API, s *http.Server, socketMode os.FileMode) error {
 	if !c.Enabled {
 		return nil
 	}
@@ -24,7 +43,14 @@ func StartServer(c *config.API, s *http.Server) error {
 
 	var listener net.Listener
 
-	if !c.ServeRemoteTraffic {
+	if isUnixAddr(addr) {
+		if listener, err = listenUnix(addr, socketMode); err != nil {
+			return err
+		}
+		if s.ConnContext == nil {
+			s.ConnContext = unixPeerConnContext
+		}
+	} else if !c.ServeRemoteTraffic {
 		// if we're not serving remote traffic, strip off any address details other
 		// than a raw TCP address
 		portAddr := config.DefaultAPIPort
@@ -49,9 +75,45 @@ func StartServer(c *config.API, s *http.Server) error {
 		listener = manet.NetListener(mal)
 	}
 
+	if s.TLSConfig != nil {
+		// certificates are provided by TLSConfig, no need for file paths
+		return s.ServeTLS(listener, "", "")
+	}
 	return s.Serve(listener)
 }
 
+// isUnixAddr reports whether a multiaddr is a unix domain socket
+func isUnixAddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_UNIX)
+	return err == nil
+}
+
+// listenUnix listens on a unix domain socket file with the given mode. The
+// socket file only appears at its address once its mode is set. A socket
+// file left behind by a node that is no longer running is replaced
+func listenUnix(addr ma.Multiaddr, mode os.FileMode) (net.Listener, error) {
+	na, err := manet.ToNetAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	path := na.String()
+
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("listening on %s: file exists and isn't a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listening on %s: socket is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return listenUnixPrivate(path, mode)
+}
+
 // HTTPSRedirect listens over TCP on addr, redirecting HTTP requests to https
 func HTTPSRedirect(addr string) {
 	ln, err := net.Listen("tcp", addr)
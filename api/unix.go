@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/token"
+)
+
+type peerUIDCtxKey struct{}
+
+// unknownPeerUID is the user ID of unix socket peers whose credentials can't
+// be read. They're never mapped to a profile
+const unknownPeerUID = -1
+
+// errUnmappedUnixPeer is the error for requests over a unix socket without a
+// token from a user that isn't mapped to a profile
+var errUnmappedUnixPeer = errors.New("unix socket user isn't mapped to a profile, use a token")
+
+// unixPeerConnContext adds the user ID of the process on the other end of a
+// unix socket connection to the connection context. Peers whose credentials
+// can't be read get unknownPeerUID. It's set as the http.Server ConnContext of
+// servers listening on a socket file
+func unixPeerConnContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	uid, err := peerUID(uc)
+	if err != nil {
+		log.Debugw("reading unix socket peer credentials", "err", err)
+		uid = unknownPeerUID
+	}
+	return context.WithValue(ctx, peerUIDCtxKey{}, uid)
+}
+
+// peerUIDFromCtx returns the user ID of the process that made a request over a
+// unix socket, if any
+func peerUIDFromCtx(ctx context.Context) (int, bool) {
+	uid, ok := ctx.Value(peerUIDCtxKey{}).(int)
+	return uid, ok
+}
+
+// unixPeerMiddleware authenticates requests made over a unix socket without a
+// token by the user ID of the calling process. The user running the node is
+// the node owner, other users are mapped to profiles by peers. Authenticated
+// requests are handled like requests with a client certificate. Requests with
+// a token pass through unchanged. Requests without a token from unmapped
+// users, or users whose credentials can't be read, respond 403. A socket
+// opened to all local users doesn't let them call the API anonymously
+func unixPeerMiddleware(peers map[int]ClientCertProfile, ownerID string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid, ok := peerUIDFromCtx(r.Context())
+			if !ok || token.FromCtx(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			p, ok := peers[uid]
+			if uid == unknownPeerUID {
+				ok = false
+			} else if uid == os.Getuid() {
+				p, ok = ClientCertProfile{ProfileID: ownerID, Role: RoleAdmin}, true
+			}
+			if !ok {
+				log.Debugw("unmapped unix socket peer", "uid", uid)
+				util.WriteErrResponse(w, http.StatusForbidden, errUnmappedUnixPeer)
+				return
+			}
+			if rec := accessRecordFromCtx(r.Context()); rec != nil {
+				rec.ProfileID = p.ProfileID
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientCertCtxKey{}, p)))
+		})
+	}
+}
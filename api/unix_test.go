@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/gorilla/mux"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "affix.sock")
+	addr, err := ma.NewMultiaddr("/unix" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isUnixAddr(addr) {
+		t.Fatalf("expected %s to be a unix address", addr)
+	}
+
+	ln, err := listenUnix(addr, UnixSocketMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != UnixSocketMode {
+		t.Errorf("socket permission mismatch. expected: %s, got: %s", UnixSocketMode, fi.Mode().Perm())
+	}
+	if _, err := listenUnix(addr, UnixSocketMode); err == nil {
+		t.Error("expected listening on a socket in use to fail")
+	}
+
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected closing the listener to remove the socket file, got: %v", err)
+	}
+	if dirs, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".affix-sock-*")); len(dirs) != 0 {
+		t.Errorf("expected no private socket directories to be left behind, got: %v", dirs)
+	}
+
+	// simulate a socket left behind by a node that didn't shut down cleanly
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err = listenUnix(addr, UnixSocketMode)
+	if err != nil {
+		t.Fatalf("expected a stale socket to be replaced, got: %s", err)
+	}
+	ln.Close()
+
+	// sockets unix peers with other user IDs connect to are opened up
+	ln, err = listenUnix(addr, UnixPeersSocketMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if fi, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != UnixPeersSocketMode {
+		t.Errorf("socket permission mismatch. expected: %s, got: %s", UnixPeersSocketMode, fi.Mode().Perm())
+	}
+}
+
+func TestUnixSocketMode(t *testing.T) {
+	s := Server{opts: Options{UnixPeers: map[int]ClientCertProfile{os.Getuid(): {ProfileID: "owner_id"}}}}
+	if mode := s.unixSocketMode(); mode != UnixSocketMode {
+		t.Errorf("expected mapping the node user to keep mode %s, got %s", UnixSocketMode, mode)
+	}
+
+	s.opts.UnixPeers[os.Getuid()+1] = ClientCertProfile{ProfileID: "reader_id"}
+	expect := UnixPeersSocketMode
+	if !peerCredentials {
+		expect = UnixSocketMode
+	}
+	if mode := s.unixSocketMode(); mode != expect {
+		t.Errorf("expected mapping other users to use mode %s, got %s", expect, mode)
+	}
+}
+
+func TestUnixPeerMiddleware(t *testing.T) {
+	other := os.Getuid() + 1
+	peers := map[int]ClientCertProfile{other: {ProfileID: "reader_id"}}
+
+	m := mux.NewRouter()
+	m.Use(unixPeerMiddleware(peers, "owner_id"))
+	m.Use(authorizationMiddleware(AuthPolicy{AEUnpack: RoleWriter}, nil, "owner_id"))
+	m.HandleFunc(AEUnpack.String(), func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	cases := []struct {
+		description string
+		uid         int
+		hasUID      bool
+		expect      int
+	}{
+		{"not a unix socket", 0, false, http.StatusUnauthorized},
+		{"node user is the owner", os.Getuid(), true, http.StatusOK},
+		{"mapped user has its profile's role", other, true, http.StatusForbidden},
+		{"unmapped user", other + 1, true, http.StatusForbidden},
+		{"peer credentials can't be read", unknownPeerUID, true, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, AEUnpack.String(), nil)
+		if c.hasUID {
+			r = r.WithContext(context.WithValue(r.Context(), peerUIDCtxKey{}, c.uid))
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		if w.Code != c.expect {
+			t.Errorf("case %q: expected status %d, got %d", c.description, c.expect, w.Code)
+		}
+	}
+}
+
+func TestUnixPeerConnContext(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("unix socket peer credentials are only read on linux")
+	}
+	addr, err := ma.NewMultiaddr("/unix" + filepath.Join(t.TempDir(), "affix.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := listenUnix(addr, UnixSocketMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotUID, hasUID := 0, false
+	srv := &http.Server{
+		ConnContext: unixPeerConnContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotUID, hasUID = peerUIDFromCtx(r.Context())
+		}),
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	cli := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", ln.Addr().String())
+		},
+	}}
+	res, err := cli.Get("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if !hasUID || gotUID != os.Getuid() {
+		t.Errorf("expected peer uid %d in request context, got %d (present: %t)", os.Getuid(), gotUID, hasUID)
+	}
+}
@@ -0,0 +1,642 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/lib"
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/qfs"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultUploadTTL is the length of time a staged upload is kept on disk
+	// after it was last written to
+	DefaultUploadTTL = time.Hour * 24
+	// DefaultMaxUploadSize is the largest body file a resumable upload accepts
+	DefaultMaxUploadSize = int64(10 << 30)
+
+	// upload protocol headers, modeled on the tus.io resumable upload protocol
+	uploadOffsetHeader  = "Upload-Offset"
+	uploadLengthHeader  = "Upload-Length"
+	uploadExpiresHeader = "Upload-Expires"
+	// uploadChunkMimeType is the required content type of PATCH requests
+	uploadChunkMimeType = "application/offset+octet-stream"
+)
+
+var (
+	// ErrUploadNotFound indicates an upload ID doesn't exist or has expired
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadOffsetMismatch indicates a chunk was written at an offset other
+	// than the upload's current offset
+	ErrUploadOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrUploadIncomplete indicates an attempt to finalize an upload that hasn't
+	// received all of its bytes
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	// ErrUploadFinalizing indicates an upload is being saved as a dataset body,
+	// and can't be written to, cancelled or finalized again
+	ErrUploadFinalizing = errors.New("upload is being finalized")
+	// ErrUploadWriting indicates a chunk is being written to an upload, which
+	// can't be written to, cancelled or finalized until the chunk is done
+	ErrUploadWriting = errors.New("upload is being written to")
+)
+
+// Upload describes the state of a staged resumable upload
+type Upload struct {
+	ID       string    `json:"id"`
+	Filename string    `json:"filename"`
+	Length   int64     `json:"length"`
+	Offset   int64     `json:"offset"`
+	Expires  time.Time `json:"expires"`
+	// ProfileID is the profile that created the upload, the only profile that
+	// can write to, finalize or cancel it
+	ProfileID string `json:"profileID,omitempty"`
+}
+
+// Complete returns true when every byte of the upload has been received
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// UploadStore stages resumable uploads on local disk. Each upload is kept as a
+// data file and a JSON file recording upload state, which lets uploads survive
+// a server restart. Uploads that aren't written to within the store's TTL
+// expire, and are removed by Reap. Uploads belong to the profile that created
+// them, other profiles are told the upload doesn't exist
+type UploadStore struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+
+	lock  sync.Mutex
+	locks map[string]*uploadLock
+	// busy holds the IDs of uploads that are being written to or finalized,
+	// & the error other changes to them report
+	busy map[string]error
+}
+
+// uploadLock serializes changes to an upload. It's dropped from the store
+// once no request holds or waits for it
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+// NewUploadStore creates an UploadStore that stages files in dir. The
+// directory is created on first use, readable only by the user running the
+// node
+func NewUploadStore(dir string, ttl time.Duration, maxSize int64) *UploadStore {
+	if ttl <= 0 {
+		ttl = DefaultUploadTTL
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxUploadSize
+	}
+	return &UploadStore{
+		dir:     dir,
+		ttl:     ttl,
+		maxSize: maxSize,
+		locks:   map[string]*uploadLock{},
+		busy:    map[string]error{},
+	}
+}
+
+// Create begins a new upload owned by a profile. Anonymous uploads have an
+// empty profileID
+func (s *UploadStore) Create(filename string, length int64, profileID string) (*Upload, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+	if filepath.Ext(filename) == "" {
+		return nil, fmt.Errorf("filename %q must have an extension to detect the body format", filename)
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("upload length must be greater than 0")
+	}
+	if length > s.maxSize {
+		return nil, fmt.Errorf("upload length %d exceeds maximum size of %d bytes", length, s.maxSize)
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating upload directory: %w", err)
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		return nil, err
+	}
+	u := &Upload{
+		ID:       id,
+		Filename: filepath.Base(filename),
+		Length:   length,
+		Expires:  time.Now().Add(s.ttl),
+
+		ProfileID: profileID,
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err := s.writeInfo(u); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+	return u, nil
+}
+
+// Get returns the state of an upload
+func (s *UploadStore) Get(id, profileID string) (*Upload, error) {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.readOwnedInfo(id, profileID)
+}
+
+// WriteChunk appends data read from r to an upload. offset must match the
+// current upload offset. Data past the declared upload length is an error.
+// WriteChunk keeps whatever bytes were written before r returns an error, so
+// clients can resume from the new offset after a dropped connection. Other
+// changes to the upload report ErrUploadWriting until the chunk is written
+func (s *UploadStore) WriteChunk(id, profileID string, offset int64, r io.Reader) (*Upload, error) {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	u, f, err := s.openChunk(id, profileID, offset)
+	if err != nil {
+		unlock()
+		return u, err
+	}
+	defer f.Close()
+	s.setBusy(id, ErrUploadWriting)
+	// reading from the network can take as long as the client likes, don't
+	// hold the upload lock while doing it
+	unlock()
+
+	// read one byte past the remaining length to detect oversized chunks
+	n, copyErr := io.Copy(f, io.LimitReader(r, u.Length-u.Offset+1))
+	if u.Offset+n > u.Length {
+		n = u.Length - u.Offset
+		copyErr = fmt.Errorf("chunk exceeds upload length of %d bytes", u.Length)
+	}
+
+	if unlock, err = s.lockUpload(id); err != nil {
+		return nil, err
+	}
+	defer unlock()
+	defer s.setBusy(id, nil)
+	if err := f.Truncate(u.Offset + n); err != nil {
+		return nil, err
+	}
+	u.Offset += n
+	u.Expires = time.Now().Add(s.ttl)
+	if err := s.writeInfo(u); err != nil {
+		return nil, err
+	}
+	return u, copyErr
+}
+
+// openChunk checks a chunk can be written to an upload at offset, returning
+// the data file positioned at the offset. callers must hold the upload lock
+func (s *UploadStore) openChunk(id, profileID string, offset int64) (*Upload, *os.File, error) {
+	u, err := s.readOwnedInfo(id, profileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.busyErr(id); err != nil {
+		return u, nil, err
+	}
+	if offset != u.Offset {
+		return u, nil, ErrUploadOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := f.Seek(u.Offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return u, f, nil
+}
+
+// Open returns the data file of a complete upload for finalizing. Until the
+// upload is closed it can't be written to, cancelled, opened again or expire.
+// The file is still owned by the store, callers must Close the upload once
+// finished with it
+func (s *UploadStore) Open(id, profileID string) (*Upload, *os.File, error) {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	u, err := s.readOwnedInfo(id, profileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.busyErr(id); err != nil {
+		return u, nil, err
+	}
+	if !u.Complete() {
+		return u, nil, ErrUploadIncomplete
+	}
+	f, err := os.Open(s.dataPath(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	s.setBusy(id, ErrUploadFinalizing)
+	return u, f, nil
+}
+
+// Close ends finalizing an upload returned by Open. Finalized uploads are
+// removed, others can be written to & opened again
+func (s *UploadStore) Close(id string, finalized bool) {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	s.setBusy(id, nil)
+	if finalized {
+		s.remove(id)
+	}
+}
+
+// Delete removes an upload & its staged data
+func (s *UploadStore) Delete(id, profileID string) error {
+	unlock, err := s.lockUpload(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := s.readOwnedInfo(id, profileID); err != nil {
+		return err
+	}
+	if err := s.busyErr(id); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
+
+// Reap removes all expired uploads, and data files without upload state that
+// are older than the upload TTL, like those left by a crash between writing
+// an upload's data & state
+func (s *UploadStore) Reap() {
+	infos, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, path := range infos {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		unlock, err := s.lockUpload(id)
+		if err != nil {
+			continue
+		}
+		if _, err := s.readInfo(id); errors.Is(err, ErrUploadNotFound) {
+			log.Debugw("removed expired upload", "id", id)
+		}
+		unlock()
+	}
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range files {
+		id := fi.Name()
+		if fi.IsDir() || time.Since(fi.ModTime()) < s.ttl {
+			continue
+		}
+		unlock, err := s.lockUpload(id)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(s.infoPath(id)); os.IsNotExist(err) && s.busyErr(id) == nil {
+			s.remove(id)
+			log.Debugw("removed orphaned upload data", "id", id)
+		}
+		unlock()
+	}
+}
+
+// ReapEvery calls Reap at a regular interval until done is closed. Intervals
+// that aren't positive disable reaping
+func (s *UploadStore) ReapEvery(interval time.Duration, done <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.Reap()
+		case <-done:
+			return
+		}
+	}
+}
+
+// readOwnedInfo loads the state of an upload created by profileID. Uploads
+// created by other profiles are reported as ErrUploadNotFound. callers must
+// hold the upload lock
+func (s *UploadStore) readOwnedInfo(id, profileID string) (*Upload, error) {
+	u, err := s.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.ProfileID != profileID {
+		return nil, ErrUploadNotFound
+	}
+	return u, nil
+}
+
+// readInfo loads upload state. Expired uploads that aren't being written to or
+// finalized are removed, reporting ErrUploadNotFound. callers must hold the
+// upload lock
+func (s *UploadStore) readInfo(id string) (*Upload, error) {
+	if !validUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+	data, err := ioutil.ReadFile(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	u := &Upload{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("reading upload %q: %w", id, err)
+	}
+	if time.Now().After(u.Expires) && s.busyErr(id) == nil {
+		s.remove(id)
+		return nil, ErrUploadNotFound
+	}
+	return u, nil
+}
+
+func (s *UploadStore) writeInfo(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	// write to a temp file & rename so a crash never leaves partial state
+	tmp := s.infoPath(u.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(u.ID))
+}
+
+func (s *UploadStore) remove(id string) {
+	os.Remove(s.infoPath(id))
+	os.Remove(s.dataPath(id))
+}
+
+// busyErr returns the error changes to a busy upload report, nil if the
+// upload isn't busy
+func (s *UploadStore) busyErr(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.busy[id]
+}
+
+// setBusy marks an upload busy until it's called again with a nil error
+func (s *UploadStore) setBusy(id string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err != nil {
+		s.busy[id] = err
+	} else {
+		delete(s.busy, id)
+	}
+}
+
+// lockUpload acquires the lock of an upload, returning the function that
+// releases it. IDs that aren't valid upload IDs report ErrUploadNotFound
+// without touching the lock table
+func (s *UploadStore) lockUpload(id string) (unlock func(), err error) {
+	if !validUploadID(id) {
+		return nil, ErrUploadNotFound
+	}
+	s.lock.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &uploadLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.lock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.lock.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.lock.Unlock()
+	}, nil
+}
+
+func (s *UploadStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *UploadStore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func newUploadID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generating upload id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// validUploadID guards against IDs that could escape the upload directory
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// CreateUploadHandler begins a resumable body upload. Requests must declare
+// the total size of the body in the Upload-Length header, and the name of the
+// body file in the "filename" param
+// Example:
+// curl -X POST -H "Upload-Length: 1073741824" http://localhost:2503/ds/upload?filename=body.csv
+func CreateUploadHandler(uploads *UploadStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.NotFoundHandler(w, r)
+			return
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get(uploadLengthHeader), 10, 64)
+		if err != nil {
+			util.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %q", uploadLengthHeader, r.Header.Get(uploadLengthHeader)))
+			return
+		}
+
+		u, err := uploads.Create(r.FormValue("filename"), length, uploadOwner(r))
+		if err != nil {
+			util.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("%s/%s", AEUpload, u.ID))
+		writeUploadHeaders(w, u)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// UploadHandler manages a single resumable upload:
+// HEAD reports the current offset, PATCH appends a chunk of bytes at the
+// offset given in the Upload-Offset header, POST finalizes a complete upload
+// by saving it as the body of a dataset, accepting the same params as
+// SaveByUploadHandler, and DELETE cancels the upload. Only the profile that
+// created an upload can use it
+// Examples:
+// curl -I http://localhost:2503/ds/upload/{id}
+// curl -X PATCH -H "Upload-Offset: 0" -H "Content-Type: application/offset+octet-stream" --data-binary @chunk http://localhost:2503/ds/upload/{id}
+// curl -X POST -F ref=me/big_dataset http://localhost:2503/ds/upload/{id}
+func UploadHandler(inst *lib.Instance, uploads *UploadStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch r.Method {
+		case http.MethodHead:
+			u, err := uploads.Get(id, uploadOwner(r))
+			if err != nil {
+				writeUploadErr(w, err)
+				return
+			}
+			writeUploadHeaders(w, u)
+			w.WriteHeader(http.StatusOK)
+		case http.MethodPatch:
+			if r.Header.Get("Content-Type") != uploadChunkMimeType {
+				util.WriteErrResponse(w, http.StatusUnsupportedMediaType, fmt.Errorf("chunk content type must be %q", uploadChunkMimeType))
+				return
+			}
+			offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+			if err != nil {
+				util.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("invalid %s header: %q", uploadOffsetHeader, r.Header.Get(uploadOffsetHeader)))
+				return
+			}
+			u, err := uploads.WriteChunk(id, uploadOwner(r), offset, r.Body)
+			if u != nil {
+				writeUploadHeaders(w, u)
+			}
+			if err != nil {
+				writeUploadErr(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			finalizeUpload(w, r, inst, uploads, id)
+		case http.MethodDelete:
+			if err := uploads.Delete(id, uploadOwner(r)); err != nil {
+				writeUploadErr(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			util.NotFoundHandler(w, r)
+		}
+	}
+}
+
+// finalizeUpload saves a complete upload as a dataset body, removing the
+// staged upload once the save succeeds. The upload can't be changed while it's
+// saved
+func finalizeUpload(w http.ResponseWriter, r *http.Request, inst *lib.Instance, uploads *UploadStore, id string) {
+	p := &lib.SaveParams{}
+	if err := parseSaveParamsFromRequest(r, p); err != nil {
+		util.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	if p.Dataset == nil {
+		p.Dataset = &dataset.Dataset{}
+	}
+	if err := parseDatasetFromRequest(r, p.Dataset); err != nil {
+		util.WriteErrResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	u, f, err := uploads.Open(id, uploadOwner(r))
+	if err != nil {
+		if u != nil {
+			writeUploadHeaders(w, u)
+		}
+		writeUploadErr(w, err)
+		return
+	}
+	saved := false
+	defer func() {
+		f.Close()
+		uploads.Close(id, saved)
+	}()
+
+	p.Dataset.SetBodyFile(qfs.NewMemfileReader(u.Filename, f))
+	// the `Save` method uses the `p.BodyPath` field to generate
+	// a default dataset name name if one is not given in the ref
+	p.BodyPath = u.Filename
+
+	ds, err := inst.Dataset().Save(r.Context(), p)
+	if err != nil {
+		util.RespondWithError(w, err)
+		return
+	}
+	saved = true
+	util.WriteResponse(w, ds)
+}
+
+// uploadOwner returns the verified profile making an upload request
+func uploadOwner(r *http.Request) string {
+	c, _ := callerFromCtx(r.Context())
+	return c.ProfileID
+}
+
+func writeUploadHeaders(w http.ResponseWriter, u *Upload) {
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+	w.Header().Set(uploadLengthHeader, strconv.FormatInt(u.Length, 10))
+	w.Header().Set(uploadExpiresHeader, u.Expires.UTC().Format(http.TimeFormat))
+}
+
+func writeUploadErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUploadNotFound):
+		util.WriteErrResponse(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrUploadOffsetMismatch), errors.Is(err, ErrUploadIncomplete), errors.Is(err, ErrUploadFinalizing), errors.Is(err, ErrUploadWriting):
+		util.WriteErrResponse(w, http.StatusConflict, err)
+	default:
+		util.WriteErrResponse(w, http.StatusBadRequest, err)
+	}
+}
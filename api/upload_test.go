@@ -0,0 +1,238 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestUploadStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_upload_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewUploadStore(dir, time.Hour, 10)
+	owner := "profile_id"
+
+	if _, err := s.Create("body", 5, owner); err == nil {
+		t.Errorf("expected creating an upload without a file extension to fail")
+	}
+	if _, err := s.Create("body.csv", 11, owner); err == nil {
+		t.Errorf("expected creating an upload larger than the max size to fail")
+	}
+
+	u, err := s.Create("body.csv", 6, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, mode := range map[string]os.FileMode{dir: 0700, s.dataPath(u.ID): 0600, s.infoPath(u.ID): 0600} {
+		if fi, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		} else if fi.Mode().Perm() != mode {
+			t.Errorf("expected %s to have mode %s, got %s", path, mode, fi.Mode().Perm())
+		}
+	}
+
+	if _, err := s.WriteChunk(u.ID, owner, 0, strings.NewReader("a,b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WriteChunk(u.ID, owner, 0, strings.NewReader("a,b\n")); !errors.Is(err, ErrUploadOffsetMismatch) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadOffsetMismatch, err)
+	}
+	if _, _, err := s.Open(u.ID, owner); !errors.Is(err, ErrUploadIncomplete) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadIncomplete, err)
+	}
+	if u, err = s.WriteChunk(u.ID, owner, 4, strings.NewReader("1,2\n")); err == nil {
+		t.Errorf("expected writing past upload length to fail")
+	}
+	if u.Offset != 6 {
+		t.Errorf("offset mismatch. expected: 6, got: %d", u.Offset)
+	}
+
+	// uploads are hidden from other profiles
+	if _, err := s.Get(u.ID, "other_id"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadNotFound, err)
+	}
+	if _, _, err := s.Open(u.ID, ""); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadNotFound, err)
+	}
+	if err := s.Delete(u.ID, "other_id"); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadNotFound, err)
+	}
+
+	_, f, err := s.Open(u.ID, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a,b\n1," {
+		t.Errorf("data mismatch. expected: %q, got: %q", "a,b\n1,", string(data))
+	}
+
+	// open uploads can't be changed until they're closed
+	if _, _, err := s.Open(u.ID, owner); !errors.Is(err, ErrUploadFinalizing) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadFinalizing, err)
+	}
+	if err := s.Delete(u.ID, owner); !errors.Is(err, ErrUploadFinalizing) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadFinalizing, err)
+	}
+	s.Close(u.ID, false)
+
+	if err := s.Delete(u.ID, owner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(u.ID, owner); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadNotFound, err)
+	}
+	if _, err := s.Get("../../etc/passwd", owner); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadNotFound, err)
+	}
+	if len(s.locks) != 0 {
+		t.Errorf("expected upload locks to be dropped once requests finish, got %d", len(s.locks))
+	}
+
+	// chunks are read without holding the upload lock, other requests don't
+	// wait on the client
+	if u, err = s.Create("body.csv", 6, owner); err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	written := make(chan error)
+	go func() {
+		_, err := s.WriteChunk(u.ID, owner, 0, pr)
+		written <- err
+	}()
+	if _, err := pw.Write([]byte("a,b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(u.ID, owner); err != nil {
+		t.Errorf("expected reading upload state while writing a chunk to succeed, got: %s", err)
+	}
+	if _, err := s.WriteChunk(u.ID, owner, 0, strings.NewReader("a,b\n")); !errors.Is(err, ErrUploadWriting) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadWriting, err)
+	}
+	if err := s.Delete(u.ID, owner); !errors.Is(err, ErrUploadWriting) {
+		t.Errorf("error mismatch. expected: %q, got: %v", ErrUploadWriting, err)
+	}
+	pw.Close()
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if u, err = s.Get(u.ID, owner); err != nil || u.Offset != 3 {
+		t.Errorf("expected offset 3 after writing a chunk, got: %v, %v", u, err)
+	}
+	if err := s.Delete(u.ID, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	// expired uploads are removed
+	s = NewUploadStore(dir, time.Millisecond, 10)
+	if u, err = s.Create("body.csv", 6, owner); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 5)
+	s.Reap()
+	if _, err := os.Stat(s.dataPath(u.ID)); !os.IsNotExist(err) {
+		t.Errorf("expected reaping to remove expired upload data")
+	}
+
+	// data files without upload state are removed once they're older than the
+	// upload TTL
+	orphan, err := newUploadID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(s.dataPath(orphan), []byte("city"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s = NewUploadStore(dir, time.Hour, 10)
+	s.Reap()
+	if _, err := os.Stat(s.dataPath(orphan)); err != nil {
+		t.Errorf("expected reaping to keep orphaned upload data younger than the TTL, got: %s", err)
+	}
+	s = NewUploadStore(dir, time.Millisecond, 10)
+	time.Sleep(time.Millisecond * 5)
+	s.Reap()
+	if _, err := os.Stat(s.dataPath(orphan)); !os.IsNotExist(err) {
+		t.Errorf("expected reaping to remove orphaned upload data")
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	run := NewAPITestRunner(t)
+	defer run.Delete()
+
+	dir := run.MustMakeWorkDir(t, "uploads")
+	uploads := NewUploadStore(dir, time.Hour, 0)
+	body, err := ioutil.ReadFile("testdata/cities/data.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create
+	req := httptest.NewRequest(http.MethodPost, "/ds/upload?filename=cities.csv", nil)
+	req.Header.Set(uploadLengthHeader, strconv.Itoa(len(body)))
+	w := httptest.NewRecorder()
+	CreateUploadHandler(uploads)(w, req)
+	assertStatusCode(t, "create upload", w.Code, http.StatusCreated)
+	loc := w.Header().Get("Location")
+	id := strings.TrimPrefix(loc, AEUpload.String()+"/")
+
+	h := UploadHandler(run.Inst, uploads)
+	call := func(method string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, loc, bytes.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if method == http.MethodPost {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	// write the body in two chunks
+	half := len(body) / 2
+	w = call(http.MethodPatch, body[:half], map[string]string{"Content-Type": uploadChunkMimeType, uploadOffsetHeader: "0"})
+	assertStatusCode(t, "first chunk", w.Code, http.StatusNoContent)
+
+	// finalizing early is a conflict
+	w = call(http.MethodPost, []byte("ref=peer/uploaded"), nil)
+	assertStatusCode(t, "finalize incomplete upload", w.Code, http.StatusConflict)
+
+	// check offset to resume from
+	w = call(http.MethodHead, nil, nil)
+	assertStatusCode(t, "head upload", w.Code, http.StatusOK)
+	offset := w.Header().Get(uploadOffsetHeader)
+	if offset != strconv.Itoa(half) {
+		t.Errorf("offset mismatch. expected: %d, got: %s", half, offset)
+	}
+
+	w = call(http.MethodPatch, body[half:], map[string]string{"Content-Type": uploadChunkMimeType, uploadOffsetHeader: offset})
+	assertStatusCode(t, "second chunk", w.Code, http.StatusNoContent)
+
+	w = call(http.MethodPost, []byte("ref=peer/uploaded"), nil)
+	assertStatusCode(t, "finalize upload", w.Code, http.StatusOK)
+
+	// finalizing removes the staged upload
+	w = call(http.MethodHead, nil, nil)
+	assertStatusCode(t, "head finalized upload", w.Code, http.StatusNotFound)
+}
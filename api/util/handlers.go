@@ -18,6 +18,12 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{ "meta": { "code": 404, "status": "not found" }, "data": null }`))
 }
 
+// MethodNotAllowedHandler is a JSON 405 response
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	w.Write([]byte(`{ "meta": { "code": 405, "status": "method not allowed" }, "data": null }`))
+}
+
 // EmptyOkHandler is an empty 200 response, often used
 // for OPTIONS requests that responds with headers set in addCorsHeaders
 func EmptyOkHandler(w http.ResponseWriter, r *http.Request) {
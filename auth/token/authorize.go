@@ -0,0 +1,227 @@
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// PKCEMethodS256 is the only supported PKCE code challenge method. The
+// challenge is the unpadded base64url encoded SHA-256 hash of the verifier,
+// see RFC 7636
+const PKCEMethodS256 = "S256"
+
+// Client is a third-party app that can ask users to authorize it, getting
+// tokens for their profile without handling private keys
+type Client struct {
+	ID string `json:"id"`
+	// Name is shown to users on the consent page
+	Name string `json:"name"`
+	// RedirectURIs are the only URIs codes are sent to
+	RedirectURIs []string `json:"redirectURIs"`
+}
+
+// AllowsRedirectURI reports whether the client registered a redirect URI.
+// Following RFC 8252, the port of loopback redirect URIs can vary, as native
+// apps like notebooks listen on ephemeral ports
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if uri == allowed || sameLoopbackRedirect(allowed, uri) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameLoopbackRedirect(allowed, uri string) bool {
+	a, err := url.Parse(allowed)
+	if err != nil || a.Scheme != "http" {
+		return false
+	}
+	if ip := net.ParseIP(a.Hostname()); ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == a.Scheme &&
+		u.User == nil &&
+		u.Hostname() == a.Hostname() &&
+		u.Path == a.Path &&
+		u.RawQuery == a.RawQuery &&
+		u.Fragment == ""
+}
+
+// AuthorizeRequest asks a user to authorize a client
+type AuthorizeRequest struct {
+	ResponseType        ResponseType
+	ClientID            string
+	RedirectURI         string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// ProfileID is the profile approving the request. Authorize doesn't
+	// authenticate users, callers must set the ID of a profile they've verified
+	ProfileID string
+}
+
+// authorization is an issued authorization code
+type authorization struct {
+	profileID     string
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	expires       time.Time
+	// redeemed codes are kept until they expire to detect reuse
+	redeemed bool
+}
+
+// verify checks a token request is allowed to exchange the code
+func (a *authorization) verify(req *Request, now time.Time) error {
+	if now.After(a.expires) {
+		return ErrCodeExpired
+	}
+	if req.ClientID != a.clientID || req.RedirectURI != a.redirectURI {
+		return ErrInvalidAuthorizeCode
+	}
+	if !verifyCodeChallenge(a.codeChallenge, req.CodeVerifier) {
+		return ErrInvalidAuthorizeCode
+	}
+	return nil
+}
+
+// validCodeChallenge checks a challenge is a base64url encoded SHA-256 hash
+func validCodeChallenge(challenge string) bool {
+	sum, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(sum) == sha256.Size
+}
+
+// verifyCodeChallenge checks a PKCE code verifier matches a S256 challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, r := range verifier {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.' || r == '_' || r == '~') {
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+// RegisterClient implements the Authorizer interface. Redirect URIs must be
+// absolute & can't have a fragment. Registering a client with the ID of an
+// existing client replaces it
+func (p *LocalProvider) RegisterClient(c *Client) error {
+	if c == nil || c.ID == "" {
+		return fmt.Errorf("%w: client ID is required", ErrInvalidClient)
+	}
+	if len(c.RedirectURIs) == 0 {
+		return fmt.Errorf("%w: client %q has no redirect URIs", ErrInvalidClient, c.ID)
+	}
+	for _, uri := range c.RedirectURIs {
+		if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" {
+			return fmt.Errorf("%w: client %q redirect URI %q must be absolute without a fragment", ErrInvalidClient, c.ID, uri)
+		}
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	p.clients[c.ID] = c
+	return nil
+}
+
+// AuthorizeClient implements the Authorizer interface. ErrInvalidClient means
+// the redirect URI can't be trusted, other errors should be sent to the client
+// at the redirect URI
+func (p *LocalProvider) AuthorizeClient(ctx context.Context, req *AuthorizeRequest) (*Client, error) {
+	p.lk.Lock()
+	c, ok := p.clients[req.ClientID]
+	p.lk.Unlock()
+	if !ok || !c.AllowsRedirectURI(req.RedirectURI) {
+		return nil, ErrInvalidClient
+	}
+	if req.ResponseType != RTCode {
+		return c, ErrUnsupportedResponseType
+	}
+	if req.CodeChallengeMethod != PKCEMethodS256 || !validCodeChallenge(req.CodeChallenge) {
+		return c, ErrInvalidRequest
+	}
+	return c, nil
+}
+
+// Authorize implements the Authorizer interface. Codes expire after
+// AccessCodeTTL & can only be exchanged once, by the client they're issued to
+func (p *LocalProvider) Authorize(ctx context.Context, req *AuthorizeRequest) (string, error) {
+	if _, err := p.AuthorizeClient(ctx, req); err != nil {
+		return "", err
+	}
+	if req.ProfileID == "" {
+		return "", ErrInvalidCredentials
+	}
+	pro, err := p.getProfile(ctx, req.ProfileID)
+	if err != nil {
+		return "", err
+	}
+	code, err := newTokenID()
+	if err != nil {
+		log.Debugf("token.Provider failed to generate authorization code: %q", err.Error())
+		return "", ErrServerError
+	}
+
+	now := Timestamp()
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	for c, a := range p.codes {
+		if now.After(a.expires) {
+			delete(p.codes, c)
+		}
+	}
+	p.codes[code] = &authorization{
+		profileID:     pro.ID.Encode(),
+		clientID:      req.ClientID,
+		redirectURI:   req.RedirectURI,
+		codeChallenge: req.CodeChallenge,
+		expires:       now.Add(AccessCodeTTL),
+	}
+	return code, nil
+}
+
+// redeemAuthorizeCode verifies a token request for an authorization code,
+// returning the ID of the profile that approved it. Codes that fail
+// verification are removed. Redeeming a code twice revokes all refresh tokens
+// of the profile, as the tokens issued for the code may have leaked
+func (p *LocalProvider) redeemAuthorizeCode(ctx context.Context, req *Request) (string, error) {
+	p.lk.Lock()
+	a, ok := p.codes[req.Code]
+	if !ok {
+		p.lk.Unlock()
+		return "", ErrInvalidAuthorizeCode
+	}
+	if a.redeemed {
+		p.lk.Unlock()
+		log.Infof("token.Provider authorization code reused, revoking refresh tokens for profile %q", a.profileID)
+		if _, err := p.RevokeRefreshTokens(ctx, a.profileID); err != nil {
+			log.Errorf("token.Provider failed to revoke refresh tokens: %q", err.Error())
+		}
+		return "", ErrInvalidAuthorizeCode
+	}
+	err := a.verify(req, Timestamp())
+	if err != nil {
+		delete(p.codes, req.Code)
+	} else {
+		a.redeemed = true
+	}
+	p.lk.Unlock()
+
+	if err != nil {
+		return "", err
+	}
+	return a.profileID, nil
+}
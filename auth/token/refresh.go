@@ -0,0 +1,196 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/affix-io/affix/profile"
+	"github.com/affix-io/qfs/localfs"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// RefreshTokensFilename is the name of the file in a repo that holds issued
+	// refresh tokens
+	RefreshTokensFilename = "refresh_tokens.json"
+	// refreshTokenKeyPrefix namespaces refresh tokens in a Store
+	refreshTokenKeyPrefix = "refresh_token:"
+)
+
+// NewRefreshTokenStore creates a token store for refresh tokens that's kept on
+// local disk in the repo at repoPath
+func NewRefreshTokenStore(repoPath string) (Store, error) {
+	// Don't create a store with the empty path, this will use the current directory
+	if repoPath == "" {
+		return nil, fmt.Errorf("refresh token store requires a non-empty repo path")
+	}
+	fs, err := localfs.NewFS(nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(filepath.Join(repoPath, RefreshTokensFilename), fs)
+}
+
+func refreshTokenKey(id string) string {
+	return refreshTokenKeyPrefix + id
+}
+
+// refreshTokenRecord is the form a refresh token is persisted in. Records keep
+// the token's header & claims, so expired tokens & the tokens of a profile can
+// be found, but replace the signature with a hash of the token. Records can't
+// be used as refresh tokens, so reading the store doesn't leak usable tokens
+func refreshTokenRecord(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return raw[:strings.LastIndex(raw, ".")+1] + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// matchesRefreshTokenRecord reports whether a stored record was created from
+// the raw refresh token
+func matchesRefreshTokenRecord(record, raw string) bool {
+	return subtle.ConstantTimeCompare([]byte(record), []byte(refreshTokenRecord(raw))) == 1
+}
+
+// newTokenID returns a random token identifier
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// issueRefreshToken creates a refresh token for a profile, persisting a record
+// of it so it can be rotated & revoked. Refresh tokens carry a unique ID, which
+// access tokens don't, so access tokens can't be used to refresh and refresh
+// tokens aren't accepted by ParseAuthToken
+func (p *LocalProvider) issueRefreshToken(ctx context.Context, pro *profile.Profile) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := Timestamp()
+	raw, err := signPrivKeyToken(pro.PrivKey, &Claims{
+		StandardClaims: &jwt.StandardClaims{
+			Id:        id,
+			Subject:   pro.ID.Encode(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(RefreshTokenTTL).In(time.UTC).Unix(),
+		},
+		ClientType: UserClient,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.pruneRefreshTokens(ctx); err != nil {
+		log.Debugf("token.Provider failed to prune expired refresh tokens: %q", err.Error())
+	}
+	if err := p.tokens.PutToken(ctx, refreshTokenKey(id), refreshTokenRecord(raw)); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// redeemRefreshToken verifies a refresh token & removes it from the store,
+// returning its claims. Each refresh token can only be redeemed once.
+// Redeeming a token that was already redeemed or revoked revokes all refresh
+// tokens of the profile, as it suggests the token was stolen
+func (p *LocalProvider) redeemRefreshToken(ctx context.Context, raw string) (*Claims, error) {
+	tok, err := parseSignedToken(ctx, raw, p.keys)
+	if err != nil {
+		log.Debugf("token.Provider error parsing refresh token: %q", err.Error())
+		return nil, ErrInvalidRefreshToken
+	}
+	claims, ok := tok.Claims.(*Claims)
+	if !ok || !tok.Valid || claims.StandardClaims == nil || claims.Id == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	key := refreshTokenKey(claims.Id)
+	stored, err := p.tokens.RawToken(ctx, key)
+	if errors.Is(err, ErrTokenNotFound) {
+		log.Infof("token.Provider refresh token reused, revoking refresh tokens for profile %q", claims.Subject)
+		if _, err := p.RevokeRefreshTokens(ctx, claims.Subject); err != nil {
+			log.Errorf("token.Provider failed to revoke refresh tokens: %q", err.Error())
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil || !matchesRefreshTokenRecord(stored, raw) {
+		return nil, ErrInvalidRefreshToken
+	}
+	// concurrent requests can both read the token, only one deletes it
+	if err := p.tokens.DeleteToken(ctx, key); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return claims, nil
+}
+
+// RevokeRefreshToken implements the Revoker interface. Revoking a token that
+// is unknown, expired or already revoked isn't an error
+func (p *LocalProvider) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	claims := &Claims{}
+	if _, _, err := (&jwt.Parser{}).ParseUnverified(refreshToken, claims); err != nil || claims.StandardClaims == nil || claims.Id == "" {
+		return nil
+	}
+	key := refreshTokenKey(claims.Id)
+	if stored, err := p.tokens.RawToken(ctx, key); err != nil || !matchesRefreshTokenRecord(stored, refreshToken) {
+		return nil
+	}
+	if err := p.tokens.DeleteToken(ctx, key); err != nil && !errors.Is(err, ErrTokenNotFound) {
+		return err
+	}
+	return nil
+}
+
+// RevokeRefreshTokens revokes all refresh tokens issued to a profile,
+// returning the number of tokens revoked
+func (p *LocalProvider) RevokeRefreshTokens(ctx context.Context, profileID string) (int, error) {
+	return p.deleteRefreshTokens(ctx, func(claims *Claims) bool {
+		return claims.Subject == profileID
+	})
+}
+
+// pruneRefreshTokens removes expired refresh tokens from the store
+func (p *LocalProvider) pruneRefreshTokens(ctx context.Context) error {
+	now := Timestamp().Unix()
+	_, err := p.deleteRefreshTokens(ctx, func(claims *Claims) bool {
+		return claims.ExpiresAt != 0 && claims.ExpiresAt < now
+	})
+	return err
+}
+
+// deleteRefreshTokens removes the refresh tokens in the store that match a
+// filter, returning the number removed
+func (p *LocalProvider) deleteRefreshTokens(ctx context.Context, match func(claims *Claims) bool) (int, error) {
+	toks, err := p.tokens.ListTokens(ctx, 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, t := range toks {
+		if !strings.HasPrefix(t.Key, refreshTokenKeyPrefix) {
+			continue
+		}
+		claims := &Claims{}
+		if _, _, err := (&jwt.Parser{}).ParseUnverified(t.Raw, claims); err != nil || claims.StandardClaims == nil {
+			continue
+		}
+		if !match(claims) {
+			continue
+		}
+		if err := p.tokens.DeleteToken(ctx, t.Key); err != nil && !errors.Is(err, ErrTokenNotFound) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
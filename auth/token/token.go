@@ -46,6 +46,9 @@ type Token = jwt.Token
 type Claims struct {
 	*jwt.StandardClaims
 	ClientType ClientType `json:"clientType"`
+	// Role optionally grants the token holder a named role. Interpretation of
+	// the role is left to the service verifying the token
+	Role string `json:"role,omitempty"`
 }
 
 // Parse will parse, validate and return a token
@@ -61,6 +64,25 @@ func ParseWithClaims(tokenString string, claims *Claims, tokens Source) (*Token,
 // NewPrivKeyAuthToken creates a JWT token string suitable for making requests
 // authenticated as the given private key
 func NewPrivKeyAuthToken(pk crypto.PrivKey, profileID string, ttl time.Duration) (string, error) {
+	var exp int64
+	if ttl != time.Duration(0) {
+		exp = Timestamp().Add(ttl).In(time.UTC).Unix()
+	}
+
+	return signPrivKeyToken(pk, &Claims{
+		StandardClaims: &jwt.StandardClaims{
+			Subject: profileID,
+			// set the expire time
+			// see http://tools.ietf.org/html/draft-ietf-oauth-json-web-token-20#section-4.1.4
+			ExpiresAt: exp,
+		},
+		ClientType: UserClient,
+	})
+}
+
+// signPrivKeyToken signs claims with a private key, setting the issuer to the
+// key's ID
+func signPrivKeyToken(pk crypto.PrivKey, claims *Claims) (string, error) {
 	signingMethod, err := jwtSigningMethod(pk)
 	if err != nil {
 		return "", err
@@ -93,28 +115,28 @@ func NewPrivKeyAuthToken(pk crypto.PrivKey, profileID string, ttl time.Duration)
 		return "", fmt.Errorf("unsupported key type for token creation: %q", pk.Type())
 	}
 
-	var exp int64
-	if ttl != time.Duration(0) {
-		exp = Timestamp().Add(ttl).In(time.UTC).Unix()
-	}
-
-	// set our claims
-	t.Claims = &Claims{
-		StandardClaims: &jwt.StandardClaims{
-			Issuer:  id,
-			Subject: profileID,
-			// set the expire time
-			// see http://tools.ietf.org/html/draft-ietf-oauth-json-web-token-20#section-4.1.4
-			ExpiresAt: exp,
-		},
-		ClientType: UserClient,
-	}
-
+	claims.Issuer = id
+	t.Claims = claims
 	return t.SignedString(signKey)
 }
 
-// ParseAuthToken will parse, validate and return a token
+// ParseAuthToken will parse, validate and return an access token. Refresh
+// tokens carry a token ID and are rejected, they can only be exchanged for
+// new tokens with a Provider
 func ParseAuthToken(ctx context.Context, tokenString string, keystore key.Store) (*Token, error) {
+	tok, err := parseSignedToken(ctx, tokenString, keystore)
+	if err != nil {
+		return tok, err
+	}
+	if claims, ok := tok.Claims.(*Claims); ok && claims.StandardClaims != nil && claims.Id != "" {
+		return nil, ErrInvalidToken
+	}
+	return tok, nil
+}
+
+// parseSignedToken parses a token, verifying it was signed by its issuer's key
+// in keystore
+func parseSignedToken(ctx context.Context, tokenString string, keystore key.Store) (*Token, error) {
 	claims := &Claims{}
 	return jwt.ParseWithClaims(tokenString, claims, func(t *Token) (interface{}, error) {
 		pid, err := key.DecodeID(claims.Issuer)
@@ -353,6 +375,9 @@ func (st *qfsStore) PutToken(ctx context.Context, key string, raw string) error
 }
 
 func (st *qfsStore) RawToken(ctx context.Context, key string) (rawToken string, err error) {
+	st.toksLk.Lock()
+	defer st.toksLk.Unlock()
+
 	t, ok := st.toks[key]
 	if !ok {
 		return "", ErrTokenNotFound
@@ -374,7 +399,9 @@ func (st *qfsStore) DeleteToken(ctx context.Context, key string) (err error) {
 func (st *qfsStore) ListTokens(ctx context.Context, offset, limit int) ([]RawToken, error) {
 	results := make([]RawToken, 0, limit+1)
 
+	st.toksLk.Lock()
 	toks := st.toRawTokens()
+	st.toksLk.Unlock()
 	for i := 0; i < len(toks); i++ {
 		if offset > 0 {
 			offset--
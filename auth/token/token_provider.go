@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/affix-io/affix/auth/key"
@@ -43,6 +44,12 @@ var (
 	ErrTokenExpired = fmt.Errorf("token expired")
 	// ErrInvalidRefreshToken is returned on parsing invalid refresh tokens
 	ErrInvalidRefreshToken = fmt.Errorf("invalid refresh token")
+	// ErrInvalidClient is returned for unregistered clients & redirect URIs a
+	// client hasn't registered
+	ErrInvalidClient = fmt.Errorf("invalid client")
+	// ErrUnsupportedResponseType is returned for authorization requests that
+	// don't ask for a code
+	ErrUnsupportedResponseType = fmt.Errorf("unsupported response type")
 )
 
 // Provider is a service that generates access & refresh tokens
@@ -51,6 +58,26 @@ type Provider interface {
 	Token(ctx context.Context, req *Request) (*Response, error)
 }
 
+// Revoker is implemented by providers that can revoke the refresh tokens they
+// issue
+type Revoker interface {
+	RevokeRefreshToken(ctx context.Context, refreshToken string) error
+}
+
+// Authorizer is implemented by providers that issue authorization codes to
+// registered clients, which exchange them for tokens with the
+// AuthorizationCode grant
+type Authorizer interface {
+	// RegisterClient allows a client to request authorization codes
+	RegisterClient(c *Client) error
+	// AuthorizeClient checks an authorization request, returning the client
+	// that made it
+	AuthorizeClient(ctx context.Context, req *AuthorizeRequest) (*Client, error)
+	// Authorize issues a code for the client on behalf of the verified profile
+	// approving an authorization request
+	Authorize(ctx context.Context, req *AuthorizeRequest) (code string, err error)
+}
+
 // Request is a wrapper for incoming token requests
 type Request struct {
 	GrantType    GrantType `json:"grant_type"`
@@ -59,6 +86,8 @@ type Request struct {
 	Password     string    `json:"password"`
 	RefreshToken string    `json:"refresh_token"`
 	RedirectURI  string    `json:"redirect_uri"`
+	ClientID     string    `json:"client_id"`
+	CodeVerifier string    `json:"code_verifier"`
 }
 
 // Response wraps the token response object
@@ -117,90 +146,185 @@ func (ct ClientType) String() string {
 type LocalProvider struct {
 	profiles profile.Store
 	keys     key.Store
+	// repoPath is the repo refresh tokens are persisted in
+	repoPath string
+	// tokens holds the refresh tokens that haven't been redeemed or revoked
+	tokens Store
+
+	lk sync.Mutex
+	// clients are the clients allowed to request authorization codes
+	clients map[string]*Client
+	// codes are the issued authorization codes that haven't expired
+	codes map[string]*authorization
+}
+
+// ProviderOption is a function that adjusts a LocalProvider
+type ProviderOption func(p *LocalProvider)
+
+// OptRepoPath persists issued refresh tokens in the repo at repoPath, so they
+// outlive the provider
+func OptRepoPath(repoPath string) ProviderOption {
+	return func(p *LocalProvider) {
+		p.repoPath = repoPath
+	}
 }
 
-// NewProvider instantiates a new LocalProvider
-func NewProvider(p profile.Store, k key.Store) (*LocalProvider, error) {
-	return &LocalProvider{
+// OptRefreshTokenStore persists issued refresh tokens in a token store instead
+// of the repo's refresh token file
+func OptRefreshTokenStore(s Store) ProviderOption {
+	return func(p *LocalProvider) {
+		p.tokens = s
+	}
+}
+
+// NewProvider instantiates a new LocalProvider. Issued refresh tokens must be
+// persisted, either in the repo given with OptRepoPath or in a store given with
+// OptRefreshTokenStore
+func NewProvider(p profile.Store, k key.Store, opts ...ProviderOption) (*LocalProvider, error) {
+	lp := &LocalProvider{
 		profiles: p,
 		keys:     k,
-	}, nil
+		clients:  map[string]*Client{},
+		codes:    map[string]*authorization{},
+	}
+	for _, opt := range opts {
+		opt(lp)
+	}
+	if lp.tokens == nil {
+		if lp.repoPath == "" {
+			return nil, fmt.Errorf("token provider requires a repo path or refresh token store")
+		}
+		tokens, err := NewRefreshTokenStore(lp.repoPath)
+		if err != nil {
+			return nil, err
+		}
+		lp.tokens = tokens
+	}
+	return lp, nil
 }
 
-// compile-time assertion that LocalProvider is a token.Provider
-var _ Provider = (*LocalProvider)(nil)
+// compile-time assertions that LocalProvider is a token.Provider, a
+// token.Revoker & a token.Authorizer
+var (
+	_ Provider   = (*LocalProvider)(nil)
+	_ Revoker    = (*LocalProvider)(nil)
+	_ Authorizer = (*LocalProvider)(nil)
+)
 
-// Token handles the OAuth token flow
+// Token handles the OAuth token flow. Refresh tokens are rotated, each can be
+// exchanged once for a new access & refresh token
 func (p *LocalProvider) Token(ctx context.Context, req *Request) (*Response, error) {
-	log.Debugf("token.Provider got request: %+v", req)
+	// requests carry credentials, only log what's being asked for
+	log.Debugf("token.Provider got %q request", req.GrantType)
 	resp := &Response{TokenType: "jwt", ExpiresIn: int64(AccessTokenTTL.Seconds())}
 	switch req.GrantType {
 	case PasswordCredentials:
-		if req.Username == "" {
-			return nil, ErrInvalidCredentials
-		}
-		// TODO(arqu): this only selects the first returned profile for a given peername.
-		// ideally we would use the profile.ID to fetch the exact profile
-		// or otherwise validate the signatures
-		pros, err := p.profiles.ProfilesForUsername(ctx, req.Username)
+		pro, err := p.authenticate(ctx, req.Username)
 		if err != nil {
-			log.Debugf("token.Provider failed to fetch profiles: %q", err.Error())
-			return nil, ErrInvalidRequest
-		}
-		if len(pros) == 0 {
-			log.Debugf("token.Provider no matching profiles found")
-			return nil, ErrNotFound
+			return nil, err
 		}
-		if len(pros) > 1 {
-			log.Infof("token.Provider found multiple profiles for the given username - selected the first one")
+		if err := p.issueTokens(ctx, pro, resp); err != nil {
+			return nil, err
 		}
-		pro := pros[0]
-		if pro.PrivKey == nil {
-			log.Debugf("token.Provider private key is nil")
-			return nil, ErrInvalidCredentials
+	case Refreshing:
+		if req.RefreshToken == "" {
+			return nil, ErrInvalidRequest
 		}
-		accessToken, err := NewPrivKeyAuthToken(pro.PrivKey, pro.ID.Encode(), AccessTokenTTL)
+		claims, err := p.redeemRefreshToken(ctx, req.RefreshToken)
 		if err != nil {
-			log.Debugf("token.Provider failed to generate access token: %q", err.Error())
-			return nil, ErrInvalidRequest
+			return nil, err
 		}
-		refreshToken, err := NewPrivKeyAuthToken(pro.PrivKey, pro.ID.Encode(), RefreshTokenTTL)
+		pro, err := p.getProfile(ctx, claims.Subject)
 		if err != nil {
-			log.Debugf("token.Provider failed to generate refresh token: %q", err.Error())
-			return nil, ErrInvalidRequest
+			return nil, err
 		}
-		resp.AccessToken = accessToken
-		resp.RefreshToken = refreshToken
-	case Refreshing:
-		if req.RefreshToken == "" {
+		if err := p.issueTokens(ctx, pro, resp); err != nil {
+			return nil, err
+		}
+	case AuthorizationCode:
+		if req.Code == "" || req.ClientID == "" || req.CodeVerifier == "" {
 			return nil, ErrInvalidRequest
 		}
-		tok, err := ParseAuthToken(ctx, req.RefreshToken, p.keys)
+		profileID, err := p.redeemAuthorizeCode(ctx, req)
 		if err != nil {
-			log.Debugf("token.Provider error parsing refresh token: %q", err.Error())
-			return nil, ErrInvalidRequest
+			return nil, err
 		}
-
-		if claims, ok := tok.Claims.(*Claims); ok {
-			pid, err := profile.IDB58Decode(claims.Subject)
-			if err != nil {
-				log.Debugf("token.Provider failed to parse profileID")
-				return nil, ErrInvalidRequest
-			}
-			pro, err := p.profiles.GetProfile(ctx, pid)
-			if errors.Is(err, profile.ErrNotFound) {
-				log.Debugf("token.Provider profile not found")
-				return nil, ErrNotFound
-			}
-			accessToken, err := NewPrivKeyAuthToken(pro.PrivKey, pro.ID.Encode(), AccessTokenTTL)
-			if err != nil {
-				log.Debugf("token.Provider failed to generate access token: %q", err.Error())
-				return nil, ErrInvalidRequest
-			}
-			resp.AccessToken = accessToken
+		pro, err := p.getProfile(ctx, profileID)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.issueTokens(ctx, pro, resp); err != nil {
+			return nil, err
 		}
 	default:
 		return nil, ErrInvalidRequest
 	}
 	return resp, nil
 }
+
+// authenticate finds the profile a user is logging in as
+func (p *LocalProvider) authenticate(ctx context.Context, username string) (*profile.Profile, error) {
+	if username == "" {
+		return nil, ErrInvalidCredentials
+	}
+	// TODO(arqu): this only selects the first returned profile for a given peername.
+	// ideally we would use the profile.ID to fetch the exact profile
+	// or otherwise validate the signatures
+	pros, err := p.profiles.ProfilesForUsername(ctx, username)
+	if err != nil {
+		log.Debugf("token.Provider failed to fetch profiles: %q", err.Error())
+		return nil, ErrInvalidRequest
+	}
+	if len(pros) == 0 {
+		log.Debugf("token.Provider no matching profiles found")
+		return nil, ErrNotFound
+	}
+	if len(pros) > 1 {
+		log.Infof("token.Provider found multiple profiles for the given username - selected the first one")
+	}
+	pro := pros[0]
+	if pro.PrivKey == nil {
+		log.Debugf("token.Provider private key is nil")
+		return nil, ErrInvalidCredentials
+	}
+	return pro, nil
+}
+
+// getProfile fetches the profile a token or code was issued to
+func (p *LocalProvider) getProfile(ctx context.Context, profileID string) (*profile.Profile, error) {
+	pid, err := profile.IDB58Decode(profileID)
+	if err != nil {
+		log.Debugf("token.Provider failed to parse profileID")
+		return nil, ErrInvalidRequest
+	}
+	pro, err := p.profiles.GetProfile(ctx, pid)
+	if errors.Is(err, profile.ErrNotFound) {
+		log.Debugf("token.Provider profile not found")
+		return nil, ErrNotFound
+	} else if err != nil {
+		log.Debugf("token.Provider failed to fetch profile: %q", err.Error())
+		return nil, ErrServerError
+	}
+	if pro.PrivKey == nil {
+		log.Debugf("token.Provider private key is nil")
+		return nil, ErrInvalidCredentials
+	}
+	return pro, nil
+}
+
+// issueTokens adds a new access & refresh token for a profile to a response
+func (p *LocalProvider) issueTokens(ctx context.Context, pro *profile.Profile, resp *Response) error {
+	accessToken, err := NewPrivKeyAuthToken(pro.PrivKey, pro.ID.Encode(), AccessTokenTTL)
+	if err != nil {
+		log.Debugf("token.Provider failed to generate access token: %q", err.Error())
+		return ErrInvalidRequest
+	}
+	refreshToken, err := p.issueRefreshToken(ctx, pro)
+	if err != nil {
+		log.Debugf("token.Provider failed to generate refresh token: %q", err.Error())
+		return ErrInvalidRequest
+	}
+	resp.AccessToken = accessToken
+	resp.RefreshToken = refreshToken
+	return nil
+}
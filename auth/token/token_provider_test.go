@@ -0,0 +1,302 @@
+package token_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/affix-io/affix/auth/key"
+	testkeys "github.com/affix-io/affix/auth/key/test"
+	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/profile"
+	"github.com/affix-io/qfs"
+)
+
+func TestLocalProviderRefreshTokens(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, ks, store, owner := newTestProvider(ctx, t)
+
+	login := func() *token.Response {
+		t.Helper()
+		res, err := p.Token(ctx, &token.Request{GrantType: token.PasswordCredentials, Username: "doug"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.RefreshToken == "" {
+			t.Fatal("expected a refresh token")
+		}
+		return res
+	}
+	refresh := func(refreshToken string) (*token.Response, error) {
+		return p.Token(ctx, &token.Request{GrantType: token.Refreshing, RefreshToken: refreshToken})
+	}
+
+	first := login()
+	toks, _ := store.ListTokens(ctx, 0, -1)
+	if len(toks) != 1 {
+		t.Fatalf("expected the refresh token to be persisted, store has %d tokens", len(toks))
+	}
+	// the store only holds a hash of the token's signature
+	signature := first.RefreshToken[strings.LastIndex(first.RefreshToken, ".")+1:]
+	if toks[0].Raw == first.RefreshToken || strings.Contains(toks[0].Raw, signature) {
+		t.Errorf("expected the store not to hold the raw refresh token, got: %q", toks[0].Raw)
+	}
+	if _, err := refresh(toks[0].Raw); !errors.Is(err, token.ErrInvalidRefreshToken) {
+		t.Errorf("expected a stored record not to be usable as a refresh token, got: %v", err)
+	}
+
+	// refreshing rotates the refresh token
+	second, err := refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.AccessToken == "" || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Errorf("expected a new access & refresh token, got: %#v", second)
+	}
+	if _, err := token.ParseAuthToken(ctx, second.AccessToken, ks); err != nil {
+		t.Errorf("expected refreshed access token to be valid: %s", err)
+	}
+	if _, err := token.ParseAuthToken(ctx, second.RefreshToken, ks); err == nil {
+		t.Error("expected a refresh token not to be accepted as an access token")
+	}
+
+	// reusing a rotated token revokes the profile's refresh tokens
+	if _, err := refresh(first.RefreshToken); !errors.Is(err, token.ErrInvalidRefreshToken) {
+		t.Errorf("expected reusing a refresh token to error with %q, got: %v", token.ErrInvalidRefreshToken, err)
+	}
+	if _, err := refresh(second.RefreshToken); !errors.Is(err, token.ErrInvalidRefreshToken) {
+		t.Errorf("expected refresh tokens to be revoked after reuse, got: %v", err)
+	}
+
+	third := login()
+	if err := p.RevokeRefreshToken(ctx, third.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := refresh(third.RefreshToken); !errors.Is(err, token.ErrInvalidRefreshToken) {
+		t.Errorf("expected a revoked refresh token to error with %q, got: %v", token.ErrInvalidRefreshToken, err)
+	}
+	if err := p.RevokeRefreshToken(ctx, third.RefreshToken); err != nil {
+		t.Errorf("expected revoking a revoked token to succeed, got: %s", err)
+	}
+
+	// access tokens can't be used as refresh tokens
+	if _, err := refresh(third.AccessToken); !errors.Is(err, token.ErrInvalidRefreshToken) {
+		t.Errorf("expected refreshing with an access token to error with %q, got: %v", token.ErrInvalidRefreshToken, err)
+	}
+
+	login()
+	login()
+	n, err := p.RevokeRefreshTokens(ctx, owner.ID.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected to revoke 2 refresh tokens, revoked %d", n)
+	}
+}
+
+func TestLocalProviderPersistsRefreshTokens(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps, ks, _ := newTestStores(ctx, t)
+	if _, err := token.NewProvider(ps, ks); err == nil {
+		t.Errorf("expected a provider without a refresh token store to error")
+	}
+
+	repoPath := t.TempDir()
+	p, err := token.NewProvider(ps, ks, token.OptRepoPath(repoPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := p.Token(ctx, &token.Request{GrantType: token.PasswordCredentials, Username: "doug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// refresh tokens outlive the provider that issued them
+	p, err = token.NewProvider(ps, ks, token.OptRepoPath(repoPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Token(ctx, &token.Request{GrantType: token.Refreshing, RefreshToken: res.RefreshToken}); err != nil {
+		t.Errorf("expected a refresh token issued before restarting to be valid, got: %s", err)
+	}
+}
+
+func TestLocalProviderAuthorizationCode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, ks, _, owner := newTestProvider(ctx, t)
+
+	badClients := []*token.Client{
+		{RedirectURIs: []string{"https://example.com/callback"}},
+		{ID: "no_redirects"},
+		{ID: "relative", RedirectURIs: []string{"/callback"}},
+		{ID: "fragment", RedirectURIs: []string{"https://example.com/callback#frag"}},
+	}
+	for i, c := range badClients {
+		if err := p.RegisterClient(c); !errors.Is(err, token.ErrInvalidClient) {
+			t.Errorf("case %d: expected registering to error with %q, got: %v", i, token.ErrInvalidClient, err)
+		}
+	}
+	client := &token.Client{
+		ID:           "notebook",
+		Name:         "Notebook",
+		RedirectURIs: []string{"https://notebook.example.com/callback", "http://127.0.0.1/callback"},
+	}
+	if err := p.RegisterClient(client); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := strings.Repeat("verifier", 6)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	authReq := func(redirectURI string) *token.AuthorizeRequest {
+		return &token.AuthorizeRequest{
+			ResponseType:        token.RTCode,
+			ClientID:            client.ID,
+			RedirectURI:         redirectURI,
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: token.PKCEMethodS256,
+			ProfileID:           owner.ID.Encode(),
+		}
+	}
+
+	authorizeCases := []struct {
+		description string
+		adjust      func(r *token.AuthorizeRequest)
+		expect      error
+	}{
+		{"registered redirect", func(r *token.AuthorizeRequest) {}, nil},
+		{"loopback redirect on another port", func(r *token.AuthorizeRequest) { r.RedirectURI = "http://127.0.0.1:52100/callback" }, nil},
+		{"unknown client", func(r *token.AuthorizeRequest) { r.ClientID = "unknown" }, token.ErrInvalidClient},
+		{"unregistered redirect", func(r *token.AuthorizeRequest) { r.RedirectURI = "https://evil.example.com/callback" }, token.ErrInvalidClient},
+		{"loopback redirect with another path", func(r *token.AuthorizeRequest) { r.RedirectURI = "http://127.0.0.1:52100/other" }, token.ErrInvalidClient},
+		{"token response type", func(r *token.AuthorizeRequest) { r.ResponseType = token.RTToken }, token.ErrUnsupportedResponseType},
+		{"missing challenge", func(r *token.AuthorizeRequest) { r.CodeChallenge = "" }, token.ErrInvalidRequest},
+		{"plain challenge", func(r *token.AuthorizeRequest) { r.CodeChallenge, r.CodeChallengeMethod = verifier, "plain" }, token.ErrInvalidRequest},
+	}
+	for _, c := range authorizeCases {
+		req := authReq(client.RedirectURIs[0])
+		c.adjust(req)
+		if _, err := p.AuthorizeClient(ctx, req); !errors.Is(err, c.expect) {
+			t.Errorf("case %q: expected error %v, got: %v", c.description, c.expect, err)
+		}
+	}
+
+	anonymous := authReq(client.RedirectURIs[0])
+	anonymous.ProfileID = ""
+	if _, err := p.Authorize(ctx, anonymous); !errors.Is(err, token.ErrInvalidCredentials) {
+		t.Errorf("expected authorizing without a profile to error with %q, got: %v", token.ErrInvalidCredentials, err)
+	}
+	unknownUser := authReq(client.RedirectURIs[0])
+	unknownUser.ProfileID = profile.IDFromPeerID(testkeys.GetKeyData(1).PeerID).Encode()
+	if _, err := p.Authorize(ctx, unknownUser); !errors.Is(err, token.ErrNotFound) {
+		t.Errorf("expected authorizing as an unknown profile to error with %q, got: %v", token.ErrNotFound, err)
+	}
+
+	authorize := func(redirectURI string) string {
+		t.Helper()
+		code, err := p.Authorize(ctx, authReq(redirectURI))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	exchange := func(code, redirectURI, verifier string) (*token.Response, error) {
+		return p.Token(ctx, &token.Request{
+			GrantType:    token.AuthorizationCode,
+			Code:         code,
+			ClientID:     client.ID,
+			RedirectURI:  redirectURI,
+			CodeVerifier: verifier,
+		})
+	}
+
+	// codes that fail verification can't be retried
+	redirectURI := "http://127.0.0.1:52100/callback"
+	code := authorize(redirectURI)
+	if _, err := exchange(code, redirectURI, strings.Repeat("wrong", 10)); !errors.Is(err, token.ErrInvalidAuthorizeCode) {
+		t.Errorf("expected a wrong code verifier to error with %q, got: %v", token.ErrInvalidAuthorizeCode, err)
+	}
+	if _, err := exchange(code, redirectURI, verifier); !errors.Is(err, token.ErrInvalidAuthorizeCode) {
+		t.Errorf("expected a failed code to be removed, got: %v", err)
+	}
+
+	code = authorize(redirectURI)
+	if _, err := exchange(code, client.RedirectURIs[1], verifier); !errors.Is(err, token.ErrInvalidAuthorizeCode) {
+		t.Errorf("expected a different redirect URI to error with %q, got: %v", token.ErrInvalidAuthorizeCode, err)
+	}
+
+	code = authorize(redirectURI)
+	res, err := exchange(code, redirectURI, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := token.ParseAuthToken(ctx, res.AccessToken, ks); err != nil {
+		t.Errorf("expected access token to be valid: %s", err)
+	}
+
+	// reusing a code revokes the refresh tokens issued for it
+	if _, err := exchange(code, redirectURI, verifier); !errors.Is(err, token.ErrInvalidAuthorizeCode) {
+		t.Errorf("expected reusing a code to error with %q, got: %v", token.ErrInvalidAuthorizeCode, err)
+	}
+	if _, err := p.Token(ctx, &token.Request{GrantType: token.Refreshing, RefreshToken: res.RefreshToken}); !errors.Is(err, token.ErrInvalidRefreshToken) {
+		t.Errorf("expected refresh tokens to be revoked after code reuse, got: %v", err)
+	}
+
+	prevTs := token.Timestamp
+	defer func() { token.Timestamp = prevTs }()
+	code = authorize(redirectURI)
+	token.Timestamp = func() time.Time { return prevTs().Add(token.AccessCodeTTL + time.Second) }
+	if _, err := exchange(code, redirectURI, verifier); !errors.Is(err, token.ErrCodeExpired) {
+		t.Errorf("expected an expired code to error with %q, got: %v", token.ErrCodeExpired, err)
+	}
+}
+
+func newTestProvider(ctx context.Context, t *testing.T) (*token.LocalProvider, key.Store, token.Store, *profile.Profile) {
+	ps, ks, owner := newTestStores(ctx, t)
+	store, err := token.NewStore("refresh_tokens.json", qfs.NewMemFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := token.NewProvider(ps, ks, token.OptRefreshTokenStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, ks, store, owner
+}
+
+func newTestStores(ctx context.Context, t *testing.T) (profile.Store, key.Store, *profile.Profile) {
+	kd := testkeys.GetKeyData(0)
+	ks, err := key.NewMemStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.AddPubKey(ctx, kd.KeyID, kd.PrivKey.GetPublic()); err != nil {
+		t.Fatal(err)
+	}
+	owner := &profile.Profile{
+		ID:       profile.IDFromPeerID(kd.PeerID),
+		Peername: "doug",
+		PrivKey:  kd.PrivKey,
+		PubKey:   kd.PrivKey.GetPublic(),
+	}
+	ps, err := profile.NewLocalStore(ctx, filepath.Join(t.TempDir(), "profiles.json"), owner, ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.PutProfile(ctx, owner); err != nil {
+		t.Fatal(err)
+	}
+	return ps, ks, owner
+}
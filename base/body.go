@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 
+	"github.com/affix-io/affix/base/bodyquery"
+	"github.com/affix-io/affix/base/columnar"
 	"github.com/affix-io/dataset"
 	"github.com/affix-io/dataset/dsio"
 	"github.com/affix-io/qfs"
@@ -16,42 +19,74 @@ var ErrNoBodyToInline = fmt.Errorf("no body to inline")
 
 // ReadBodyBytes grabs some or all of a dataset's body, writing an output in the desired format
 func ReadBodyBytes(ds *dataset.Dataset, format dataset.DataFormat, fcfg dataset.FormatConfig, limit, offset int, all bool) (data []byte, err error) {
-	if ds == nil {
-		return nil, fmt.Errorf("can't load body from a nil dataset")
+	return ReadBodyBytesWithQuery(ds, nil, format, fcfg, limit, offset, all)
+}
+
+// ReadBodyBytesWithQuery selects rows of a dataset's body with a query,
+// writing an output in the desired format. limit & offset page through query
+// results. A nil query reads the body unmodified
+func ReadBodyBytesWithQuery(ds *dataset.Dataset, q *bodyquery.Query, format dataset.DataFormat, fcfg dataset.FormatConfig, limit, offset int, all bool) ([]byte, error) {
+	rr, err := newBodyReader(ds, q, limit, offset, all)
+	if err != nil {
+		return nil, err
 	}
 
-	file := ds.BodyFile()
-	if file == nil {
-		err = fmt.Errorf("no body file to read")
-		return
+	st := bodyOutputStructure(rr.Structure(), format, fcfg)
+	buf := &bytes.Buffer{}
+	var ew dsio.EntryWriter
+	if pretty, _ := st.FormatConfig["pretty"].(bool); pretty && format == dataset.JSONDataFormat {
+		ew, err = dsio.NewJSONPrettyWriter(st, buf, " ")
+	} else {
+		ew, err = dsio.NewEntryWriter(st, buf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error allocating data writer: %w", err)
 	}
+	if err := copyBody(rr, ew); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	st := &dataset.Structure{}
-	assign := &dataset.Structure{
-		Format: format.String(),
-		Schema: ds.Structure.Schema,
+// WriteBody streams some or all of a dataset's body to w, converting entries
+// to the desired format as they're read. Unlike ReadBodyBytes, WriteBody never
+// holds the full body in memory, which makes it suitable for large bodies.
+// Queries that sort results are the exception, buffering matched rows
+func WriteBody(w io.Writer, ds *dataset.Dataset, q *bodyquery.Query, format dataset.DataFormat, fcfg dataset.FormatConfig, limit, offset int, all bool) error {
+	rr, err := newBodyReader(ds, q, limit, offset, all)
+	if err != nil {
+		return err
 	}
-	if fcfg != nil {
-		assign.FormatConfig = fcfg.Map()
+
+	ew, err := dsio.NewEntryWriter(bodyOutputStructure(rr.Structure(), format, fcfg), w)
+	if err != nil {
+		return fmt.Errorf("error allocating data writer: %w", err)
 	}
-	st.Assign(ds.Structure, assign)
+	return copyBody(rr, ew)
+}
 
-	data, err = dsio.ConvertFile(file, ds.Structure, st, limit, offset, all)
+// WriteColumnarBody streams some or all of a dataset's body to w in a
+// columnar format. Column names & types come from the dataset's schema, which
+// must be tabular
+func WriteColumnarBody(w io.Writer, ds *dataset.Dataset, q *bodyquery.Query, format columnar.Format, limit, offset int, all bool) error {
+	rr, err := newBodyReader(ds, q, limit, offset, all)
 	if err != nil {
-		log.Debug(err.Error())
-		return nil, err
+		return err
 	}
 
-	return data, nil
+	ew, err := columnar.NewEntryWriter(format, rr.Structure(), w)
+	if err != nil {
+		return fmt.Errorf("error allocating data writer: %w", err)
+	}
+	return copyBody(rr, ew)
 }
 
-// GetBody takes returns the Body as a go-native structure,
-// using limit, offset, and all parameters to determine what part of the Body to return
-func GetBody(ds *dataset.Dataset, limit, offset int, all bool) (interface{}, error) {
+// newBodyReader opens an entry reader on a dataset's body file, applying a
+// query and paging through the results unless all is true
+func newBodyReader(ds *dataset.Dataset, q *bodyquery.Query, limit, offset int, all bool) (dsio.EntryReader, error) {
 	if ds == nil {
 		return nil, fmt.Errorf("can't load body from a nil dataset")
 	}
-
 	file := ds.BodyFile()
 	if file == nil {
 		return nil, fmt.Errorf("no body file to read")
@@ -59,7 +94,10 @@ func GetBody(ds *dataset.Dataset, limit, offset int, all bool) (interface{}, err
 
 	rr, err := dsio.NewEntryReader(ds.Structure, file)
 	if err != nil {
-		return nil, fmt.Errorf("error allocating data reader: %s", err)
+		return nil, fmt.Errorf("error allocating data reader: %w", err)
+	}
+	if rr, err = bodyquery.NewReader(rr, q); err != nil {
+		return nil, err
 	}
 	if !all {
 		rr = &dsio.PagedReader{
@@ -68,6 +106,48 @@ func GetBody(ds *dataset.Dataset, limit, offset int, all bool) (interface{}, err
 			Offset: offset,
 		}
 	}
+	return rr, nil
+}
+
+// bodyOutputStructure gives the structure for writing entries read with
+// structure in to a new format
+func bodyOutputStructure(in *dataset.Structure, format dataset.DataFormat, fcfg dataset.FormatConfig) *dataset.Structure {
+	st := &dataset.Structure{}
+	assign := &dataset.Structure{
+		Format: format.String(),
+		Schema: in.Schema,
+	}
+	if fcfg != nil {
+		assign.FormatConfig = fcfg.Map()
+	}
+	st.Assign(in, assign)
+	return st
+}
+
+// copyBody reads all entries into an entry writer, closing the writer when all
+// entries are written
+func copyBody(rr dsio.EntryReader, ew dsio.EntryWriter) error {
+	if err := dsio.Copy(rr, ew); err != nil {
+		log.Debug(err.Error())
+		return err
+	}
+	return ew.Close()
+}
+
+// GetBody takes returns the Body as a go-native structure,
+// using limit, offset, and all parameters to determine what part of the Body to return
+func GetBody(ds *dataset.Dataset, limit, offset int, all bool) (interface{}, error) {
+	return GetBodyWithQuery(ds, nil, limit, offset, all)
+}
+
+// GetBodyWithQuery selects rows of a dataset's body with a query, returning
+// results as a go-native structure. limit & offset page through query results.
+// A nil query reads the body unmodified
+func GetBodyWithQuery(ds *dataset.Dataset, q *bodyquery.Query, limit, offset int, all bool) (interface{}, error) {
+	rr, err := newBodyReader(ds, q, limit, offset, all)
+	if err != nil {
+		return nil, err
+	}
 	return ReadEntries(rr)
 }
 
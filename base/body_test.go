@@ -9,6 +9,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/affix-io/affix/base/bodyquery"
+	"github.com/affix-io/affix/base/columnar"
 	"github.com/affix-io/dataset"
 	"github.com/affix-io/dataset/dsio"
 	"github.com/affix-io/dataset/tabular"
@@ -70,6 +72,129 @@ func TestReadBodyBytes(t *testing.T) {
 	}
 }
 
+func TestBodyWithQuery(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	ref := addCitiesDataset(t, r)
+
+	ds, err := ReadDataset(ctx, r, ref.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := bodyquery.Parse("city,avg_age", "in_usa = true AND avg_age < 60", "-avg_age")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = OpenDataset(ctx, r.Filesystem(), ds); err != nil {
+		t.Fatal(err)
+	}
+	gotBody, err := GetBodyWithQuery(ds, q, 2, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBody := []interface{}{
+		[]interface{}{"raleigh", 50.65},
+		[]interface{}{"new york", 44.4},
+	}
+	if diff := cmp.Diff(expectBody, gotBody); diff != "" {
+		t.Errorf("GetBodyWithQuery output (-want +got):\n%s", diff)
+	}
+
+	cases := []struct {
+		format dataset.DataFormat
+		expect string
+	}{
+		{dataset.JSONDataFormat, `[["raleigh",50.65],["new york",44.4],["chicago",44.4]]`},
+		{dataset.CSVDataFormat, "city,avg_age\nraleigh,50.65\nnew york,44.4\nchicago,44.4\n"},
+	}
+	for i, c := range cases {
+		if err = OpenDataset(ctx, r.Filesystem(), ds); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ReadBodyBytesWithQuery(ds, q, c.format, nil, 0, 0, true)
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if diff := cmp.Diff(c.expect, string(data)); diff != "" {
+			t.Errorf("case %d result mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+
+	if err = OpenDataset(ctx, r.Filesystem(), ds); err != nil {
+		t.Fatal(err)
+	}
+	q = &bodyquery.Query{Columns: []string{"country"}}
+	if _, err := GetBodyWithQuery(ds, q, 0, 0, true); err == nil || err.Error() != `unknown column "country"` {
+		t.Errorf("expected unknown column error, got: %v", err)
+	}
+}
+
+func TestWriteBody(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	ref := addCitiesDataset(t, r)
+
+	ds, err := ReadDataset(ctx, r, ref.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		format dataset.DataFormat
+		limit  int
+		offset int
+		all    bool
+		expect string
+	}{
+		{dataset.JSONDataFormat, 1, 1, false, `[["new york",8500000,44.4,true]]`},
+		{dataset.CSVDataFormat, 2, 0, false, "city,pop,avg_age,in_usa\ntoronto,40000000,55.5,false\nnew york,8500000,44.4,true\n"},
+		{dataset.NDJSONDataFormat, 2, 3, false, "[\"chatham\",35000,65.25,true]\n[\"raleigh\",250000,50.65,true]\n"},
+	}
+
+	for i, c := range cases {
+		if err = OpenDataset(ctx, r.Filesystem(), ds); err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := WriteBody(buf, ds, nil, c.format, nil, c.limit, c.offset, c.all); err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if diff := cmp.Diff(c.expect, buf.String()); diff != "" {
+			t.Errorf("case %d result mismatch (-want +got):\n%s", i, diff)
+		}
+		ds.SetBodyFile(nil)
+	}
+}
+
+func TestWriteColumnarBody(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	ref := addCitiesDataset(t, r)
+
+	ds, err := ReadDataset(ctx, r, ref.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []columnar.Format{columnar.Parquet, columnar.Arrow} {
+		if err = OpenDataset(ctx, r.Filesystem(), ds); err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := WriteColumnarBody(buf, ds, nil, format, 2, 0, false); err != nil {
+			t.Errorf("%s: unexpected error: %s", format, err)
+		}
+		if buf.Len() == 0 {
+			t.Errorf("%s: expected output", format)
+		}
+		ds.SetBodyFile(nil)
+	}
+}
+
 func TestConvertBodyFormat(t *testing.T) {
 	jsonStructure := &dataset.Structure{Format: "json", Schema: dataset.BaseSchemaArray}
 	csvStructure := &dataset.Structure{Format: "csv", Schema: tabular.BaseTabularSchema}
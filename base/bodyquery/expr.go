@@ -0,0 +1,538 @@
+package bodyquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a boolean expression over the columns of a row, like:
+//
+//	age > 65 AND (dx = "flu" OR NOT in_usa)
+//
+// Operands are column names, string literals in single or double quotes,
+// numbers, true, false & null. Column names that aren't plain identifiers are
+// quoted with backticks. Comparison operators are =, ==, !=, <>, <, <=, > and
+// >=. Keywords are case-insensitive
+type Expr interface {
+	// String gives a canonical representation of the expression
+	String() string
+	// columns lists the column names the expression reads
+	columns() []string
+	// bind resolves column names to row positions, returning a function that
+	// evaluates the expression against a row
+	bind(index map[string]int) (func(row []interface{}) bool, error)
+}
+
+// ParseExpr parses a boolean expression
+func ParseExpr(s string) (Expr, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", t, t.pos)
+	}
+	return e, nil
+}
+
+// logical is a boolean AND or OR of two expressions
+type logical struct {
+	op          string
+	left, right Expr
+}
+
+func (e logical) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.left, e.op, e.right)
+}
+
+func (e logical) columns() []string {
+	return append(e.left.columns(), e.right.columns()...)
+}
+
+func (e logical) bind(index map[string]int) (func(row []interface{}) bool, error) {
+	left, err := e.left.bind(index)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.bind(index)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "AND" {
+		return func(row []interface{}) bool { return left(row) && right(row) }, nil
+	}
+	return func(row []interface{}) bool { return left(row) || right(row) }, nil
+}
+
+// not negates an expression
+type not struct {
+	x Expr
+}
+
+func (e not) String() string {
+	return fmt.Sprintf("NOT %s", e.x)
+}
+
+func (e not) columns() []string {
+	return e.x.columns()
+}
+
+func (e not) bind(index map[string]int) (func(row []interface{}) bool, error) {
+	x, err := e.x.bind(index)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []interface{}) bool { return !x(row) }, nil
+}
+
+// comparison compares two operands
+type comparison struct {
+	op          string
+	left, right operand
+}
+
+func (e comparison) String() string {
+	return fmt.Sprintf("%s %s %s", e.left, e.op, e.right)
+}
+
+func (e comparison) columns() []string {
+	var cols []string
+	for _, o := range []operand{e.left, e.right} {
+		if o.column != "" {
+			cols = append(cols, o.column)
+		}
+	}
+	return cols
+}
+
+func (e comparison) bind(index map[string]int) (func(row []interface{}) bool, error) {
+	left, err := e.left.bind(index)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.bind(index)
+	if err != nil {
+		return nil, err
+	}
+	test := comparisonTests[e.op]
+	return func(row []interface{}) bool {
+		c, ok := Compare(left(row), right(row))
+		return test(c, ok)
+	}, nil
+}
+
+// comparisonTests interpret the result of comparing two values for each
+// operator. values of different types are only ever unequal
+var comparisonTests = map[string]func(c int, comparable bool) bool{
+	"=":  func(c int, ok bool) bool { return ok && c == 0 },
+	"!=": func(c int, ok bool) bool { return !ok || c != 0 },
+	"<":  func(c int, ok bool) bool { return ok && c < 0 },
+	"<=": func(c int, ok bool) bool { return ok && c <= 0 },
+	">":  func(c int, ok bool) bool { return ok && c > 0 },
+	">=": func(c int, ok bool) bool { return ok && c >= 0 },
+}
+
+// operand is either a column reference or a literal value
+type operand struct {
+	column string
+	value  interface{}
+}
+
+func (o operand) String() string {
+	if o.column != "" {
+		return quoteColumn(o.column)
+	}
+	switch v := o.value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%v", o.value)
+}
+
+func (o operand) bind(index map[string]int) (func(row []interface{}) interface{}, error) {
+	if o.column == "" {
+		return func([]interface{}) interface{} { return o.value }, nil
+	}
+	i, ok := index[o.column]
+	if !ok {
+		return nil, fmt.Errorf("unknown column %q", o.column)
+	}
+	return func(row []interface{}) interface{} { return value(row, i) }, nil
+}
+
+// quoteColumn wraps a column name in backticks if it isn't a plain identifier
+// or collides with a keyword
+func quoteColumn(name string) string {
+	if isIdent(name) && !isKeyword(name) {
+		return name
+	}
+	return "`" + name + "`"
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+func isKeyword(s string) bool {
+	switch strings.ToUpper(s) {
+	case "AND", "OR", "NOT", "TRUE", "FALSE", "NULL":
+		return true
+	}
+	return false
+}
+
+// Compare orders two values of the same kind, reporting false if the values
+// can't be compared. nulls only compare equal to other nulls
+func Compare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, a == nil && b == nil
+	}
+	if x, ok := toFloat64(a); ok {
+		y, ok := toFloat64(b)
+		return compareFloats(x, y), ok
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		return compareBools(x, y), ok
+	}
+	return 0, false
+}
+
+// Order is a total ordering of values used for sorting. values of different
+// kinds sort nulls first, then booleans, numbers, strings & everything else
+func Order(a, b interface{}) int {
+	ra, rb := kindRank(a), kindRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	if c, ok := Compare(a, b); ok {
+		return c
+	}
+	// arrays & objects compare by their JSON encoding
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return strings.Compare(string(x), string(y))
+}
+
+func kindRank(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	if _, ok := v.(bool); ok {
+		return 1
+	}
+	if _, ok := toFloat64(v); ok {
+		return 2
+	}
+	if _, ok := v.(string); ok {
+		return 3
+	}
+	return 4
+}
+
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func compareBools(x, y bool) int {
+	switch {
+	case x == y:
+		return 0
+	case !x:
+		return -1
+	}
+	return 1
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int8:
+		return float64(x), true
+	case int16:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case uint16:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil && !math.IsNaN(f)
+	}
+	return 0, false
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// lex splits an expression into tokens
+func lex(s string) ([]token, error) {
+	var toks []token
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			toks = append(toks, token{tokRParen, ")", i})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			start := i
+			i++
+			if i < len(rs) && (rs[i] == '=' || (r == '<' && rs[i] == '>')) {
+				i++
+			}
+			op := string(rs[start:i])
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			case "!":
+				return nil, fmt.Errorf("unexpected \"!\" at position %d", start)
+			}
+			toks = append(toks, token{tokOp, op, start})
+		case r == '"' || r == '\'' || r == '`':
+			start := i
+			var sb strings.Builder
+			for i++; ; i++ {
+				if i >= len(rs) {
+					return nil, fmt.Errorf("unterminated quote at position %d", start)
+				}
+				if rs[i] == '\\' && r != '`' && i+1 < len(rs) {
+					i++
+					sb.WriteRune(rs[i])
+					continue
+				}
+				if rs[i] == r {
+					i++
+					break
+				}
+				sb.WriteRune(rs[i])
+			}
+			kind := tokString
+			if r == '`' {
+				kind = tokIdent
+			}
+			toks = append(toks, token{kind, sb.String(), start})
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			start := i
+			for i++; i < len(rs) && (unicode.IsDigit(rs[i]) || strings.ContainsRune(".eE", rs[i]) || ((rs[i] == '-' || rs[i] == '+') && (rs[i-1] == 'e' || rs[i-1] == 'E'))); i++ {
+			}
+			text := string(rs[start:i])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", text, start)
+			}
+			toks = append(toks, token{tokNumber, text, start})
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i++; i < len(rs) && (rs[i] == '_' || unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i])); i++ {
+			}
+			text := string(rs[start:i])
+			if isKeyword(text) {
+				toks = append(toks, token{tokKeyword, strings.ToUpper(text), start})
+			} else {
+				toks = append(toks, token{tokIdent, text, start})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", r, i)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(rs)}), nil
+}
+
+// parser is a recursive descent parser over expression tokens. From lowest to
+// highest precedence: OR, AND, NOT, comparisons & parenthesized expressions
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokKeyword && t.text == kw {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (Expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (Expr, error) {
+	if p.keyword("NOT") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return not{x: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	if p.peek().kind == tokLParen {
+		p.next()
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected \")\" at position %d, got %s", t.pos, t)
+		}
+		return e, nil
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	if op.kind != tokOp {
+		return nil, fmt.Errorf("expected comparison operator at position %d, got %s", op.pos, op)
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if left.column == "" && right.column == "" {
+		return nil, fmt.Errorf("comparison at position %d must reference a column", op.pos)
+	}
+	return comparison{op: op.text, left: left, right: right}, nil
+}
+
+func (p *parser) operand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIdent:
+		return operand{column: t.text}, nil
+	case tokString:
+		return operand{value: t.text}, nil
+	case tokNumber:
+		f, _ := strconv.ParseFloat(t.text, 64)
+		return operand{value: f}, nil
+	case tokKeyword:
+		switch t.text {
+		case "TRUE":
+			return operand{value: true}, nil
+		case "FALSE":
+			return operand{value: false}, nil
+		case "NULL":
+			return operand{}, nil
+		}
+	}
+	return operand{}, fmt.Errorf("expected column or value at position %d, got %s", t.pos, t)
+}
@@ -0,0 +1,121 @@
+package bodyquery
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	cases := []struct {
+		in     string
+		expect string
+	}{
+		{`age > 65`, `age > 65`},
+		{`age>=65.5`, `age >= 65.5`},
+		{`dx == "flu"`, `dx = "flu"`},
+		{`dx <> 'flu'`, `dx != "flu"`},
+		{`"flu" = dx`, `"flu" = dx`},
+		{`a = 1 AND b = 2 OR c = 3`, `((a = 1 AND b = 2) OR c = 3)`},
+		{`a = 1 and (b = 2 or c = 3)`, `(a = 1 AND (b = 2 OR c = 3))`},
+		{`NOT not in_usa = true`, `NOT NOT in_usa = true`},
+		{"`avg age` < -1e3", "`avg age` < -1000"},
+		{"`and` = null", "`and` = null"},
+		{`name = "say \"hi\""`, `name = "say \"hi\""`},
+	}
+	for i, c := range cases {
+		e, err := ParseExpr(c.in)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if got := e.String(); got != c.expect {
+			t.Errorf("case %d: expected %q, got %q", i, c.expect, got)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{``, `expected column or value at position 0, got end of expression`},
+		{`age`, `expected comparison operator at position 3, got end of expression`},
+		{`age > `, `expected column or value at position 6, got end of expression`},
+		{`1 = 2`, `comparison at position 2 must reference a column`},
+		{`(age > 1`, `expected ")" at position 8, got end of expression`},
+		{`age > 1 age`, `unexpected "age" at position 8`},
+		{`age ! 1`, `unexpected "!" at position 4`},
+		{`dx = "flu`, `unterminated quote at position 5`},
+		{`age > 1.2.3`, `invalid number "1.2.3" at position 6`},
+		{`age > 1 & b`, `unexpected '&' at position 8`},
+	}
+	for i, c := range cases {
+		_, err := ParseExpr(c.in)
+		if err == nil {
+			t.Errorf("case %d: expected error, got nil", i)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("case %d: error mismatch. expected: %q, got: %q", i, c.err, err.Error())
+		}
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	index := map[string]int{"city": 0, "pop": 1, "avg_age": 2, "in_usa": 3}
+	row := []interface{}{"toronto", int64(40000000), json.Number("55.5"), false}
+
+	cases := []struct {
+		expr   string
+		expect bool
+	}{
+		{`pop > 1000`, true},
+		{`pop = 40000000`, true},
+		{`avg_age <= 55.5`, true},
+		{`avg_age < 55.5`, false},
+		{`city = "toronto"`, true},
+		{`city > "new york"`, true},
+		{`in_usa = false`, true},
+		{`NOT in_usa = true`, true},
+		{`city = 5`, false},
+		{`city != 5`, true},
+		{`city < 5`, false},
+		{`pop = null`, false},
+		{`pop != null`, true},
+		{`city = "toronto" AND pop < 10`, false},
+		{`city = "toronto" OR pop < 10`, true},
+		{`1000 < pop`, true},
+	}
+	for i, c := range cases {
+		e, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		match, err := e.bind(index)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if got := match(row); got != c.expect {
+			t.Errorf("case %d: %q expected %t, got %t", i, c.expr, c.expect, got)
+		}
+	}
+
+	e, _ := ParseExpr(`missing = 1 OR city = "toronto"`)
+	if _, err := e.bind(index); err == nil || err.Error() != `unknown column "missing"` {
+		t.Errorf("expected unknown column error, got: %v", err)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	vals := []interface{}{nil, false, true, int64(-1), 0.5, json.Number("2"), "a", "b", []interface{}{1}}
+	for i := range vals {
+		for j := range vals {
+			got := Order(vals[i], vals[j])
+			expect := compareFloats(float64(i), float64(j))
+			if got != expect {
+				t.Errorf("Order(%#v, %#v): expected %d, got %d", vals[i], vals[j], expect, got)
+			}
+		}
+	}
+}
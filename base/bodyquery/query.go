@@ -0,0 +1,351 @@
+// Package bodyquery selects, filters & sorts the rows of tabular dataset
+// bodies. Queries wrap a dsio.EntryReader, so projection & filtering happen in
+// a single streaming pass over the body
+package bodyquery
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/dataset/dsio"
+	"github.com/affix-io/dataset/tabular"
+)
+
+// Query describes a subset of a tabular body
+type Query struct {
+	// Columns projects rows to the named columns, in order. empty selects all
+	// columns
+	Columns []string
+	// Where filters rows, nil matches all rows
+	Where Expr
+	// Sort orders rows by one or more columns
+	Sort []SortKey
+}
+
+// SortKey orders rows by a column
+type SortKey struct {
+	Column     string
+	Descending bool
+}
+
+// String formats a sort key, prefixing descending keys with "-"
+func (k SortKey) String() string {
+	if k.Descending {
+		return "-" + k.Column
+	}
+	return k.Column
+}
+
+// Parse creates a query from its string forms: a comma-separated list of
+// columns, a where expression & a comma-separated list of sort keys. Parse
+// returns a nil query if all strings are empty
+func Parse(columns, where, sort string) (*Query, error) {
+	if columns == "" && where == "" && sort == "" {
+		return nil, nil
+	}
+	q := &Query{}
+	var err error
+	if q.Columns, err = ParseColumns(columns); err != nil {
+		return nil, err
+	}
+	if where != "" {
+		if q.Where, err = ParseExpr(where); err != nil {
+			return nil, fmt.Errorf("invalid where expression: %w", err)
+		}
+	}
+	if q.Sort, err = ParseSort(sort); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// ParseColumns parses a comma-separated list of column names
+func ParseColumns(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var cols []string
+	seen := map[string]bool{}
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			return nil, fmt.Errorf("invalid columns %q: empty column name", s)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("invalid columns %q: column %q is listed more than once", s, c)
+		}
+		seen[c] = true
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// ParseSort parses a comma-separated list of sort keys. Keys sort ascending
+// unless prefixed with "-", eg: "-age,id"
+func ParseSort(s string) ([]SortKey, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var keys []SortKey
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		key := SortKey{Column: k}
+		if strings.HasPrefix(k, "-") {
+			key = SortKey{Column: strings.TrimSpace(k[1:]), Descending: true}
+		} else if strings.HasPrefix(k, "+") {
+			key.Column = strings.TrimSpace(k[1:])
+		}
+		if key.Column == "" {
+			return nil, fmt.Errorf("invalid sort %q: empty column name", s)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// String encodes a query as URL query parameters. Equivalent queries encode
+// to the same string
+func (q *Query) String() string {
+	if q == nil {
+		return ""
+	}
+	v := url.Values{}
+	if len(q.Columns) > 0 {
+		v.Set("columns", strings.Join(q.Columns, ","))
+	}
+	if q.Where != nil {
+		v.Set("where", q.Where.String())
+	}
+	if len(q.Sort) > 0 {
+		keys := make([]string, len(q.Sort))
+		for i, k := range q.Sort {
+			keys[i] = k.String()
+		}
+		v.Set("sort", strings.Join(keys, ","))
+	}
+	return v.Encode()
+}
+
+// Structure gives the structure of query results read from a body with
+// structure st. Projected queries narrow the schema to the selected columns.
+// Entry count, length & checksum describe the source body & are cleared
+func (q *Query) Structure(st *dataset.Structure) (*dataset.Structure, error) {
+	if st == nil || st.Schema == nil {
+		return nil, fmt.Errorf("querying a body requires a schema")
+	}
+	cols, _, err := tabular.ColumnsFromJSONSchema(st.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("querying a body requires a tabular schema: %w", err)
+	}
+	index := columnIndex(cols)
+	if err := q.validate(index); err != nil {
+		return nil, err
+	}
+
+	out := &dataset.Structure{}
+	out.Assign(st)
+	out.Entries = 0
+	out.Length = 0
+	out.Checksum = ""
+	if len(q.Columns) == 0 {
+		return out, nil
+	}
+
+	items, _ := st.Schema["items"].(map[string]interface{})
+	itemSchemas, _ := items["items"].([]interface{})
+	projected := make([]interface{}, len(q.Columns))
+	for i, c := range q.Columns {
+		projected[i] = itemSchemas[index[c]]
+	}
+	schItems := map[string]interface{}{}
+	for k, v := range items {
+		schItems[k] = v
+	}
+	schItems["items"] = projected
+	sch := map[string]interface{}{}
+	for k, v := range st.Schema {
+		sch[k] = v
+	}
+	sch["items"] = schItems
+	out.Schema = sch
+	return out, nil
+}
+
+// validate checks all columns the query references exist
+func (q *Query) validate(index map[string]int) error {
+	refs := append([]string{}, q.Columns...)
+	if q.Where != nil {
+		refs = append(refs, q.Where.columns()...)
+	}
+	for _, k := range q.Sort {
+		refs = append(refs, k.Column)
+	}
+	for _, c := range refs {
+		if _, ok := index[c]; !ok {
+			return fmt.Errorf("unknown column %q", c)
+		}
+	}
+	return nil
+}
+
+// columnIndex maps column titles to their position in a row. when titles are
+// repeated the first column wins
+func columnIndex(cols tabular.Columns) map[string]int {
+	index := make(map[string]int, len(cols))
+	for i := len(cols) - 1; i >= 0; i-- {
+		index[cols[i].Title] = i
+	}
+	return index
+}
+
+// reader applies a query to entries read from a source reader
+type reader struct {
+	src   dsio.EntryReader
+	st    *dataset.Structure
+	match func(row []interface{}) bool
+	proj  []int
+	sort  []sortIndex
+	// sorted holds matched rows once read & sorted
+	sorted [][]interface{}
+	filled bool
+	n      int
+}
+
+type sortIndex struct {
+	col  int
+	desc bool
+}
+
+var _ dsio.EntryReader = (*reader)(nil)
+
+// NewReader wraps an entry reader, returning only rows that match the query.
+// The source structure must have a tabular schema. Filtering & projection are
+// applied as entries are read. Sorting requires all matching rows, which are
+// buffered in memory on the first read. A nil query returns rr unchanged
+func NewReader(rr dsio.EntryReader, q *Query) (dsio.EntryReader, error) {
+	if q == nil {
+		return rr, nil
+	}
+	st, err := q.Structure(rr.Structure())
+	if err != nil {
+		return nil, err
+	}
+	cols, _, _ := tabular.ColumnsFromJSONSchema(rr.Structure().Schema)
+	index := columnIndex(cols)
+
+	r := &reader{src: rr, st: st}
+	if q.Where != nil {
+		if r.match, err = q.Where.bind(index); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range q.Columns {
+		r.proj = append(r.proj, index[c])
+	}
+	for _, k := range q.Sort {
+		r.sort = append(r.sort, sortIndex{col: index[k.Column], desc: k.Descending})
+	}
+	return r, nil
+}
+
+// Structure gives the structure of query results
+func (r *reader) Structure() *dataset.Structure {
+	return r.st
+}
+
+// ReadEntry returns the next row that matches the query
+func (r *reader) ReadEntry() (dsio.Entry, error) {
+	if len(r.sort) > 0 {
+		if !r.filled {
+			if err := r.fill(); err != nil {
+				return dsio.Entry{}, err
+			}
+		}
+		if r.n >= len(r.sorted) {
+			return dsio.Entry{}, io.EOF
+		}
+		return r.entry(r.sorted[r.n]), nil
+	}
+
+	row, err := r.next()
+	if err != nil {
+		return dsio.Entry{}, err
+	}
+	return r.entry(row), nil
+}
+
+// Close closes the source reader
+func (r *reader) Close() error {
+	return r.src.Close()
+}
+
+// next reads the next matching row from the source
+func (r *reader) next() ([]interface{}, error) {
+	for {
+		e, err := r.src.ReadEntry()
+		if err != nil {
+			return nil, err
+		}
+		row, ok := e.Value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("entry %d: expected an array of values, got %T", e.Index, e.Value)
+		}
+		if r.match == nil || r.match(row) {
+			return row, nil
+		}
+	}
+}
+
+// fill reads all matching rows from the source & sorts them
+func (r *reader) fill() error {
+	r.filled = true
+	for {
+		row, err := r.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		r.sorted = append(r.sorted, row)
+	}
+	sort.SliceStable(r.sorted, func(i, j int) bool {
+		a, b := r.sorted[i], r.sorted[j]
+		for _, k := range r.sort {
+			c := Order(value(a, k.col), value(b, k.col))
+			if c == 0 {
+				continue
+			}
+			if k.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// entry projects a row, numbering entries in the order they're returned
+func (r *reader) entry(row []interface{}) dsio.Entry {
+	e := dsio.Entry{Index: r.n, Value: row}
+	r.n++
+	if r.proj != nil {
+		vals := make([]interface{}, len(r.proj))
+		for i, col := range r.proj {
+			vals[i] = value(row, col)
+		}
+		e.Value = vals
+	}
+	return e
+}
+
+func value(row []interface{}, i int) interface{} {
+	if i < len(row) {
+		return row[i]
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package bodyquery
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/dataset/dsio"
+	"github.com/google/go-cmp/cmp"
+)
+
+var citiesStructure = &dataset.Structure{
+	Format:       "csv",
+	FormatConfig: map[string]interface{}{"headerRow": true},
+	Entries:      5,
+	Length:       155,
+	Schema: map[string]interface{}{
+		"type": "array",
+		"items": map[string]interface{}{
+			"type": "array",
+			"items": []interface{}{
+				map[string]interface{}{"title": "city", "type": "string"},
+				map[string]interface{}{"title": "pop", "type": "integer"},
+				map[string]interface{}{"title": "avg_age", "type": "number"},
+				map[string]interface{}{"title": "in_usa", "type": "boolean"},
+			},
+		},
+	},
+}
+
+const citiesCSV = `city,pop,avg_age,in_usa
+toronto,40000000,55.5,false
+new york,8500000,44.4,true
+chicago,300000,44.4,true
+chatham,35000,65.25,true
+raleigh,250000,50.65,true
+`
+
+func citiesReader(t *testing.T) dsio.EntryReader {
+	t.Helper()
+	rr, err := dsio.NewEntryReader(citiesStructure, bytes.NewBufferString(citiesCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestParse(t *testing.T) {
+	q, err := Parse("", "", "")
+	if err != nil || q != nil {
+		t.Errorf("expected empty strings to give a nil query, got: %v, %v", q, err)
+	}
+
+	q, err = Parse("city, pop", "pop > 1000", "-pop,+city")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "columns=city%2Cpop&sort=-pop%2Ccity&where=pop+%3E+1000"
+	if got := q.String(); got != expect {
+		t.Errorf("string mismatch. expected: %q, got: %q", expect, got)
+	}
+
+	bad := []struct {
+		columns, where, sort string
+		err                  string
+	}{
+		{"city,", "", "", `invalid columns "city,": empty column name`},
+		{"city,city", "", "", `invalid columns "city,city": column "city" is listed more than once`},
+		{"", "pop >", "", `invalid where expression: expected column or value at position 5, got end of expression`},
+		{"", "", "pop,-", `invalid sort "pop,-": empty column name`},
+	}
+	for i, c := range bad {
+		_, err := Parse(c.columns, c.where, c.sort)
+		if err == nil {
+			t.Errorf("case %d: expected error, got nil", i)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("case %d: error mismatch. expected: %q, got: %q", i, c.err, err.Error())
+		}
+	}
+}
+
+func TestQueryStructure(t *testing.T) {
+	q := &Query{Columns: []string{"pop", "city"}}
+	st, err := q.Structure(citiesStructure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &dataset.Structure{
+		Format:       "csv",
+		FormatConfig: map[string]interface{}{"headerRow": true},
+		Schema: map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "array",
+				"items": []interface{}{
+					map[string]interface{}{"title": "pop", "type": "integer"},
+					map[string]interface{}{"title": "city", "type": "string"},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(expect, st); diff != "" {
+		t.Errorf("structure mismatch (-want +got):\n%s", diff)
+	}
+	if citiesStructure.Entries != 5 || len(citiesStructure.Schema["items"].(map[string]interface{})["items"].([]interface{})) != 4 {
+		t.Errorf("expected source structure to be unmodified")
+	}
+
+	bad := []struct {
+		q   *Query
+		st  *dataset.Structure
+		err string
+	}{
+		{&Query{Columns: []string{"nope"}}, citiesStructure, `unknown column "nope"`},
+		{&Query{Sort: []SortKey{{Column: "nope"}}}, citiesStructure, `unknown column "nope"`},
+		{&Query{Columns: []string{"city"}}, &dataset.Structure{Format: "json"}, `querying a body requires a schema`},
+		{&Query{Columns: []string{"city"}}, &dataset.Structure{Format: "json", Schema: dataset.BaseSchemaObject}, `querying a body requires a tabular schema: unfinished`},
+	}
+	for i, c := range bad {
+		_, err := c.q.Structure(c.st)
+		if err == nil {
+			t.Errorf("case %d: expected error, got nil", i)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("case %d: error mismatch. expected: %q, got: %q", i, c.err, err.Error())
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	cases := []struct {
+		columns, where, sort string
+		expect               []interface{}
+	}{
+		{"", "", "", []interface{}{
+			[]interface{}{"toronto", int64(40000000), 55.5, false},
+			[]interface{}{"new york", int64(8500000), 44.4, true},
+			[]interface{}{"chicago", int64(300000), 44.4, true},
+			[]interface{}{"chatham", int64(35000), 65.25, true},
+			[]interface{}{"raleigh", int64(250000), 50.65, true},
+		}},
+		{"city", "in_usa = true AND pop > 100000", "", []interface{}{
+			[]interface{}{"new york"},
+			[]interface{}{"chicago"},
+			[]interface{}{"raleigh"},
+		}},
+		{"avg_age,city", "", "-avg_age,city", []interface{}{
+			[]interface{}{65.25, "chatham"},
+			[]interface{}{55.5, "toronto"},
+			[]interface{}{50.65, "raleigh"},
+			[]interface{}{44.4, "chicago"},
+			[]interface{}{44.4, "new york"},
+		}},
+		{"city", "NOT in_usa = true OR avg_age > 60", "pop", []interface{}{
+			[]interface{}{"chatham"},
+			[]interface{}{"toronto"},
+		}},
+		{"pop", `city = "nowhere"`, "", []interface{}{}},
+	}
+	for i, c := range cases {
+		q, err := Parse(c.columns, c.where, c.sort)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		rr, err := NewReader(citiesReader(t), q)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		got, err := dsio.ReadAllArray(rr)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if diff := cmp.Diff(c.expect, got); diff != "" {
+			t.Errorf("case %d: result mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+func TestReaderPaging(t *testing.T) {
+	q := &Query{Sort: []SortKey{{Column: "pop"}}, Columns: []string{"city"}}
+	rr, err := NewReader(citiesReader(t), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = &dsio.PagedReader{Reader: rr, Limit: 2, Offset: 1}
+
+	got, err := dsio.ReadAllArray(rr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []interface{}{
+		[]interface{}{"raleigh"},
+		[]interface{}{"chicago"},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,260 @@
+package columnar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/dataset/dsio"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// arrow IPC flatbuffer constants, from the Schema.fbs & Message.fbs
+// definitions in the arrow format specification
+const (
+	arrowMetadataV5 = 4
+
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+
+	arrowPrecisionDouble = 2
+
+	// arrowContinuation prefixes every encapsulated IPC message
+	arrowContinuation = 0xFFFFFFFF
+)
+
+// arrowWriter writes entries as an arrow IPC stream: a schema message, a
+// record batch message for every batchSize entries & an end-of-stream marker
+type arrowWriter struct {
+	st          *dataset.Structure
+	cols        []Column
+	bufs        []*columnBuffer
+	w           io.Writer
+	wroteSchema bool
+}
+
+var _ dsio.EntryWriter = (*arrowWriter)(nil)
+
+func newArrowWriter(st *dataset.Structure, cols []Column, w io.Writer) *arrowWriter {
+	return &arrowWriter{
+		st:   st,
+		cols: cols,
+		bufs: newColumnBuffers(cols),
+		w:    w,
+	}
+}
+
+// Structure gives the structure being written
+func (aw *arrowWriter) Structure() *dataset.Structure {
+	return aw.st
+}
+
+// WriteEntry buffers an entry, writing a record batch when the buffer is full
+func (aw *arrowWriter) WriteEntry(e dsio.Entry) error {
+	if err := appendRow(aw.bufs, e); err != nil {
+		return err
+	}
+	if len(aw.bufs) > 0 && aw.bufs[0].len() >= batchSize {
+		return aw.flush()
+	}
+	return nil
+}
+
+// Close writes any buffered entries & ends the stream. It does not close the
+// underlying writer
+func (aw *arrowWriter) Close() error {
+	if err := aw.flush(); err != nil {
+		return err
+	}
+	eos := make([]byte, 8)
+	binary.LittleEndian.PutUint32(eos, arrowContinuation)
+	_, err := aw.w.Write(eos)
+	return err
+}
+
+func (aw *arrowWriter) flush() error {
+	if !aw.wroteSchema {
+		if err := writeArrowMessage(aw.w, arrowHeaderSchema, aw.buildSchema, nil); err != nil {
+			return fmt.Errorf("writing arrow schema: %w", err)
+		}
+		aw.wroteSchema = true
+	}
+	if len(aw.bufs) == 0 || aw.bufs[0].len() == 0 {
+		return nil
+	}
+
+	length := aw.bufs[0].len()
+	var (
+		body    []byte
+		nodes   [][2]int64
+		buffers [][2]int64
+	)
+	addBuffer := func(data []byte) {
+		buffers = append(buffers, [2]int64{int64(len(body)), int64(len(data))})
+		body = append(body, data...)
+		body = append(body, make([]byte, padding(len(body), 8))...)
+	}
+	for _, b := range aw.bufs {
+		nodes = append(nodes, [2]int64{int64(length), int64(b.nulls)})
+		if b.nulls > 0 {
+			addBuffer(bitmap(b.valid))
+		} else {
+			// validity bitmaps may be omitted when a column has no nulls
+			addBuffer(nil)
+		}
+
+		switch b.col.Type {
+		case Int64:
+			data := make([]byte, 8*length)
+			for i, x := range b.ints {
+				binary.LittleEndian.PutUint64(data[i*8:], uint64(x))
+			}
+			addBuffer(data)
+		case Float64:
+			data := make([]byte, 8*length)
+			for i, x := range b.floats {
+				binary.LittleEndian.PutUint64(data[i*8:], math.Float64bits(x))
+			}
+			addBuffer(data)
+		case Bool:
+			addBuffer(bitmap(b.bools))
+		default:
+			offsets := make([]byte, 4*(length+1))
+			size := 0
+			for i, s := range b.strs {
+				size += len(s)
+				if size > math.MaxInt32 {
+					return fmt.Errorf("column %q: record batch string data exceeds 2GB", b.col.Name)
+				}
+				binary.LittleEndian.PutUint32(offsets[(i+1)*4:], uint32(size))
+			}
+			addBuffer(offsets)
+			data := make([]byte, 0, size)
+			for _, s := range b.strs {
+				data = append(data, s...)
+			}
+			addBuffer(data)
+		}
+		b.reset()
+	}
+
+	buildBatch := func(fb *flatbuffers.Builder) flatbuffers.UOffsetT {
+		// struct vectors are written back to front
+		fb.StartVector(16, len(nodes), 8)
+		for i := len(nodes) - 1; i >= 0; i-- {
+			fb.Prep(8, 16)
+			fb.PrependInt64(nodes[i][1])
+			fb.PrependInt64(nodes[i][0])
+		}
+		nodesVec := fb.EndVector(len(nodes))
+		fb.StartVector(16, len(buffers), 8)
+		for i := len(buffers) - 1; i >= 0; i-- {
+			fb.Prep(8, 16)
+			fb.PrependInt64(buffers[i][1])
+			fb.PrependInt64(buffers[i][0])
+		}
+		buffersVec := fb.EndVector(len(buffers))
+
+		fb.StartObject(4)
+		fb.PrependUOffsetTSlot(2, buffersVec, 0)
+		fb.PrependUOffsetTSlot(1, nodesVec, 0)
+		fb.PrependInt64Slot(0, int64(length), 0)
+		return fb.EndObject()
+	}
+	if err := writeArrowMessage(aw.w, arrowHeaderRecordBatch, buildBatch, body); err != nil {
+		return fmt.Errorf("writing arrow record batch: %w", err)
+	}
+	return nil
+}
+
+// buildSchema encodes column names & types as an arrow Schema table
+func (aw *arrowWriter) buildSchema(fb *flatbuffers.Builder) flatbuffers.UOffsetT {
+	// flatbuffers can't nest object construction, so create all strings &
+	// child objects of each field before the fields themselves
+	fields := make([]flatbuffers.UOffsetT, len(aw.cols))
+	for i, col := range aw.cols {
+		name := fb.CreateString(col.Name)
+
+		var typeType byte
+		switch col.Type {
+		case Int64:
+			typeType = arrowTypeInt
+			fb.StartObject(2)
+			fb.PrependBoolSlot(1, true, false)
+			fb.PrependInt32Slot(0, 64, 0)
+		case Float64:
+			typeType = arrowTypeFloatingPoint
+			fb.StartObject(1)
+			fb.PrependInt16Slot(0, arrowPrecisionDouble, 0)
+		case Bool:
+			typeType = arrowTypeBool
+			fb.StartObject(0)
+		default:
+			typeType = arrowTypeUtf8
+			fb.StartObject(0)
+		}
+		typ := fb.EndObject()
+
+		// readers require a children vector, even for primitive types
+		fb.StartVector(4, 0, 4)
+		children := fb.EndVector(0)
+
+		fb.StartObject(7)
+		fb.PrependUOffsetTSlot(5, children, 0)
+		fb.PrependUOffsetTSlot(3, typ, 0)
+		fb.PrependByteSlot(2, typeType, 0)
+		fb.PrependBoolSlot(1, true, false)
+		fb.PrependUOffsetTSlot(0, name, 0)
+		fields[i] = fb.EndObject()
+	}
+
+	fb.StartVector(4, len(fields), 4)
+	for i := len(fields) - 1; i >= 0; i-- {
+		fb.PrependUOffsetT(fields[i])
+	}
+	fieldsVec := fb.EndVector(len(fields))
+
+	// endianness defaults to little endian
+	fb.StartObject(4)
+	fb.PrependUOffsetTSlot(1, fieldsVec, 0)
+	return fb.EndObject()
+}
+
+// writeArrowMessage writes an encapsulated IPC message: a continuation
+// marker, the length of the flatbuffer Message metadata, the metadata padded
+// to an 8-byte boundary, and the message body
+func writeArrowMessage(w io.Writer, headerType byte, buildHeader func(*flatbuffers.Builder) flatbuffers.UOffsetT, body []byte) error {
+	fb := flatbuffers.NewBuilder(1024)
+	header := buildHeader(fb)
+	fb.StartObject(5)
+	fb.PrependInt64Slot(3, int64(len(body)), 0)
+	fb.PrependUOffsetTSlot(2, header, 0)
+	fb.PrependByteSlot(1, headerType, 0)
+	fb.PrependInt16Slot(0, arrowMetadataV5, 0)
+	fb.Finish(fb.EndObject())
+	meta := fb.FinishedBytes()
+
+	metaLen := len(meta) + padding(8+len(meta), 8)
+	prefix := make([]byte, 8)
+	binary.LittleEndian.PutUint32(prefix, arrowContinuation)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(metaLen))
+	for _, p := range [][]byte{prefix, meta, make([]byte, metaLen-len(meta)), body} {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// padding returns the number of bytes needed to align n to a multiple of
+// alignment
+func padding(n, alignment int) int {
+	return (alignment - n%alignment) % alignment
+}
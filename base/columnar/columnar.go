@@ -0,0 +1,282 @@
+// Package columnar writes tabular dataset bodies in column-oriented formats
+// used by analysis tools: Apache Parquet files & Apache Arrow IPC streams.
+// Writers implement dsio.EntryWriter, buffering entries into batches of
+// columns typed by the dataset's schema
+package columnar
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/dataset/dsio"
+	"github.com/affix-io/dataset/tabular"
+)
+
+// Format names a columnar output format
+type Format string
+
+const (
+	// Parquet is the Apache Parquet file format
+	Parquet Format = "parquet"
+	// Arrow is the Apache Arrow IPC streaming format
+	Arrow Format = "arrow"
+)
+
+// String implements the fmt.Stringer interface
+func (f Format) String() string {
+	return string(f)
+}
+
+// ParseFormat converts a format name to a Format
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case Parquet:
+		return Parquet, nil
+	case Arrow:
+		return Arrow, nil
+	}
+	return "", fmt.Errorf("unknown columnar format %q", s)
+}
+
+// batchSize is the number of rows buffered before writing a parquet row group
+// or arrow record batch
+const batchSize = 64 * 1024
+
+// NewEntryWriter allocates a writer for a columnar format. The structure must
+// have a tabular schema, which sets the name & type of each column
+func NewEntryWriter(f Format, st *dataset.Structure, w io.Writer) (dsio.EntryWriter, error) {
+	cols, err := Columns(st)
+	if err != nil {
+		return nil, err
+	}
+	switch f {
+	case Parquet:
+		return newParquetWriter(st, cols, w), nil
+	case Arrow:
+		return newArrowWriter(st, cols, w), nil
+	}
+	return nil, fmt.Errorf("unknown columnar format %q", f)
+}
+
+// Type is the data type of a column
+type Type int
+
+const (
+	// String columns hold UTF-8 text. columns with mixed or nested types are
+	// written as strings, encoding non-string values as JSON
+	String Type = iota
+	// Int64 columns hold signed 64 bit integers
+	Int64
+	// Float64 columns hold double-precision floating point numbers
+	Float64
+	// Bool columns hold boolean values
+	Bool
+)
+
+// Column is the name & type of a column. All columns are nullable
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Columns derives columns from a structure's schema. Only tabular schemas,
+// where each entry is an array of values, can be written in columnar formats
+func Columns(st *dataset.Structure) ([]Column, error) {
+	if st == nil || st.Schema == nil {
+		return nil, fmt.Errorf("columnar formats require a schema")
+	}
+	tcols, _, err := tabular.ColumnsFromJSONSchema(st.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("columnar formats require a tabular schema: %w", err)
+	}
+	cols := make([]Column, len(tcols))
+	for i, tc := range tcols {
+		cols[i] = Column{Name: tc.Title, Type: String}
+		if tc.Type != nil {
+			cols[i].Type = columnType(*tc.Type)
+		}
+	}
+	return cols, nil
+}
+
+// columnType maps JSON schema types to a column type. Types that allow null
+// values map to the non-null type
+func columnType(ct tabular.ColType) Type {
+	var types []string
+	for _, t := range ct {
+		if t != "null" {
+			types = append(types, t)
+		}
+	}
+	if len(types) != 1 {
+		return String
+	}
+	switch types[0] {
+	case "integer":
+		return Int64
+	case "number":
+		return Float64
+	case "boolean":
+		return Bool
+	}
+	return String
+}
+
+// columnBuffer accumulates values of a single column. Values that can't be
+// represented by the column type are stored as nulls
+type columnBuffer struct {
+	col    Column
+	valid  []bool
+	nulls  int
+	ints   []int64
+	floats []float64
+	bools  []bool
+	strs   []string
+}
+
+func newColumnBuffers(cols []Column) []*columnBuffer {
+	bufs := make([]*columnBuffer, len(cols))
+	for i, col := range cols {
+		bufs[i] = &columnBuffer{col: col}
+	}
+	return bufs
+}
+
+// appendRow adds the values of an entry to a set of column buffers
+func appendRow(bufs []*columnBuffer, e dsio.Entry) error {
+	row, ok := e.Value.([]interface{})
+	if !ok {
+		return fmt.Errorf("entry %d: expected an array of values, got %T", e.Index, e.Value)
+	}
+	for i, b := range bufs {
+		var v interface{}
+		if i < len(row) {
+			v = row[i]
+		}
+		b.append(v)
+	}
+	return nil
+}
+
+// len returns the number of values in the buffer
+func (b *columnBuffer) len() int {
+	return len(b.valid)
+}
+
+func (b *columnBuffer) append(v interface{}) {
+	var ok bool
+	switch b.col.Type {
+	case Int64:
+		var x int64
+		x, ok = toInt64(v)
+		b.ints = append(b.ints, x)
+	case Float64:
+		var x float64
+		x, ok = toFloat64(v)
+		b.floats = append(b.floats, x)
+	case Bool:
+		var x bool
+		x, ok = v.(bool)
+		b.bools = append(b.bools, x)
+	default:
+		var x string
+		x, ok = toString(v)
+		b.strs = append(b.strs, x)
+	}
+	b.valid = append(b.valid, ok)
+	if !ok {
+		b.nulls++
+	}
+}
+
+// reset empties the buffer, keeping allocated memory
+func (b *columnBuffer) reset() {
+	b.valid = b.valid[:0]
+	b.nulls = 0
+	b.ints = b.ints[:0]
+	b.floats = b.floats[:0]
+	b.bools = b.bools[:0]
+	b.strs = b.strs[:0]
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint8:
+		return int64(x), true
+	case uint16:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case uint64:
+		if x <= math.MaxInt64 {
+			return int64(x), true
+		}
+	case float64:
+		if x == math.Trunc(x) && x >= math.MinInt64 && x <= math.MaxInt64 {
+			return int64(x), true
+		}
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case json.Number:
+		if f, err := x.Float64(); err == nil {
+			return f, true
+		}
+	}
+	if i, ok := toInt64(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+func toString(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return x, true
+	case []byte:
+		return string(x), true
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// bitmap packs booleans into bits, least-significant bit first, as used by
+// both arrow validity bitmaps & parquet bit-packed encodings
+func bitmap(bits []bool) []byte {
+	buf := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			buf[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return buf
+}
@@ -0,0 +1,217 @@
+package columnar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/dataset/dsio"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/google/go-cmp/cmp"
+)
+
+var citiesStructure = &dataset.Structure{
+	Format: "csv",
+	Schema: map[string]interface{}{
+		"type": "array",
+		"items": map[string]interface{}{
+			"type": "array",
+			"items": []interface{}{
+				map[string]interface{}{"title": "city", "type": "string"},
+				map[string]interface{}{"title": "pop", "type": []interface{}{"integer", "null"}},
+				map[string]interface{}{"title": "avg_age", "type": "number"},
+				map[string]interface{}{"title": "in_usa", "type": "boolean"},
+				map[string]interface{}{"title": "tags", "type": "array"},
+			},
+		},
+	},
+}
+
+func cityEntries(n int) []dsio.Entry {
+	entries := make([]dsio.Entry, n)
+	for i := range entries {
+		var pop interface{} = int64(i * 1000)
+		if i%3 == 0 {
+			pop = nil
+		}
+		entries[i] = dsio.Entry{Index: i, Value: []interface{}{"toronto", pop, 40.5, i%2 == 0, []interface{}{"a", i}}}
+	}
+	return entries
+}
+
+func writeEntries(t *testing.T, f Format, entries []dsio.Entry) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := NewEntryWriter(f, citiesStructure, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := w.WriteEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestColumns(t *testing.T) {
+	got, err := Columns(citiesStructure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Column{
+		{Name: "city", Type: String},
+		{Name: "pop", Type: Int64},
+		{Name: "avg_age", Type: Float64},
+		{Name: "in_usa", Type: Bool},
+		{Name: "tags", Type: String},
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("columns mismatch (-want +got):\n%s", diff)
+	}
+
+	bad := []*dataset.Structure{
+		nil,
+		{Format: "json"},
+		{Format: "json", Schema: map[string]interface{}{"type": "object"}},
+	}
+	for i, st := range bad {
+		if _, err := Columns(st); err == nil {
+			t.Errorf("case %d: expected error for non-tabular structure", i)
+		}
+	}
+}
+
+func TestColumnBufferAppend(t *testing.T) {
+	cases := []struct {
+		typ   Type
+		value interface{}
+		valid bool
+	}{
+		{Int64, int64(5), true},
+		{Int64, float64(5), true},
+		{Int64, 5.5, false},
+		{Int64, "5", false},
+		{Float64, int64(5), true},
+		{Float64, 5.5, true},
+		{Bool, true, true},
+		{Bool, "true", false},
+		{String, "hello", true},
+		{String, map[string]interface{}{"a": 1}, true},
+		{String, nil, false},
+	}
+	for i, c := range cases {
+		b := &columnBuffer{col: Column{Type: c.typ}}
+		b.append(c.value)
+		if b.valid[0] != c.valid {
+			t.Errorf("case %d: appending %#v to a %d column. expected valid: %t", i, c.value, c.typ, c.valid)
+		}
+	}
+}
+
+func TestArrowWriter(t *testing.T) {
+	data := writeEntries(t, Arrow, cityEntries(batchSize+1))
+
+	// read the header type of each encapsulated message
+	var headerTypes []byte
+	for len(data) > 0 {
+		if len(data) < 8 || binary.LittleEndian.Uint32(data) != arrowContinuation {
+			t.Fatalf("expected continuation marker at start of message")
+		}
+		metaLen := int(binary.LittleEndian.Uint32(data[4:]))
+		if metaLen == 0 {
+			data = data[8:]
+			break
+		}
+		if (8+metaLen)%8 != 0 {
+			t.Errorf("message metadata isn't padded to 8 bytes")
+		}
+		meta := data[8 : 8+metaLen]
+		msg := flatbuffers.Table{Bytes: meta, Pos: flatbuffers.GetUOffsetT(meta)}
+		// fields set to their default value are omitted from the table
+		headerTypes = append(headerTypes, msg.GetByteSlot(6, 0))
+		bodyLen := int(msg.GetInt64Slot(10, 0))
+		data = data[8+metaLen+bodyLen:]
+	}
+
+	expect := []byte{arrowHeaderSchema, arrowHeaderRecordBatch, arrowHeaderRecordBatch}
+	if diff := cmp.Diff(expect, headerTypes); diff != "" {
+		t.Errorf("message header types mismatch (-want +got):\n%s", diff)
+	}
+	if len(data) != 0 {
+		t.Errorf("expected end-of-stream marker to end the stream, got %d trailing bytes", len(data))
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	for _, n := range []int{0, 10} {
+		data := writeEntries(t, Parquet, cityEntries(n))
+		if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+			t.Fatalf("%d entries: expected file to begin and end with %q", n, parquetMagic)
+		}
+		metaLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+		if metaLen <= 0 || metaLen > len(data)-12 {
+			t.Fatalf("%d entries: invalid metadata length %d", n, metaLen)
+		}
+		meta := data[len(data)-8-metaLen : len(data)-8]
+		for _, col := range []string{"city", "pop", "avg_age", "in_usa", "tags"} {
+			if !bytes.Contains(meta, []byte(col)) {
+				t.Errorf("%d entries: expected metadata to describe column %q", n, col)
+			}
+		}
+	}
+}
+
+// TestGoldenFiles compares writer output with files in testdata. The golden
+// files were checked by reading them with the Apache Arrow Go readers
+// (ipc.NewReader, pqarrow.NewFileReader) when they were written; changes to
+// the encoding must be re-checked the same way before updating them. Set
+// AFFIX_UPDATE_GOLDEN_FILES to rewrite them
+func TestGoldenFiles(t *testing.T) {
+	for _, f := range []Format{Arrow, Parquet} {
+		path := filepath.Join("testdata", "cities."+f.String())
+		got := writeEntries(t, f, cityEntries(10))
+		if os.Getenv("AFFIX_UPDATE_GOLDEN_FILES") != "" {
+			if err := ioutil.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expect, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expect, got) {
+			t.Errorf("%s output doesn't match %s", f, path)
+		}
+	}
+}
+
+func TestThriftWriterFieldHeaders(t *testing.T) {
+	tw := &thriftWriter{}
+	tw.beginStruct()
+	tw.i32(1, 1)
+	tw.i32(17, -1)
+	tw.structField(18)
+	tw.i64(1, 300)
+	tw.endStruct()
+	tw.endStruct()
+
+	expect := []byte{
+		0x15, 0x02, // field 1, i32, zigzag 1
+		0x05, 0x22, 0x01, // field 17 with a long-form header, zigzag -1
+		0x1c,             // field 18, struct
+		0x16, 0xd8, 0x04, // field 1, i64, zigzag 300
+		0x00, // stop nested struct
+		0x00, // stop top-level struct
+	}
+	if diff := cmp.Diff(expect, tw.Bytes()); diff != "" {
+		t.Errorf("encoding mismatch (-want +got):\n%s", diff)
+	}
+}
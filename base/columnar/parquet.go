@@ -0,0 +1,299 @@
+package columnar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/dataset/dsio"
+)
+
+// parquet thrift enum values, from the parquet-format specification
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+
+	parquetConvertedUTF8 = 0
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecUncompressed = 0
+	parquetPageData          = 0
+)
+
+// parquetMagic begins & ends every parquet file
+const parquetMagic = "PAR1"
+
+// parquetWriter writes entries as an uncompressed parquet file, with a row
+// group for every batchSize entries. Each column chunk is a single PLAIN
+// encoded data page with definition levels marking nulls
+type parquetWriter struct {
+	st   *dataset.Structure
+	cols []Column
+	bufs []*columnBuffer
+	w    io.Writer
+
+	offset    int64
+	numRows   int64
+	rowGroups []parquetRowGroup
+}
+
+type parquetRowGroup struct {
+	numRows   int64
+	totalSize int64
+	chunks    []parquetChunk
+}
+
+type parquetChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+var _ dsio.EntryWriter = (*parquetWriter)(nil)
+
+func newParquetWriter(st *dataset.Structure, cols []Column, w io.Writer) *parquetWriter {
+	return &parquetWriter{
+		st:   st,
+		cols: cols,
+		bufs: newColumnBuffers(cols),
+		w:    w,
+	}
+}
+
+// Structure gives the structure being written
+func (pw *parquetWriter) Structure() *dataset.Structure {
+	return pw.st
+}
+
+// WriteEntry buffers an entry, writing a row group when the buffer is full
+func (pw *parquetWriter) WriteEntry(e dsio.Entry) error {
+	if err := appendRow(pw.bufs, e); err != nil {
+		return err
+	}
+	if len(pw.bufs) > 0 && pw.bufs[0].len() >= batchSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// Close writes any buffered entries & the file footer. It does not close the
+// underlying writer
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+	meta := pw.fileMetadata()
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, uint32(len(meta)))
+	for _, p := range [][]byte{meta, footer, []byte(parquetMagic)} {
+		if err := pw.write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pw *parquetWriter) write(p []byte) error {
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	return err
+}
+
+// flush writes buffered entries as a row group
+func (pw *parquetWriter) flush() error {
+	if pw.offset == 0 {
+		if err := pw.write([]byte(parquetMagic)); err != nil {
+			return err
+		}
+	}
+	if len(pw.bufs) == 0 || pw.bufs[0].len() == 0 {
+		return nil
+	}
+
+	rg := parquetRowGroup{numRows: int64(pw.bufs[0].len())}
+	for _, b := range pw.bufs {
+		page, err := parquetPage(b)
+		if err != nil {
+			return err
+		}
+		header := newParquetPageHeader(b.len(), len(page))
+		chunk := parquetChunk{
+			offset:    pw.offset,
+			size:      int64(len(header) + len(page)),
+			numValues: int64(b.len()),
+		}
+		if err := pw.write(header); err != nil {
+			return err
+		}
+		if err := pw.write(page); err != nil {
+			return err
+		}
+		rg.chunks = append(rg.chunks, chunk)
+		rg.totalSize += chunk.size
+		b.reset()
+	}
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.numRows += rg.numRows
+	return nil
+}
+
+// parquetPage encodes the contents of a data page: definition levels as a
+// length-prefixed RLE/bit-packed hybrid run, followed by PLAIN encoded non-null
+// values
+func parquetPage(b *columnBuffer) ([]byte, error) {
+	// a single bit-packed run holds all definition levels, padded to a
+	// multiple of 8 values. with a maximum level of 1, the levels are the
+	// validity bitmap
+	levels := bitmap(b.valid)
+	run := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(run, uint64(len(levels))<<1|1)
+	run = append(run[:n], levels...)
+
+	page := make([]byte, 4, 4+len(run))
+	binary.LittleEndian.PutUint32(page, uint32(len(run)))
+	page = append(page, run...)
+
+	switch b.col.Type {
+	case Int64:
+		for i, x := range b.ints {
+			if b.valid[i] {
+				page = appendUint64(page, uint64(x))
+			}
+		}
+	case Float64:
+		for i, x := range b.floats {
+			if b.valid[i] {
+				page = appendUint64(page, math.Float64bits(x))
+			}
+		}
+	case Bool:
+		var vals []bool
+		for i, x := range b.bools {
+			if b.valid[i] {
+				vals = append(vals, x)
+			}
+		}
+		page = append(page, bitmap(vals)...)
+	default:
+		for i, s := range b.strs {
+			if !b.valid[i] {
+				continue
+			}
+			if len(s) > math.MaxInt32 {
+				return nil, fmt.Errorf("column %q: value exceeds 2GB", b.col.Name)
+			}
+			page = appendUint32(page, uint32(len(s)))
+			page = append(page, s...)
+		}
+	}
+	if len(page) > math.MaxInt32 {
+		return nil, fmt.Errorf("column %q: page exceeds 2GB", b.col.Name)
+	}
+	return page, nil
+}
+
+func appendUint32(p []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(p, b[:]...)
+}
+
+func appendUint64(p []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(p, b[:]...)
+}
+
+// newParquetPageHeader encodes a PageHeader for an uncompressed data page
+func newParquetPageHeader(numValues, size int) []byte {
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32(1, parquetPageData)
+	t.i32(2, int32(size))
+	t.i32(3, int32(size))
+	t.structField(5)
+	t.i32(1, int32(numValues))
+	t.i32(2, parquetEncodingPlain)
+	t.i32(3, parquetEncodingRLE)
+	t.i32(4, parquetEncodingRLE)
+	t.endStruct()
+	t.endStruct()
+	return t.Bytes()
+}
+
+// fileMetadata encodes the FileMetaData footer
+func (pw *parquetWriter) fileMetadata() []byte {
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32(1, 1)
+
+	t.list(2, thriftStruct, len(pw.cols)+1)
+	t.beginStruct()
+	t.string(4, "schema")
+	t.i32(5, int32(len(pw.cols)))
+	t.endStruct()
+	for _, col := range pw.cols {
+		t.beginStruct()
+		t.i32(1, parquetPhysicalType(col.Type))
+		t.i32(3, parquetOptional)
+		t.string(4, col.Name)
+		if col.Type == String {
+			t.i32(6, parquetConvertedUTF8)
+		}
+		t.endStruct()
+	}
+
+	t.i64(3, pw.numRows)
+
+	t.list(4, thriftStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		t.beginStruct()
+		t.list(1, thriftStruct, len(rg.chunks))
+		for i, c := range rg.chunks {
+			col := pw.cols[i]
+			t.beginStruct()
+			t.i64(2, c.offset)
+			t.structField(3)
+			t.i32(1, parquetPhysicalType(col.Type))
+			t.list(2, thriftI32, 2)
+			t.i32Elem(parquetEncodingPlain)
+			t.i32Elem(parquetEncodingRLE)
+			t.list(3, thriftBinary, 1)
+			t.stringElem(col.Name)
+			t.i32(4, parquetCodecUncompressed)
+			t.i64(5, c.numValues)
+			t.i64(6, c.size)
+			t.i64(7, c.size)
+			t.i64(9, c.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, rg.totalSize)
+		t.i64(3, rg.numRows)
+		t.endStruct()
+	}
+
+	t.string(6, "affix")
+	t.endStruct()
+	return t.Bytes()
+}
+
+func parquetPhysicalType(t Type) int32 {
+	switch t {
+	case Int64:
+		return parquetInt64
+	case Float64:
+		return parquetDouble
+	case Bool:
+		return parquetBoolean
+	}
+	return parquetByteArray
+}
@@ -0,0 +1,109 @@
+package columnar
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// thrift compact protocol type ids
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the thrift compact protocol, which parquet
+// uses for page headers & file metadata. It implements only the subset of the
+// protocol parquet metadata needs. Callers open the top-level struct with
+// beginStruct
+type thriftWriter struct {
+	buf bytes.Buffer
+	// lastField holds the previous field id of each open struct, field ids are
+	// written as deltas from the previous field
+	lastField []int16
+}
+
+// Bytes returns the encoded data
+func (t *thriftWriter) Bytes() []byte {
+	return t.buf.Bytes()
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := &t.lastField[len(t.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.buf.Write(b[:n])
+}
+
+// varint writes a zigzag encoded integer
+func (t *thriftWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	t.buf.Write(b[:n])
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) string(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.stringElem(s)
+}
+
+// list writes a list field header. Elements follow, written with the *Elem
+// methods or beginStruct & endStruct
+func (t *thriftWriter) list(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		t.uvarint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) i32Elem(v int32) {
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) stringElem(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// structField opens a struct-typed field, closed with endStruct
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+// beginStruct opens a struct that isn't a field, like a list element or the
+// top-level struct
+func (t *thriftWriter) beginStruct() {
+	t.lastField = append(t.lastField, 0)
+}
+
+// endStruct writes a stop byte, closing the innermost open struct
+func (t *thriftWriter) endStruct() {
+	t.buf.WriteByte(0)
+	t.lastField = t.lastField[:len(t.lastField)-1]
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/affix-io/affix/dsref"
 	"github.com/affix-io/affix/event"
+	"github.com/affix-io/affix/requestid"
 	"github.com/affix-io/dataset"
 	"github.com/affix-io/dataset/dsviz"
 	"github.com/affix-io/dataset/validate"
@@ -112,9 +113,12 @@ func CreateDataset(
 
 	peername := ds.Peername
 	name := ds.Name
+	// save events share the ID of the request that triggered the save, letting
+	// subscribers correlate events with API requests
+	sessionID := requestid.FromCtx(ctx)
 
 	go func() {
-		evtErr := pub.Publish(ctx, event.ETDatasetSaveStarted, event.DsSaveEvent{
+		evtErr := pub.PublishID(ctx, event.ETDatasetSaveStarted, sessionID, event.DsSaveEvent{
 			Username:   peername,
 			Name:       name,
 			Message:    "save started",
@@ -128,7 +132,7 @@ func CreateDataset(
 	path, err := WriteDataset(ctx, source, destination, prev, ds, pub, pk, sw)
 	if err != nil {
 		log.Debug(err.Error())
-		if evtErr := pub.Publish(ctx, event.ETDatasetSaveCompleted, event.DsSaveEvent{
+		if evtErr := pub.PublishID(ctx, event.ETDatasetSaveCompleted, sessionID, event.DsSaveEvent{
 			Username:   peername,
 			Name:       name,
 			Error:      err,
@@ -144,7 +148,7 @@ func CreateDataset(
 	// the caller doesn't use the ds arg afterward
 	// might make sense to have a wrapper function that writes and loads on success
 	if err := DerefDataset(ctx, destination, ds); err != nil {
-		if evtErr := pub.Publish(ctx, event.ETDatasetSaveCompleted, event.DsSaveEvent{
+		if evtErr := pub.PublishID(ctx, event.ETDatasetSaveCompleted, sessionID, event.DsSaveEvent{
 			Username:   peername,
 			Name:       name,
 			Error:      err,
@@ -155,7 +159,7 @@ func CreateDataset(
 		return path, err
 	}
 
-	return path, pub.Publish(ctx, event.ETDatasetSaveCompleted, event.DsSaveEvent{
+	return path, pub.PublishID(ctx, event.ETDatasetSaveCompleted, sessionID, event.DsSaveEvent{
 		Username:   peername,
 		Name:       name,
 		Message:    "dataset saved",
@@ -13,6 +13,7 @@ import (
 	testkeys "github.com/affix-io/affix/auth/key/test"
 	"github.com/affix-io/affix/base/toqtype"
 	"github.com/affix-io/affix/event"
+	"github.com/affix-io/affix/requestid"
 	"github.com/affix-io/dataset"
 	"github.com/affix-io/dataset/dsio"
 	"github.com/affix-io/dataset/dstest"
@@ -276,6 +277,37 @@ func TestDatasetSaveEvents(t *testing.T) {
 	}
 }
 
+func TestDatasetSaveEventsSessionID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = requestid.AddToContext(ctx, "request-id")
+
+	fs := qfs.NewMemFS()
+	privKey := testkeys.GetKeyData(10).PrivKey
+	bus := event.NewBus(ctx)
+
+	sessionID := ""
+	bus.SubscribeTypes(func(ctx context.Context, e event.Event) error {
+		sessionID = e.SessionID
+		return nil
+	}, event.ETDatasetSaveCompleted)
+
+	ds := &dataset.Dataset{
+		Commit: &dataset.Commit{
+			Timestamp: time.Date(2100, 1, 2, 3, 4, 5, 6, time.Local),
+		},
+		Structure: &dataset.Structure{Format: "json", Schema: dataset.BaseSchemaArray},
+	}
+	ds.SetBodyFile(qfs.NewMemfileBytes("/body.json", []byte(`[]`)))
+
+	if _, err := CreateDataset(ctx, fs, fs, bus, ds, nil, privKey, SaveSwitches{}); err != nil {
+		t.Fatal(err)
+	}
+	if sessionID != "request-id" {
+		t.Errorf("save completed event session ID mismatch. expected: %q, got: %q", "request-id", sessionID)
+	}
+}
+
 // Test that if the body is too large, the commit message just assumes the body changed
 func TestCreateDatasetBodyTooLarge(t *testing.T) {
 	ctx := context.Background()
@@ -0,0 +1,64 @@
+// Package client forwards to the versioned API client at
+// github.com/affix-io/affix/client/v1, which callers should import instead.
+// It keeps code written against the original import path building
+//
+// Deprecated: import github.com/affix-io/affix/client/v1
+package client
+
+import (
+	v1 "github.com/affix-io/affix/client/v1"
+	qhttp "github.com/affix-io/affix/lib/http"
+)
+
+// ErrNoMorePages is returned by Cursor.Next when results are exhausted
+var ErrNoMorePages = v1.ErrNoMorePages
+
+type (
+	// Client makes typed calls to the HTTP API of a affix node
+	Client = v1.Client
+	// Option configures a Client
+	Option = v1.Option
+	// Cursor fetches pages of a paginated method's results
+	Cursor = v1.Cursor
+	// Error is an error response from the API
+	Error = v1.Error
+
+	// AccessClient calls access methods
+	AccessClient = v1.AccessClient
+	// AutomationClient calls automation methods
+	AutomationClient = v1.AutomationClient
+	// CollectionClient calls collection methods
+	CollectionClient = v1.CollectionClient
+	// DatasetClient calls dataset methods
+	DatasetClient = v1.DatasetClient
+	// DiffClient calls diff methods
+	DiffClient = v1.DiffClient
+	// PeerClient calls peer methods
+	PeerClient = v1.PeerClient
+	// ProfileClient calls profile methods
+	ProfileClient = v1.ProfileClient
+	// RemoteClient calls remote methods
+	RemoteClient = v1.RemoteClient
+	// SearchClient calls search methods
+	SearchClient = v1.SearchClient
+	// SQLClient calls sql methods
+	SQLClient = v1.SQLClient
+)
+
+// OptToken sets an access token to authenticate calls with. Tokens carried by
+// a call context take precedence
+func OptToken(tok string) Option {
+	return v1.OptToken(tok)
+}
+
+// New creates a Client for a node listening at a multiaddr, eg:
+// "/ip4/127.0.0.1/tcp/2503"
+func New(multiaddr string, opts ...Option) (*Client, error) {
+	return v1.New(multiaddr, opts...)
+}
+
+// NewWithHTTPClient creates a Client that makes calls with an existing
+// lib/http Client
+func NewWithHTTPClient(hc *qhttp.Client, opts ...Option) *Client {
+	return v1.NewWithHTTPClient(hc, opts...)
+}
@@ -0,0 +1,171 @@
+// Package client is a typed Go client for version 1 of the affix HTTP API. Each
+// lib method group that is served over HTTP has a matching client group with
+// one method per lib method, taking the same params & returning the same
+// results.
+//
+// The client is versioned by import path, github.com/affix-io/affix/client/v1.
+// Changes that break the signatures of generated methods go in a new version
+// directory, leaving callers of v1 to upgrade when they're ready.
+//
+// Method groups in methods.go are generated from lib method attributes, and
+// must be regenerated whenever lib methods change:
+//
+//	go run ./cmd/generate client
+//
+// Errors returned by the API are *Error values carrying the response status
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/base/params"
+	qhttp "github.com/affix-io/affix/lib/http"
+)
+
+// ErrNoMorePages is returned by Cursor.Next when results are exhausted
+var ErrNoMorePages = errors.New("no more pages")
+
+// Error is an error response from the API
+type Error = qhttp.Error
+
+// Client makes typed calls to the HTTP API of a affix node
+type Client struct {
+	http  *qhttp.Client
+	token string
+}
+
+// Option configures a Client
+type Option func(c *Client)
+
+// OptToken sets an access token to authenticate calls with. Tokens carried by
+// a call context take precedence
+func OptToken(tok string) Option {
+	return func(c *Client) {
+		c.token = tok
+	}
+}
+
+// New creates a Client for a node listening at a multiaddr, eg:
+// "/ip4/127.0.0.1/tcp/2503"
+func New(multiaddr string, opts ...Option) (*Client, error) {
+	hc, err := qhttp.NewClient(multiaddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithHTTPClient(hc, opts...), nil
+}
+
+// NewWithHTTPClient creates a Client that makes calls with an existing
+// lib/http Client
+func NewWithHTTPClient(hc *qhttp.Client, opts ...Option) *Client {
+	c := &Client{http: hc}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// call makes a single call to an endpoint, decoding response data into res
+func (c *Client) call(ctx context.Context, endpoint qhttp.APIEndpoint, verb string, p, res interface{}) error {
+	if c.token != "" && token.FromCtx(ctx) == "" {
+		ctx = token.AddToContext(ctx, c.token)
+	}
+	return c.http.CallMethod(ctx, endpoint, verb, "", p, res)
+}
+
+// callPaged calls a paginated endpoint, returning a cursor for the pages that
+// follow
+func (c *Client) callPaged(ctx context.Context, endpoint qhttp.APIEndpoint, verb string, p, res interface{}) (*Cursor, error) {
+	if err := c.call(ctx, endpoint, verb, p, res); err != nil {
+		return nil, err
+	}
+	cur := &Cursor{c: c, endpoint: endpoint, verb: verb}
+	cur.params, cur.more = nextPageParams(p, pageLen(res))
+	return cur, nil
+}
+
+// Cursor fetches the pages of results that follow a call to a paginated
+// method. Pages are requested by advancing the Offset of the method params by
+// Limit, and end when a page comes back with fewer than Limit results
+type Cursor struct {
+	c        *Client
+	endpoint qhttp.APIEndpoint
+	verb     string
+	params   interface{}
+	more     bool
+}
+
+// HasNext reports whether there may be another page of results
+func (cur *Cursor) HasNext() bool {
+	return cur.more
+}
+
+// Next fetches the next page of results into result, which must be a pointer
+// to the result type of the method that created the cursor
+func (cur *Cursor) Next(ctx context.Context, result interface{}) error {
+	if !cur.more {
+		return ErrNoMorePages
+	}
+	if err := cur.c.call(ctx, cur.endpoint, cur.verb, cur.params, result); err != nil {
+		return err
+	}
+	cur.params, cur.more = nextPageParams(cur.params, pageLen(result))
+	return nil
+}
+
+// nextPageParams copies params with the offset advanced by one page, reporting
+// false if params aren't paginated or the last page had fewer than limit
+// results
+func nextPageParams(p interface{}, n int) (interface{}, bool) {
+	v := reflect.ValueOf(p)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+	next := reflect.New(v.Elem().Type())
+	next.Elem().Set(v.Elem())
+
+	offset := next.Elem().FieldByName("Offset")
+	limit := next.Elem().FieldByName("Limit")
+	if offset.Kind() != reflect.Int || limit.Kind() != reflect.Int {
+		return nil, false
+	}
+	if limit.Int() <= 0 {
+		limit.SetInt(int64(params.DefaultListLimit))
+	}
+	if offset.Int() < 0 {
+		offset.SetInt(0)
+	}
+	if int64(n) < limit.Int() {
+		return nil, false
+	}
+	offset.SetInt(offset.Int() + limit.Int())
+	return next.Interface(), true
+}
+
+// pageLen counts the results in a page, which are either a slice or a value
+// with a Len method. pageLen returns -1 if results can't be counted
+func pageLen(res interface{}) int {
+	v := reflect.ValueOf(res)
+	for v.IsValid() {
+		if (v.Kind() != reflect.Ptr || !v.IsNil()) && v.CanInterface() {
+			if l, ok := v.Interface().(interface{ Len() int }); ok {
+				return l.Len()
+			}
+		}
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if v.IsNil() {
+				return 0
+			}
+			v = v.Elem()
+		case reflect.Slice, reflect.Array:
+			return v.Len()
+		default:
+			return -1
+		}
+	}
+	return -1
+}
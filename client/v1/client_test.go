@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	apiutil "github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/dsref"
+	"github.com/affix-io/affix/lib"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/affix-io/affix/sql"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestClient(t *testing.T, h http.HandlerFunc, opts ...Option) *Client {
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWithHTTPClient(&qhttp.Client{Address: u.Host, Protocol: "http"}, opts...)
+}
+
+func TestCollectionListPages(t *testing.T) {
+	ctx := context.Background()
+	names := []string{"a", "b", "c", "d", "e"}
+	gotAuth := ""
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != qhttp.AEList.String() || r.Method != http.MethodPost {
+			apiutil.NotFoundHandler(w, r)
+			return
+		}
+		gotAuth = r.Header.Get("Authorization")
+		p := &lib.CollectionListParams{}
+		if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+			apiutil.WriteErrResponse(w, http.StatusBadRequest, err)
+			return
+		}
+		infos := []dsref.VersionInfo{}
+		for i := p.Offset; i < p.Offset+p.Limit && i < len(names); i++ {
+			infos = append(infos, dsref.VersionInfo{Name: names[i]})
+		}
+		apiutil.WriteResponse(w, infos)
+	}, OptToken("client_token"))
+
+	p := &lib.CollectionListParams{}
+	p.Limit = 2
+	infos, cur, err := c.Collection().List(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotAuth != "Bearer client_token" {
+		t.Errorf("expected client token to authenticate the call, got authorization header %q", gotAuth)
+	}
+
+	got := []string{}
+	for _, vi := range infos {
+		got = append(got, vi.Name)
+	}
+	pages := 1
+	for cur.HasNext() {
+		page := []dsref.VersionInfo{}
+		if err := cur.Next(ctx, &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, vi := range page {
+			got = append(got, vi.Name)
+		}
+		pages++
+	}
+	if diff := cmp.Diff(names, got); diff != "" {
+		t.Errorf("listed names mismatch (-want +got):\n%s", diff)
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got %d", pages)
+	}
+	if err := cur.Next(ctx, &[]dsref.VersionInfo{}); !errors.Is(err, ErrNoMorePages) {
+		t.Errorf("expected ErrNoMorePages once results are exhausted, got: %v", err)
+	}
+	if p.Offset != 0 {
+		t.Errorf("expected paging to leave caller params unchanged, got offset %d", p.Offset)
+	}
+
+	ctx = token.AddToContext(ctx, "context_token")
+	if _, _, err := c.Collection().List(ctx, &lib.CollectionListParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if gotAuth != "Bearer context_token" {
+		t.Errorf("expected context token to take precedence, got authorization header %q", gotAuth)
+	}
+}
+
+func TestCallError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		apiutil.WriteErrResponse(w, http.StatusBadRequest, fmt.Errorf("query is required"))
+	})
+
+	_, _, err := c.SQL().Query(context.Background(), &lib.SQLQueryParams{})
+	apiErr := &Error{}
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an *Error, got: %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("status code mismatch. expected: %d, got: %d", http.StatusBadRequest, apiErr.StatusCode)
+	}
+	if apiErr.Error() != "query is required" {
+		t.Errorf("error mismatch. expected: %q, got: %q", "query is required", apiErr.Error())
+	}
+}
+
+func TestPageLen(t *testing.T) {
+	var nilResult *sql.Result
+	cases := []struct {
+		res    interface{}
+		expect int
+	}{
+		{&[]string{"a", "b"}, 2},
+		{&[]string{}, 0},
+		{&nilResult, 0},
+		{&sql.Result{Rows: [][]interface{}{{1}, {2}, {3}}}, 3},
+		{new(string), -1},
+	}
+	for i, c := range cases {
+		if got := pageLen(c.res); got != c.expect {
+			t.Errorf("case %d: expected %d, got %d", i, c.expect, got)
+		}
+	}
+}
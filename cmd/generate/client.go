@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/format"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/affix-io/affix/lib"
+	qhttp "github.com/affix-io/affix/lib/http"
+)
+
+// clientFilepath is the generated client source, relative to the repo root
+const clientFilepath = "client/v1/methods.go"
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// generateClient writes source for the client package, with a group type for
+// each lib method group & a method for each lib method served over HTTP
+func generateClient(methods []lib.MethodSet) ([]byte, error) {
+	imps := imports{"context": "context"}
+	body := &bytes.Buffer{}
+	for _, ms := range methods {
+		if err := writeClientGroup(body, ms, imps); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("// Code generated by \"go run ./cmd/generate client\". DO NOT EDIT.\n\n")
+	buf.WriteString("package client\n\n")
+	buf.WriteString("import (\n")
+	for _, imp := range imps.sorted() {
+		buf.WriteString(imp)
+		buf.WriteString("\n")
+	}
+	buf.WriteString(")\n")
+	buf.Write(body.Bytes())
+	return format.Source(buf.Bytes())
+}
+
+func writeClientGroup(w *bytes.Buffer, ms lib.MethodSet, imps imports) error {
+	msType := reflect.TypeOf(ms)
+	group := strings.TrimSuffix(msType.Name(), "Methods")
+	groupType := group + "Client"
+
+	attrs := ms.Attributes()
+	methods := make([]reflect.Method, 0, len(attrs))
+	for i := 0; i < msType.NumMethod(); i++ {
+		m := msType.Method(i)
+		if attr, ok := attrs[strings.ToLower(m.Name)]; ok && attr.Endpoint != qhttp.DenyHTTP {
+			methods = append(methods, m)
+		}
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+
+	fmt.Fprintf(w, "\n// %s calls %s methods\ntype %s struct {\n\tc *Client\n}\n", groupType, ms.Name(), groupType)
+	fmt.Fprintf(w, "\n// %s returns a client for %s methods\nfunc (c *Client) %s() %s {\n\treturn %s{c: c}\n}\n", group, ms.Name(), group, groupType, groupType)
+
+	for _, m := range methods {
+		attr := attrs[strings.ToLower(m.Name)]
+		verb := attr.HTTPVerb
+		if verb == "" {
+			verb = "POST"
+		}
+		if err := writeClientMethod(w, ms.Name(), groupType, m, attr.Endpoint, verb, imps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeClientMethod(w *bytes.Buffer, group, groupType string, m reflect.Method, endpoint qhttp.APIEndpoint, verb string, imps imports) error {
+	ft := m.Type
+	// method types include the receiver: (recv, ctx, params)
+	if ft.NumIn() != 3 || ft.In(1) != contextType || ft.In(2).Kind() != reflect.Ptr {
+		return fmt.Errorf("%s.%s: expected (context.Context, *Params) arguments, got %v", group, m.Name, ft)
+	}
+	if ft.NumOut() < 1 || ft.NumOut() > 3 || ft.Out(ft.NumOut()-1) != errorType {
+		return fmt.Errorf("%s.%s: expected error as last result, got %v", group, m.Name, ft)
+	}
+
+	paramsType, err := imps.typeString(ft.In(2))
+	if err != nil {
+		return fmt.Errorf("%s.%s: %w", group, m.Name, err)
+	}
+	fmt.Fprintf(w, "\n// %s calls %s.%s at %s\n", m.Name, group, strings.ToLower(m.Name), endpoint)
+	fmt.Fprintf(w, "func (m %s) %s(ctx context.Context, p %s) ", groupType, m.Name, paramsType)
+
+	switch ft.NumOut() {
+	case 1:
+		fmt.Fprintf(w, "error {\n\treturn m.c.call(ctx, %q, %q, p, nil)\n}\n", endpoint, verb)
+	case 2:
+		resType, err := imps.typeString(ft.Out(0))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", group, m.Name, err)
+		}
+		fmt.Fprintf(w, "(%s, error) {\n\tvar res %s\n\terr := m.c.call(ctx, %q, %q, p, &res)\n\treturn res, err\n}\n", resType, resType, endpoint, verb)
+	case 3:
+		resType, err := imps.typeString(ft.Out(0))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", group, m.Name, err)
+		}
+		fmt.Fprintf(w, "(%s, *Cursor, error) {\n\tvar res %s\n\tcur, err := m.c.callPaged(ctx, %q, %q, p, &res)\n\treturn res, cur, err\n}\n", resType, resType, endpoint, verb)
+	}
+	return nil
+}
+
+// imports maps package paths to the names they're imported as
+type imports map[string]string
+
+// typeString writes a Go type as source, adding the packages it refers to
+func (imps imports) typeString(t reflect.Type) (string, error) {
+	if t.Name() != "" {
+		if t.PkgPath() == "" {
+			return t.Name(), nil
+		}
+		return imps.name(t.PkgPath()) + "." + t.Name(), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		elem, err := imps.typeString(t.Elem())
+		if t.Kind() == reflect.Ptr {
+			return "*" + elem, err
+		}
+		return "[]" + elem, err
+	case reflect.Array:
+		elem, err := imps.typeString(t.Elem())
+		return fmt.Sprintf("[%d]%s", t.Len(), elem), err
+	case reflect.Map:
+		key, err := imps.typeString(t.Key())
+		if err != nil {
+			return "", err
+		}
+		elem, err := imps.typeString(t.Elem())
+		return fmt.Sprintf("map[%s]%s", key, elem), err
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return "interface{}", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %v", t)
+}
+
+// name returns the name a package is imported as, qualifying the package name
+// with its parent directory when two packages share a name
+func (imps imports) name(pkgPath string) string {
+	if name, ok := imps[pkgPath]; ok {
+		return name
+	}
+	name := path.Base(pkgPath)
+	for _, taken := range imps {
+		if taken == name {
+			name = path.Base(path.Dir(pkgPath)) + name
+			break
+		}
+	}
+	imps[pkgPath] = name
+	return name
+}
+
+// sorted returns import lines, standard library packages first
+func (imps imports) sorted() []string {
+	var std, other []string
+	for pkgPath := range imps {
+		if strings.Contains(strings.SplitN(pkgPath, "/", 2)[0], ".") {
+			other = append(other, pkgPath)
+		} else {
+			std = append(std, pkgPath)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+	if len(std) > 0 && len(other) > 0 {
+		std = append(std, "")
+	}
+
+	lines := []string{}
+	for _, pkgPath := range append(std, other...) {
+		switch name := imps[pkgPath]; {
+		case pkgPath == "":
+			lines = append(lines, "")
+		case name == path.Base(pkgPath):
+			lines = append(lines, fmt.Sprintf("\t%q", pkgPath))
+		default:
+			lines = append(lines, fmt.Sprintf("\t%s %q", name, pkgPath))
+		}
+	}
+	return lines
+}
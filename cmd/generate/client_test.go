@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/affix-io/affix/lib"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGeneratedClientUpToDate(t *testing.T) {
+	got, err := generateClient((&lib.Instance{}).AllMethods())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := ioutil.ReadFile(filepath.Join("../..", clientFilepath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(expect), string(got)); diff != "" {
+		t.Errorf("%s is out of date, regenerate it with `go run ./cmd/generate client` (-want +got):\n%s", clientFilepath, diff)
+	}
+}
@@ -1,19 +1,27 @@
-// Package generate is a command that creates a bash completion file for affix
+// Package generate is a command that creates a bash completion file, markdown
+// docs, the typed API client & the OpenAPI document for affix
 package main
 
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 
+	"github.com/affix-io/affix/api"
 	"github.com/affix-io/affix/auth/key"
 	"github.com/affix-io/affix/cmd"
+	"github.com/affix-io/affix/lib"
 	"github.com/affix-io/ioes"
 	"github.com/affix-io/qfs/qipfs"
+	"github.com/ghodss/yaml"
 	"github.com/spf13/cobra/doc"
 )
 
+// openAPIFilepath is the generated OpenAPI document, relative to the repo root
+const openAPIFilepath = "api/open_api_3.yaml"
+
 func main() {
 	lastArg := os.Args[len(os.Args)-1]
 
@@ -43,7 +51,30 @@ func main() {
 			log.Fatal(err)
 		}
 		fmt.Println("done")
+	case "client":
+		fmt.Printf("generating api client...")
+		// method groups only need an instance to dispatch calls, which
+		// generating doesn't do
+		src, err := generateClient((&lib.Instance{}).AllMethods())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(clientFilepath, src, 0644); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("done")
+	case "openapi":
+		fmt.Printf("generating openapi document...")
+		data, err := yaml.Marshal(api.OpenAPISpec((&lib.Instance{}).AllMethods()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		data = append([]byte("# Code generated by \"go run ./cmd/generate openapi\". DO NOT EDIT.\n"), data...)
+		if err := ioutil.WriteFile(openAPIFilepath, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("done")
 	default:
-		fmt.Println("please provide a generate argument: [docs|completions]")
+		fmt.Println("please provide a generate argument: [docs|completions|client|openapi]")
 	}
 }
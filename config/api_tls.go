@@ -0,0 +1,75 @@
+package config
+
+import "fmt"
+
+// APITLS configures serving the JSON api over HTTPS. Servers read it with
+// api.OptTLSConfig
+type APITLS struct {
+	// CertFile & KeyFile are paths to a PEM encoded certificate & private key
+	CertFile string `json:"certfile"`
+	KeyFile  string `json:"keyfile"`
+	// ClientCAFile is a path to PEM encoded authorities that sign client
+	// certificates
+	ClientCAFile string `json:"clientcafile,omitempty"`
+	// RequireClientCert rejects clients that don't present a certificate signed
+	// by ClientCAFile
+	RequireClientCert bool `json:"requireclientcert,omitempty"`
+	// ClientProfiles maps the common name of client certificates to profiles
+	ClientProfiles map[string]APIClientProfile `json:"clientprofiles,omitempty"`
+}
+
+// APIClientProfile is the profile a client certificate authenticates as
+type APIClientProfile struct {
+	// ProfileID is the encoded profile identifier of the client
+	ProfileID string `json:"profileid"`
+	// Role is one of "reader", "writer" or "admin". defaults to "reader"
+	Role string `json:"role,omitempty"`
+}
+
+// Copy returns a deep copy of an APITLS struct
+func (t *APITLS) Copy() *APITLS {
+	if t == nil {
+		return nil
+	}
+	res := &APITLS{
+		CertFile:          t.CertFile,
+		KeyFile:           t.KeyFile,
+		ClientCAFile:      t.ClientCAFile,
+		RequireClientCert: t.RequireClientCert,
+	}
+	if t.ClientProfiles != nil {
+		res.ClientProfiles = make(map[string]APIClientProfile, len(t.ClientProfiles))
+		for cn, p := range t.ClientProfiles {
+			res.ClientProfiles[cn] = p
+		}
+	}
+	return res
+}
+
+// Validate returns an error if APITLS fields are in an invalid state. A nil
+// APITLS is valid & serves plain HTTP
+func (t *APITLS) Validate() error {
+	if t == nil {
+		return nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("api.tls: certfile and keyfile must be set together")
+	}
+	if t.CertFile == "" && (t.ClientCAFile != "" || t.RequireClientCert || len(t.ClientProfiles) > 0) {
+		return fmt.Errorf("api.tls: client certificates require certfile and keyfile")
+	}
+	if t.RequireClientCert && t.ClientCAFile == "" {
+		return fmt.Errorf("api.tls: requireclientcert requires clientcafile")
+	}
+	for cn, p := range t.ClientProfiles {
+		if p.ProfileID == "" {
+			return fmt.Errorf("api.tls: client profile %q: profileid is required", cn)
+		}
+		switch p.Role {
+		case "", "reader", "writer", "admin":
+		default:
+			return fmt.Errorf("api.tls: client profile %q: unknown role %q", cn, p.Role)
+		}
+	}
+	return nil
+}
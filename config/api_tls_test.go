@@ -0,0 +1,73 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAPITLSRoundTrip(t *testing.T) {
+	tls := &APITLS{
+		CertFile:          "/etc/affix/cert.pem",
+		KeyFile:           "/etc/affix/key.pem",
+		ClientCAFile:      "/etc/affix/ca.pem",
+		RequireClientCert: true,
+		ClientProfiles: map[string]APIClientProfile{
+			"notebook": {ProfileID: "QmProfile", Role: "writer"},
+		},
+	}
+	if err := tls.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(tls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &APITLS{}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(tls, got); diff != "" {
+		t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+	}
+
+	cp := tls.Copy()
+	if diff := cmp.Diff(tls, cp); diff != "" {
+		t.Errorf("copy mismatch (-want +got):\n%s", diff)
+	}
+	cp.ClientProfiles["notebook"] = APIClientProfile{ProfileID: "QmOther"}
+	if tls.ClientProfiles["notebook"].ProfileID != "QmProfile" {
+		t.Error("expected modifying a copy not to modify the original")
+	}
+	if (*APITLS)(nil).Copy() != nil {
+		t.Error("expected a copy of nil to be nil")
+	}
+}
+
+func TestAPITLSValidate(t *testing.T) {
+	cases := []struct {
+		description string
+		tls         *APITLS
+		expectErr   bool
+	}{
+		{"nil", nil, false},
+		{"empty", &APITLS{}, false},
+		{"cert and key", &APITLS{CertFile: "cert.pem", KeyFile: "key.pem"}, false},
+		{"cert without key", &APITLS{CertFile: "cert.pem"}, true},
+		{"key without cert", &APITLS{KeyFile: "key.pem"}, true},
+		{"client ca without cert", &APITLS{ClientCAFile: "ca.pem"}, true},
+		{"required client cert without ca", &APITLS{CertFile: "cert.pem", KeyFile: "key.pem", RequireClientCert: true}, true},
+		{"client profile without id", &APITLS{CertFile: "cert.pem", KeyFile: "key.pem", ClientProfiles: map[string]APIClientProfile{"cn": {}}}, true},
+		{"unknown role", &APITLS{CertFile: "cert.pem", KeyFile: "key.pem", ClientProfiles: map[string]APIClientProfile{"cn": {ProfileID: "QmProfile", Role: "root"}}}, true},
+	}
+	for _, c := range cases {
+		err := c.tls.Validate()
+		if c.expectErr && err == nil {
+			t.Errorf("case %q: expected an error", c.description)
+		} else if !c.expectErr && err != nil {
+			t.Errorf("case %q: unexpected error: %s", c.description, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/affix-io/affix/automation"
 	"github.com/affix-io/affix/automation/run"
@@ -317,15 +318,99 @@ func (automationImpl) Deploy(scope scope, p *DeployParams) error {
 
 	// Because deploy runs as a background task, re-root execution context atop
 	// the application context
+	if err := scope.inst.startDeploy(); err != nil {
+		return err
+	}
 	log.Debugw("app context", "ctx", scope.AppContext())
 	scope = scope.ReplaceParentContext(scope.AppContext())
 	// TODO(ramfox): if we decide that you can interact with the automation subsystem when
 	// affix connect is NOT running, we need a `wait` flag in DeployParams, that, when `true`,
 	// does NOT deploy in a go routine
-	go deploy(scope, p)
+	go func() {
+		defer scope.inst.finishDeploy()
+		deploy(scope, p)
+	}()
+	return nil
+}
+
+// ErrDeploysClosed is returned by deploys started while WaitForDeploys is
+// waiting
+var ErrDeploysClosed = errors.New("deploy: node is shutting down")
+
+// deployTracker counts the background deploys running on an instance
+type deployTracker struct {
+	running int
+	// idle is closed when the last deploy finishes while waiting. Once
+	// waiting starts no new deploys are accepted
+	idle chan struct{}
+}
+
+// instanceDeploys holds the trackers of instances with running deploys.
+// Trackers are removed when their last deploy finishes, so instances aren't
+// kept alive once their deploys are done
+var instanceDeploys = struct {
+	sync.Mutex
+	trackers map[*Instance]*deployTracker
+}{trackers: map[*Instance]*deployTracker{}}
+
+// startDeploy counts a deploy started on the instance
+func (inst *Instance) startDeploy() error {
+	instanceDeploys.Lock()
+	defer instanceDeploys.Unlock()
+	t, ok := instanceDeploys.trackers[inst]
+	if !ok {
+		t = &deployTracker{}
+		instanceDeploys.trackers[inst] = t
+	}
+	if t.idle != nil {
+		return ErrDeploysClosed
+	}
+	t.running++
 	return nil
 }
 
+// finishDeploy counts a deploy as finished, dropping the instance's tracker
+// when no deploys are left running
+func (inst *Instance) finishDeploy() {
+	instanceDeploys.Lock()
+	defer instanceDeploys.Unlock()
+	t := instanceDeploys.trackers[inst]
+	t.running--
+	if t.running > 0 {
+		return
+	}
+	if t.idle != nil {
+		close(t.idle)
+	}
+	delete(instanceDeploys.trackers, inst)
+}
+
+// WaitForDeploys blocks until all background deploys started on the
+// instance have finished. Deploys started while it waits fail with
+// ErrDeploysClosed. Servers should call it while shutting down, before the
+// application context is cancelled. If ctx is done first an error is
+// returned, deploys still running are cancelled with the application context
+func (inst *Instance) WaitForDeploys(ctx context.Context) error {
+	instanceDeploys.Lock()
+	t, ok := instanceDeploys.trackers[inst]
+	if !ok {
+		instanceDeploys.Unlock()
+		return nil
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	instanceDeploys.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for deploys: %w", ctx.Err())
+	}
+}
+
 func deploy(scope scope, p *DeployParams) {
 	vi := dsref.ConvertDatasetToVersionInfo(p.Dataset)
 	ref := vi.SimpleRef().String()
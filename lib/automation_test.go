@@ -280,6 +280,48 @@ func TestWorkflowParamsValidate(t *testing.T) {
 	}
 }
 
+func TestWaitForDeploys(t *testing.T) {
+	inst := &Instance{}
+	if err := inst.WaitForDeploys(context.Background()); err != nil {
+		t.Fatalf("expected waiting without deploys to return, got: %s", err)
+	}
+
+	if err := inst.startDeploy(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := inst.WaitForDeploys(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected waiting on a running deploy to time out, got: %v", err)
+	}
+	if err := inst.startDeploy(); !errors.Is(err, ErrDeploysClosed) {
+		t.Errorf("expected starting a deploy while waiting to fail with ErrDeploysClosed, got: %v", err)
+	}
+
+	// each instance tracks its own deploys
+	other := &Instance{}
+	if err := other.startDeploy(); err != nil {
+		t.Errorf("expected waiting on one instance to leave others accepting deploys, got: %s", err)
+	} else {
+		other.finishDeploy()
+	}
+
+	waitErr := make(chan error)
+	go func() { waitErr <- inst.WaitForDeploys(context.Background()) }()
+	inst.finishDeploy()
+	if err := <-waitErr; err != nil {
+		t.Errorf("expected no error once deploys finish, got: %s", err)
+	}
+
+	instanceDeploys.Lock()
+	defer instanceDeploys.Unlock()
+	for _, i := range []*Instance{inst, other} {
+		if _, ok := instanceDeploys.trackers[i]; ok {
+			t.Errorf("expected trackers to be dropped once deploys finish")
+		}
+	}
+}
+
 func errOnTimeout(t *testing.T, c chan string) <-chan string {
 	done := make(chan string)
 	go func() {
@@ -93,6 +93,8 @@ const (
 	AEDAGInfo APIEndpoint = "/ds/daginfo"
 	// AEWhatChanged gets what changed at a specific version in history
 	AEWhatChanged APIEndpoint = "/ds/whatchanged"
+	// AEQuery runs a SQL query against dataset bodies
+	AEQuery APIEndpoint = "/ds/query"
 
 	// peer endpoints
 
@@ -147,6 +149,8 @@ const (
 
 	// other endpoints
 
+	// AEBatch calls many lib methods in a single request
+	AEBatch APIEndpoint = "/batch"
 	// AEConnections lists affix & IPFS connections
 	AEConnections APIEndpoint = "/connections"
 	// AEConnectedaffixProfiles lists affix profile connections
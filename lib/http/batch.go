@@ -0,0 +1,67 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	apiutil "github.com/affix-io/affix/api/util"
+)
+
+// BatchCall is a single lib method call in a batch request. Method is a
+// dispatch name like "collection.list". Source overrides the source used to
+// resolve references, like the SourceResolver header of a single call
+type BatchCall struct {
+	Method string      `json:"method"`
+	Source string      `json:"source,omitempty"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+// BatchResult is the outcome of a call in a batch, in the same envelope as the
+// response of calling the method on its own
+type BatchResult struct {
+	Data json.RawMessage `json:"data,omitempty"`
+	Meta *apiutil.Meta   `json:"meta"`
+}
+
+// Err returns the error a call failed with as an *Error, nil if it succeeded
+func (r BatchResult) Err() error {
+	if r.Meta == nil {
+		return &Error{StatusCode: http.StatusInternalServerError, Message: "batch result is missing meta"}
+	}
+	if r.Meta.Code < 200 || r.Meta.Code > 299 {
+		return &Error{StatusCode: r.Meta.Code, Message: r.Meta.Error}
+	}
+	return nil
+}
+
+// Decode unmarshals the data of a successful call into result
+func (r BatchResult) Decode(result interface{}) error {
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if len(r.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Data, result)
+}
+
+// CallBatch calls many lib methods in a single request, running up to
+// parallel calls at once on the node. The node caps parallelism, values below
+// one run calls in order. Results match the order of calls, errors of
+// individual calls are reported by each result's Err method
+func (c Client) CallBatch(ctx context.Context, calls []BatchCall, parallel int) ([]BatchResult, error) {
+	ep := AEBatch
+	if parallel > 1 {
+		ep = APIEndpoint(fmt.Sprintf("%s?parallel=%d", AEBatch, parallel))
+	}
+	results := []BatchResult{}
+	if err := c.Call(ctx, ep, "", calls, &results); err != nil {
+		return nil, err
+	}
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("batch response has %d results for %d calls", len(results), len(calls))
+	}
+	return results, nil
+}
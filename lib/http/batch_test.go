@@ -0,0 +1,54 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestCallBatch(t *testing.T) {
+	gotQuery := ""
+	gotCalls := []BatchCall{}
+	cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		json.NewDecoder(r.Body).Decode(&gotCalls)
+		w.Write([]byte(`{"meta":{"code":200},"data":[
+			{"meta":{"code":200},"data":{"name":"a"}},
+			{"meta":{"code":404,"error":"reference not found"}}
+		]}`))
+	})
+
+	calls := []BatchCall{
+		{Method: "collection.get", Params: map[string]string{"ref": "me/a"}},
+		{Method: "collection.get", Params: map[string]string{"ref": "me/b"}},
+	}
+	res, err := cli.CallBatch(context.Background(), calls, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotQuery != "parallel=4" {
+		t.Errorf("query mismatch. expected: %q, got: %q", "parallel=4", gotQuery)
+	}
+	if len(gotCalls) != 2 || gotCalls[1].Method != "collection.get" {
+		t.Errorf("unexpected calls sent: %v", gotCalls)
+	}
+
+	got := struct{ Name string }{}
+	if err := res[0].Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "a" {
+		t.Errorf("name mismatch. expected: %q, got: %q", "a", got.Name)
+	}
+
+	apiErr := &Error{}
+	if err := res[1].Decode(&got); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404 *Error, got: %v", err)
+	}
+
+	if _, err := cli.CallBatch(context.Background(), calls[:1], 1); err == nil {
+		t.Error("expected a result count mismatch to error")
+	}
+}
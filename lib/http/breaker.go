@@ -0,0 +1,119 @@
+package http
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Client calls while the circuit breaker for the
+// client's address is open
+var ErrCircuitOpen = errors.New("circuit breaker is open: node is unavailable")
+
+// BreakerPolicy configures the circuit breaker a Client keeps for each
+// address it calls. Once Threshold calls in a row fail because the node is
+// unavailable the circuit opens, and calls fail fast with ErrCircuitOpen.
+// After Cooldown a single trial call is let through, closing the circuit if
+// it succeeds
+type BreakerPolicy struct {
+	// Threshold is the number of consecutive failed calls that opens the
+	// circuit. zero disables the circuit breaker
+	Threshold int
+	// Cooldown is how long an open circuit rejects calls before letting a
+	// trial call through
+	Cooldown time.Duration
+}
+
+// DefaultBreakerPolicy is the circuit breaker policy of clients that don't
+// configure one
+var DefaultBreakerPolicy = BreakerPolicy{
+	Threshold: 5,
+	Cooldown:  10 * time.Second,
+}
+
+// callOutcome is the result of a call for circuit breaking purposes
+type callOutcome int
+
+const (
+	// callOK means the node responded
+	callOK callOutcome = iota
+	// callFailed means the node couldn't be reached or was unavailable
+	callFailed
+	// callAbandoned means the caller gave up before the node responded
+	callAbandoned
+)
+
+// breakers holds a circuit breaker per address
+type breakers struct {
+	policy BreakerPolicy
+	lk     sync.Mutex
+	byAddr map[string]*breaker
+}
+
+func newBreakers(p BreakerPolicy) *breakers {
+	return &breakers{policy: p, byAddr: map[string]*breaker{}}
+}
+
+// get returns the breaker for an address, nil if circuit breaking is disabled
+func (bs *breakers) get(addr string) *breaker {
+	if bs == nil || bs.policy.Threshold <= 0 {
+		return nil
+	}
+	bs.lk.Lock()
+	defer bs.lk.Unlock()
+	b, ok := bs.byAddr[addr]
+	if !ok {
+		b = &breaker{policy: bs.policy, now: time.Now}
+		bs.byAddr[addr] = b
+	}
+	return b
+}
+
+// breaker is a circuit breaker for a single address. Methods of a nil breaker
+// allow every call
+type breaker struct {
+	policy BreakerPolicy
+	now    func() time.Time
+
+	lk       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// allow returns ErrCircuitOpen if a call shouldn't be made. Allowed calls must
+// be reported with done
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	if b.failures < b.policy.Threshold {
+		return nil
+	}
+	if b.trial || b.now().Sub(b.openedAt) < b.policy.Cooldown {
+		return ErrCircuitOpen
+	}
+	b.trial = true
+	return nil
+}
+
+// done records the outcome of an allowed call
+func (b *breaker) done(o callOutcome) {
+	if b == nil {
+		return
+	}
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.trial = false
+	switch o {
+	case callOK:
+		b.failures = 0
+	case callFailed:
+		b.failures++
+		if b.failures >= b.policy.Threshold {
+			b.openedAt = b.now()
+		}
+	}
+}
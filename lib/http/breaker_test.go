@@ -0,0 +1,67 @@
+package http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	bs := newBreakers(BreakerPolicy{Threshold: 2, Cooldown: time.Minute})
+	b := bs.get("127.0.0.1:2503")
+	b.now = func() time.Time { return now }
+
+	if bs.get("127.0.0.1:2503") != b {
+		t.Fatal("expected the same breaker for an address")
+	}
+	if newBreakers(BreakerPolicy{}).get("127.0.0.1:2503") != nil {
+		t.Fatal("expected a zero threshold to disable circuit breaking")
+	}
+
+	steps := []struct {
+		description string
+		advance     time.Duration
+		outcome     callOutcome
+		allowed     bool
+	}{
+		{"closed", 0, callFailed, true},
+		{"success resets failures", 0, callOK, true},
+		{"first failure", 0, callFailed, true},
+		{"abandoned calls don't count", 0, callAbandoned, true},
+		{"second failure opens", 0, callFailed, true},
+		{"open", 30 * time.Second, callFailed, false},
+		{"failed trial after cooldown", 30 * time.Second, callFailed, true},
+		{"reopened", 30 * time.Second, callFailed, false},
+		{"successful trial", time.Minute, callOK, true},
+		{"closed again", 0, callFailed, true},
+	}
+
+	for _, s := range steps {
+		now = now.Add(s.advance)
+		err := b.allow()
+		if s.allowed != (err == nil) {
+			t.Errorf("step %q: expected allowed=%t, got error: %v", s.description, s.allowed, err)
+		}
+		if err == nil {
+			b.done(s.outcome)
+		}
+	}
+}
+
+func TestBreakerSingleTrial(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &breaker{policy: BreakerPolicy{Threshold: 1, Cooldown: time.Minute}, now: func() time.Time { return now }}
+	b.done(callFailed)
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a trial call after cooldown, got: %s", err)
+	}
+	if err := b.allow(); err != ErrCircuitOpen {
+		t.Errorf("expected a single trial call at a time, got: %v", err)
+	}
+	b.done(callAbandoned)
+	if err := b.allow(); err != nil {
+		t.Errorf("expected an abandoned trial to allow another, got: %s", err)
+	}
+}
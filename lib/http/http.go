@@ -6,12 +6,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"time"
 
 	apiutil "github.com/affix-io/affix/api/util"
 	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/requestid"
 	golog "github.com/ipfs/go-log"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -30,51 +34,182 @@ var (
 	ErrUnsupportedRPC = errors.New("method is not supported over RPC")
 )
 
-// Client makes remote procedure calls to a affix node over HTTP
+// Error is returned by Client calls when the API responds with an error
+// status
+type Error struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Message is the error from the response envelope, or the response body
+	// if it isn't an envelope
+	Message string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Client makes remote procedure calls to a affix node over HTTP. Clients
+// created with a struct literal use the default options without a circuit
+// breaker
 type Client struct {
 	Address  string
 	Protocol string
+
+	opts     *ClientOptions
+	breakers *breakers
+}
+
+// ClientOptions configures how a Client makes requests
+type ClientOptions struct {
+	// Transport makes requests, defaults to a connection-pooling transport
+	// shared by all clients
+	Transport http.RoundTripper
+	// Timeout bounds each call, including retries. zero disables the
+	// timeout, which is the default as saves & applies can take minutes
+	Timeout time.Duration
+	// Retry configures retries of failed requests
+	Retry RetryPolicy
+	// Breaker configures the circuit breaker kept for each address
+	Breaker BreakerPolicy
+}
+
+// ClientOption is a function that adjusts client options
+type ClientOption func(o *ClientOptions)
+
+// DefaultClientOptions returns the default client configuration
+func DefaultClientOptions() *ClientOptions {
+	return &ClientOptions{
+		Transport: defaultTransport,
+		Retry:     DefaultRetryPolicy,
+		Breaker:   DefaultBreakerPolicy,
+	}
+}
+
+// defaultTransport is shared by clients so they share pooled connections
+var defaultTransport = NewPooledTransport(16)
+
+// NewPooledTransport creates a transport that keeps up to maxIdlePerHost idle
+// connections to each host open for reuse
+func NewPooledTransport(maxIdlePerHost int) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   maxIdlePerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// NewUnixSocketTransport creates a pooled transport that connects to the unix
+// socket file at path, regardless of the requested host
+func NewUnixSocketTransport(path string) *http.Transport {
+	t := NewPooledTransport(16)
+	t.Proxy = nil
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", path)
+	}
+	return t
+}
+
+// OptTransport sets the transport a client makes requests with
+func OptTransport(rt http.RoundTripper) ClientOption {
+	return func(o *ClientOptions) {
+		o.Transport = rt
+	}
+}
+
+// OptTimeout bounds each call, including retries. zero disables the timeout
+func OptTimeout(d time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.Timeout = d
+	}
+}
+
+// OptRetry replaces the default retry policy
+func OptRetry(p RetryPolicy) ClientOption {
+	return func(o *ClientOptions) {
+		o.Retry = p
+	}
+}
+
+// OptCircuitBreaker replaces the default circuit breaker policy
+func OptCircuitBreaker(p BreakerPolicy) ClientOption {
+	return func(o *ClientOptions) {
+		o.Breaker = p
+	}
+}
+
+// newClient creates a client for a network address
+func newClient(addr, protocol string, opts []ClientOption) *Client {
+	o := DefaultClientOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Client{
+		Address:  addr,
+		Protocol: protocol,
+		opts:     o,
+		breakers: newBreakers(o.Breaker),
+	}
 }
 
-// NewClient instantiates a new Client
-func NewClient(multiaddrStr string) (*Client, error) {
+// NewClient instantiates a new Client. /unix multiaddrs connect to an API
+// served on a unix socket file
+func NewClient(multiaddrStr string, opts ...ClientOption) (*Client, error) {
 	maAddr, err := ma.NewMultiaddr(multiaddrStr)
 	if err != nil {
 		return nil, err
 	}
 	// we default to the http protocol
 	protocol := "http"
-	protocols := maAddr.Protocols()
-	httpAddr, err := manet.ToNetAddr(maAddr)
-	if err != nil {
-		return nil, err
-	}
-	for _, p := range protocols {
+	for _, p := range maAddr.Protocols() {
 		// if https is present in the multiAddr we preffer that over http
 		if p.Code == ma.P_HTTPS {
 			protocol = "https"
 		}
 	}
-	return &Client{
-		Address:  httpAddr.String(),
-		Protocol: protocol,
-	}, nil
+	return clientForMultiaddr(maAddr, protocol, opts)
 }
 
 // NewClientWithProtocol instantiates a new Client with either http or https protocols
-func NewClientWithProtocol(multiaddrStr, protocol string) (*Client, error) {
+func NewClientWithProtocol(multiaddrStr, protocol string, opts ...ClientOption) (*Client, error) {
 	maAddr, err := ma.NewMultiaddr(multiaddrStr)
 	if err != nil {
 		return nil, err
 	}
-	httpAddr, err := manet.ToNetAddr(maAddr)
+	return clientForMultiaddr(maAddr, protocol, opts)
+}
+
+// clientForMultiaddr creates a client for a multiaddr. Clients of unix
+// sockets request "localhost" over a transport that dials the socket file,
+// unless opts set a transport
+func clientForMultiaddr(maAddr ma.Multiaddr, protocol string, opts []ClientOption) (*Client, error) {
+	netAddr, err := manet.ToNetAddr(maAddr)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{
-		Address:  httpAddr.String(),
-		Protocol: protocol,
-	}, nil
+	if unixAddr, ok := netAddr.(*net.UnixAddr); ok {
+		opts = append([]ClientOption{OptTransport(NewUnixSocketTransport(unixAddr.Name))}, opts...)
+		return newClient("localhost", protocol, opts), nil
+	}
+	return newClient(netAddr.String(), protocol, opts), nil
+}
+
+// options returns client options, falling back to defaults for clients
+// created with a struct literal
+func (c Client) options() *ClientOptions {
+	if c.opts == nil {
+		return DefaultClientOptions()
+	}
+	return c.opts
 }
 
 // Call calls API endpoint and passes on parameters, context info
@@ -107,54 +242,19 @@ func (c Client) CallMethodRaw(ctx context.Context, apiEndpoint APIEndpoint, http
 }
 
 func (c Client) do(ctx context.Context, addr string, httpMethod string, mimeType string, source string, params interface{}, result interface{}, raw bool) error {
-	var req *http.Request
-	var err error
-
-	log.Debugf("http: %s - %s", httpMethod, addr)
-
-	if httpMethod == http.MethodGet || httpMethod == http.MethodDelete {
-		u, err := url.Parse(addr)
-		if err != nil {
-			return err
-		}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 
-		if params != nil {
-			if pm, ok := params.(map[string]string); ok {
-				qvars := u.Query()
-				for k, v := range pm {
-					qvars.Set(k, v)
-				}
-				u.RawQuery = qvars.Encode()
-			}
-		}
-		req, err = http.NewRequest(httpMethod, u.String(), nil)
-	} else if httpMethod == http.MethodPost || httpMethod == http.MethodPut {
-		payload, err := json.Marshal(params)
-		if err != nil {
-			return err
-		}
-		req, err = http.NewRequest(httpMethod, addr, bytes.NewReader(payload))
-	}
+	newRequest, err := c.requestFunc(ctx, addr, httpMethod, mimeType, mimeType, source, params)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Content-Type", mimeType)
-	req.Header.Set("Accept", mimeType)
-
-	if source != "" {
-		req.Header.Set(SourceResolver, source)
-	}
-
-	req, added := token.AddContextTokenToRequest(ctx, req)
-	if !added {
-		log.Debugw("No token was set on an http client request. Unauthenticated requests may fail", "httpMethod", httpMethod, "addr", addr)
-	}
-
-	res, err := http.DefaultClient.Do(req)
+	res, err := c.send(ctx, httpMethod, newRequest)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -188,6 +288,122 @@ func (c Client) do(ctx context.Context, addr string, httpMethod string, mimeType
 	return nil
 }
 
+// withTimeout bounds a call context by the client timeout. The returned
+// cancel func must be called once the call is finished
+func (c Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t := c.options().Timeout; t > 0 {
+		return context.WithTimeout(ctx, t)
+	}
+	return context.WithCancel(ctx)
+}
+
+// requestFunc returns a function that creates the request for a call, so the
+// request can be sent again when retrying
+func (c Client) requestFunc(ctx context.Context, addr, httpMethod, contentType, accept, source string, params interface{}) (func() (*http.Request, error), error) {
+	log.Debugw("http client request", "method", httpMethod, "addr", addr, "requestID", requestid.FromCtx(ctx))
+
+	var payload []byte
+	if httpMethod == http.MethodGet || httpMethod == http.MethodDelete {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if params != nil {
+			if pm, ok := params.(map[string]string); ok {
+				qvars := u.Query()
+				for k, v := range pm {
+					qvars.Set(k, v)
+				}
+				u.RawQuery = qvars.Encode()
+			}
+		}
+		addr = u.String()
+	} else if httpMethod == http.MethodPost || httpMethod == http.MethodPut {
+		var err error
+		if payload, err = json.Marshal(params); err != nil {
+			return nil, err
+		}
+	}
+
+	// tie this call to the request that caused it, or start a new request
+	// chain if there isn't one. retries share the request ID
+	reqID := requestid.FromCtx(ctx)
+	if reqID == "" {
+		reqID = requestid.New()
+	}
+	if token.FromCtx(ctx) == "" {
+		log.Debugw("No token was set on an http client request. Unauthenticated requests may fail", "httpMethod", httpMethod, "addr", addr)
+	}
+
+	return func() (*http.Request, error) {
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, httpMethod, addr, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept", accept)
+		if source != "" {
+			req.Header.Set(SourceResolver, source)
+		}
+		req.Header.Set(requestid.Header, reqID)
+		req, _ = token.AddContextTokenToRequest(ctx, req)
+		return req, nil
+	}, nil
+}
+
+// send makes a request, retrying failures that are safe to retry with
+// exponential backoff. Bodies of retried responses are closed, the caller must
+// close the body of the returned response
+func (c Client) send(ctx context.Context, httpMethod string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	opts := c.options()
+	br := c.breakers.get(c.Address)
+	if err := br.allow(); err != nil {
+		return nil, err
+	}
+
+	cli := &http.Client{Transport: opts.Transport}
+	retry := canRetry(ctx, httpMethod)
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			br.done(callAbandoned)
+			return nil, err
+		}
+
+		res, err := cli.Do(req)
+		if err != nil && ctx.Err() != nil {
+			br.done(callAbandoned)
+			return nil, err
+		}
+		failed := err != nil || unavailableStatus(res.StatusCode)
+		if !failed || !retry || attempt >= opts.Retry.MaxAttempts {
+			if failed {
+				br.done(callFailed)
+			} else {
+				br.done(callOK)
+			}
+			return res, err
+		}
+		if res != nil {
+			// drain the body so the connection can be reused
+			io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxErrorBodySize))
+			res.Body.Close()
+		}
+
+		wait := opts.Retry.backoff(attempt)
+		log.Debugw("retrying http client request", "method", httpMethod, "url", req.URL.String(), "attempt", attempt, "wait", wait, "err", err)
+		if err := sleep(ctx, wait); err != nil {
+			br.done(callAbandoned)
+			return nil, err
+		}
+	}
+}
+
 func (c Client) checkError(res *http.Response, body []byte, raw bool) error {
 	metaResponse := struct {
 		Meta *apiutil.Meta
@@ -212,7 +428,18 @@ func (c Client) checkError(res *http.Response, body []byte, raw bool) error {
 
 	if res.StatusCode < 200 || res.StatusCode > 299 {
 		log.Debugf("Client response error: %d - %q", res.StatusCode, body)
-		return fmt.Errorf(string(body))
+		return responseError(res, body)
 	}
 	return nil
 }
+
+// responseError decodes the error envelope of a failed response
+func responseError(res *http.Response, body []byte) *Error {
+	env := struct {
+		Meta *apiutil.Meta
+	}{}
+	if err := json.Unmarshal(body, &env); err == nil && env.Meta != nil && env.Meta.Error != "" {
+		return &Error{StatusCode: res.StatusCode, Message: env.Meta.Error}
+	}
+	return &Error{StatusCode: res.StatusCode, Message: string(body)}
+}
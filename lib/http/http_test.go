@@ -0,0 +1,185 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/affix-io/affix/requestid"
+)
+
+func TestClientRequestID(t *testing.T) {
+	gotID := ""
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get(requestid.Header)
+		w.Write([]byte(`{"meta":{"code":200},"data":{}}`))
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Client{Address: u.Host, Protocol: "http"}
+
+	ctx := requestid.AddToContext(context.Background(), "request-id")
+	if err := c.Call(ctx, AEList, "", nil, &map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if gotID != "request-id" {
+		t.Errorf("request ID mismatch. expected: %q, got: %q", "request-id", gotID)
+	}
+
+	if err := c.Call(context.Background(), AEList, "", nil, &map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if !requestid.Valid(gotID) || gotID == "request-id" {
+		t.Errorf("expected client to generate a request ID, got %q", gotID)
+	}
+}
+
+func newTestClient(t *testing.T, h http.HandlerFunc, opts ...ClientOption) *Client {
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newClient(u.Host, "http", opts)
+}
+
+func TestClientRetries(t *testing.T) {
+	retry := OptRetry(RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	cases := []struct {
+		description string
+		method      string
+		ctx         context.Context
+		statuses    []int
+		attempts    int
+		err         string
+	}{
+		{"get recovers", http.MethodGet, context.Background(), []int{503, 502, 200}, 3, ""},
+		{"get gives up", http.MethodGet, context.Background(), []int{504, 504, 504, 200}, 3, "unavailable"},
+		{"post isn't retried", http.MethodPost, context.Background(), []int{503, 200}, 1, "unavailable"},
+		{"retry safe post recovers", http.MethodPost, WithRetrySafe(context.Background()), []int{503, 200}, 2, ""},
+		{"bad requests aren't retried", http.MethodGet, context.Background(), []int{400, 200}, 1, "bad"},
+		{"server errors aren't retried", http.MethodGet, context.Background(), []int{500, 200}, 1, "failed"},
+	}
+
+	messages := map[int]string{400: "bad", 500: "failed", 502: "unavailable", 503: "unavailable", 504: "unavailable"}
+	for _, c := range cases {
+		attempts := 0
+		cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			status := c.statuses[attempts]
+			attempts++
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				w.Write([]byte(`{"meta":{"code":200},"data":{}}`))
+				return
+			}
+			w.Write([]byte(fmt.Sprintf(`{"meta":{"code":%d,"error":%q}}`, status, messages[status])))
+		}, retry, OptCircuitBreaker(BreakerPolicy{}))
+
+		err := cli.CallMethod(c.ctx, AEList, c.method, "", nil, &map[string]interface{}{})
+		if c.err == "" && err != nil {
+			t.Errorf("case %q: unexpected error: %s", c.description, err)
+		} else if c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Errorf("case %q: error mismatch. expected: %q, got: %v", c.description, c.err, err)
+		}
+		if attempts != c.attempts {
+			t.Errorf("case %q: expected %d attempts, got %d", c.description, c.attempts, attempts)
+		}
+	}
+}
+
+func TestClientRetryRespectsContext(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, OptRetry(RetryPolicy{MaxAttempts: 10, MinBackoff: time.Hour, MaxBackoff: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := cli.CallMethod(ctx, AEList, http.MethodGet, "", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded error, got: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt before the context expired, got %d", attempts)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(500 * time.Millisecond):
+		}
+	}, OptTimeout(20*time.Millisecond), OptRetry(RetryPolicy{}))
+
+	start := time.Now()
+	err := cli.Call(context.Background(), AEList, "", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("expected call to time out, took %s", elapsed)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	attempts := 0
+	cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, OptRetry(RetryPolicy{}), OptCircuitBreaker(BreakerPolicy{Threshold: 2, Cooldown: time.Hour}))
+
+	for i := 0; i < 2; i++ {
+		if err := cli.Call(context.Background(), AEList, "", nil, nil); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: circuit opened early", i)
+		}
+	}
+	if err := cli.Call(context.Background(), AEList, "", nil, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected open circuit error, got: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected open circuit to stop requests, got %d requests", attempts)
+	}
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "affix.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotHost := ""
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		w.Write([]byte(`{"meta":{"code":200},"data":{"ok":true}}`))
+	})}
+	go s.Serve(ln)
+	defer s.Close()
+
+	c, err := NewClient("/unix" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := map[string]interface{}{}
+	if err := c.Call(context.Background(), AEList, "", nil, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res["ok"] != true {
+		t.Errorf("unexpected result: %v", res)
+	}
+	if gotHost != "localhost" {
+		t.Errorf("host mismatch. expected: %q, got: %q", "localhost", gotHost)
+	}
+}
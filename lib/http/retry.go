@@ -0,0 +1,82 @@
+package http
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy configures how a Client retries failed requests. Requests are
+// retried when the connection fails or the node responds with a 502, 503 or
+// 504 status, and only if the request is safe to send more than once: the
+// HTTP verb is idempotent or the call context was marked with WithRetrySafe
+type RetryPolicy struct {
+	// MaxAttempts caps the number of times a request is sent, including the
+	// first. values below 2 disable retries
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry, doubling with each retry
+	MinBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of clients that don't configure one
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+}
+
+// backoff returns the wait before a retry, which doubles after each attempt
+// with up to half the wait added as jitter so clients don't retry in lockstep
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+type retrySafeKey struct{}
+
+// WithRetrySafe marks calls made with the returned context as safe to retry.
+// Use it for POST endpoints that don't change state, or that change it the
+// same way no matter how many times they're called
+func WithRetrySafe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retrySafeKey{}, true)
+}
+
+// canRetry reports whether a request can be sent more than once
+func canRetry(ctx context.Context, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	safe, _ := ctx.Value(retrySafeKey{}).(bool)
+	return safe
+}
+
+// unavailableStatus reports whether a response status means the node is
+// unavailable, rather than the request having failed
+func unavailableStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// sleep waits for d, returning early with an error if ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
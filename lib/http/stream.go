@@ -0,0 +1,92 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// maxErrorBodySize caps how much of a failed response is read
+const maxErrorBodySize = 1 << 20
+
+// ProgressFunc is called as a streamed response body is read, with the number
+// of bytes read so far & the size of the body, -1 if the size isn't known
+type ProgressFunc func(read, total int64)
+
+// CallStream calls an API endpoint, returning the response body as a stream
+// instead of buffering it. The caller must close the stream, which ends the
+// call. Error responses are decoded into an *Error. progress may be nil.
+// When the client has a timeout it bounds reading the stream too
+func (c Client) CallStream(ctx context.Context, apiEndpoint APIEndpoint, httpMethod, source string, params interface{}, progress ProgressFunc) (io.ReadCloser, error) {
+	addr := fmt.Sprintf("%s://%s%s", c.Protocol, c.Address, apiEndpoint)
+	ctx, cancel := c.withTimeout(ctx)
+
+	newRequest, err := c.requestFunc(ctx, addr, httpMethod, JSONMimeType, "*/*", source, params)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res, err := c.send(ctx, httpMethod, newRequest)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer cancel()
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("Client stream response error: %d - %q", res.StatusCode, body)
+		return nil, responseError(res, body)
+	}
+
+	return &streamBody{
+		ReadCloser: res.Body,
+		cancel:     cancel,
+		total:      res.ContentLength,
+		progress:   progress,
+	}, nil
+}
+
+// CallStreamTo calls an API endpoint, copying the response body to w. It
+// returns the number of bytes written
+func (c Client) CallStreamTo(ctx context.Context, apiEndpoint APIEndpoint, httpMethod, source string, params interface{}, w io.Writer, progress ProgressFunc) (int64, error) {
+	rc, err := c.CallStream(ctx, apiEndpoint, httpMethod, source, params, progress)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, rc)
+}
+
+// streamBody reports progress reading a response body, and releases the call
+// context when closed
+type streamBody struct {
+	io.ReadCloser
+	cancel   context.CancelFunc
+	read     int64
+	total    int64
+	progress ProgressFunc
+}
+
+// Read implements the io.Reader interface
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.progress != nil {
+		b.read += int64(n)
+		b.progress(b.read, b.total)
+	}
+	return n, err
+}
+
+// Close implements the io.Closer interface
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
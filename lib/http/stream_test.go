@@ -0,0 +1,82 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestCallStream(t *testing.T) {
+	body := bytes.Repeat([]byte("affix,"), 100000)
+	cli := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/body":
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"meta":{"code":404,"error":"reference not found"}}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("upstream unavailable"))
+		}
+	}, OptRetry(RetryPolicy{}))
+	ctx := context.Background()
+
+	var read, total int64
+	calls := 0
+	progress := func(r, t int64) {
+		read, total = r, t
+		calls++
+	}
+	rc, err := cli.CallStream(ctx, "/body", http.MethodGet, "", nil, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, got) {
+		t.Errorf("streamed body mismatch. expected %d bytes, got %d", len(body), len(got))
+	}
+	if read != int64(len(body)) || total != int64(len(body)) || calls < 2 {
+		t.Errorf("expected progress to report %d of %d bytes over several reads, got %d of %d in %d calls", len(body), len(body), read, total, calls)
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := cli.CallStreamTo(ctx, "/body", http.MethodGet, "", nil, buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(body)) || !bytes.Equal(body, buf.Bytes()) {
+		t.Errorf("expected %d bytes written, got %d", len(body), n)
+	}
+
+	errCases := []struct {
+		endpoint APIEndpoint
+		status   int
+		message  string
+	}{
+		{"/missing", http.StatusNotFound, "reference not found"},
+		{"/proxied", http.StatusBadGateway, "upstream unavailable"},
+	}
+	for _, c := range errCases {
+		_, err := cli.CallStream(ctx, c.endpoint, http.MethodGet, "", nil, nil)
+		apiErr := &Error{}
+		if !errors.As(err, &apiErr) {
+			t.Errorf("%s: expected an *Error, got: %v", c.endpoint, err)
+			continue
+		}
+		if apiErr.StatusCode != c.status || apiErr.Message != c.message {
+			t.Errorf("%s: expected status %d %q, got %d %q", c.endpoint, c.status, c.message, apiErr.StatusCode, apiErr.Message)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/affix-io/affix/base/dsfs"
+	"github.com/affix-io/affix/base/params"
+	"github.com/affix-io/affix/dsref"
+	qhttp "github.com/affix-io/affix/lib/http"
+	"github.com/affix-io/affix/sql"
+	"github.com/affix-io/dataset"
+)
+
+// SQLMethods groups together methods for querying datasets with SQL
+type SQLMethods struct {
+	d dispatcher
+}
+
+// SQL returns the SQLMethods that Instance has registered
+func (inst *Instance) SQL() SQLMethods {
+	return SQLMethods{d: inst}
+}
+
+// Name returns the name of this method group
+func (m SQLMethods) Name() string {
+	return "sql"
+}
+
+// Attributes defines attributes for each method
+func (m SQLMethods) Attributes() map[string]AttributeSet {
+	return map[string]AttributeSet{
+		"query": {Endpoint: qhttp.AEQuery, HTTPVerb: "POST"},
+	}
+}
+
+// SQLQueryParams defines parameters for running a SQL query. Offset & Limit
+// page through query results, applying after any LIMIT & OFFSET clauses in
+// the query itself
+type SQLQueryParams struct {
+	params.List
+	// Query is a SELECT statement. Table names are dataset references, eg:
+	// "SELECT dx, COUNT(*) FROM me/cohort@/ipfs/QmHash GROUP BY dx"
+	Query string `json:"query"`
+}
+
+// SetNonZeroDefaults sets a default limit & offset
+func (p *SQLQueryParams) SetNonZeroDefaults() {
+	if p.Offset < 0 {
+		p.Offset = 0
+	}
+	if p.Limit <= 0 {
+		p.Limit = params.DefaultListLimit
+	}
+}
+
+// Validate returns an error if SQLQueryParams fields are in an invalid state
+func (p *SQLQueryParams) Validate() error {
+	if p.Query == "" {
+		return fmt.Errorf("query is required")
+	}
+	return nil
+}
+
+// Query runs a SQL query against dataset bodies
+func (m SQLMethods) Query(ctx context.Context, p *SQLQueryParams) (*sql.Result, Cursor, error) {
+	got, cur, err := m.d.Dispatch(ctx, dispatchMethodName(m, "query"), p)
+	if res, ok := got.(*sql.Result); ok {
+		return res, cur, err
+	}
+	return nil, nil, dispatchReturnError(got, err)
+}
+
+// sqlImpl holds the method implementations for SQLMethods
+type sqlImpl struct{}
+
+// Query runs a SQL query against dataset bodies
+func (sqlImpl) Query(scope scope, p *SQLQueryParams) (*sql.Result, Cursor, error) {
+	svc := sql.New(scope.Filesystem(), collectionLoader{scope: scope})
+	res, err := svc.Exec(scope.Context(), p.Query, p.Offset, p.Limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.Offset += p.Limit
+	cur := scope.MakeCursor(len(res.Rows), p)
+	return res, cur, nil
+}
+
+// collectionLoader loads datasets from the collection of the active profile,
+// so a query can't read datasets of another profile that the caller hasn't
+// pulled. Datasets outside the collection are reported as not found. The
+// version that is checked is the version that is loaded
+type collectionLoader struct {
+	scope scope
+}
+
+// LoadDataset implements the dsref.Loader interface
+func (l collectionLoader) LoadDataset(ctx context.Context, refstr string) (*dataset.Dataset, error) {
+	ref, _, err := l.scope.ParseAndResolveRef(ctx, refstr)
+	if err != nil {
+		return nil, err
+	}
+	pro := l.scope.ActiveProfile()
+	if s := l.scope.CollectionSet(); s != nil {
+		if _, err := s.Get(ctx, pro.ID, ref.InitID); err != nil {
+			log.Debugw("sql query dataset outside collection", "profileID", pro.ID.Encode(), "ref", refstr, "err", err)
+			return nil, fmt.Errorf("%w: %q", dsref.ErrRefNotFound, refstr)
+		}
+	} else if ref.ProfileID != pro.ID.Encode() {
+		return nil, fmt.Errorf("%w: %q", dsref.ErrRefNotFound, refstr)
+	}
+	if ref.Path == "" {
+		return nil, fmt.Errorf("%w: %q has no versions", dsref.ErrRefNotFound, refstr)
+	}
+	return dsfs.LoadDataset(ctx, l.scope.Filesystem(), ref.Path)
+}
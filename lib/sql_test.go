@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	testkeys "github.com/affix-io/affix/auth/key/test"
+	"github.com/affix-io/affix/auth/token"
+	testcfg "github.com/affix-io/affix/config/test"
+	"github.com/affix-io/affix/dsref"
+	"github.com/affix-io/affix/event"
+	"github.com/affix-io/affix/p2p"
+	"github.com/affix-io/affix/profile"
+	testrepo "github.com/affix-io/affix/repo/test"
+)
+
+func TestSQLQueryCollectionAccess(t *testing.T) {
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	mr, err := testrepo.NewTestRepo()
+	if err != nil {
+		t.Fatalf("error allocating test repo: %s", err)
+	}
+	node, err := p2p.NewaffixNode(mr, testcfg.DefaultP2PForTesting(), event.NilBus, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst := NewInstanceFromConfigAndNode(ctx, testcfg.DefaultConfigForTesting(), node)
+
+	p := &SQLQueryParams{Query: "SELECT city FROM peer/cities"}
+	res, _, err := inst.SQL().Query(ctx, p)
+	if err != nil {
+		t.Fatalf("expected the owner to query a dataset in their collection, got: %s", err)
+	}
+	if res.Len() == 0 {
+		t.Errorf("expected query to return rows")
+	}
+
+	// another profile known to the node hasn't pulled the owner's dataset
+	kd := testkeys.GetKeyData(10)
+	other := &profile.Profile{
+		ID:       profile.IDFromPeerID(kd.PeerID),
+		Peername: "other",
+		PubKey:   kd.PrivKey.GetPublic(),
+	}
+	if err := inst.keystore.AddPubKey(ctx, kd.KeyID, kd.PrivKey.GetPublic()); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Repo.Profiles().PutProfile(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	s, err := token.NewPrivKeyAuthToken(kd.PrivKey, other.ID.Encode(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCtx := token.AddToContext(ctx, s)
+
+	p = &SQLQueryParams{Query: "SELECT city FROM peer/cities"}
+	if _, _, err := inst.SQL().Query(otherCtx, p); !errors.Is(err, dsref.ErrRefNotFound) {
+		t.Errorf("expected querying another profile's dataset to error with %q, got: %v", dsref.ErrRefNotFound, err)
+	}
+}
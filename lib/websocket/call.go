@@ -0,0 +1,123 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	apiutil "github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/token"
+)
+
+// maxConcurrentCalls caps the number of calls a connection can have running
+// at once. The connection's messages aren't read while it's at the cap
+const maxConcurrentCalls = 8
+
+// Call is a lib method called over a websocket connection
+type Call struct {
+	Method string
+	// Source is the resolver the method uses, like the SourceResolver header
+	// of an HTTP request
+	Source string
+	Params json.RawMessage
+	// RemoteAddr is the network address of the client
+	RemoteAddr string
+}
+
+// CallFunc runs a call, returning the response envelope sent to the client.
+// ctx carries the token the connection subscribed with, so callers can be
+// authorized the same way as HTTP requests
+type CallFunc func(ctx context.Context, call Call) apiutil.Response
+
+// OptCallFunc sets the function that runs calls made over websocket
+// connections. Without one, calls respond with an error
+func OptCallFunc(fn CallFunc) Option {
+	return func(o *Options) {
+		o.Call = fn
+	}
+}
+
+// errNotSubscribed is the error calls made before subscribing respond with.
+// The upgrade doesn't check the request origin, so a connection must prove who
+// it is before it can call methods
+var errNotSubscribed = fmt.Errorf("subscribe with a token before making calls")
+
+// callMessage is the expected structure of an incoming "call" message
+type callMessage struct {
+	// ID is chosen by the client, and returned in the matching "response"
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Source string          `json:"source"`
+	Params json.RawMessage `json:"params"`
+}
+
+// callResult is the payload of a "response" message, the ID of the call it
+// responds to & the same envelope an HTTP request to the method responds with
+type callResult struct {
+	ID string `json:"id"`
+	apiutil.Response
+}
+
+// call runs a call message in the background, queueing a "response" message
+// with the result
+func (h *connections) call(c *conn, payload json.RawMessage) {
+	cm := &callMessage{}
+	if err := json.Unmarshal(payload, cm); err != nil {
+		log.Debugw("websocket unmarshal", "error", err, "connection id", c.id)
+		h.respond(c, cm.ID, callError(http.StatusBadRequest, err))
+		return
+	}
+	if h.opts.Call == nil {
+		h.respond(c, cm.ID, callError(http.StatusNotImplemented, fmt.Errorf("calls are not supported")))
+		return
+	}
+	h.subsLock.Lock()
+	tokenStr := c.token
+	h.subsLock.Unlock()
+	if tokenStr == "" {
+		h.respond(c, cm.ID, callError(http.StatusUnauthorized, errNotSubscribed))
+		return
+	}
+
+	select {
+	case c.calls <- struct{}{}:
+	case <-c.done:
+		return
+	}
+	go func() {
+		defer func() { <-c.calls }()
+		// a panicking method responds with an error instead of taking down
+		// the process
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.Errorw("websocket call panicked", "method", cm.Method, "connection id", c.id, "panic", rec, "stack", string(debug.Stack()))
+				h.respond(c, cm.ID, callError(http.StatusInternalServerError, fmt.Errorf("internal error calling %s", cm.Method)))
+			}
+		}()
+		ctx := token.AddToContext(c.ctx, tokenStr)
+		res := h.opts.Call(ctx, Call{
+			Method:     cm.Method,
+			Source:     cm.Source,
+			Params:     cm.Params,
+			RemoteAddr: c.remoteAddr,
+		})
+		h.respond(c, cm.ID, res)
+	}()
+}
+
+// respond queues the response to a call
+func (h *connections) respond(c *conn, id string, res apiutil.Response) {
+	payload, err := json.Marshal(callResult{ID: id, Response: res})
+	if err != nil {
+		log.Debugw("encoding call response", "error", err, "connection id", c.id)
+		payload, _ = json.Marshal(callResult{ID: id, Response: callError(http.StatusInternalServerError, err)})
+	}
+	h.write(c.ctx, c, &message{Type: callResponse, Payload: payload})
+}
+
+// callError creates the envelope of a failed call
+func callError(code int, err error) apiutil.Response {
+	return apiutil.Response{Meta: &apiutil.Meta{Code: code, Error: err.Error()}}
+}
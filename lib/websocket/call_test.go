@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	apiutil "github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/token"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWebsocketCall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	callFunc := func(ctx context.Context, call Call) apiutil.Response {
+		if call.Method != "collection.list" {
+			return callError(http.StatusNotFound, fmt.Errorf("method %q not found", call.Method))
+		}
+		if string(call.Params) == `"panic"` {
+			panic("method panicked")
+		}
+		params := map[string]interface{}{}
+		if err := json.Unmarshal(call.Params, &params); err != nil {
+			return callError(http.StatusBadRequest, err)
+		}
+		return apiutil.Response{
+			Meta: &apiutil.Meta{Code: http.StatusOK},
+			Data: map[string]interface{}{
+				"params":        params,
+				"source":        call.Source,
+				"authenticated": token.FromCtx(ctx) != "",
+			},
+		}
+	}
+	_, s := newTestHandler(ctx, t, OptCallFunc(callFunc))
+
+	// connections that haven't subscribed with a token can't call methods
+	c := dial(ctx, t, s)
+	c.send(callRequest, callMessage{ID: "0", Method: "collection.list", Params: json.RawMessage(`{}`)})
+	expect := map[string]interface{}{
+		"id":   "0",
+		"meta": map[string]interface{}{"code": float64(401), "error": errNotSubscribed.Error()},
+	}
+	if diff := cmp.Diff(expect, c.expect(string(callResponse))["payload"]); diff != "" {
+		t.Errorf("unsubscribed response mismatch (-want +got):\n%s", diff)
+	}
+
+	c = dialSubscribed(ctx, t, s)
+
+	c.send(callRequest, callMessage{ID: "1", Method: "collection.list", Source: "local", Params: json.RawMessage(`{"limit":10}`)})
+	expect = map[string]interface{}{
+		"id":   "1",
+		"meta": map[string]interface{}{"code": float64(200)},
+		"data": map[string]interface{}{
+			"params":        map[string]interface{}{"limit": float64(10)},
+			"source":        "local",
+			"authenticated": true,
+		},
+	}
+	if diff := cmp.Diff(expect, c.expect(string(callResponse))["payload"]); diff != "" {
+		t.Errorf("response mismatch (-want +got):\n%s", diff)
+	}
+
+	c.send(callRequest, callMessage{ID: "2", Method: "unknown.method"})
+	expect = map[string]interface{}{
+		"id":   "2",
+		"meta": map[string]interface{}{"code": float64(404), "error": `method "unknown.method" not found`},
+	}
+	if diff := cmp.Diff(expect, c.expect(string(callResponse))["payload"]); diff != "" {
+		t.Errorf("error response mismatch (-want +got):\n%s", diff)
+	}
+
+	c.send(callRequest, callMessage{ID: "panic", Method: "collection.list", Params: json.RawMessage(`"panic"`)})
+	expect = map[string]interface{}{
+		"id":   "panic",
+		"meta": map[string]interface{}{"code": float64(500), "error": "internal error calling collection.list"},
+	}
+	if diff := cmp.Diff(expect, c.expect(string(callResponse))["payload"]); diff != "" {
+		t.Errorf("panic response mismatch (-want +got):\n%s", diff)
+	}
+
+	// handlers without a CallFunc reject calls
+	_, s = newTestHandler(ctx, t)
+	c = dialSubscribed(ctx, t, s)
+	c.send(callRequest, callMessage{ID: "3", Method: "collection.list"})
+	res := c.expect(string(callResponse))["payload"].(map[string]interface{})
+	if meta := res["meta"].(map[string]interface{}); res["id"] != "3" || meta["code"] != float64(http.StatusNotImplemented) {
+		t.Errorf("expected call 3 to respond %d, got: %v", http.StatusNotImplemented, res)
+	}
+}
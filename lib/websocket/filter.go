@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/affix-io/affix/event"
+)
+
+// topicsMessage is the expected structure of an incoming "subscribe:topics"
+// message. Events must match every non-empty field to be sent, an empty
+// message sends all events
+type topicsMessage struct {
+	// Types are event type prefixes, like "automation:"
+	Types []string `json:"types"`
+	// InitIDs are dataset init IDs. Events match if their payload has one of
+	// these InitIDs, or one of the WorkflowIDs
+	InitIDs []string `json:"initIDs"`
+	// WorkflowIDs are automation workflow IDs
+	WorkflowIDs []string `json:"workflowIDs"`
+}
+
+// eventFilter decides which events are sent to a connection. A nil filter
+// matches all events
+type eventFilter struct {
+	types       []string
+	initIDs     map[string]struct{}
+	workflowIDs map[string]struct{}
+}
+
+// newEventFilter creates a filter from a topics message, returning nil if the
+// message doesn't filter events
+func newEventFilter(m *topicsMessage) *eventFilter {
+	if len(m.Types) == 0 && len(m.InitIDs) == 0 && len(m.WorkflowIDs) == 0 {
+		return nil
+	}
+	return &eventFilter{
+		types:       m.Types,
+		initIDs:     stringSet(m.InitIDs),
+		workflowIDs: stringSet(m.WorkflowIDs),
+	}
+}
+
+// match reports whether an event passes the filter
+func (f *eventFilter) match(e event.Event) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.types) > 0 {
+		matched := false
+		for _, prefix := range f.types {
+			if strings.HasPrefix(string(e.Type), prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(f.initIDs) == 0 && len(f.workflowIDs) == 0 {
+		return true
+	}
+	if _, ok := f.initIDs[payloadField(e.Payload, "InitID")]; ok {
+		return true
+	}
+	_, ok := f.workflowIDs[payloadField(e.Payload, "WorkflowID")]
+	return ok
+}
+
+func stringSet(strs []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(strs))
+	for _, s := range strs {
+		if s != "" {
+			set[s] = struct{}{}
+		}
+	}
+	return set
+}
+
+// payloadField returns the value of a string field of an event payload, which
+// may be a struct, a pointer to a struct or a map with keys in JSON casing
+// ("InitID" is read from the "initID" key). It returns the empty string if
+// the payload doesn't have the field
+func payloadField(payload interface{}, name string) string {
+	v := reflect.ValueOf(payload)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		f := v.FieldByName(name)
+		if f.IsValid() && f.Kind() == reflect.String {
+			return f.String()
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return ""
+		}
+		key := strings.ToLower(name[:1]) + name[1:]
+		f := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+		if f.IsValid() {
+			if s, ok := f.Interface().(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/affix-io/affix/event"
+)
+
+type testPayload struct {
+	InitID     string
+	WorkflowID string
+}
+
+func TestEventFilter(t *testing.T) {
+	deploy := event.Event{Type: "automation:deploy:start", Payload: testPayload{InitID: "init_a", WorkflowID: "wf_a"}}
+	save := event.Event{Type: "dataset:save:progress", Payload: &testPayload{InitID: "init_b"}}
+	mapped := event.Event{Type: "dataset:save:progress", Payload: map[string]interface{}{"initID": "init_c"}}
+	empty := event.Event{Type: "dataset:save:progress"}
+
+	cases := []struct {
+		description string
+		topics      topicsMessage
+		expect      []bool
+	}{
+		{"no topics", topicsMessage{}, []bool{true, true, true, true}},
+		{"type prefix", topicsMessage{Types: []string{"automation:"}}, []bool{true, false, false, false}},
+		{"many type prefixes", topicsMessage{Types: []string{"automation:", "dataset:save"}}, []bool{true, true, true, true}},
+		{"init ID", topicsMessage{InitIDs: []string{"init_b"}}, []bool{false, true, false, false}},
+		{"init ID from a map", topicsMessage{InitIDs: []string{"init_c"}}, []bool{false, false, true, false}},
+		{"workflow ID", topicsMessage{WorkflowIDs: []string{"wf_a"}}, []bool{true, false, false, false}},
+		{"init or workflow ID", topicsMessage{InitIDs: []string{"init_b"}, WorkflowIDs: []string{"wf_a"}}, []bool{true, true, false, false}},
+		{"type prefix and ID", topicsMessage{Types: []string{"automation:"}, InitIDs: []string{"init_b"}}, []bool{false, false, false, false}},
+	}
+
+	for _, c := range cases {
+		f := newEventFilter(&c.topics)
+		for i, e := range []event.Event{deploy, save, mapped, empty} {
+			if got := f.match(e); got != c.expect[i] {
+				t.Errorf("case %q, event %d: expected match %t, got %t", c.description, i, c.expect[i], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"sync"
+
+	"github.com/affix-io/affix/event"
+)
+
+// historySize is the number of recent events kept for each profile
+const historySize = 512
+
+// sequencedEvent is an event numbered in the order it was published to a
+// profile
+type sequencedEvent struct {
+	seq uint64
+	event.Event
+}
+
+// eventHistory keeps a bounded ring buffer of recent events for each profile,
+// numbering events with sequence numbers that increase by one for each event
+// published to the profile. The first event is numbered 1
+type eventHistory struct {
+	size     int
+	lock     sync.Mutex
+	profiles map[string]*eventRing
+}
+
+type eventRing struct {
+	events []sequencedEvent
+	last   uint64
+}
+
+func newEventHistory(size int) *eventHistory {
+	return &eventHistory{
+		size:     size,
+		profiles: map[string]*eventRing{},
+	}
+}
+
+// add records an event published to a profile, returning its sequence number
+func (h *eventHistory) add(profileID string, e event.Event) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	r, ok := h.profiles[profileID]
+	if !ok {
+		r = &eventRing{events: make([]sequencedEvent, h.size)}
+		h.profiles[profileID] = r
+	}
+	r.last++
+	r.events[(r.last-1)%uint64(h.size)] = sequencedEvent{seq: r.last, Event: e}
+	return r.last
+}
+
+// last returns the sequence number of the latest event published to a
+// profile, 0 if there are none
+func (h *eventHistory) last(profileID string) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if r, ok := h.profiles[profileID]; ok {
+		return r.last
+	}
+	return 0
+}
+
+// between returns the events published to a profile with sequence numbers
+// after from, up to & including to, in order. complete is false if some of
+// those events are no longer kept
+func (h *eventHistory) between(profileID string, from, to uint64) (events []sequencedEvent, complete bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	r, ok := h.profiles[profileID]
+	if !ok {
+		return nil, from >= to
+	}
+	if to > r.last {
+		to = r.last
+	}
+	oldest := uint64(1)
+	if r.last > uint64(h.size) {
+		oldest = r.last - uint64(h.size) + 1
+	}
+	complete = true
+	if from+1 < oldest {
+		from = oldest - 1
+		complete = false
+	}
+	for seq := from + 1; seq <= to; seq++ {
+		events = append(events, r.events[(seq-1)%uint64(h.size)])
+	}
+	return events, complete
+}
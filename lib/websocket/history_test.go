@@ -0,0 +1,56 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/affix-io/affix/event"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEventHistory(t *testing.T) {
+	h := newEventHistory(4)
+	if h.last("profile_a") != 0 {
+		t.Fatal("expected no events for a new profile")
+	}
+	for i := 1; i <= 6; i++ {
+		if seq := h.add("profile_a", event.Event{Timestamp: int64(i)}); seq != uint64(i) {
+			t.Fatalf("expected sequence number %d, got %d", i, seq)
+		}
+	}
+	if seq := h.add("profile_b", event.Event{Timestamp: 100}); seq != 1 {
+		t.Errorf("expected profiles to be numbered separately, got sequence number %d", seq)
+	}
+
+	cases := []struct {
+		description string
+		from, to    uint64
+		expect      []int64
+		complete    bool
+	}{
+		{"all kept events", 2, 6, []int64{3, 4, 5, 6}, true},
+		{"range", 3, 5, []int64{4, 5}, true},
+		{"up to date", 6, 6, nil, true},
+		{"to is capped at the latest event", 5, 10, []int64{6}, true},
+		{"dropped events", 0, 6, []int64{3, 4, 5, 6}, false},
+	}
+	for _, c := range cases {
+		events, complete := h.between("profile_a", c.from, c.to)
+		var got []int64
+		for _, e := range events {
+			if e.seq != uint64(e.Timestamp) {
+				t.Errorf("case %q: event %d has sequence number %d", c.description, e.Timestamp, e.seq)
+			}
+			got = append(got, e.Timestamp)
+		}
+		if diff := cmp.Diff(c.expect, got); diff != "" {
+			t.Errorf("case %q: events mismatch (-want +got):\n%s", c.description, diff)
+		}
+		if complete != c.complete {
+			t.Errorf("case %q: expected complete %t, got %t", c.description, c.complete, complete)
+		}
+	}
+
+	if events, complete := h.between("profile_c", 0, 0); len(events) != 0 || !complete {
+		t.Errorf("expected an unknown profile without missed events to be complete")
+	}
+}
@@ -0,0 +1,234 @@
+package websocket
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+const (
+	// DefaultQueueSize is the default number of messages waiting to be sent
+	// to a connection
+	DefaultQueueSize = 256
+	// DefaultWriteTimeout is the default time allowed for writing a message to
+	// a websocket connection
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// SlowConsumerPolicy decides what happens when an event is published to a
+// connection whose outbound queue is full
+type SlowConsumerPolicy int
+
+const (
+	// DropEvents discards the event, keeping the connection open. Clients can
+	// spot the gap in event sequence numbers & resume to recover what they
+	// missed
+	DropEvents SlowConsumerPolicy = iota
+	// Disconnect closes the connection
+	Disconnect
+)
+
+// String implements the fmt.Stringer interface
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case DropEvents:
+		return "drop"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// Options configures how messages are delivered to connections
+type Options struct {
+	// QueueSize caps the number of messages waiting to be sent to each
+	// connection
+	QueueSize int
+	// WriteTimeout bounds writing a single message to a websocket connection.
+	// Connections that time out are closed
+	WriteTimeout time.Duration
+	// SlowConsumers decides what happens to connections that fall far enough
+	// behind to fill their queue
+	SlowConsumers SlowConsumerPolicy
+	// Call runs lib methods called over websocket connections
+	Call CallFunc
+}
+
+// Option is a function that adjusts handler options
+type Option func(o *Options)
+
+// DefaultOptions returns the default handler configuration
+func DefaultOptions() *Options {
+	return &Options{
+		QueueSize:     DefaultQueueSize,
+		WriteTimeout:  DefaultWriteTimeout,
+		SlowConsumers: DropEvents,
+	}
+}
+
+// OptQueueSize sets the outbound queue size of each connection. Sizes below
+// one keep the default
+func OptQueueSize(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.QueueSize = n
+		}
+	}
+}
+
+// OptWriteTimeout sets the time allowed for writing a message to a websocket
+// connection. A zero duration keeps the default
+func OptWriteTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		if d > 0 {
+			o.WriteTimeout = d
+		}
+	}
+}
+
+// OptSlowConsumerPolicy sets what happens to connections that fill their
+// outbound queue
+func OptSlowConsumerPolicy(p SlowConsumerPolicy) Option {
+	return func(o *Options) {
+		o.SlowConsumers = p
+	}
+}
+
+const (
+	// TransportWebsocket is the transport of websocket connections
+	TransportWebsocket = "websocket"
+	// TransportSSE is the transport of server-sent event streams
+	TransportSSE = "sse"
+)
+
+// QueueStats summarizes the outbound queues of all connections over one
+// transport. Stats aren't broken down by connection, details of connections
+// that fall behind are logged instead
+type QueueStats struct {
+	// Transport is TransportWebsocket or TransportSSE
+	Transport string
+	// Conns is the number of open connections
+	Conns int
+	// QueueDepth is the number of messages waiting to be sent, summed over
+	// connections
+	QueueDepth int
+	// Dropped counts events discarded because a queue was full, including
+	// events for connections that have since closed
+	Dropped uint64
+}
+
+// outMessage is a message waiting to be sent to a connection. seq is the
+// sequence number of event messages
+type outMessage struct {
+	seq uint64
+	msg interface{}
+}
+
+// tryQueue adds a message to the connection's outbound queue without blocking,
+// reporting false if the queue is full
+func (c *conn) tryQueue(m outMessage) bool {
+	select {
+	case c.out <- m:
+		return true
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// queue adds a message to the connection's outbound queue, waiting for room if
+// the queue is full
+func (c *conn) queue(ctx context.Context, m outMessage) error {
+	select {
+	case c.out <- m:
+		return nil
+	case <-c.done:
+		return errNotFound
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send writes a message to the connection
+func (c *conn) send(timeout time.Duration, m outMessage) error {
+	if c.stream != nil {
+		return c.stream.writeEvent(m.seq, m.msg)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// writes that miss the deadline close the connection
+	return wsjson.Write(ctx, c.conn, m.msg)
+}
+
+// writeLoop sends queued messages to a connection until it closes. It is the
+// only goroutine that writes to the connection
+func (h *connections) writeLoop(c *conn) {
+	for {
+		select {
+		case m := <-c.out:
+			if err := c.send(h.opts.WriteTimeout, m); err != nil {
+				log.Debugw("connection write", "id", c.id, "err", err)
+				h.removeConn(c.id)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// transport returns the transport a connection uses
+func (c *conn) transport() string {
+	if c.stream != nil {
+		return TransportSSE
+	}
+	return TransportWebsocket
+}
+
+// slowConsumer applies the slow consumer policy to a connection with a full
+// queue, subscribed to events for profileID
+func (h *connections) slowConsumer(c *conn, profileID string) {
+	dropped := atomic.AddUint64(&c.dropped, 1)
+	if c.stream != nil {
+		atomic.AddUint64(&h.droppedSSE, 1)
+	} else {
+		atomic.AddUint64(&h.droppedWebsocket, 1)
+	}
+	if h.opts.SlowConsumers == Disconnect {
+		log.Infow("disconnecting slow consumer", "id", c.id, "profileID", profileID, "transport", c.transport(), "remoteAddr", c.remoteAddr)
+		// closing a websocket waits on the close handshake, which mustn't
+		// hold up publishing to other connections
+		go h.closeConn(c.id, websocket.StatusPolicyViolation, "connection is too slow")
+		return
+	}
+	// log the first drop & every hundredth after, so a connection that stays
+	// behind doesn't flood the log
+	if dropped == 1 || dropped%100 == 0 {
+		log.Infow("dropping events for slow consumer", "id", c.id, "profileID", profileID, "transport", c.transport(), "remoteAddr", c.remoteAddr, "dropped", dropped)
+	}
+}
+
+// Stats returns the outbound queue state of connections, one QueueStats for
+// each transport
+func (h *connections) Stats() []QueueStats {
+	stats := []QueueStats{
+		{Transport: TransportWebsocket, Dropped: atomic.LoadUint64(&h.droppedWebsocket)},
+		{Transport: TransportSSE, Dropped: atomic.LoadUint64(&h.droppedSSE)},
+	}
+
+	h.connsLock.Lock()
+	defer h.connsLock.Unlock()
+	for _, c := range h.conns {
+		st := &stats[0]
+		if c.stream != nil {
+			st = &stats[1]
+		}
+		st.Conns++
+		st.QueueDepth += len(c.out)
+	}
+	return stats
+}
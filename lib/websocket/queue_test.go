@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/affix-io/affix/auth/key"
+	"github.com/affix-io/affix/event"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSlowConsumers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	profileID := "profile_id"
+
+	cases := []struct {
+		policy       SlowConsumerPolicy
+		expectStats  []QueueStats
+		expectClosed bool
+		// publishing doesn't wait for slow consumers to disconnect, so events
+		// can be dropped until the connection is removed
+		minDropped uint64
+	}{
+		{DropEvents, []QueueStats{{Transport: TransportWebsocket}, {Transport: TransportSSE, Conns: 1, QueueDepth: 2}}, false, 3},
+		{Disconnect, []QueueStats{{Transport: TransportWebsocket}, {Transport: TransportSSE}}, true, 1},
+	}
+
+	for _, c := range cases {
+		ks, err := key.NewMemStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler, err := NewHandler(ctx, event.NewBus(ctx), ks, OptQueueSize(2), OptSlowConsumerPolicy(c.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := handler.(*connections)
+
+		// a subscribed connection without a writeLoop never drains its queue
+		rec := httptest.NewRecorder()
+		sc := &conn{
+			id:        "conn",
+			profileID: profileID,
+			stream:    newEventStream(rec, rec),
+			out:       make(chan outMessage, h.opts.QueueSize),
+			done:      make(chan struct{}),
+			cancel:    func() {},
+		}
+		h.conns[sc.id] = sc
+		h.subscriptions[profileID] = connectionSet{sc.id: struct{}{}}
+
+		published := make(chan struct{})
+		go func() {
+			for i := 0; i < 5; i++ {
+				h.messageHandler(ctx, event.Event{Type: "automation:deploy:run", ProfileID: profileID})
+			}
+			close(published)
+		}()
+		select {
+		case <-published:
+		case <-time.After(time.Second):
+			t.Fatalf("case %q: publishing blocked on a slow consumer", c.policy)
+		}
+
+		if c.expectClosed {
+			select {
+			case <-sc.stream.done:
+			case <-time.After(time.Second):
+				t.Errorf("case %q: expected slow consumer to be disconnected", c.policy)
+			}
+		}
+		got := h.Stats()
+		if diff := cmp.Diff(c.expectStats, got, cmpopts.IgnoreFields(QueueStats{}, "Dropped")); diff != "" {
+			t.Errorf("case %q: stats mismatch (-want +got):\n%s", c.policy, diff)
+		}
+		if got[1].Dropped < c.minDropped {
+			t.Errorf("case %q: expected at least %d dropped events, got %d", c.policy, c.minDropped, got[1].Dropped)
+		}
+	}
+}
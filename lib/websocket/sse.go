@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apiutil "github.com/affix-io/affix/api/util"
+	"github.com/affix-io/affix/auth/token"
+)
+
+// heartbeatInterval is how often an idle event stream sends a comment, which
+// keeps proxies from timing out the request
+var heartbeatInterval = 15 * time.Second
+
+var errStreamClosed = fmt.Errorf("event stream closed")
+
+// eventStream writes server-sent events to an open response. events written
+// before the stream starts are held until it does, so a stream can subscribe
+// to events before deciding whether to respond with an error, and replay
+// missed events ahead of ones that arrived live
+type eventStream struct {
+	lock    sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+	pending []sequencedString
+	closed  bool
+	done    chan struct{}
+}
+
+type sequencedString struct {
+	seq uint64
+	str string
+}
+
+func newEventStream(w http.ResponseWriter, flusher http.Flusher) *eventStream {
+	return &eventStream{
+		w:       w,
+		flusher: flusher,
+		done:    make(chan struct{}),
+	}
+}
+
+// writeEvent sends an event message, using the sequence number as the event ID
+// so reconnecting clients can resume with the Last-Event-ID header
+func (s *eventStream) writeEvent(seq uint64, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.write(seq, fmt.Sprintf("id: %d\ndata: %s\n\n", seq, data))
+}
+
+// heartbeat sends a comment line, which clients ignore
+func (s *eventStream) heartbeat() error {
+	return s.write(0, ": heartbeat\n\n")
+}
+
+// start writes the response headers & any pending events in sequence order
+func (s *eventStream) start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	h := s.w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+	sort.SliceStable(s.pending, func(i, j int) bool { return s.pending[i].seq < s.pending[j].seq })
+	for _, p := range s.pending {
+		if _, err := s.w.Write([]byte(p.str)); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+	s.started = true
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *eventStream) write(seq uint64, str string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	if !s.started {
+		s.pending = append(s.pending, sequencedString{seq: seq, str: str})
+		return nil
+	}
+	if _, err := s.w.Write([]byte(str)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// close ends the stream. the response can't be written to once the handler
+// serving it returns, so writes after close fail
+func (s *eventStream) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// ServeEvents implements the Handler interface. Requests must carry a bearer
+// token, which subscribes the stream to the token's profile the same way a
+// websocket "subscribe:request" message does. The "types", "initIDs" and
+// "workflowIDs" query parameters filter events like a "subscribe:topics"
+// message, and reconnecting clients are sent events they missed after the
+// Last-Event-ID header
+func (h *connections) ServeEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiutil.WriteErrResponse(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	c := h.addConn(nil, newEventStream(w, flusher), r.RemoteAddr)
+	id := c.id
+	defer h.removeConn(id)
+
+	c.setFilter(newEventFilter(&topicsMessage{
+		Types:       queryList(r, "types"),
+		InitIDs:     queryList(r, "initIDs"),
+		WorkflowIDs: queryList(r, "workflowIDs"),
+	}))
+	if err := h.subscribeConn(id, token.FromCtx(r.Context())); err != nil {
+		log.Debugw("event stream subscribeConn", "error", err, "connection id", id)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="affix", error="invalid_token"`)
+		apiutil.WriteErrResponse(w, http.StatusUnauthorized, token.ErrInvalidToken)
+		return
+	}
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		seq, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			log.Debugw("event stream Last-Event-ID", "error", err, "connection id", id)
+		} else if _, _, err := h.resume(r.Context(), c, seq); err != nil {
+			log.Debugw("event stream resume", "error", err, "connection id", id)
+		}
+	}
+	if err := c.stream.start(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.stream.heartbeat(); err != nil {
+				return
+			}
+		case <-c.stream.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// EndStreams implements the Handler interface
+func (h *connections) EndStreams() {
+	h.connsLock.Lock()
+	defer h.connsLock.Unlock()
+	for _, c := range h.conns {
+		if c.stream != nil {
+			c.stream.close()
+		}
+	}
+}
+
+// queryList reads a query parameter as a list, accepting both repeated and
+// comma-separated values
+func queryList(r *http.Request, name string) []string {
+	var list []string
+	for _, v := range r.URL.Query()[name] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	testkeys "github.com/affix-io/affix/auth/key/test"
+	"github.com/affix-io/affix/auth/token"
+	"github.com/affix-io/affix/event"
+)
+
+func TestServeEvents(t *testing.T) {
+	prevInterval := heartbeatInterval
+	heartbeatInterval = 50 * time.Millisecond
+	defer func() { heartbeatInterval = prevInterval }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wsh, _ := newTestHandler(ctx, t)
+	s := httptest.NewServer(token.OAuthTokenMiddleware(http.HandlerFunc(wsh.ServeEvents)))
+	defer s.Close()
+
+	kd := testkeys.GetKeyData(0)
+	profileID := kd.KeyID.String()
+	tokenStr, err := token.NewPrivKeyAuthToken(kd.PrivKey, profileID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unauthenticated request to respond %d, got %d", http.StatusUnauthorized, res.StatusCode)
+	}
+
+	// events published while no client is connected
+	for _, typ := range []event.Type{"automation:deploy:start", "dataset:save:started", "automation:deploy:end"} {
+		wsh.messageHandler(ctx, event.Event{Type: typ, ProfileID: profileID})
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"?types=automation:", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenStr)
+	req.Header.Set("Last-Event-ID", "1")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected content type %q, got %q", "text/event-stream", ct)
+	}
+	sse := &sseReader{t: t, r: bufio.NewReader(res.Body)}
+
+	// the filtered save event isn't replayed
+	if id, e := sse.next(); id != "3" || e["type"] != "automation:deploy:end" {
+		t.Errorf("expected replayed event 3 of type %q, got %s %v", "automation:deploy:end", id, e)
+	}
+
+	wsh.messageHandler(ctx, event.Event{Type: "dataset:save:started", ProfileID: profileID})
+	wsh.messageHandler(ctx, event.Event{Type: "automation:deploy:start", ProfileID: profileID})
+	id, e := sse.next()
+	if id != "5" || e["type"] != "automation:deploy:start" {
+		t.Errorf("expected live event 5 of type %q, got %s %v", "automation:deploy:start", id, e)
+	}
+	for _, key := range []string{"type", "ts", "sessionID", "seq", "data"} {
+		if _, ok := e[key]; !ok {
+			t.Errorf("expected event to have field %q", key)
+		}
+	}
+
+	if !sse.heartbeat() {
+		t.Error("expected a heartbeat comment")
+	}
+
+	wsh.EndStreams()
+	if _, err := io.ReadAll(sse.r); err != nil {
+		t.Errorf("expected ending streams to end the response, got: %s", err)
+	}
+	wsh.connsLock.Lock()
+	defer wsh.connsLock.Unlock()
+	if len(wsh.conns) != 0 {
+		t.Errorf("expected stream connection to be removed, have %d connections", len(wsh.conns))
+	}
+}
+
+type sseReader struct {
+	t *testing.T
+	r *bufio.Reader
+}
+
+// next reads the ID & data of the next event, skipping comments
+func (s *sseReader) next() (string, map[string]interface{}) {
+	var id string
+	for {
+		line := s.readLine()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			e := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				s.t.Fatal(err)
+			}
+			return id, e
+		}
+	}
+}
+
+// heartbeat reports whether the next line is a heartbeat comment
+func (s *sseReader) heartbeat() bool {
+	for {
+		if line := s.readLine(); line != "" {
+			return line == ": heartbeat"
+		}
+	}
+}
+
+func (s *sseReader) readLine() string {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
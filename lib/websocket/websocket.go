@@ -45,6 +45,18 @@ func setIDRand(r io.Reader) {
 // Handler defines the handler interface
 type Handler interface {
 	ConnectionHandler(w http.ResponseWriter, r *http.Request)
+	// ServeEvents streams events to an authenticated client as server-sent
+	// events
+	ServeEvents(w http.ResponseWriter, r *http.Request)
+	// EndStreams ends all server-sent event streams. http.Server.Shutdown
+	// waits for requests to finish, which streams otherwise never do
+	EndStreams()
+	// Stats returns the outbound queue state of connections by transport
+	Stats() []QueueStats
+	// Shutdown closes all connections with a "going away" close frame,
+	// returning early with an error if the context is cancelled before all
+	// clients acknowledge
+	Shutdown(ctx context.Context) error
 }
 
 type connectionSet map[string]struct{}
@@ -52,30 +64,97 @@ type connectionSet map[string]struct{}
 // connections maintains the set of active websocket connections & associated
 // connection metadata
 type connections struct {
+	// dropped counters are accessed atomically, & must be first for 64-bit
+	// alignment
+	droppedWebsocket uint64
+	droppedSSE       uint64
+
 	conns         map[string]*conn
 	connsLock     sync.Mutex
 	keystore      key.Store
 	subscriptions map[string]connectionSet
 	subsLock      sync.Mutex
+	history       *eventHistory
+	opts          Options
 }
 
 type conn struct {
+	// dropped is accessed atomically, & must be first for 64-bit alignment
+	dropped uint64
+
 	id        string
 	profileID string
-	conn      *websocket.Conn
+	// token is the token the connection subscribed with
+	token      string
+	remoteAddr string
+	conn       *websocket.Conn
+	// stream is set in place of conn for server-sent event clients
+	stream *eventStream
+	// liveFrom is the sequence number of the latest event published to the
+	// profile when the connection subscribed. later events are sent live,
+	// earlier ones can be replayed with a resume message
+	liveFrom uint64
+
+	filterLock sync.Mutex
+	filter     *eventFilter
+
+	// out queues messages for the connection's writeLoop
+	out       chan outMessage
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// ctx is cancelled when the connection closes, ending running calls
+	ctx    context.Context
+	cancel context.CancelFunc
+	calls  chan struct{}
+}
+
+// setFilter replaces the filter deciding which events are sent to the
+// connection
+func (c *conn) setFilter(f *eventFilter) {
+	c.filterLock.Lock()
+	defer c.filterLock.Unlock()
+	c.filter = f
+}
+
+// wants reports whether an event passes the connection's filter
+func (c *conn) wants(e event.Event) bool {
+	c.filterLock.Lock()
+	defer c.filterLock.Unlock()
+	return c.filter.match(e)
+}
+
+// close ends the connection, sending websocket clients a close frame with the
+// given status code. Queued messages are discarded
+func (c *conn) close(code websocket.StatusCode, reason string) error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.cancel()
+	})
+	if c.stream != nil {
+		c.stream.close()
+		return nil
+	}
+	return c.conn.Close(code, reason)
 }
 
 var _ Handler = (*connections)(nil)
 
 // NewHandler creates a new connections instance that clients
 // can connect to in order to get realtime events
-func NewHandler(ctx context.Context, bus event.Bus, keystore key.Store) (Handler, error) {
+func NewHandler(ctx context.Context, bus event.Bus, keystore key.Store, opts ...Option) (Handler, error) {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
 	ws := &connections{
 		conns:         map[string]*conn{},
 		connsLock:     sync.Mutex{},
 		keystore:      keystore,
 		subscriptions: map[string]connectionSet{},
 		subsLock:      sync.Mutex{},
+		history:       newEventHistory(historySize),
+		opts:          *o,
 	}
 
 	bus.SubscribeAll(ws.messageHandler)
@@ -92,52 +171,146 @@ func (h *connections) ConnectionHandler(w http.ResponseWriter, r *http.Request)
 		log.Debugf("Websocket accept error: %s", err)
 		return
 	}
-	id := newID()
+	c := h.addConn(wsc, nil, r.RemoteAddr)
+	go h.read(c.id)
+}
+
+// addConn registers a websocket connection or event stream, starting its
+// writeLoop
+func (h *connections) addConn(wsc *websocket.Conn, stream *eventStream, remoteAddr string) *conn {
+	ctx, cancel := context.WithCancel(context.Background())
 	c := &conn{
-		id:   id,
-		conn: wsc,
+		id:         newID(),
+		remoteAddr: remoteAddr,
+		conn:       wsc,
+		stream:     stream,
+		out:        make(chan outMessage, h.opts.QueueSize),
+		done:       make(chan struct{}),
+		ctx:        ctx,
+		cancel:     cancel,
+		calls:      make(chan struct{}, maxConcurrentCalls),
 	}
 	h.connsLock.Lock()
-	defer h.connsLock.Unlock()
-	h.conns[id] = c
-	go h.read(id)
+	h.conns[c.id] = c
+	h.connsLock.Unlock()
+	go h.writeLoop(c)
+	return c
 }
 
-func (h *connections) messageHandler(_ context.Context, e event.Event) error {
-	ctx := context.Background()
-	evt := map[string]interface{}{
-		"type":      string(e.Type),
-		"ts":        e.Timestamp,
-		"sessionID": e.SessionID,
-		"data":      e.Payload,
+// Shutdown implements the Handler interface. websocket connections are
+// hijacked from the HTTP server, so they aren't closed by http.Server.Shutdown.
+// event streams are ended so their requests can finish
+func (h *connections) Shutdown(ctx context.Context) error {
+	h.connsLock.Lock()
+	conns := make([]*conn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.connsLock.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *conn) {
+			defer wg.Done()
+			// the read loop removes the connection once the close handshake ends
+			if err := c.close(websocket.StatusGoingAway, "server shutting down"); err != nil {
+				log.Debugw("closing websocket", "id", c.id, "err", err)
+			}
+		}(c)
 	}
 
-	profileIDString := e.ProfileID
-	if profileIDString == "" {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
 		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("closing websocket connections: %w", ctx.Err())
 	}
-	connIDs, err := h.getConnIDs(profileIDString)
-	if err != nil {
-		log.Errorf("profile %q: %w", profileIDString, err)
+}
+
+// messageHandler queues events for the connections subscribed to the event's
+// profile. It never waits on a connection, connections with a full queue are
+// handled by the slow consumer policy
+func (h *connections) messageHandler(_ context.Context, e event.Event) error {
+	profileIDString := e.ProfileID
+	if profileIDString == "" {
 		return nil
 	}
+	seq, connIDs := h.record(profileIDString, e)
+	evt := eventMessage(seq, e)
 
-	for connID := range connIDs {
+	for _, connID := range connIDs {
 		c, err := h.getConn(connID)
 		if err != nil {
 			h.unsubscribeConn(profileIDString, connID)
-			log.Errorf("connection %q, profile %q: %w", connID, profileIDString, err)
-			return nil
+			log.Errorf("connection %q, profile %q: %s", connID, profileIDString, err)
+			continue
+		}
+		if !c.wants(e) {
+			continue
 		}
 		log.Debugf("sending event %q to websocket conns %q", e.Type, profileIDString)
-		if err := wsjson.Write(ctx, c.conn, evt); err != nil {
-			log.Errorf("connection %q: wsjson write error: %s", profileIDString, err)
-			return nil
+		if !c.tryQueue(outMessage{seq: seq, msg: evt}) {
+			h.slowConsumer(c, profileIDString)
 		}
 	}
 	return nil
 }
 
+// record adds an event to the history of a profile, returning the event's
+// sequence number & the IDs of connections subscribed to the profile when it
+// was recorded
+func (h *connections) record(profileID string, e event.Event) (uint64, []string) {
+	h.subsLock.Lock()
+	defer h.subsLock.Unlock()
+	seq := h.history.add(profileID, e)
+	connIDs := make([]string, 0, len(h.subscriptions[profileID]))
+	for id := range h.subscriptions[profileID] {
+		connIDs = append(connIDs, id)
+	}
+	return seq, connIDs
+}
+
+// eventMessage is the JSON structure events are sent to clients in
+func eventMessage(seq uint64, e event.Event) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      string(e.Type),
+		"ts":        e.Timestamp,
+		"sessionID": e.SessionID,
+		"seq":       seq,
+		"data":      e.Payload,
+	}
+}
+
+// resume sends a subscribed connection the events published to its profile
+// after seq that were published before it subscribed. complete is false if
+// some of those events are no longer kept
+func (h *connections) resume(ctx context.Context, c *conn, seq uint64) (replayed int, complete bool, err error) {
+	h.subsLock.Lock()
+	profileID, liveFrom := c.profileID, c.liveFrom
+	h.subsLock.Unlock()
+	if profileID == "" {
+		return 0, false, fmt.Errorf("connection must subscribe before resuming")
+	}
+
+	events, complete := h.history.between(profileID, seq, liveFrom)
+	for _, e := range events {
+		if !c.wants(e.Event) {
+			continue
+		}
+		if err := c.queue(ctx, outMessage{seq: e.seq, msg: eventMessage(e.seq, e.Event)}); err != nil {
+			return replayed, complete, err
+		}
+		replayed++
+	}
+	return replayed, complete, nil
+}
+
 // getConn gets a *conn from the map of connections
 func (h *connections) getConn(id string) (*conn, error) {
 	h.connsLock.Lock()
@@ -191,16 +364,18 @@ func (h *connections) subscribeConn(connID, tokenString string) error {
 	if err != nil {
 		return fmt.Errorf("connection %q: %w", connID, err)
 	}
-	c.profileID = claims.Subject
 
 	h.subsLock.Lock()
 	defer h.subsLock.Unlock()
+	c.profileID = claims.Subject
+	c.token = tokenString
 	connIDs, ok := h.subscriptions[claims.Subject]
 	if !ok || connIDs == nil {
 		connIDs = connectionSet{}
 	}
 	connIDs[connID] = struct{}{}
 	h.subscriptions[claims.Subject] = connIDs
+	c.liveFrom = h.history.last(claims.Subject)
 	log.Debugw("subscribeConn", "id", connID)
 	return nil
 }
@@ -208,22 +383,19 @@ func (h *connections) subscribeConn(connID, tokenString string) error {
 // unsubscribeConn remove the profileID and connID from the map of "subscribed"
 // connections
 func (h *connections) unsubscribeConn(profileID, connID string) {
-	connIDs, err := h.getConnIDs(profileID)
-	if err != nil {
-		return
-	}
-	for cid := range connIDs {
+	h.subsLock.Lock()
+	defer h.subsLock.Unlock()
+	for cid := range h.subscriptions[profileID] {
 		if connID == "" || cid == connID {
 			c, err := h.getConn(cid)
 			if err != nil || c == nil {
 				continue
 			}
 			c.profileID = ""
+			c.token = ""
 		}
 	}
 
-	h.subsLock.Lock()
-	defer h.subsLock.Unlock()
 	if connID == "" {
 		delete(h.subscriptions, profileID)
 	} else {
@@ -239,12 +411,18 @@ func (h *connections) unsubscribeConn(profileID, connID string) {
 // removeConn removes the conn from the map of connections and subscriptions
 // closing the connection if needed
 func (h *connections) removeConn(id string) {
+	h.closeConn(id, websocket.StatusNormalClosure, "pruning connection")
+}
+
+// closeConn removes the conn from the map of connections and subscriptions,
+// closing the connection with the given status code
+func (h *connections) closeConn(id string, code websocket.StatusCode, reason string) {
 	c, err := h.getConn(id)
 	if err != nil {
 		return
 	}
 	defer func() {
-		c.conn.Close(websocket.StatusNormalClosure, "pruning connection")
+		c.close(code, reason)
 	}()
 	if c.profileID != "" {
 		h.unsubscribeConn(c.profileID, id)
@@ -292,6 +470,32 @@ func (h *connections) handleMessage(ctx context.Context, c *conn, msg *message)
 			return
 		}
 		h.write(ctx, c, &message{Type: subscribeSuccess})
+	case subscribeTopics:
+		topicsMsg := &topicsMessage{}
+		if err := json.Unmarshal(msg.Payload, topicsMsg); err != nil {
+			log.Debugw("websocket unmarshal", "error", err, "connection id", c.id, "msg", msg)
+			h.write(ctx, c, &message{Type: subscribeTopicsFailure, Error: err})
+			return
+		}
+		c.setFilter(newEventFilter(topicsMsg))
+		h.write(ctx, c, &message{Type: subscribeTopicsSuccess})
+	case resumeRequest:
+		resumeMsg := &resumeMessage{}
+		if err := json.Unmarshal(msg.Payload, resumeMsg); err != nil {
+			log.Debugw("websocket unmarshal", "error", err, "connection id", c.id, "msg", msg)
+			h.write(ctx, c, &message{Type: resumeFailure, Error: err})
+			return
+		}
+		replayed, complete, err := h.resume(ctx, c, resumeMsg.Seq)
+		if err != nil {
+			log.Debugw("resume", "error", err, "connection id", c.id, "msg", msg)
+			h.write(ctx, c, &message{Type: resumeFailure, Error: err})
+			return
+		}
+		payload, _ := json.Marshal(resumeResult{Replayed: replayed, Complete: complete})
+		h.write(ctx, c, &message{Type: resumeSuccess, Payload: payload})
+	case callRequest:
+		h.call(c, msg.Payload)
 	case unsubscribeRequest:
 		h.unsubscribeConn(c.profileID, c.id)
 	default:
@@ -299,15 +503,12 @@ func (h *connections) handleMessage(ctx context.Context, c *conn, msg *message)
 	}
 }
 
-// write sends a json message over the connection
+// write queues a json message for the connection. Replies wait for room in
+// the queue instead of being dropped
 func (h *connections) write(ctx context.Context, c *conn, msg *message) {
 	log.Debugf("sending message %q to websocket conns %q", msg.Type, c.id)
-	if err := wsjson.Write(ctx, c.conn, msg); err != nil {
-		log.Errorf("connection %q: wsjson write error: %s", c.id, err)
-		// the connection will close if there is any `write` error
-		// we must remove it from our own stores, so as not to hold
-		// onto any dead connections
-		h.removeConn(c.id)
+	if err := c.queue(ctx, outMessage{msg: msg}); err != nil {
+		log.Debugw("queueing message", "id", c.id, "err", err)
 	}
 }
 
@@ -327,6 +528,37 @@ const (
 	// upgrade to an authenticated connection
 	// payload is nil
 	subscribeFailure = msgType("subscribe:failure")
+	// subscribeTopics narrows the events sent to the connection to those
+	// matching the topics in the payload, replacing any previous topics
+	// payload is a `topicsMessage`
+	subscribeTopics = msgType("subscribe:topics")
+	// subscribeTopicsSuccess indicates the connection's topics were set
+	// payload is nil
+	subscribeTopicsSuccess = msgType("subscribe:topics:success")
+	// subscribeTopicsFailure indicates the topics message was invalid
+	// payload is nil
+	subscribeTopicsFailure = msgType("subscribe:topics:failure")
+	// resumeRequest replays the events published to a subscribed connection's
+	// profile after the sequence number in the payload, up to when the
+	// connection subscribed. events since subscribing are sent live, so
+	// clients should resume right after subscribing
+	// payload is a `resumeMessage`
+	resumeRequest = msgType("resume")
+	// resumeSuccess is sent after all missed events have been replayed
+	// payload is a `resumeResult`
+	resumeSuccess = msgType("resume:success")
+	// resumeFailure indicates events couldn't be replayed
+	// payload is nil
+	resumeFailure = msgType("resume:failure")
+	// callRequest runs a lib method, checked against the same permissions as
+	// an HTTP request made with the token the connection subscribed with.
+	// unsubscribed connections call methods anonymously
+	// payload is a `callMessage`
+	callRequest = msgType("call")
+	// callResponse is sent when a call finishes. calls run concurrently, so
+	// responses can arrive in a different order than calls were made
+	// payload is a `callResult`
+	callResponse = msgType("response")
 	// unsubscribeRequest indicates the connection no longer wants
 	// to be authenticated
 	// payload is nil
@@ -345,3 +577,18 @@ type message struct {
 type subscribeMessage struct {
 	Token string `json:"token"`
 }
+
+// resumeMessage is the expected structure of an incoming "resume" message
+type resumeMessage struct {
+	// Seq is the sequence number of the last event the client received
+	Seq uint64 `json:"seq"`
+}
+
+// resumeResult is the payload of a "resume:success" message
+type resumeResult struct {
+	// Replayed is the number of events sent
+	Replayed int `json:"replayed"`
+	// Complete is false if some missed events were no longer kept, and
+	// couldn't be replayed
+	Complete bool `json:"complete"`
+}
@@ -4,16 +4,21 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/affix-io/affix/auth/key"
 	testkeys "github.com/affix-io/affix/auth/key/test"
 	"github.com/affix-io/affix/auth/token"
 	"github.com/affix-io/affix/event"
+	"github.com/google/go-cmp/cmp"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
 )
 
 func TestWebsocket(t *testing.T) {
@@ -52,6 +57,7 @@ func TestWebsocket(t *testing.T) {
 	setIDRand(strings.NewReader(randIDStr))
 	connID := newID()
 	setIDRand(strings.NewReader(randIDStr))
+	defer setIDRand(nil)
 
 	wsh.ConnectionHandler(mockWriterAndRequest())
 	if _, err := wsh.getConn(connID); err != nil {
@@ -131,6 +137,7 @@ func TestWebsocketUnsubscribe(t *testing.T) {
 	setIDRand(strings.NewReader(randIDStr))
 	connID := newID()
 	setIDRand(strings.NewReader(randIDStr))
+	defer setIDRand(nil)
 
 	wsh.ConnectionHandler(mockWriterAndRequest())
 	if _, err := wsh.getConn(connID); err != nil {
@@ -162,6 +169,164 @@ func TestWebsocketUnsubscribe(t *testing.T) {
 	}
 }
 
+func TestWebsocketShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ks, err := key.NewMemStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	websocketHandler, err := NewHandler(ctx, event.NewBus(ctx), ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(http.HandlerFunc(websocketHandler.ConnectionHandler))
+	defer s.Close()
+
+	wsc, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(s.URL, "http"), &websocket.DialOptions{
+		Subprotocols: []string{affixWebsocketProtocol},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// clients must read to respond to the close handshake
+	readErr := make(chan error)
+	go func() {
+		_, _, err := wsc.Read(ctx)
+		readErr <- err
+	}()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, time.Second*5)
+	defer shutdownCancel()
+	if err := websocketHandler.Shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+	if status := websocket.CloseStatus(<-readErr); status != websocket.StatusGoingAway {
+		t.Errorf("expected close status %s, got %s", websocket.StatusGoingAway, status)
+	}
+}
+
+// testClient is a websocket client connected to a handler
+type testClient struct {
+	t   *testing.T
+	ctx context.Context
+	wsc *websocket.Conn
+}
+
+// newTestHandler creates a handler that accepts tokens signed by the first
+// test key, serving it over HTTP
+func newTestHandler(ctx context.Context, t *testing.T, opts ...Option) (*connections, *httptest.Server) {
+	kd := testkeys.GetKeyData(0)
+	ks, err := key.NewMemStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.AddPubKey(ctx, kd.KeyID, kd.PrivKey.GetPublic()); err != nil {
+		t.Fatal(err)
+	}
+	websocketHandler, err := NewHandler(ctx, event.NewBus(ctx), ks, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsh := websocketHandler.(*connections)
+	s := httptest.NewServer(http.HandlerFunc(wsh.ConnectionHandler))
+	t.Cleanup(s.Close)
+	return wsh, s
+}
+
+// dial connects to a test server without subscribing
+func dial(ctx context.Context, t *testing.T, s *httptest.Server) *testClient {
+	wsc, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(s.URL, "http"), &websocket.DialOptions{
+		Subprotocols: []string{affixWebsocketProtocol},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { wsc.Close(websocket.StatusNormalClosure, "") })
+	return &testClient{t: t, ctx: ctx, wsc: wsc}
+}
+
+// dialSubscribed connects to a test server, subscribing as the first test key
+func dialSubscribed(ctx context.Context, t *testing.T, s *httptest.Server) *testClient {
+	kd := testkeys.GetKeyData(0)
+	tokenStr, err := token.NewPrivKeyAuthToken(kd.PrivKey, kd.KeyID.String(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dial(ctx, t, s)
+	c.send(subscribeRequest, subscribeMessage{Token: tokenStr})
+	c.expect(string(subscribeSuccess))
+	return c
+}
+
+func (c *testClient) send(typ msgType, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := wsjson.Write(c.ctx, c.wsc, message{Type: typ, Payload: data}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads the next message, failing if it isn't of type typ
+func (c *testClient) expect(typ string) map[string]interface{} {
+	msg := map[string]interface{}{}
+	if err := wsjson.Read(c.ctx, c.wsc, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	if msg["type"] != typ {
+		c.t.Fatalf("expected a %q message, got: %v", typ, msg)
+	}
+	return msg
+}
+
+func TestWebsocketTopics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wsh, s := newTestHandler(ctx, t)
+	c := dialSubscribed(ctx, t, s)
+
+	c.send(subscribeTopics, topicsMessage{Types: []string{"automation:"}})
+	c.expect(string(subscribeTopicsSuccess))
+
+	profileID := testkeys.GetKeyData(0).KeyID.String()
+	wsh.messageHandler(ctx, event.Event{Type: "dataset:save:progress", ProfileID: profileID})
+	wsh.messageHandler(ctx, event.Event{Type: "automation:deploy:start", ProfileID: profileID})
+	// the filtered save event is never sent, so the deploy event is next
+	c.expect("automation:deploy:start")
+}
+
+func TestWebsocketResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wsh, s := newTestHandler(ctx, t)
+	profileID := testkeys.GetKeyData(0).KeyID.String()
+
+	// events published while no client is connected
+	for _, typ := range []event.Type{"automation:deploy:start", "automation:deploy:run", "automation:deploy:end"} {
+		wsh.messageHandler(ctx, event.Event{Type: typ, ProfileID: profileID})
+	}
+
+	c := dialSubscribed(ctx, t, s)
+	c.send(resumeRequest, resumeMessage{Seq: 1})
+	if seq := c.expect("automation:deploy:run")["seq"]; seq != float64(2) {
+		t.Errorf("expected sequence number 2, got %v", seq)
+	}
+	c.expect("automation:deploy:end")
+	res := c.expect(string(resumeSuccess))
+	expect := map[string]interface{}{"replayed": float64(2), "complete": true}
+	if diff := cmp.Diff(expect, res["payload"]); diff != "" {
+		t.Errorf("resume result mismatch (-want +got):\n%s", diff)
+	}
+
+	wsh.messageHandler(ctx, event.Event{Type: "dataset:save:started", ProfileID: profileID})
+	if seq := c.expect("dataset:save:started")["seq"]; seq != float64(4) {
+		t.Errorf("expected live event sequence number 4, got %v", seq)
+	}
+}
+
 func mockWriterAndRequest() (http.ResponseWriter, *http.Request) {
 	w := mockHijacker{
 		ResponseWriter: httptest.NewRecorder(),
@@ -0,0 +1,57 @@
+// Package requestid carries request identifiers through a context, tying
+// together log lines, events and responses that stem from the same request
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header is the HTTP header field request identifiers are read from & written
+// to
+const Header = "X-Request-ID"
+
+// maxLength caps the length of request identifiers accepted from clients
+const maxLength = 128
+
+// ctxKey is the key for adding a request ID to a context.Context
+type ctxKey struct{}
+
+// New generates a random request identifier
+func New() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand only fails if the OS randomness source is broken
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Valid reports whether a client-provided request ID is safe to accept: non
+// empty, no longer than 128 bytes, and made of printable ASCII characters
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// AddToContext adds a request ID to a context
+func AddToContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromCtx extracts a request ID from a context, returning the empty string if
+// none is set
+func FromCtx(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if got := FromCtx(ctx); got != "" {
+		t.Errorf("expected empty context to have no request ID, got %q", got)
+	}
+	ctx = AddToContext(ctx, "abc")
+	if got := FromCtx(ctx); got != "abc" {
+		t.Errorf("request ID mismatch. expected: %q, got: %q", "abc", got)
+	}
+}
+
+func TestNew(t *testing.T) {
+	a, b := New(), New()
+	if a == b {
+		t.Errorf("expected generated request IDs to be unique, got %q twice", a)
+	}
+	if !Valid(a) {
+		t.Errorf("expected generated request ID %q to be valid", a)
+	}
+}
+
+func TestValid(t *testing.T) {
+	cases := []struct {
+		id     string
+		expect bool
+	}{
+		{"", false},
+		{"f81d4fae-7dec-11d0-a765-00a0c91e6bf6", true},
+		{"req:123/abc", true},
+		{"has space", false},
+		{"new\nline", false},
+		{"ünicode", false},
+		{strings.Repeat("a", 128), true},
+		{strings.Repeat("a", 129), false},
+	}
+
+	for i, c := range cases {
+		if got := Valid(c.id); got != c.expect {
+			t.Errorf("case %d %q: expected %t, got %t", i, c.id, c.expect, got)
+		}
+	}
+}
@@ -0,0 +1,1106 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/affix-io/affix/base/bodyquery"
+	"github.com/affix-io/dataset/dsio"
+)
+
+// opener opens a dataset reference, returning the column names & a reader
+// for the body of the dataset
+type opener func(ctx context.Context, ref string) ([]string, dsio.EntryReader, error)
+
+// table is a dataset opened for a query. Columns from all tables in a query
+// are laid out side by side in a single row, starting at offset
+type table struct {
+	alias   string
+	columns []string
+	offset  int
+	rr      dsio.EntryReader
+	// rows holds the body of joined tables, which are read into memory
+	rows [][]interface{}
+}
+
+// evalFn evaluates an expression against a row
+type evalFn func(row []interface{}) interface{}
+
+// plan is a compiled select statement
+type plan struct {
+	tables []*table
+	width  int
+	where  evalFn
+	joins  []joinPlan
+	// maxRows caps the number of rows held in memory for joined tables,
+	// groups & sorting. zero means no cap
+	maxRows int
+
+	grouped bool
+	groupBy []evalFn
+	aggs    []aggPlan
+
+	columns []string
+	output  []evalFn
+	// aliases maps select list aliases to output columns
+	aliases map[string]int
+	sort    []sortPlan
+}
+
+type joinPlan struct {
+	left bool
+	on   evalFn
+	// probe & index implement a hash join when the join condition includes an
+	// equality between the joined table & earlier tables
+	probe evalFn
+	index map[string][]int
+}
+
+type aggPlan struct {
+	name string
+	star bool
+	arg  evalFn
+}
+
+// sortPlan evaluates a sort key from either the source or output row
+type sortPlan struct {
+	key  func(src, out []interface{}) interface{}
+	desc bool
+}
+
+// execute runs a parsed statement, opening tables with open. Queries that
+// need to hold more than maxRows rows in memory fail, zero disables the cap
+func execute(ctx context.Context, stmt *selectStmt, open opener, offset, limit, maxRows int) (*Result, error) {
+	tables, err := openTables(ctx, stmt, open)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, t := range tables {
+			if err := t.rr.Close(); err != nil {
+				log.Debugf("closing %q: %s", t.alias, err)
+			}
+		}
+	}()
+
+	p, err := compile(stmt, tables)
+	if err != nil {
+		return nil, err
+	}
+	p.maxRows = maxRows
+	for i, j := range stmt.joins {
+		if err := p.loadJoin(ctx, i, j); err != nil {
+			return nil, err
+		}
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+	start := stmt.offset + offset
+	end := -1
+	if stmt.limit >= 0 {
+		end = stmt.offset + stmt.limit
+	}
+	if limit >= 0 && (end < 0 || start+limit < end) {
+		end = start + limit
+	}
+
+	rows, err := p.run(ctx, end)
+	if err != nil {
+		return nil, err
+	}
+	if start > len(rows) {
+		start = len(rows)
+	}
+	if end < 0 || end > len(rows) {
+		end = len(rows)
+	}
+	if end < start {
+		end = start
+	}
+	return &Result{Columns: p.columns, Rows: rows[start:end]}, nil
+}
+
+// openTables opens every table a statement reads from
+func openTables(ctx context.Context, stmt *selectStmt, open opener) ([]*table, error) {
+	refs := []tableRef{stmt.from}
+	for _, j := range stmt.joins {
+		refs = append(refs, j.table)
+	}
+
+	var tables []*table
+	closeAll := func() {
+		for _, t := range tables {
+			t.rr.Close()
+		}
+	}
+	seen := map[string]bool{}
+	offset := 0
+	for _, ref := range refs {
+		if ref.alias == "" {
+			closeAll()
+			return nil, NewQueryError("table %q needs a name, use AS to give it one", ref.ref)
+		}
+		if seen[ref.alias] {
+			closeAll()
+			return nil, NewQueryError("table name %q is used more than once, use AS to give tables distinct names", ref.alias)
+		}
+		seen[ref.alias] = true
+
+		cols, rr, err := open(ctx, ref.ref)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		tables = append(tables, &table{alias: ref.alias, columns: cols, offset: offset, rr: rr})
+		offset += len(cols)
+	}
+	return tables, nil
+}
+
+// compile resolves columns & builds evaluation functions for a statement
+func compile(stmt *selectStmt, tables []*table) (*plan, error) {
+	last := tables[len(tables)-1]
+	p := &plan{tables: tables, width: last.offset + len(last.columns), aliases: map[string]int{}}
+	c := &compiler{tables: tables}
+
+	var err error
+	if stmt.where != nil {
+		if p.where, err = c.compileRow(stmt.where, "WHERE"); err != nil {
+			return nil, err
+		}
+	}
+	for i, j := range stmt.joins {
+		jc := &compiler{tables: tables[:i+2]}
+		jp := joinPlan{left: j.left}
+		if jp.on, err = jc.compileRow(j.on, "JOIN"); err != nil {
+			return nil, err
+		}
+		p.joins = append(p.joins, jp)
+	}
+
+	p.grouped = len(stmt.groupBy) > 0
+	for _, item := range stmt.items {
+		if item.expr != nil && hasAggregate(item.expr) {
+			p.grouped = true
+		}
+	}
+	for _, item := range stmt.orderBy {
+		if hasAggregate(item.expr) {
+			p.grouped = true
+		}
+	}
+	if p.grouped {
+		c.groupKeys = stmt.groupBy
+		for _, g := range stmt.groupBy {
+			fn, err := c.compileRow(g, "GROUP BY")
+			if err != nil {
+				return nil, err
+			}
+			p.groupBy = append(p.groupBy, fn)
+		}
+	}
+
+	for _, item := range stmt.items {
+		if item.star {
+			if p.grouped {
+				return nil, NewQueryError("SELECT * can't be used with GROUP BY or aggregate functions")
+			}
+			if err := p.addStar(item.table); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		fn, err := c.compileOutput(item.expr, p.grouped)
+		if err != nil {
+			return nil, err
+		}
+		name := item.alias
+		if name != "" {
+			p.aliases[name] = len(p.output)
+		} else {
+			if col, ok := item.expr.(colRef); ok {
+				name = col.name
+			} else {
+				name = item.expr.String()
+			}
+		}
+		p.columns = append(p.columns, name)
+		p.output = append(p.output, fn)
+	}
+
+	for _, item := range stmt.orderBy {
+		key, err := p.compileSort(c, item.expr)
+		if err != nil {
+			return nil, err
+		}
+		p.sort = append(p.sort, sortPlan{key: key, desc: item.desc})
+	}
+	p.aggs = c.aggs
+	return p, nil
+}
+
+// addStar adds all columns of the named table to the output, or all columns
+// of every table if name is empty
+func (p *plan) addStar(name string) error {
+	found := false
+	for _, t := range p.tables {
+		if name != "" && t.alias != name {
+			continue
+		}
+		found = true
+		for i, col := range t.columns {
+			idx := t.offset + i
+			p.columns = append(p.columns, col)
+			p.output = append(p.output, func(row []interface{}) interface{} { return row[idx] })
+		}
+	}
+	if !found {
+		return NewQueryError("unknown table %q", name)
+	}
+	return nil
+}
+
+// compileSort builds a sort key function. ORDER BY can refer to output
+// columns by alias or by position, counting from 1
+func (p *plan) compileSort(c *compiler, e expr) (func(src, out []interface{}) interface{}, error) {
+	outputColumn := func(i int) func(src, out []interface{}) interface{} {
+		return func(_, out []interface{}) interface{} { return out[i] }
+	}
+	if l, ok := e.(literal); ok {
+		if n, ok := l.val.(int64); ok {
+			if n < 1 || int(n) > len(p.output) {
+				return nil, NewQueryError("ORDER BY position %d is not in the select list", n)
+			}
+			return outputColumn(int(n) - 1), nil
+		}
+	}
+	if col, ok := e.(colRef); ok && col.table == "" {
+		if i, ok := p.aliases[col.name]; ok {
+			return outputColumn(i), nil
+		}
+	}
+	fn, err := c.compileOutput(e, p.grouped)
+	if err != nil {
+		return nil, err
+	}
+	return func(src, _ []interface{}) interface{} { return fn(src) }, nil
+}
+
+// errTooManyRows is the error for queries that hold more than max rows in
+// memory
+func errTooManyRows(max int, what string) error {
+	return NewQueryError("query holds more than %d rows of %s in memory, narrow it with WHERE or add a LIMIT", max, what)
+}
+
+// loadJoin reads the body of the i'th joined table into memory, indexing rows
+// by join key when possible
+func (p *plan) loadJoin(ctx context.Context, i int, j join) error {
+	t := p.tables[i+1]
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		row, err := readRow(t)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if p.maxRows > 0 && len(t.rows) >= p.maxRows {
+			return errTooManyRows(p.maxRows, fmt.Sprintf("joined table %q", t.alias))
+		}
+		t.rows = append(t.rows, row)
+	}
+
+	c := &compiler{tables: p.tables[:i+2]}
+	build, probe := c.hashJoinKeys(j.on, i+1)
+	if build == nil {
+		return nil
+	}
+	scratch := make([]interface{}, p.width)
+	index := map[string][]int{}
+	for n, row := range t.rows {
+		copy(scratch[t.offset:], row)
+		if key, ok := hashKey(build(scratch)); ok {
+			index[key] = append(index[key], n)
+		}
+	}
+	p.joins[i].probe = probe
+	p.joins[i].index = index
+	return nil
+}
+
+// run reads rows from the first table through joins & filters, returning
+// output rows. Queries without grouping or sorting stop once end rows are
+// produced. Sorted queries with an end only keep the first end rows in sort
+// order as they go
+func (p *plan) run(ctx context.Context, end int) ([][]interface{}, error) {
+	type group struct {
+		keys []interface{}
+		accs []accumulator
+	}
+	var (
+		groups   []*group
+		byKey    = map[string]*group{}
+		out      = [][]interface{}{}
+		keys     [][]interface{}
+		streamed = !p.grouped && len(p.sort) == 0
+		// bufErr is set once the query holds more than maxRows rows
+		bufErr error
+	)
+
+	emit := func(src []interface{}) {
+		row := make([]interface{}, len(p.output))
+		for i, fn := range p.output {
+			row[i] = fn(src)
+		}
+		out = append(out, row)
+		if len(p.sort) > 0 {
+			k := make([]interface{}, len(p.sort))
+			for i, s := range p.sort {
+				k[i] = s.key(src, row)
+			}
+			keys = append(keys, k)
+			if end >= 0 && len(out) > 2*end {
+				// rows past end in sort order can't be part of the result
+				out, keys = p.sortRows(out, keys)
+				out, keys = out[:end], keys[:end]
+			}
+		}
+		if p.maxRows > 0 && len(out) > p.maxRows {
+			bufErr = errTooManyRows(p.maxRows, "output")
+		}
+	}
+
+	accumulate := func(src []interface{}) {
+		vals := make([]interface{}, len(p.groupBy))
+		for i, fn := range p.groupBy {
+			vals[i] = fn(src)
+		}
+		key := groupKey(vals)
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{keys: vals, accs: make([]accumulator, len(p.aggs))}
+			for i, a := range p.aggs {
+				g.accs[i] = newAccumulator(a.name, a.star)
+			}
+			byKey[key] = g
+			groups = append(groups, g)
+			if p.maxRows > 0 && len(groups) > p.maxRows {
+				bufErr = errTooManyRows(p.maxRows, "groups")
+			}
+		}
+		for i, a := range p.aggs {
+			var v interface{}
+			if a.arg != nil {
+				v = a.arg(src)
+			}
+			g.accs[i].add(v)
+		}
+	}
+
+	row := make([]interface{}, p.width)
+	from := p.tables[0]
+	for {
+		if streamed && end >= 0 && len(out) >= end {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vals, err := readRow(from)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		copy(row, vals)
+		p.expand(row, 0, func(src []interface{}) {
+			if p.where != nil && !isTrue(p.where(src)) {
+				return
+			}
+			if p.grouped {
+				accumulate(src)
+			} else {
+				emit(src)
+			}
+		})
+		if bufErr != nil {
+			return nil, bufErr
+		}
+	}
+
+	if p.grouped {
+		// aggregates over no rows give a single row, unless grouped by columns
+		if len(groups) == 0 && len(p.groupBy) == 0 {
+			g := &group{accs: make([]accumulator, len(p.aggs))}
+			for i, a := range p.aggs {
+				g.accs[i] = newAccumulator(a.name, a.star)
+			}
+			groups = append(groups, g)
+		}
+		for _, g := range groups {
+			src := append([]interface{}{}, g.keys...)
+			for _, acc := range g.accs {
+				src = append(src, acc.result())
+			}
+			emit(src)
+		}
+		if bufErr != nil {
+			return nil, bufErr
+		}
+	}
+
+	if len(p.sort) > 0 {
+		out, _ = p.sortRows(out, keys)
+	}
+	return out, nil
+}
+
+// sortRows stably sorts output rows by their sort keys. Sorting a prefix of
+// rows & then the prefix with more rows appended gives the same order as
+// sorting all rows at once
+func (p *plan) sortRows(out, keys [][]interface{}) ([][]interface{}, [][]interface{}) {
+	idx := make([]int, len(out))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		ka, kb := keys[idx[a]], keys[idx[b]]
+		for i, s := range p.sort {
+			c := bodyquery.Order(ka[i], kb[i])
+			if c == 0 {
+				continue
+			}
+			if s.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	sortedOut := make([][]interface{}, len(out))
+	sortedKeys := make([][]interface{}, len(keys))
+	for i, n := range idx {
+		sortedOut[i], sortedKeys[i] = out[n], keys[n]
+	}
+	return sortedOut, sortedKeys
+}
+
+// expand fills in columns for joined tables starting with the i'th join,
+// calling fn for each combined row
+func (p *plan) expand(row []interface{}, i int, fn func(row []interface{})) {
+	if i == len(p.joins) {
+		fn(row)
+		return
+	}
+	j := p.joins[i]
+	t := p.tables[i+1]
+	cols := row[t.offset : t.offset+len(t.columns)]
+
+	matched := false
+	try := func(n int) {
+		copy(cols, t.rows[n])
+		if isTrue(j.on(row)) {
+			matched = true
+			p.expand(row, i+1, fn)
+		}
+	}
+	if j.index != nil {
+		if key, ok := hashKey(j.probe(row)); ok {
+			for _, n := range j.index[key] {
+				try(n)
+			}
+		}
+	} else {
+		for n := range t.rows {
+			try(n)
+		}
+	}
+	if !matched && j.left {
+		for k := range cols {
+			cols[k] = nil
+		}
+		p.expand(row, i+1, fn)
+	}
+}
+
+// readRow reads the next row from a table, padding short rows with nulls
+func readRow(t *table) ([]interface{}, error) {
+	e, err := t.rr.ReadEntry()
+	if err != nil {
+		return nil, err
+	}
+	vals, ok := e.Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s entry %d: expected an array of values, got %T", t.alias, e.Index, e.Value)
+	}
+	row := make([]interface{}, len(t.columns))
+	copy(row, vals)
+	return row, nil
+}
+
+// compiler resolves column references & builds evaluation functions
+type compiler struct {
+	tables []*table
+	// groupKeys are the GROUP BY expressions of a grouped query. expressions
+	// over groups are evaluated against a row of group keys followed by
+	// aggregate results
+	groupKeys []expr
+	aggs      []aggPlan
+}
+
+// compileRow compiles an expression evaluated against source rows. clause
+// names the part of the query the expression belongs to for error messages
+func (c *compiler) compileRow(e expr, clause string) (evalFn, error) {
+	if hasAggregate(e) {
+		return nil, NewQueryError("aggregate functions are not allowed in %s", clause)
+	}
+	return c.compile(e, func(e expr) (evalFn, bool, error) {
+		col, ok := e.(colRef)
+		if !ok {
+			return nil, false, nil
+		}
+		idx, err := c.resolve(col)
+		if err != nil {
+			return nil, true, err
+		}
+		return func(row []interface{}) interface{} { return row[idx] }, true, nil
+	})
+}
+
+// compileOutput compiles a select list or ORDER BY expression. expressions in
+// grouped queries may only refer to columns through GROUP BY expressions or
+// aggregate functions
+func (c *compiler) compileOutput(e expr, grouped bool) (evalFn, error) {
+	if !grouped {
+		return c.compileRow(e, "SELECT")
+	}
+	return c.compile(e, func(e expr) (evalFn, bool, error) {
+		s := e.String()
+		for i, g := range c.groupKeys {
+			if g.String() == s {
+				return func(row []interface{}) interface{} { return row[i] }, true, nil
+			}
+		}
+		switch x := e.(type) {
+		case call:
+			a := aggPlan{name: x.name, star: x.star}
+			if !x.star {
+				if hasAggregate(x.arg) {
+					return nil, true, NewQueryError("aggregate functions can't be nested")
+				}
+				var err error
+				if a.arg, err = c.compileRow(x.arg, x.name); err != nil {
+					return nil, true, err
+				}
+			}
+			idx := len(c.groupKeys) + len(c.aggs)
+			c.aggs = append(c.aggs, a)
+			return func(row []interface{}) interface{} { return row[idx] }, true, nil
+		case colRef:
+			if _, err := c.resolve(x); err != nil {
+				return nil, true, err
+			}
+			return nil, true, NewQueryError("column %s must appear in GROUP BY or be used in an aggregate function", x)
+		}
+		return nil, false, nil
+	})
+}
+
+// compile builds an evaluation function for e. leaf is consulted first for
+// every node & handles column references
+func (c *compiler) compile(e expr, leaf func(e expr) (evalFn, bool, error)) (evalFn, error) {
+	if fn, ok, err := leaf(e); ok || err != nil {
+		return fn, err
+	}
+
+	switch x := e.(type) {
+	case literal:
+		return func([]interface{}) interface{} { return x.val }, nil
+	case unary:
+		fn, err := c.compile(x.x, leaf)
+		if err != nil {
+			return nil, err
+		}
+		if x.op == "NOT" {
+			return func(row []interface{}) interface{} {
+				v := fn(row)
+				if b, ok := v.(bool); ok {
+					return !b
+				}
+				return nil
+			}, nil
+		}
+		return func(row []interface{}) interface{} {
+			return arithmetic("-", int64(0), fn(row))
+		}, nil
+	case isNull:
+		fn, err := c.compile(x.x, leaf)
+		if err != nil {
+			return nil, err
+		}
+		return func(row []interface{}) interface{} {
+			return (fn(row) == nil) != x.not
+		}, nil
+	case binary:
+		left, err := c.compile(x.left, leaf)
+		if err != nil {
+			return nil, err
+		}
+		right, err := c.compile(x.right, leaf)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "AND":
+			return func(row []interface{}) interface{} {
+				return and(left(row), right(row))
+			}, nil
+		case "OR":
+			return func(row []interface{}) interface{} {
+				return or(left(row), right(row))
+			}, nil
+		case "=", "!=", "<", "<=", ">", ">=":
+			return func(row []interface{}) interface{} {
+				return comparison(x.op, left(row), right(row))
+			}, nil
+		}
+		return func(row []interface{}) interface{} {
+			return arithmetic(x.op, left(row), right(row))
+		}, nil
+	}
+	return nil, NewQueryError("unsupported expression %s", e)
+}
+
+// resolve finds the position of a column in a row
+func (c *compiler) resolve(col colRef) (int, error) {
+	if col.table != "" {
+		for _, t := range c.tables {
+			if t.alias != col.table {
+				continue
+			}
+			for i, name := range t.columns {
+				if name == col.name {
+					return t.offset + i, nil
+				}
+			}
+			return 0, NewQueryError("unknown column %s", col)
+		}
+		return 0, NewQueryError("unknown table %q", col.table)
+	}
+
+	idx := -1
+	for _, t := range c.tables {
+		for i, name := range t.columns {
+			if name == col.name {
+				if idx >= 0 {
+					return 0, NewQueryError("column %s is ambiguous, qualify it with a table name", col)
+				}
+				idx = t.offset + i
+				break
+			}
+		}
+	}
+	if idx < 0 {
+		return 0, NewQueryError("unknown column %s", col)
+	}
+	return idx, nil
+}
+
+// tablesOf lists the tables an expression refers to
+func (c *compiler) tablesOf(e expr) map[int]bool {
+	refs := map[int]bool{}
+	walk(e, func(e expr) {
+		col, ok := e.(colRef)
+		if !ok {
+			return
+		}
+		idx, err := c.resolve(col)
+		if err != nil {
+			return
+		}
+		for i, t := range c.tables {
+			if idx >= t.offset && idx < t.offset+len(t.columns) {
+				refs[i] = true
+			}
+		}
+	})
+	return refs
+}
+
+// hashJoinKeys looks for an equality in a join condition between an
+// expression over the joined table & an expression over earlier tables. It
+// returns the build side, evaluated against rows of the joined table, & the
+// probe side, or nil if the condition has no such equality
+func (c *compiler) hashJoinKeys(on expr, joined int) (build, probe evalFn) {
+	for _, term := range conjuncts(on) {
+		eq, ok := term.(binary)
+		if !ok || eq.op != "=" {
+			continue
+		}
+		l, r := c.tablesOf(eq.left), c.tablesOf(eq.right)
+		onlyJoined := func(refs map[int]bool) bool { return len(refs) == 1 && refs[joined] }
+		earlier := func(refs map[int]bool) bool { return len(refs) > 0 && !refs[joined] }
+
+		var b, pr expr
+		switch {
+		case onlyJoined(l) && earlier(r):
+			b, pr = eq.left, eq.right
+		case onlyJoined(r) && earlier(l):
+			b, pr = eq.right, eq.left
+		default:
+			continue
+		}
+		var err error
+		if build, err = c.compileRow(b, "JOIN"); err != nil {
+			continue
+		}
+		if probe, err = c.compileRow(pr, "JOIN"); err != nil {
+			continue
+		}
+		return build, probe
+	}
+	return nil, nil
+}
+
+// conjuncts splits an expression into terms joined by AND
+func conjuncts(e expr) []expr {
+	if b, ok := e.(binary); ok && b.op == "AND" {
+		return append(conjuncts(b.left), conjuncts(b.right)...)
+	}
+	return []expr{e}
+}
+
+// walk calls fn for e & every expression it contains
+func walk(e expr, fn func(e expr)) {
+	fn(e)
+	switch x := e.(type) {
+	case unary:
+		walk(x.x, fn)
+	case isNull:
+		walk(x.x, fn)
+	case binary:
+		walk(x.left, fn)
+		walk(x.right, fn)
+	case call:
+		if x.arg != nil {
+			walk(x.arg, fn)
+		}
+	}
+}
+
+func hasAggregate(e expr) bool {
+	found := false
+	walk(e, func(e expr) {
+		if _, ok := e.(call); ok {
+			found = true
+		}
+	})
+	return found
+}
+
+// isTrue reports whether v is boolean true. null & non-boolean values are
+// not true
+func isTrue(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// and implements three-valued logical AND, where null is unknown
+func and(a, b interface{}) interface{} {
+	ab, aok := a.(bool)
+	bb, bok := b.(bool)
+	if (aok && !ab) || (bok && !bb) {
+		return false
+	}
+	if aok && bok {
+		return true
+	}
+	return nil
+}
+
+// or implements three-valued logical OR, where null is unknown
+func or(a, b interface{}) interface{} {
+	ab, aok := a.(bool)
+	bb, bok := b.(bool)
+	if (aok && ab) || (bok && bb) {
+		return true
+	}
+	if aok && bok {
+		return false
+	}
+	return nil
+}
+
+// comparison compares two values. comparisons with null are null. values of
+// different kinds are never equal & can't be ordered
+func comparison(op string, a, b interface{}) interface{} {
+	if a == nil || b == nil {
+		return nil
+	}
+	c, ok := bodyquery.Compare(a, b)
+	if !ok {
+		switch op {
+		case "=":
+			return false
+		case "!=":
+			return true
+		}
+		return nil
+	}
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// arithmetic applies an arithmetic operator to two numbers. integer operands
+// give integer results, except for division. Non-numeric operands &
+// division by zero give null
+func arithmetic(op string, a, b interface{}) interface{} {
+	if x, ok := toInt(a); ok && op != "/" {
+		if y, ok := toInt(b); ok {
+			switch op {
+			case "+":
+				return x + y
+			case "-":
+				return x - y
+			case "*":
+				return x * y
+			case "%":
+				if y == 0 {
+					return nil
+				}
+				return x % y
+			}
+		}
+	}
+	x, ok := toFloat(a)
+	if !ok {
+		return nil
+	}
+	y, ok := toFloat(b)
+	if !ok {
+		return nil
+	}
+	switch op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			return nil
+		}
+		return x / y
+	case "%":
+		if y == 0 {
+			return nil
+		}
+		return math.Mod(x, y)
+	}
+	return nil
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case json.Number:
+		n, err := x.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// hashKey encodes a value as a string such that values which compare as
+// equal have the same key. null & non-scalar values have no key
+func hashKey(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return "s:" + x, true
+	case bool:
+		return "b:" + strconv.FormatBool(x), true
+	}
+	if f, ok := toFloat(v); ok {
+		return "n:" + strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// groupKey encodes group values as a string. unlike join keys, nulls group
+// together
+func groupKey(vals []interface{}) string {
+	var b strings.Builder
+	for _, v := range vals {
+		key, ok := hashKey(v)
+		if !ok {
+			if v == nil {
+				key = "z"
+			} else {
+				data, _ := json.Marshal(v)
+				key = "j:" + string(data)
+			}
+		}
+		b.WriteString(strconv.Itoa(len(key)))
+		b.WriteByte(':')
+		b.WriteString(key)
+	}
+	return b.String()
+}
+
+// accumulator computes an aggregate function over the rows of a group
+type accumulator interface {
+	add(v interface{})
+	result() interface{}
+}
+
+func newAccumulator(name string, star bool) accumulator {
+	switch name {
+	case "COUNT":
+		return &count{star: star}
+	case "SUM":
+		return &sum{}
+	case "AVG":
+		return &avg{}
+	case "MIN":
+		return &extreme{}
+	}
+	return &extreme{max: true}
+}
+
+// count counts non-null values, or all rows for COUNT(*)
+type count struct {
+	star bool
+	n    int64
+}
+
+func (c *count) add(v interface{}) {
+	if c.star || v != nil {
+		c.n++
+	}
+}
+
+func (c *count) result() interface{} { return c.n }
+
+// sum adds numeric values, giving an integer if all values are integers.
+// sum ignores non-numeric values & is null if there are no numbers
+type sum struct {
+	i       int64
+	f       float64
+	isFloat bool
+	any     bool
+}
+
+func (s *sum) add(v interface{}) {
+	if n, ok := toInt(v); ok && !s.isFloat {
+		s.i += n
+		s.any = true
+		return
+	}
+	f, ok := toFloat(v)
+	if !ok {
+		return
+	}
+	if !s.isFloat {
+		s.isFloat = true
+		s.f = float64(s.i)
+	}
+	s.f += f
+	s.any = true
+}
+
+func (s *sum) result() interface{} {
+	if !s.any {
+		return nil
+	}
+	if s.isFloat {
+		return s.f
+	}
+	return s.i
+}
+
+// avg gives the mean of numeric values, ignoring non-numeric values
+type avg struct {
+	total float64
+	n     int64
+}
+
+func (a *avg) add(v interface{}) {
+	if f, ok := toFloat(v); ok {
+		a.total += f
+		a.n++
+	}
+}
+
+func (a *avg) result() interface{} {
+	if a.n == 0 {
+		return nil
+	}
+	return a.total / float64(a.n)
+}
+
+// extreme gives the least or greatest non-null value
+type extreme struct {
+	max bool
+	v   interface{}
+}
+
+func (e *extreme) add(v interface{}) {
+	if v == nil {
+		return
+	}
+	if e.v == nil {
+		e.v = v
+		return
+	}
+	c := bodyquery.Order(v, e.v)
+	if (e.max && c > 0) || (!e.max && c < 0) {
+		e.v = v
+	}
+}
+
+func (e *extreme) result() interface{} { return e.v }
@@ -0,0 +1,260 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/affix-io/dataset"
+	"github.com/affix-io/dataset/dsio"
+	"github.com/google/go-cmp/cmp"
+)
+
+var testTables = map[string]struct {
+	columns []string
+	types   []string
+	csv     string
+}{
+	"me/cities": {
+		[]string{"city", "pop", "avg_age", "in_usa"},
+		[]string{"string", "integer", "number", "boolean"},
+		`toronto,40000000,55.5,false
+new york,8500000,44.4,true
+chicago,300000,44.4,true
+chatham,35000,65.25,true
+raleigh,250000,50.65,true
+`,
+	},
+	"me/countries": {
+		[]string{"city", "country"},
+		[]string{"string", "string"},
+		`toronto,canada
+new york,usa
+chicago,usa
+raleigh,usa
+`,
+	},
+}
+
+// testOpener opens tables from testTables, ignoring the version part of refs
+func testOpener(ctx context.Context, ref string) ([]string, dsio.EntryReader, error) {
+	name := ref
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		name = ref[:i]
+	}
+	tbl, ok := testTables[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("reference not found")
+	}
+	items := make([]interface{}, len(tbl.columns))
+	for i, c := range tbl.columns {
+		items[i] = map[string]interface{}{"title": c, "type": tbl.types[i]}
+	}
+	st := &dataset.Structure{
+		Format: "csv",
+		Schema: map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "array", "items": items},
+		},
+	}
+	rr, err := dsio.NewEntryReader(st, bytes.NewBufferString(tbl.csv))
+	return tbl.columns, rr, err
+}
+
+func runQuery(query string, offset, limit int) (*Result, error) {
+	stmt, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return execute(context.Background(), stmt, testOpener, offset, limit, DefaultMaxRows)
+}
+
+func TestExecute(t *testing.T) {
+	cases := []struct {
+		query  string
+		expect *Result
+	}{
+		{"SELECT * FROM me/cities@/ipfs/QmHash WHERE pop < 300000", &Result{
+			Columns: []string{"city", "pop", "avg_age", "in_usa"},
+			Rows: [][]interface{}{
+				{"chatham", int64(35000), 65.25, true},
+				{"raleigh", int64(250000), 50.65, true},
+			},
+		}},
+		{"SELECT city AS name, pop / 1000 AS k FROM me/cities WHERE in_usa ORDER BY k DESC LIMIT 2", &Result{
+			Columns: []string{"name", "k"},
+			Rows: [][]interface{}{
+				{"new york", float64(8500)},
+				{"chicago", float64(300)},
+			},
+		}},
+		{"SELECT city FROM me/cities ORDER BY avg_age, city DESC LIMIT 2 OFFSET 1", &Result{
+			Columns: []string{"city"},
+			Rows: [][]interface{}{
+				{"chicago"},
+				{"raleigh"},
+			},
+		}},
+		{"SELECT in_usa, COUNT(*) AS n, SUM(pop), MIN(city), MAX(avg_age) FROM me/cities GROUP BY in_usa ORDER BY n DESC", &Result{
+			Columns: []string{"in_usa", "n", "SUM(pop)", "MIN(city)", "MAX(avg_age)"},
+			Rows: [][]interface{}{
+				{true, int64(4), int64(9085000), "chatham", 65.25},
+				{false, int64(1), int64(40000000), "toronto", 55.5},
+			},
+		}},
+		{"SELECT COUNT(*), AVG(avg_age) FROM me/cities WHERE avg_age = 44.4", &Result{
+			Columns: []string{"COUNT(*)", "AVG(avg_age)"},
+			Rows:    [][]interface{}{{int64(2), 44.4}},
+		}},
+		{"SELECT COUNT(*), SUM(pop) FROM me/cities WHERE pop < 0", &Result{
+			Columns: []string{"COUNT(*)", "SUM(pop)"},
+			Rows:    [][]interface{}{{int64(0), nil}},
+		}},
+		{"SELECT c.city, k.country FROM me/cities c JOIN me/countries@/ipfs/QmHash k ON c.city = k.city WHERE k.country = 'usa' ORDER BY 1", &Result{
+			Columns: []string{"city", "country"},
+			Rows: [][]interface{}{
+				{"chicago", "usa"},
+				{"new york", "usa"},
+				{"raleigh", "usa"},
+			},
+		}},
+		{"SELECT cities.city, country FROM me/cities LEFT JOIN me/countries ON countries.city = cities.city AND pop > 1000000 ORDER BY cities.city", &Result{
+			Columns: []string{"city", "country"},
+			Rows: [][]interface{}{
+				{"chatham", nil},
+				{"chicago", nil},
+				{"new york", "usa"},
+				{"raleigh", nil},
+				{"toronto", "canada"},
+			},
+		}},
+		{"SELECT country, COUNT(*) AS cities, SUM(pop) AS pop FROM me/cities c JOIN me/countries k ON k.city = c.city GROUP BY country ORDER BY pop", &Result{
+			Columns: []string{"country", "cities", "pop"},
+			Rows: [][]interface{}{
+				{"usa", int64(3), int64(9050000)},
+				{"canada", int64(1), int64(40000000)},
+			},
+		}},
+		{"SELECT a.city, b.city FROM me/cities a JOIN me/cities b ON a.avg_age = b.avg_age AND a.city < b.city", &Result{
+			Columns: []string{"city", "city"},
+			Rows:    [][]interface{}{{"chicago", "new york"}},
+		}},
+		{"SELECT city FROM me/cities WHERE pop IS NULL OR NOT (in_usa OR avg_age > 60)", &Result{
+			Columns: []string{"city"},
+			Rows:    [][]interface{}{{"toronto"}},
+		}},
+		{"SELECT city FROM me/cities LIMIT 0", &Result{
+			Columns: []string{"city"},
+			Rows:    [][]interface{}{},
+		}},
+	}
+
+	for i, c := range cases {
+		got, err := runQuery(c.query, 0, -1)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if diff := cmp.Diff(c.expect, got); diff != "" {
+			t.Errorf("case %d: result mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+func TestExecutePaging(t *testing.T) {
+	cases := []struct {
+		query         string
+		offset, limit int
+		expect        []string
+	}{
+		{"SELECT city FROM me/cities", 0, 2, []string{"toronto", "new york"}},
+		{"SELECT city FROM me/cities", 4, 2, []string{"raleigh"}},
+		{"SELECT city FROM me/cities", 10, 2, []string{}},
+		{"SELECT city FROM me/cities LIMIT 3", 2, 2, []string{"chicago"}},
+		{"SELECT city FROM me/cities LIMIT 3 OFFSET 1", 1, 5, []string{"chicago", "chatham"}},
+		{"SELECT city FROM me/cities ORDER BY pop", 1, 2, []string{"raleigh", "chicago"}},
+		{"SELECT city FROM me/cities ORDER BY pop DESC", 0, 1, []string{"toronto"}},
+		{"SELECT city FROM me/cities ORDER BY in_usa", 0, 3, []string{"toronto", "new york", "chicago"}},
+	}
+
+	for i, c := range cases {
+		res, err := runQuery(c.query, c.offset, c.limit)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		got := []string{}
+		for _, row := range res.Rows {
+			got = append(got, row[0].(string))
+		}
+		if diff := cmp.Diff(c.expect, got); diff != "" {
+			t.Errorf("case %d: result mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+}
+
+func TestExecuteMaxRows(t *testing.T) {
+	cases := []struct {
+		query string
+		limit int
+		err   string
+	}{
+		{"SELECT city FROM me/cities", -1, "query holds more than 3 rows of output in memory, narrow it with WHERE or add a LIMIT"},
+		{"SELECT city FROM me/cities ORDER BY pop", -1, "query holds more than 3 rows of output in memory, narrow it with WHERE or add a LIMIT"},
+		{"SELECT city, COUNT(*) FROM me/cities GROUP BY city", -1, "query holds more than 3 rows of groups in memory, narrow it with WHERE or add a LIMIT"},
+		{"SELECT a.city FROM me/countries a JOIN me/cities b ON a.city = b.city", 1, `query holds more than 3 rows of joined table "b" in memory, narrow it with WHERE or add a LIMIT`},
+		// sorted queries with a limit only keep the rows they return
+		{"SELECT city FROM me/cities ORDER BY pop", 1, ""},
+		{"SELECT city FROM me/cities WHERE pop > 300000", -1, ""},
+	}
+
+	for i, c := range cases {
+		stmt, err := parse(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = execute(context.Background(), stmt, testOpener, 0, c.limit, 3)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("case %d: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != c.err {
+			t.Errorf("case %d: error mismatch. expected: %q, got: %v", i, c.err, err)
+		}
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	cases := []struct {
+		query string
+		err   string
+	}{
+		{"SELECT nope FROM me/cities", "unknown column nope"},
+		{"SELECT x.city FROM me/cities", `unknown table "x"`},
+		{"SELECT city FROM me/cities JOIN me/countries ON cities.city = countries.city", "column city is ambiguous, qualify it with a table name"},
+		{"SELECT city FROM me/cities JOIN me/cities ON pop = pop", `table name "cities" is used more than once, use AS to give tables distinct names`},
+		{"SELECT city FROM @/ipfs/QmHash", `table "@/ipfs/QmHash" needs a name, use AS to give it one`},
+		{"SELECT city, COUNT(*) FROM me/cities", "column city must appear in GROUP BY or be used in an aggregate function"},
+		{"SELECT * FROM me/cities GROUP BY in_usa", "SELECT * can't be used with GROUP BY or aggregate functions"},
+		{"SELECT city FROM me/cities WHERE COUNT(*) > 1", "aggregate functions are not allowed in WHERE"},
+		{"SELECT SUM(COUNT(*)) FROM me/cities", "aggregate functions can't be nested"},
+		{"SELECT city FROM me/cities ORDER BY 2", "ORDER BY position 2 is not in the select list"},
+		{"SELECT a.city FROM me/cities a JOIN me/countries b ON b.city = c.city JOIN me/cities c ON c.city = a.city", `unknown table "c"`},
+		{"SELECT * FROM me/missing", "reference not found"},
+	}
+
+	for i, c := range cases {
+		_, err := runQuery(c.query, 0, -1)
+		if err == nil {
+			t.Errorf("case %d: expected error, got nil", i)
+			continue
+		}
+		if err.Error() != c.err {
+			t.Errorf("case %d: error mismatch. expected: %q, got: %q", i, c.err, err.Error())
+		}
+	}
+}
@@ -0,0 +1,881 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// selectStmt is a parsed SELECT query
+type selectStmt struct {
+	items   []selectItem
+	from    tableRef
+	joins   []join
+	where   expr
+	groupBy []expr
+	orderBy []orderItem
+	// limit is -1 when the query has no LIMIT clause
+	limit  int
+	offset int
+}
+
+// selectItem is a single entry in a select list. star items select all
+// columns of every table, or of a single table if table is set
+type selectItem struct {
+	expr  expr
+	alias string
+	star  bool
+	table string
+}
+
+// tableRef names a dataset to read rows from
+type tableRef struct {
+	ref   string
+	alias string
+}
+
+type join struct {
+	left  bool
+	table tableRef
+	on    expr
+}
+
+type orderItem struct {
+	expr expr
+	desc bool
+}
+
+// expr is a node in an expression tree
+type expr interface {
+	String() string
+}
+
+// colRef references a column, optionally qualified by table name
+type colRef struct {
+	table string
+	name  string
+}
+
+func (c colRef) String() string {
+	if c.table != "" {
+		return quoteIdent(c.table) + "." + quoteIdent(c.name)
+	}
+	return quoteIdent(c.name)
+}
+
+type literal struct {
+	val interface{}
+}
+
+func (l literal) String() string {
+	switch v := l.val.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprintf("%v", l.val)
+}
+
+// binary is a logical, comparison or arithmetic operation
+type binary struct {
+	op          string
+	left, right expr
+}
+
+func (b binary) String() string {
+	return parenthesize(b.left) + " " + b.op + " " + parenthesize(b.right)
+}
+
+type unary struct {
+	op string
+	x  expr
+}
+
+func (u unary) String() string {
+	if u.op == "NOT" {
+		return "NOT " + parenthesize(u.x)
+	}
+	return u.op + parenthesize(u.x)
+}
+
+type isNull struct {
+	x   expr
+	not bool
+}
+
+func (n isNull) String() string {
+	if n.not {
+		return parenthesize(n.x) + " IS NOT NULL"
+	}
+	return parenthesize(n.x) + " IS NULL"
+}
+
+// call is an aggregate function call
+type call struct {
+	name string
+	arg  expr
+	// star is set for COUNT(*)
+	star bool
+}
+
+func (c call) String() string {
+	if c.star {
+		return c.name + "(*)"
+	}
+	return c.name + "(" + c.arg.String() + ")"
+}
+
+func parenthesize(e expr) string {
+	switch e.(type) {
+	case binary, isNull:
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// aggregates lists supported aggregate functions
+var aggregates = map[string]bool{
+	"COUNT": true,
+	"SUM":   true,
+	"AVG":   true,
+	"MIN":   true,
+	"MAX":   true,
+}
+
+// keywords can't be used as unquoted identifiers
+var keywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "ORDER": true,
+	"BY": true, "LIMIT": true, "OFFSET": true, "JOIN": true, "INNER": true,
+	"LEFT": true, "OUTER": true, "ON": true, "AS": true, "AND": true, "OR": true,
+	"NOT": true, "ASC": true, "DESC": true, "IS": true, "NULL": true,
+	"TRUE": true, "FALSE": true,
+}
+
+type tokenKind int
+
+const (
+	tkEOF tokenKind = iota
+	// tkIdent is an unquoted identifier or keyword
+	tkIdent
+	// tkQuoted is a quoted identifier
+	tkQuoted
+	tkString
+	tkNumber
+	tkSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	val  interface{}
+	pos  int
+	end  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tkEOF:
+		return "end of query"
+	case tkString:
+		return literal{t.val}.String()
+	}
+	return strconv.Quote(t.text)
+}
+
+// parser is a recursive descent parser for a subset of SQL SELECT statements.
+// table names are dataset references, which contain characters that aren't
+// valid in SQL identifiers, so tokens are lexed as the parser consumes them.
+// This lets the parser read everything up to the next space as a reference
+// after FROM & JOIN
+type parser struct {
+	s   string
+	tok token
+}
+
+// parse parses a query string into a select statement
+func parse(query string) (*selectStmt, error) {
+	p := &parser{s: query}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	stmt, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	if p.isSymbol(";") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.tok.kind != tkEOF {
+		return nil, NewQueryError("unexpected %s at position %d", p.tok, p.tok.pos)
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseSelect() (*selectStmt, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	stmt := &selectStmt{limit: -1}
+	for {
+		item, err := p.parseSelectItem()
+		if err != nil {
+			return nil, err
+		}
+		stmt.items = append(stmt.items, item)
+		if !p.isSymbol(",") {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.isKeyword("FROM") {
+		return nil, NewQueryError("expected FROM at position %d, got %s", p.tok.pos, p.tok)
+	}
+	var err error
+	if stmt.from, err = p.parseTableRef(); err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("JOIN") || p.isKeyword("INNER") || p.isKeyword("LEFT") {
+		j := join{left: p.isKeyword("LEFT")}
+		if !p.isKeyword("JOIN") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if j.left && p.isKeyword("OUTER") {
+				if err := p.next(); err != nil {
+					return nil, err
+				}
+			}
+			if !p.isKeyword("JOIN") {
+				return nil, NewQueryError("expected JOIN at position %d, got %s", p.tok.pos, p.tok)
+			}
+		}
+		if j.table, err = p.parseTableRef(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("ON"); err != nil {
+			return nil, err
+		}
+		if j.on, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		stmt.joins = append(stmt.joins, j)
+	}
+
+	if p.isKeyword("WHERE") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if stmt.where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isKeyword("GROUP") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			stmt.groupBy = append(stmt.groupBy, e)
+			if !p.isSymbol(",") {
+				break
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.isKeyword("ORDER") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			item := orderItem{expr: e}
+			if p.isKeyword("ASC") || p.isKeyword("DESC") {
+				item.desc = p.isKeyword("DESC")
+				if err := p.next(); err != nil {
+					return nil, err
+				}
+			}
+			stmt.orderBy = append(stmt.orderBy, item)
+			if !p.isSymbol(",") {
+				break
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.isKeyword("LIMIT") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if stmt.limit, err = p.parseCount("LIMIT"); err != nil {
+			return nil, err
+		}
+		if p.isKeyword("OFFSET") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if stmt.offset, err = p.parseCount("OFFSET"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseSelectItem() (selectItem, error) {
+	if p.isSymbol("*") {
+		return selectItem{star: true}, p.next()
+	}
+	// check for table.* by looking ahead without consuming tokens
+	if p.tok.kind == tkIdent || p.tok.kind == tkQuoted {
+		if dot, err := lex(p.s, p.tok.end); err == nil && dot.kind == tkSymbol && dot.text == "." {
+			if star, err := lex(p.s, dot.end); err == nil && star.kind == tkSymbol && star.text == "*" {
+				item := selectItem{star: true, table: p.tok.text}
+				p.tok = star
+				return item, p.next()
+			}
+		}
+	}
+
+	e, err := p.parseExpr()
+	if err != nil {
+		return selectItem{}, err
+	}
+	item := selectItem{expr: e}
+	if item.alias, err = p.parseAlias(); err != nil {
+		return selectItem{}, err
+	}
+	return item, nil
+}
+
+// parseAlias parses an optional alias, with or without AS
+func (p *parser) parseAlias() (string, error) {
+	if p.isKeyword("AS") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		if !p.isName() {
+			return "", NewQueryError("expected alias at position %d, got %s", p.tok.pos, p.tok)
+		}
+	} else if !p.isName() {
+		return "", nil
+	}
+	alias := p.tok.text
+	return alias, p.next()
+}
+
+// parseTableRef reads a dataset reference following the current FROM or JOIN
+// token, with an optional alias. Tables without an alias are named by the
+// dataset name in their reference
+func (p *parser) parseTableRef() (tableRef, error) {
+	ref, end, err := lexRef(p.s, p.tok.end)
+	if err != nil {
+		return tableRef{}, err
+	}
+	if p.tok, err = lex(p.s, end); err != nil {
+		return tableRef{}, err
+	}
+	t := tableRef{ref: ref}
+	if t.alias, err = p.parseAlias(); err != nil {
+		return tableRef{}, err
+	}
+	if t.alias == "" {
+		t.alias = refName(ref)
+	}
+	return t, nil
+}
+
+// parseCount parses a non-negative integer for LIMIT & OFFSET clauses
+func (p *parser) parseCount(clause string) (int, error) {
+	n, ok := p.tok.val.(int64)
+	if p.tok.kind != tkNumber || !ok || n < 0 {
+		return 0, NewQueryError("%s at position %d must be a non-negative integer, got %s", clause, p.tok.pos, p.tok)
+	}
+	return int(n), p.next()
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.isKeyword("NOT") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: "NOT", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonOps = map[string]string{
+	"=":  "=",
+	"==": "=",
+	"!=": "!=",
+	"<>": "!=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if p.isKeyword("IS") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n := isNull{x: left}
+		if p.isKeyword("NOT") {
+			n.not = true
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	if p.tok.kind != tkSymbol {
+		return left, nil
+	}
+	op, ok := comparisonOps[p.tok.text]
+	if !ok {
+		return left, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return binary{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol("+") || p.isSymbol("-") {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isSymbol("*") || p.isSymbol("/") || p.isSymbol("%") {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.isSymbol("-") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// fold negative number literals
+		if l, ok := x.(literal); ok {
+			switch v := l.val.(type) {
+			case int64:
+				return literal{-v}, nil
+			case float64:
+				return literal{-v}, nil
+			}
+		}
+		return unary{op: "-", x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tkNumber, tkString:
+		return literal{tok.val}, p.next()
+	case tkSymbol:
+		if tok.text != "(" {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isSymbol(")") {
+			return nil, NewQueryError("expected \")\" at position %d, got %s", p.tok.pos, p.tok)
+		}
+		return e, p.next()
+	case tkIdent:
+		switch strings.ToUpper(tok.text) {
+		case "NULL":
+			return literal{nil}, p.next()
+		case "TRUE":
+			return literal{true}, p.next()
+		case "FALSE":
+			return literal{false}, p.next()
+		}
+		if isKeyword(tok.text) {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.isSymbol("(") {
+			return p.parseCall(tok)
+		}
+		return p.parseColumn(tok)
+	case tkQuoted:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return p.parseColumn(tok)
+	case tkEOF:
+		return nil, NewQueryError("expected expression at position %d, got end of query", tok.pos)
+	}
+	return nil, NewQueryError("unexpected %s at position %d", tok, tok.pos)
+}
+
+// parseColumn parses a column reference that begins with name, which has
+// already been consumed
+func (p *parser) parseColumn(name token) (expr, error) {
+	if !p.isSymbol(".") {
+		return colRef{name: name.text}, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if !p.isName() {
+		return nil, NewQueryError("expected column name at position %d, got %s", p.tok.pos, p.tok)
+	}
+	col := colRef{table: name.text, name: p.tok.text}
+	return col, p.next()
+}
+
+// parseCall parses the arguments of an aggregate function call. the current
+// token is the opening parenthesis
+func (p *parser) parseCall(name token) (expr, error) {
+	fn := strings.ToUpper(name.text)
+	if !aggregates[fn] {
+		return nil, NewQueryError("unknown function %q at position %d", name.text, name.pos)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	c := call{name: fn}
+	if fn == "COUNT" && p.isSymbol("*") {
+		c.star = true
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if c.arg, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if !p.isSymbol(")") {
+		return nil, NewQueryError("expected \")\" at position %d, got %s", p.tok.pos, p.tok)
+	}
+	return c, p.next()
+}
+
+func (p *parser) next() (err error) {
+	p.tok, err = lex(p.s, p.tok.end)
+	return err
+}
+
+func (p *parser) isKeyword(kw string) bool {
+	return p.tok.kind == tkIdent && strings.EqualFold(p.tok.text, kw)
+}
+
+func (p *parser) isSymbol(s string) bool {
+	return p.tok.kind == tkSymbol && p.tok.text == s
+}
+
+// isName reports whether the current token can be used as a name
+func (p *parser) isName() bool {
+	return p.tok.kind == tkQuoted || (p.tok.kind == tkIdent && !isKeyword(p.tok.text))
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.isKeyword(kw) {
+		return NewQueryError("expected %s at position %d, got %s", kw, p.tok.pos, p.tok)
+	}
+	return p.next()
+}
+
+// lex reads the token starting at or after position pos
+func lex(s string, pos int) (token, error) {
+	for pos < len(s) && isSpace(s[pos]) {
+		pos++
+	}
+	if pos >= len(s) {
+		return token{kind: tkEOF, pos: pos, end: pos}, nil
+	}
+
+	c := s[pos]
+	switch {
+	case isIdentStart(c):
+		end := pos + 1
+		for end < len(s) && isIdentChar(s[end]) {
+			end++
+		}
+		return token{kind: tkIdent, text: s[pos:end], pos: pos, end: end}, nil
+	case c == '`' || c == '"':
+		text, end, err := lexQuoted(s, pos)
+		return token{kind: tkQuoted, text: text, pos: pos, end: end}, err
+	case c == '\'':
+		text, end, err := lexQuoted(s, pos)
+		return token{kind: tkString, text: text, val: text, pos: pos, end: end}, err
+	case isDigit(c) || (c == '.' && pos+1 < len(s) && isDigit(s[pos+1])):
+		return lexNumber(s, pos)
+	}
+
+	if pos+1 < len(s) {
+		switch op := s[pos : pos+2]; op {
+		case "!=", "<>", "<=", ">=", "==":
+			return token{kind: tkSymbol, text: op, pos: pos, end: pos + 2}, nil
+		}
+	}
+	if strings.IndexByte(",().*+-/%=<>;", c) >= 0 {
+		return token{kind: tkSymbol, text: string(c), pos: pos, end: pos + 1}, nil
+	}
+	return token{}, NewQueryError("unexpected %q at position %d", c, pos)
+}
+
+// lexQuoted reads a quoted string starting at pos. quote characters are
+// escaped by doubling them
+func lexQuoted(s string, pos int) (string, int, error) {
+	q := s[pos]
+	var b strings.Builder
+	for i := pos + 1; i < len(s); i++ {
+		if s[i] != q {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == q {
+			b.WriteByte(q)
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", 0, NewQueryError("unterminated quote at position %d", pos)
+}
+
+func lexNumber(s string, pos int) (token, error) {
+	end := pos
+	float := false
+	for end < len(s) {
+		c := s[end]
+		if isDigit(c) {
+			end++
+		} else if c == '.' {
+			float = true
+			end++
+		} else if (c == 'e' || c == 'E') && end+1 < len(s) {
+			float = true
+			end++
+			if s[end] == '+' || s[end] == '-' {
+				end++
+			}
+		} else {
+			break
+		}
+	}
+	text := s[pos:end]
+	tok := token{kind: tkNumber, text: text, pos: pos, end: end}
+	if !float {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			tok.val = n
+			return tok, nil
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return token{}, NewQueryError("invalid number %q at position %d", text, pos)
+	}
+	tok.val = f
+	return tok, nil
+}
+
+// lexRef reads a dataset reference starting at or after position pos.
+// References are either quoted with backticks or run until the next space,
+// comma, closing parenthesis or semicolon
+func lexRef(s string, pos int) (string, int, error) {
+	for pos < len(s) && isSpace(s[pos]) {
+		pos++
+	}
+	if pos >= len(s) {
+		return "", pos, NewQueryError("expected dataset reference at position %d, got end of query", pos)
+	}
+	if s[pos] == '`' {
+		return lexQuoted(s, pos)
+	}
+	end := pos
+	for end < len(s) && !isSpace(s[end]) && strings.IndexByte(",);", s[end]) < 0 {
+		end++
+	}
+	if end == pos {
+		return "", pos, NewQueryError("expected dataset reference at position %d, got %q", pos, s[pos])
+	}
+	return s[pos:end], end, nil
+}
+
+// refName gives the dataset name of a reference, which names tables that
+// aren't given an alias. "me/cohort@/ipfs/Qm..." is named "cohort"
+func refName(ref string) string {
+	if i := strings.IndexByte(ref, '@'); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndexByte(ref, '/'); i >= 0 {
+		ref = ref[i+1:]
+	}
+	return ref
+}
+
+func quoteIdent(s string) string {
+	if isIdent(s) && !isKeyword(s) {
+		return s
+	}
+	return "`" + strings.Replace(s, "`", "``", -1) + "`"
+}
+
+func isIdent(s string) bool {
+	if s == "" || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isKeyword(s string) bool {
+	return keywords[strings.ToUpper(s)]
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
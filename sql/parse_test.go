@@ -0,0 +1,141 @@
+package sql
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		query string
+		check func(t *testing.T, stmt *selectStmt)
+	}{
+		{"SELECT * FROM me/cities", func(t *testing.T, stmt *selectStmt) {
+			if len(stmt.items) != 1 || !stmt.items[0].star {
+				t.Errorf("expected a single star item, got: %v", stmt.items)
+			}
+			if stmt.from != (tableRef{ref: "me/cities", alias: "cities"}) {
+				t.Errorf("from mismatch. got: %v", stmt.from)
+			}
+			if stmt.limit != -1 {
+				t.Errorf("expected no limit, got: %d", stmt.limit)
+			}
+		}},
+		{"select c.city AS name, pop p from me/cities@/ipfs/QmHash c limit 10 offset 2;", func(t *testing.T, stmt *selectStmt) {
+			if stmt.from != (tableRef{ref: "me/cities@/ipfs/QmHash", alias: "c"}) {
+				t.Errorf("from mismatch. got: %v", stmt.from)
+			}
+			if stmt.items[0].alias != "name" || stmt.items[0].expr.String() != "c.city" {
+				t.Errorf("item 0 mismatch. got: %v", stmt.items[0])
+			}
+			if stmt.items[1].alias != "p" || stmt.items[1].expr.String() != "pop" {
+				t.Errorf("item 1 mismatch. got: %v", stmt.items[1])
+			}
+			if stmt.limit != 10 || stmt.offset != 2 {
+				t.Errorf("expected limit 10 offset 2, got limit %d offset %d", stmt.limit, stmt.offset)
+			}
+		}},
+		{"SELECT c.*, k.country FROM `me/cities` c LEFT OUTER JOIN me/countries AS k ON c.city = k.city JOIN me/x ON k.a = x.b", func(t *testing.T, stmt *selectStmt) {
+			if !stmt.items[0].star || stmt.items[0].table != "c" {
+				t.Errorf("expected c.* star item, got: %v", stmt.items[0])
+			}
+			if len(stmt.joins) != 2 {
+				t.Fatalf("expected 2 joins, got %d", len(stmt.joins))
+			}
+			if j := stmt.joins[0]; !j.left || j.table != (tableRef{ref: "me/countries", alias: "k"}) || j.on.String() != "c.city = k.city" {
+				t.Errorf("join 0 mismatch. got: %v", j)
+			}
+			if j := stmt.joins[1]; j.left || j.table.alias != "x" {
+				t.Errorf("join 1 mismatch. got: %v", j)
+			}
+		}},
+		{"SELECT in_usa, COUNT(*), avg(avg_age) FROM me/cities WHERE pop > 1e5 AND NOT city = 'chicago' GROUP BY in_usa ORDER BY 2 DESC, in_usa", func(t *testing.T, stmt *selectStmt) {
+			if got := stmt.items[1].expr.String(); got != "COUNT(*)" {
+				t.Errorf("expected COUNT(*), got: %s", got)
+			}
+			if got := stmt.items[2].expr.String(); got != "AVG(avg_age)" {
+				t.Errorf("expected AVG(avg_age), got: %s", got)
+			}
+			if got := stmt.where.String(); got != "(pop > 100000) AND NOT (city = 'chicago')" {
+				t.Errorf("where mismatch. got: %s", got)
+			}
+			if len(stmt.groupBy) != 1 || stmt.groupBy[0].String() != "in_usa" {
+				t.Errorf("group by mismatch. got: %v", stmt.groupBy)
+			}
+			if len(stmt.orderBy) != 2 || !stmt.orderBy[0].desc || stmt.orderBy[1].desc {
+				t.Errorf("order by mismatch. got: %v", stmt.orderBy)
+			}
+		}},
+		{"SELECT a + b * -2, (a + b) * 2, x IS NOT NULL, `order` FROM t", func(t *testing.T, stmt *selectStmt) {
+			expect := []string{"a + (b * -2)", "(a + b) * 2", "x IS NOT NULL", "`order`"}
+			for i, e := range expect {
+				if got := stmt.items[i].expr.String(); got != e {
+					t.Errorf("item %d: expected %q, got %q", i, e, got)
+				}
+			}
+		}},
+	}
+
+	for i, c := range cases {
+		stmt, err := parse(c.query)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		c.check(t, stmt)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		query string
+		err   string
+	}{
+		{"", `expected SELECT at position 0, got end of query`},
+		{"SELECT", `expected expression at position 6, got end of query`},
+		{"SELECT city", `expected FROM at position 11, got end of query`},
+		{"SELECT city FROM", `expected dataset reference at position 16, got end of query`},
+		{"SELECT city FROM me/cities WHERE", `expected expression at position 32, got end of query`},
+		{"SELECT city FROM me/cities LEFT me/x", `expected JOIN at position 32, got "me"`},
+		{"SELECT city FROM me/cities JOIN me/x", `expected ON at position 36, got end of query`},
+		{"SELECT city FROM me/cities GROUP city", `expected BY at position 33, got "city"`},
+		{"SELECT city FROM me/cities LIMIT -1", `LIMIT at position 33 must be a non-negative integer, got "-"`},
+		{"SELECT city FROM me/cities LIMIT 1.5", `LIMIT at position 33 must be a non-negative integer, got "1.5"`},
+		{"SELECT city FROM me/cities extra words", `unexpected "words" at position 33`},
+		{"SELECT city FROM me/cities WHERE city = 'toronto", `unterminated quote at position 40`},
+		{"SELECT city FROM me/cities WHERE pop ! 1", `unexpected '!' at position 37`},
+		{"SELECT median(pop) FROM me/cities", `unknown function "median" at position 7`},
+		{"SELECT COUNT(pop FROM me/cities", `expected ")" at position 17, got "FROM"`},
+		{"SELECT c. FROM me/cities c", `expected column name at position 10, got "FROM"`},
+		{"SELECT FROM me/cities", `unexpected "FROM" at position 7`},
+	}
+
+	for i, c := range cases {
+		_, err := parse(c.query)
+		if err == nil {
+			t.Errorf("case %d: expected error, got nil", i)
+			continue
+		}
+		if _, ok := err.(*QueryError); !ok {
+			t.Errorf("case %d: expected a *QueryError, got %T", i, err)
+		}
+		if err.Error() != c.err {
+			t.Errorf("case %d: error mismatch. expected: %q, got: %q", i, c.err, err.Error())
+		}
+	}
+}
+
+func TestRefName(t *testing.T) {
+	cases := []struct {
+		ref, expect string
+	}{
+		{"me/cohort", "cohort"},
+		{"me/cohort@/ipfs/QmHash", "cohort"},
+		{"peer/cohort@QmProfile/ipfs/QmHash", "cohort"},
+		{"@/ipfs/QmHash", ""},
+	}
+	for i, c := range cases {
+		if got := refName(c.ref); got != c.expect {
+			t.Errorf("case %d: expected %q, got %q", i, c.expect, got)
+		}
+	}
+}
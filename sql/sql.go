@@ -0,0 +1,135 @@
+// Package sql runs SQL queries against dataset bodies. It supports a subset of
+// SELECT statements: projection, WHERE, GROUP BY with aggregates, ORDER BY,
+// LIMIT & OFFSET, and inner & left joins. Table names are dataset references:
+//
+//	SELECT dx, COUNT(*) AS n
+//	FROM me/cohort@/ipfs/QmHash
+//	WHERE age > 65
+//	GROUP BY dx
+//	ORDER BY n DESC
+//
+// Tables are named by the dataset name in their reference unless given an
+// alias with AS. References that contain spaces can be quoted with backticks
+package sql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/affix-io/affix/base/dsfs"
+	"github.com/affix-io/affix/dsref"
+	"github.com/affix-io/dataset/dsio"
+	"github.com/affix-io/dataset/tabular"
+	"github.com/affix-io/qfs"
+	golog "github.com/ipfs/go-log"
+)
+
+var log = golog.Logger("sql")
+
+// QueryError is an error caused by an invalid query
+type QueryError struct {
+	Message string
+}
+
+// Error renders the QueryError as a string
+func (e *QueryError) Error() string {
+	return e.Message
+}
+
+// NewQueryError returns a new QueryError, its parameters are a format string
+// and arguments
+func NewQueryError(template string, args ...interface{}) error {
+	return &QueryError{Message: fmt.Sprintf(template, args...)}
+}
+
+// Result is the output of a query
+type Result struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// Len returns the number of rows in the result
+func (r *Result) Len() int {
+	return len(r.Rows)
+}
+
+// DefaultMaxRows is the default number of rows a query can hold in memory
+// for joined tables, groups & sorting
+const DefaultMaxRows = 100000
+
+// Service executes SQL queries against datasets
+type Service struct {
+	fs      qfs.Filesystem
+	loader  dsref.Loader
+	maxRows int
+}
+
+// Option configures a Service
+type Option func(svc *Service)
+
+// OptMaxRows sets the number of rows a query can hold in memory. Bodies are
+// streamed, but joined tables are read into memory, as are groups & the rows
+// of sorted queries without a limit. Queries that need more rows fail with a
+// QueryError. Zero removes the cap
+func OptMaxRows(n int) Option {
+	return func(svc *Service) {
+		svc.maxRows = n
+	}
+}
+
+// New creates an SQL service. Datasets are resolved with loader & bodies are
+// read from fs
+func New(fs qfs.Filesystem, loader dsref.Loader, opts ...Option) *Service {
+	svc := &Service{
+		fs:      fs,
+		loader:  loader,
+		maxRows: DefaultMaxRows,
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	return svc
+}
+
+// Exec runs a query, returning at most limit rows of the result starting at
+// offset. offset & limit page through the rows a query selects, applying
+// after any LIMIT & OFFSET clauses in the query itself. A limit of -1 returns
+// all rows
+func (svc *Service) Exec(ctx context.Context, query string, offset, limit int) (*Result, error) {
+	stmt, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return execute(ctx, stmt, svc.open, offset, limit, svc.maxRows)
+}
+
+// open loads a dataset & opens a reader for its body
+func (svc *Service) open(ctx context.Context, ref string) ([]string, dsio.EntryReader, error) {
+	ds, err := svc.loader.LoadDataset(ctx, ref)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading %q: %w", ref, err)
+	}
+	if ds.Structure == nil || ds.BodyPath == "" {
+		return nil, nil, NewQueryError("dataset %q has no body", ref)
+	}
+	cols, _, err := tabular.ColumnsFromJSONSchema(ds.Structure.Schema)
+	if err != nil {
+		return nil, nil, NewQueryError("dataset %q doesn't have a tabular body: %s", ref, err)
+	}
+	titles := make([]string, len(cols))
+	for i, c := range cols {
+		titles[i] = c.Title
+	}
+
+	f, err := dsfs.LoadBody(ctx, svc.fs, ds)
+	if err != nil {
+		log.Debugf("loading body for %q: %s", ref, err)
+		return nil, nil, fmt.Errorf("loading body for %q: %w", ref, err)
+	}
+	rr, err := dsio.NewEntryReader(ds.Structure, f)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("reading body for %q: %w", ref, err)
+	}
+	return titles, rr, nil
+}